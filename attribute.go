@@ -0,0 +1,211 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+	"zebrax.id/emi/integration/erp/connector/odoo/model"
+)
+
+// VariantSlot is a variant parameter of the product stored functions
+// (fn_get_product_id_v2, fn_get_product_id_guest, fn_get_product_stock).
+type VariantSlot string
+
+const (
+	VariantColor   VariantSlot = "color"
+	VariantBattery VariantSlot = "battery"
+	VariantMirror  VariantSlot = "mirror"
+	VariantWheel   VariantSlot = "wheel"
+)
+
+var variantSlots = []VariantSlot{VariantColor, VariantBattery, VariantMirror, VariantWheel}
+
+// ErrUnknownAttribute is returned when an order carries an attribute ID that
+// is not mapped to any variant slot.
+var ErrUnknownAttribute = errors.New("unknown vehicle attribute")
+
+// AttributeRegistry maps Odoo product attribute IDs to the variant slots the
+// stored functions expect.
+type AttributeRegistry struct {
+	slots map[string]VariantSlot
+}
+
+// DefaultAttributeRegistry is the mapping of the production catalog, used
+// until SetAttributeRegistry is called at startup.
+var DefaultAttributeRegistry = &AttributeRegistry{
+	slots: map[string]VariantSlot{
+		"4":  VariantMirror,
+		"5":  VariantWheel,
+		"10": VariantColor,
+		"11": VariantBattery,
+	},
+}
+
+var (
+	attributeRegistryMu sync.RWMutex
+	attributeRegistry   = DefaultAttributeRegistry
+)
+
+// SetAttributeRegistry replaces the registry used by the repository.
+func SetAttributeRegistry(registry *AttributeRegistry) {
+	attributeRegistryMu.Lock()
+	defer attributeRegistryMu.Unlock()
+
+	attributeRegistry = registry
+}
+
+func currentAttributeRegistry() *AttributeRegistry {
+	attributeRegistryMu.RLock()
+	defer attributeRegistryMu.RUnlock()
+
+	return attributeRegistry
+}
+
+// NewAttributeRegistry builds a registry from attribute ID to slot. Each slot
+// may be claimed by more than one attribute ID, but every slot must be known.
+func NewAttributeRegistry(slots map[string]VariantSlot) (*AttributeRegistry, error) {
+	registry := &AttributeRegistry{slots: make(map[string]VariantSlot, len(slots))}
+	for attributeID, slot := range slots {
+		if !slot.valid() {
+			return nil, fmt.Errorf("attribute %s: invalid variant slot %q", attributeID, slot)
+		}
+		registry.slots[strings.TrimSpace(attributeID)] = slot
+	}
+
+	return registry, nil
+}
+
+// ParseAttributeRegistry reads a registry from config in the form
+// "4:mirror,5:wheel,10:color,11:battery".
+func ParseAttributeRegistry(spec string) (*AttributeRegistry, error) {
+	slots := make(map[string]VariantSlot)
+	for _, pair := range strings.Split(spec, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		item := strings.SplitN(pair, ":", 2)
+		if len(item) != 2 {
+			return nil, fmt.Errorf("attribute registry: invalid entry %q", pair)
+		}
+		slots[strings.TrimSpace(item[0])] = VariantSlot(strings.ToLower(strings.TrimSpace(item[1])))
+	}
+
+	return NewAttributeRegistry(slots)
+}
+
+// LoadAttributeRegistry checks the configured registry against the
+// product.attribute records in Odoo. A configured attribute ID that Odoo does
+// not know fails with ErrUnknownAttribute; Odoo attributes without a slot are
+// only logged, so the catalog team sees what still needs configuring.
+func (r *repository) LoadAttributeRegistry(ctx context.Context, configured *AttributeRegistry) (*AttributeRegistry, error) {
+	defer log.Info("[Odoo - Connector - LoadAttributeRegistry] End")
+	log.Info("[Odoo - Connector - LoadAttributeRegistry] Start")
+
+	response, err := r.executeKw(ctx, "search_read", "product.attribute", []interface{}{
+		[]interface{}{},
+	}, map[string]interface{}{
+		"fields": []string{"id", "name"},
+	})
+	if err != nil {
+		log.Info("[Odoo - Connector - LoadAttributeRegistry] RPC product.attribute - search_read Error: ", err.Error())
+		return nil, err
+	}
+
+	var attributes []struct {
+		ID   int    `json:"id"`
+		Name string `json:"name"`
+	}
+	jsonStr, err := json.Marshal(response)
+	if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(jsonStr, &attributes); err != nil {
+		return nil, err
+	}
+
+	names := make(map[string]string, len(attributes))
+	for _, attribute := range attributes {
+		attributeID := fmt.Sprintf("%d", attribute.ID)
+		names[attributeID] = attribute.Name
+		if _, ok := configured.Slot(attributeID); !ok {
+			log.Info(fmt.Sprintf("[Odoo - Connector - LoadAttributeRegistry] Attribute %s %s has no variant slot", attributeID, attribute.Name))
+		}
+	}
+
+	for _, attributeID := range configured.AttributeIDs() {
+		name, ok := names[attributeID]
+		if !ok {
+			return nil, fmt.Errorf("%w: attribute id %s is configured but not in Odoo", ErrUnknownAttribute, attributeID)
+		}
+		slot, _ := configured.Slot(attributeID)
+		log.Info(fmt.Sprintf("[Odoo - Connector - LoadAttributeRegistry] Attribute %s %s is %s", attributeID, name, slot))
+	}
+
+	return configured, nil
+}
+
+func (s VariantSlot) valid() bool {
+	for _, slot := range variantSlots {
+		if s == slot {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Slot returns the variant slot of attributeID.
+func (a *AttributeRegistry) Slot(attributeID string) (VariantSlot, bool) {
+	slot, ok := a.slots[strings.TrimSpace(attributeID)]
+	return slot, ok
+}
+
+// AttributeIDs returns the registered attribute IDs in ascending order.
+func (a *AttributeRegistry) AttributeIDs() (ids []string) {
+	for id := range a.slots {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	return ids
+}
+
+// variantSet holds the variant ID of every slot for one product line. Slots
+// without a selected attribute stay "0", which the stored functions read as
+// "any variant".
+type variantSet struct {
+	Color   string
+	Battery string
+	Mirror  string
+	Wheel   string
+}
+
+// Resolve maps the selected attributes of an order onto the variant slots.
+func (a *AttributeRegistry) Resolve(attrs []model.Attribute) (variants variantSet, err error) {
+	variants = variantSet{Color: "0", Battery: "0", Mirror: "0", Wheel: "0"}
+	for _, attr := range attrs {
+		slot, ok := a.Slot(attr.AttributeID)
+		if !ok {
+			return variants, fmt.Errorf("%w: attribute id %s (%s)", ErrUnknownAttribute, attr.AttributeID, attr.AttributeName)
+		}
+
+		switch slot {
+		case VariantColor:
+			variants.Color = attr.VariantID
+		case VariantBattery:
+			variants.Battery = attr.VariantID
+		case VariantMirror:
+			variants.Mirror = attr.VariantID
+		case VariantWheel:
+			variants.Wheel = attr.VariantID
+		}
+	}
+
+	return variants, nil
+}
@@ -0,0 +1,96 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var (
+	ErrAlreadyWaitlisted     = errors.New("already on the waitlist of this slot")
+	ErrWaitlistEntryNotFound = errors.New("waitlist entry not found")
+	ErrSlotHeld              = errors.New("slot is held for a waitlisted customer")
+	ErrSlotInPast            = errors.New("slot has already started")
+	ErrSlotNotFull           = errors.New("slot still has free seats")
+)
+
+// DefaultBookingDuration is assumed when a booking comes without an end time.
+const DefaultBookingDuration = time.Hour
+
+// ParseBookingTime reads the start or end of a booking. Odoo returns either a
+// full timestamp such as "2022-03-01T11:00:00+07:00" or a time of day to be
+// combined with date. Times without an offset are in WIB, the zone Odoo
+// schedules in, whatever the zone of this host.
+func ParseBookingTime(date string, clock string) (time.Time, error) {
+	clock = strings.TrimSpace(clock)
+	for _, layout := range []string{time.RFC3339, "2006-01-02T15:04:05", "2006-01-02 15:04:05"} {
+		if t, err := time.ParseInLocation(layout, clock, WIB); err == nil {
+			return t, nil
+		}
+	}
+	for _, layout := range []string{"2006-01-02 15:04:05", "2006-01-02 15:04", "2006-01-02 15.04"} {
+		if t, err := time.ParseInLocation(layout, strings.TrimSpace(date)+" "+clock, WIB); err == nil {
+			return t, nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("cannot parse booking time %q %q", date, clock)
+}
+
+// BookingStatusCancelled reports whether a booking status names a
+// cancellation.
+func BookingStatusCancelled(status string) bool {
+	return strings.HasPrefix(strings.ToLower(strings.TrimSpace(status)), "cancel")
+}
+
+// Period returns the start and end of the booking.
+func (b BookingTestDriveResponse) Period() (start time.Time, end time.Time, err error) {
+	if start, err = ParseBookingTime(b.Date, b.StartTime); err != nil {
+		return start, end, err
+	}
+	if strings.TrimSpace(b.EndTime) == "" {
+		return start, start.Add(DefaultBookingDuration), nil
+	}
+	if end, err = ParseBookingTime(b.Date, b.EndTime); err != nil {
+		return start, end, err
+	}
+
+	return start, end, nil
+}
+
+// Cancelled reports whether the booking has been cancelled.
+func (b BookingTestDriveResponse) Cancelled() bool {
+	return BookingStatusCancelled(b.BookingStatus) || b.CancelDate != ""
+}
+
+// OperatingSchedule returns the structured operating hours of the booked
+// experience center at now, in its time zone.
+func (b BookingTestDriveResponse) OperatingSchedule(now time.Time) (OperatingSchedule, bool) {
+	return ParseOperatingSchedule(b.OperatingHours, DealerLocation(b.State, b.Longitude), now)
+}
+
+// WaitlistParams names the slot a customer wants to wait for.
+type WaitlistParams struct {
+	UID               string
+	ProductID         int32
+	EcID              int32
+	AppointmentTypeID int32
+	SlotDate          string
+	SlotStartTime     string
+}
+
+// WaitlistEntry is a customer's place on the waitlist of a slot.
+type WaitlistEntry struct {
+	ID                int64
+	UID               int32
+	ProductID         int32
+	EcID              int32
+	AppointmentTypeID int32
+	SlotStart         time.Time
+	Status            string
+	// HoldExpires is set while the slot is held for the customer.
+	HoldExpires time.Time
+	// Ahead is the number of customers waiting before this one.
+	Ahead int
+}
@@ -0,0 +1,277 @@
+package usecase
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/labstack/echo/v4"
+	log "github.com/sirupsen/logrus"
+	"zebrax.id/emi/integration/erp/adapter/repository/query"
+	odooConnectorModel "zebrax.id/emi/integration/erp/connector/odoo/model"
+)
+
+// CalendarContentType is the media type of the exported .ics files.
+const CalendarContentType = "text/calendar; charset=utf-8"
+
+const (
+	calendarProductID = "-//Zebrax//Test Drive//EN"
+	calendarUIDDomain = "testdrive.zebrax.id"
+	calendarTimestamp = "20060102T150405Z"
+	// calendarLineOctets is the longest content line before folding.
+	calendarLineOctets = 75
+)
+
+// CustomerContextKey is the echo context key under which the authentication
+// middleware stores the ID of the signed-in customer.
+const CustomerContextKey = "customer_id"
+
+// CalendarOrganizer is the ORGANIZER of the exported events. RFC 5546 requires
+// one on every scheduling message, including METHOD:CANCEL.
+type CalendarOrganizer struct {
+	Name  string
+	Email string
+}
+
+// DefaultCalendarOrganizer is used until SetCalendarOrganizer is called.
+var DefaultCalendarOrganizer = CalendarOrganizer{Name: "Zebrax Test Drive", Email: "testdrive@zebrax.id"}
+
+var (
+	calendarOrganizerMu sync.RWMutex
+	calendarOrganizer   = DefaultCalendarOrganizer
+)
+
+// SetCalendarOrganizer replaces the organizer of the exported events.
+func SetCalendarOrganizer(organizer CalendarOrganizer) {
+	calendarOrganizerMu.Lock()
+	defer calendarOrganizerMu.Unlock()
+	calendarOrganizer = organizer
+}
+
+func currentCalendarOrganizer() CalendarOrganizer {
+	calendarOrganizerMu.RLock()
+	defer calendarOrganizerMu.RUnlock()
+	return calendarOrganizer
+}
+
+// authenticatedCustomer returns the ID of the signed-in customer.
+func authenticatedCustomer(ctx echo.Context) (string, bool) {
+	switch customer := ctx.Get(CustomerContextKey).(type) {
+	case string:
+		return customer, customer != ""
+	case int:
+		return strconv.Itoa(customer), customer != 0
+	case int32:
+		return strconv.Itoa(int(customer)), customer != 0
+	case int64:
+		return strconv.FormatInt(customer, 10), customer != 0
+	}
+	return "", false
+}
+
+// TestDriveCalendar exports the booking named by the booking_id query
+// parameter as an RFC 5545 calendar. Only the bookings of the signed-in
+// customer can be exported; a uid query parameter naming anyone else is
+// refused.
+func (r *useCase) TestDriveCalendar(ctx echo.Context) (ics []byte, err error) {
+	defer log.Info("[TestDriveCalendar] End")
+	log.Info("[TestDriveCalendar] Start")
+
+	uid, ok := authenticatedCustomer(ctx)
+	if !ok {
+		return nil, echo.NewHTTPError(http.StatusUnauthorized, "sign in to export a booking")
+	}
+	if requested := ctx.QueryParam("uid"); requested != "" && requested != uid {
+		log.Info(fmt.Sprintf("[TestDriveCalendar] Customer %s requested the bookings of %s", uid, requested))
+		return nil, echo.NewHTTPError(http.StatusForbidden, "bookings of another customer cannot be exported")
+	}
+
+	bookingID := ctx.QueryParam("booking_id")
+	if bookingID == "" {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, "booking_id is required")
+	}
+
+	bookings, err := r.oRepo.GetTestDriveListByUid(ctx.Request().Context(), uid)
+	if err != nil {
+		log.Info("[TestDriveCalendar] Get Test Drive List Error : ", err.Error())
+		return nil, err
+	}
+
+	for _, booking := range bookings {
+		if booking.BookingID != bookingID {
+			continue
+		}
+
+		event, err := r.testDriveCalendarEvent(ctx, booking)
+		if err != nil {
+			return nil, err
+		}
+		return BookingCalendar(booking, event.Sequence, event.UpdatedTime)
+	}
+
+	return nil, echo.NewHTTPError(http.StatusNotFound, fmt.Sprintf("booking %s not found", bookingID))
+}
+
+// testDriveCalendarEvent returns the stored event of booking, moving it to the
+// next sequence when the booking changed since it was last exported.
+func (r *useCase) testDriveCalendarEvent(ctx echo.Context, booking odooConnectorModel.BookingTestDriveResponse) (query.TestdriveCalendarEvent, error) {
+	// The event rendered without its sequence and stamps changes with every
+	// field it shows, e.g. a renamed product or experience center.
+	event, err := renderBookingCalendar(booking, 0, time.Time{}, time.Time{})
+	if err != nil {
+		return query.TestdriveCalendarEvent{}, err
+	}
+	fingerprint := sha256.Sum256(event)
+
+	return r.repo.UpsertTestDriveCalendarEvent(ctx.Request().Context(), &query.UpsertTestDriveCalendarEventParams{
+		BookingCode: booking.BookingCode,
+		Fingerprint: hex.EncodeToString(fingerprint[:]),
+		UpdatedTime: time.Now().UTC(),
+	})
+}
+
+// BookingCalendar renders booking as an RFC 5545 calendar with a single event.
+// The event UID is derived from the booking code, so a rescheduled booking
+// exported with a higher sequence replaces the earlier event, and a cancelled
+// booking is exported with METHOD:CANCEL.
+func BookingCalendar(booking odooConnectorModel.BookingTestDriveResponse, sequence int32, modified time.Time) ([]byte, error) {
+	return renderBookingCalendar(booking, sequence, modified, time.Now())
+}
+
+// renderBookingCalendar renders the calendar of BookingCalendar, stamped at
+// stamp.
+func renderBookingCalendar(booking odooConnectorModel.BookingTestDriveResponse, sequence int32, modified time.Time, stamp time.Time) ([]byte, error) {
+	start, end, err := booking.Period()
+	if err != nil {
+		return nil, err
+	}
+
+	method, status := "PUBLISH", "CONFIRMED"
+	if booking.Cancelled() {
+		method, status = "CANCEL", "CANCELLED"
+	}
+
+	location := []string{booking.LocationName, booking.Address, booking.City, booking.State, booking.Country}
+	description := []string{"Booking code: " + booking.BookingCode}
+	if booking.OperatingHours != "" {
+		description = append(description, "Operating hours: "+booking.OperatingHours)
+	}
+
+	var buf bytes.Buffer
+	line := func(name string, value string) {
+		writeCalendarLine(&buf, name+":"+value)
+	}
+
+	line("BEGIN", "VCALENDAR")
+	line("VERSION", "2.0")
+	line("PRODID", calendarProductID)
+	line("CALSCALE", "GREGORIAN")
+	line("METHOD", method)
+	line("BEGIN", "VEVENT")
+	line("UID", calendarUID(booking.BookingCode))
+	writeCalendarLine(&buf, calendarOrganizerProperty(currentCalendarOrganizer()))
+	line("SEQUENCE", strconv.Itoa(int(sequence)))
+	line("DTSTAMP", stamp.UTC().Format(calendarTimestamp))
+	line("LAST-MODIFIED", modified.UTC().Format(calendarTimestamp))
+	line("DTSTART", start.UTC().Format(calendarTimestamp))
+	line("DTEND", end.UTC().Format(calendarTimestamp))
+	line("SUMMARY", calendarText("Test Drive "+booking.ProductName))
+	line("LOCATION", calendarText(joinNonEmpty(location, ", ")))
+	if geo, ok := calendarGeo(booking.Latitude, booking.Longitude); ok {
+		line("GEO", geo)
+	}
+	line("DESCRIPTION", calendarText(strings.Join(description, "\n")))
+	line("STATUS", status)
+	line("END", "VEVENT")
+	line("END", "VCALENDAR")
+
+	return buf.Bytes(), nil
+}
+
+func calendarUID(bookingCode string) string {
+	code := strings.Map(func(r rune) rune {
+		if r == '/' || r == ' ' {
+			return '-'
+		}
+		return r
+	}, bookingCode)
+
+	return strings.ToLower(code) + "@" + calendarUIDDomain
+}
+
+// calendarOrganizerProperty formats the ORGANIZER property, with the name as
+// a quoted CN parameter.
+func calendarOrganizerProperty(organizer CalendarOrganizer) string {
+	property := "ORGANIZER"
+	if name := strings.Map(func(r rune) rune {
+		if r == '"' || r < ' ' {
+			return -1
+		}
+		return r
+	}, organizer.Name); name != "" {
+		property += `;CN="` + name + `"`
+	}
+	return property + ":mailto:" + organizer.Email
+}
+
+// calendarGeo formats the GEO property, which needs both coordinates as
+// decimal degrees.
+func calendarGeo(latitude string, longitude string) (string, bool) {
+	lat, err := strconv.ParseFloat(strings.TrimSpace(latitude), 64)
+	if err != nil {
+		return "", false
+	}
+	lon, err := strconv.ParseFloat(strings.TrimSpace(longitude), 64)
+	if err != nil {
+		return "", false
+	}
+
+	return strconv.FormatFloat(lat, 'f', -1, 64) + ";" + strconv.FormatFloat(lon, 'f', -1, 64), true
+}
+
+// calendarText escapes a TEXT property value.
+func calendarText(value string) string {
+	return strings.NewReplacer(
+		`\`, `\\`,
+		";", `\;`,
+		",", `\,`,
+		"\r\n", `\n`,
+		"\n", `\n`,
+	).Replace(value)
+}
+
+// writeCalendarLine writes a content line, folded after 75 octets without
+// splitting a UTF-8 character, and terminated by CRLF.
+func writeCalendarLine(buf *bytes.Buffer, content string) {
+	limit := calendarLineOctets
+	for len(content) > limit {
+		cut := limit
+		for cut > 0 && !utf8.RuneStart(content[cut]) {
+			cut--
+		}
+		buf.WriteString(content[:cut])
+		buf.WriteString("\r\n ")
+		content = content[cut:]
+		// Continuation lines start with a space, which counts towards the limit.
+		limit = calendarLineOctets - 1
+	}
+	buf.WriteString(content)
+	buf.WriteString("\r\n")
+}
+
+func joinNonEmpty(values []string, sep string) string {
+	parts := make([]string, 0, len(values))
+	for _, value := range values {
+		if value = strings.TrimSpace(value); value != "" {
+			parts = append(parts, value)
+		}
+	}
+	return strings.Join(parts, sep)
+}
@@ -0,0 +1,19 @@
+-- Schema and sqlc queries for the test drive calendar events.
+
+CREATE TABLE IF NOT EXISTS testdrive_calendar_events (
+    booking_code VARCHAR(64) PRIMARY KEY,
+    fingerprint  CHAR(64)    NOT NULL,
+    sequence     INTEGER     NOT NULL DEFAULT 0,
+    updated_time TIMESTAMP   NOT NULL DEFAULT NOW()
+);
+
+-- The sequence goes up whenever the exported event differs from the last
+-- export, so calendars replace the earlier copy.
+-- name: UpsertTestDriveCalendarEvent :one
+INSERT INTO testdrive_calendar_events AS e (booking_code, fingerprint, sequence, updated_time)
+VALUES ($1, $2, 0, $3)
+ON CONFLICT (booking_code) DO UPDATE
+SET sequence     = e.sequence + CASE WHEN e.fingerprint = EXCLUDED.fingerprint THEN 0 ELSE 1 END,
+    updated_time = CASE WHEN e.fingerprint = EXCLUDED.fingerprint THEN e.updated_time ELSE EXCLUDED.updated_time END,
+    fingerprint  = EXCLUDED.fingerprint
+RETURNING *;
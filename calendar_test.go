@@ -0,0 +1,228 @@
+package usecase
+
+import (
+	"bytes"
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/labstack/echo/v4"
+	"zebrax.id/emi/integration/erp/adapter/repository/query"
+	odooConnectorModel "zebrax.id/emi/integration/erp/connector/odoo/model"
+)
+
+func calendarBooking() odooConnectorModel.BookingTestDriveResponse {
+	return odooConnectorModel.BookingTestDriveResponse{
+		BookingID:      "733",
+		BookingCode:    "TD/D0202/22/00187",
+		ProductName:    "EV-V Sporty",
+		Date:           "2022-03-01",
+		StartTime:      "11:00",
+		EndTime:        "12:00",
+		LocationID:     "1",
+		LocationName:   "Indy Office Bintaro",
+		Address:        "Jl. Al Hidayah No.44, Pd. Jaya",
+		City:           "Kota Tangerang Selatan",
+		State:          "Banten",
+		Country:        "Indonesia",
+		Latitude:       "-6.27466",
+		Longitude:      "106.72046",
+		OperatingHours: "Everydays 10.00 - 18.00",
+	}
+}
+
+// unfoldCalendar joins folded lines and splits the calendar into its content
+// lines.
+func unfoldCalendar(ics []byte) []string {
+	return strings.Split(strings.TrimSuffix(strings.ReplaceAll(string(ics), "\r\n ", ""), "\r\n"), "\r\n")
+}
+
+func TestBookingCalendar(t *testing.T) {
+	modified := time.Date(2022, 2, 20, 8, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		booking func(b *odooConnectorModel.BookingTestDriveResponse)
+		want    []string
+		absent  []string
+	}{
+		{
+			name: "confirmed",
+			want: []string{
+				"METHOD:PUBLISH",
+				"UID:td-d0202-22-00187@" + calendarUIDDomain,
+				`ORGANIZER;CN="Zebrax Test Drive":mailto:testdrive@zebrax.id`,
+				"SEQUENCE:2",
+				"LAST-MODIFIED:20220220T080000Z",
+				// 11:00 and 12:00 WIB, whatever the zone of the host.
+				"DTSTART:20220301T040000Z",
+				"DTEND:20220301T050000Z",
+				"SUMMARY:Test Drive EV-V Sporty",
+				`LOCATION:Indy Office Bintaro\, Jl. Al Hidayah No.44\, Pd. Jaya\, Kota Tangerang Selatan\, Banten\, Indonesia`,
+				"GEO:-6.27466;106.72046",
+				`DESCRIPTION:Booking code: TD/D0202/22/00187\nOperating hours: Everydays 10.00 - 18.00`,
+				"STATUS:CONFIRMED",
+			},
+		},
+		{
+			name: "cancelled",
+			booking: func(b *odooConnectorModel.BookingTestDriveResponse) {
+				b.CancelDate = "2022-02-28"
+			},
+			want: []string{"METHOD:CANCEL", "STATUS:CANCELLED"},
+		},
+		{
+			name: "without end time or position",
+			booking: func(b *odooConnectorModel.BookingTestDriveResponse) {
+				b.EndTime = ""
+				b.Latitude = ""
+			},
+			want:   []string{"DTEND:20220301T050000Z"},
+			absent: []string{"GEO:"},
+		},
+		{
+			name: "full timestamps",
+			booking: func(b *odooConnectorModel.BookingTestDriveResponse) {
+				b.StartTime = "2022-03-01T13:00:00+08:00"
+				b.EndTime = "2022-03-01T14:00:00+08:00"
+			},
+			want: []string{"DTSTART:20220301T050000Z", "DTEND:20220301T060000Z"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			booking := calendarBooking()
+			if tt.booking != nil {
+				tt.booking(&booking)
+			}
+
+			ics, err := BookingCalendar(booking, 2, modified)
+			if err != nil {
+				t.Fatal(err)
+			}
+			lines := unfoldCalendar(ics)
+			for _, want := range tt.want {
+				found := false
+				for _, line := range lines {
+					found = found || line == want
+				}
+				if !found {
+					t.Errorf("missing %q in\n%s", want, ics)
+				}
+			}
+			for _, prefix := range tt.absent {
+				for _, line := range lines {
+					if strings.HasPrefix(line, prefix) {
+						t.Errorf("unexpected %q", line)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestWriteCalendarLine(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{name: "short", content: "SUMMARY:Test Drive"},
+		{name: "exactly 75 octets", content: "DESCRIPTION:" + strings.Repeat("a", 63)},
+		{name: "long ascii", content: "DESCRIPTION:" + strings.Repeat("a", 200)},
+		{name: "multi-byte", content: "LOCATION:" + strings.Repeat("é", 100)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			writeCalendarLine(&buf, tt.content)
+
+			out := buf.String()
+			if !strings.HasSuffix(out, "\r\n") {
+				t.Fatalf("line %q not terminated by CRLF", out)
+			}
+			for i, line := range strings.Split(strings.TrimSuffix(out, "\r\n"), "\r\n") {
+				if len(line) > calendarLineOctets {
+					t.Errorf("line of %d octets, want at most %d", len(line), calendarLineOctets)
+				}
+				if i > 0 && !strings.HasPrefix(line, " ") {
+					t.Errorf("continuation line %q does not start with a space", line)
+				}
+				if !utf8.ValidString(strings.TrimPrefix(line, " ")) {
+					t.Errorf("line %q splits a character", line)
+				}
+			}
+			if got := strings.ReplaceAll(strings.TrimSuffix(out, "\r\n"), "\r\n ", ""); got != tt.content {
+				t.Errorf("unfolded = %q, want %q", got, tt.content)
+			}
+		})
+	}
+}
+
+func TestCalendarText(t *testing.T) {
+	tests := map[string]string{
+		"plain":         "plain",
+		"a, b; c":       `a\, b\; c`,
+		`C:\path`:       `C:\\path`,
+		"line\nbreak":   `line\nbreak`,
+		"crlf\r\nbreak": `crlf\nbreak`,
+	}
+	for value, want := range tests {
+		if got := calendarText(value); got != want {
+			t.Errorf("calendarText(%q) = %q, want %q", value, got, want)
+		}
+	}
+}
+
+// calendarEventStore keeps the fingerprint of the last upserted event and
+// bumps its sequence when the fingerprint changes.
+type calendarEventStore struct {
+	query.Store
+	event query.TestdriveCalendarEvent
+}
+
+func (s *calendarEventStore) UpsertTestDriveCalendarEvent(ctx context.Context, arg *query.UpsertTestDriveCalendarEventParams) (query.TestdriveCalendarEvent, error) {
+	if s.event.Fingerprint != arg.Fingerprint {
+		s.event.Sequence++
+		s.event.Fingerprint = arg.Fingerprint
+		s.event.UpdatedTime = arg.UpdatedTime
+	}
+	s.event.BookingCode = arg.BookingCode
+	return s.event, nil
+}
+
+func TestTestDriveCalendarEventSequence(t *testing.T) {
+	store := &calendarEventStore{}
+	r := &useCase{repo: store}
+	ctx := echo.New().NewContext(httptest.NewRequest("GET", "/", nil), httptest.NewRecorder())
+
+	changes := []struct {
+		name   string
+		change func(b *odooConnectorModel.BookingTestDriveResponse)
+		bumped bool
+	}{
+		{name: "first export", change: func(b *odooConnectorModel.BookingTestDriveResponse) {}, bumped: true},
+		{name: "unchanged", change: func(b *odooConnectorModel.BookingTestDriveResponse) {}},
+		{name: "status message", change: func(b *odooConnectorModel.BookingTestDriveResponse) { b.Message = "Searching Succesfully" }},
+		{name: "product renamed", change: func(b *odooConnectorModel.BookingTestDriveResponse) { b.ProductName = "EV-V Touring" }, bumped: true},
+		{name: "center renamed", change: func(b *odooConnectorModel.BookingTestDriveResponse) { b.LocationName = "Indy Bintaro" }, bumped: true},
+		{name: "rescheduled", change: func(b *odooConnectorModel.BookingTestDriveResponse) { b.StartTime = "13:00" }, bumped: true},
+		{name: "cancelled", change: func(b *odooConnectorModel.BookingTestDriveResponse) { b.CancelDate = "2022-02-28" }, bumped: true},
+	}
+
+	booking := calendarBooking()
+	for _, tt := range changes {
+		before := store.event.Sequence
+		tt.change(&booking)
+		event, err := r.testDriveCalendarEvent(ctx, booking)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bumped := event.Sequence != before; bumped != tt.bumped {
+			t.Errorf("%s: sequence %d -> %d, want bumped = %t", tt.name, before, event.Sequence, tt.bumped)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package repository
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+	utils "zebrax.id/emi/integration/core/utils"
+	"zebrax.id/emi/integration/erp/connector/odoo/model"
+	"zebrax.id/emi/integration/erp/connector/odoo/repository/query"
+)
+
+func orderLinesHash(lines []orderLine) string {
+	linesJSON, _ := json.Marshal(lines)
+	return fmt.Sprintf("%x", sha256.Sum256(linesJSON))
+}
+
+// recordSaleOrderLines remembers the order lines written to the sales order.
+func (r *repository) recordSaleOrderLines(ctx context.Context, salesOrderId int32, lines []orderLine) {
+	err := r.qry.UpsertSalesOrderCart(ctx, &query.UpsertSalesOrderCartParams{
+		SalesOrderID: salesOrderId,
+		LinesHash:    orderLinesHash(lines),
+	})
+	if err != nil {
+		log.Error(fmt.Sprintf("[Odoo - Connector - SaleOrderLines] Record %d Error: ", salesOrderId), err)
+	}
+}
+
+// UpdateSaleOrderLines replaces the product lines of the existing sales order
+// of purchaseParams when its order lines differ from the ones last written, or
+// when none were recorded for the order. It reports whether the lines were
+// replaced. Coupon, reward and booking fee lines are
+// kept; Odoo recomputes the coupon lines afterwards, dropping the coupons that
+// no longer apply. Only quotations can change; a confirmed or cancelled order
+// gives model.ErrOrderLocked.
+func (r *repository) UpdateSaleOrderLines(ctx context.Context, purchaseParams model.PurchaseParams) (changed bool, err error) {
+	defer log.Info("[Odoo - Connector - UpdateSaleOrderLines] End")
+	log.Info("[Odoo - Connector - UpdateSaleOrderLines] Start")
+
+	orderId, _ := utils.StringToInt32(purchaseParams.SalesOrderID)
+	uId, _ := utils.StringToInt(purchaseParams.CustomerID)
+	dealerId, _ := utils.StringToInt(purchaseParams.DealerID)
+	lines, err := resolveOrderLines(purchaseParams)
+	if err != nil {
+		return false, err
+	}
+	if orderId == 0 || uId == 0 || len(lines) == 0 {
+		return false, nil
+	}
+
+	cart, err := r.qry.GetSalesOrderCart(ctx, orderId)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		// The order was created before its lines were recorded, so what it
+		// holds is unknown; replace its lines.
+		log.Info(fmt.Sprintf("[Odoo - Connector - UpdateSaleOrderLines] No lines recorded for sale order %d", orderId))
+	case err != nil:
+		return false, err
+	case cart.LinesHash == orderLinesHash(lines):
+		return false, nil
+	}
+
+	productLines, err := r.saleOrderProductLines(ctx, orderId)
+	if err != nil {
+		return false, err
+	}
+
+	products, status, err := r.resolveProductIds(ctx, dealerId, uId, lines)
+	if err != nil {
+		return false, err
+	}
+	if !status.ok() {
+		return false, errors.New(status.Message)
+	}
+
+	// (2, id) deletes a line, (0, 0, values) creates one.
+	commands := []interface{}{}
+	for _, id := range productLines {
+		commands = append(commands, []interface{}{2, id})
+	}
+	for i, line := range lines {
+		product := products[i]
+		commands = append(commands, []interface{}{0, 0, map[string]interface{}{
+			"product_id":      product.ProductID,
+			"name":            product.ProductName,
+			"product_uom":     product.UomID,
+			"product_uom_qty": line.Qty,
+			"price_unit":      product.UnitPrice,
+			"price_total":     product.UnitPrice * float64(line.Qty),
+		}})
+	}
+	log.Info(fmt.Sprintf("[Odoo - Connector - UpdateSaleOrderLines] Replace %d product lines of sale order %d", len(productLines), orderId))
+	if _, err = r.executeKw(ctx, "write", "sale.order", []interface{}{
+		[]interface{}{orderId},
+		map[string]interface{}{"order_line": commands},
+	}, nil); err != nil {
+		return false, err
+	}
+	if _, err = r.executeKw(ctx, "recompute_coupon_lines", "sale.order", []interface{}{
+		[]interface{}{orderId},
+	}, nil); err != nil {
+		return true, err
+	}
+
+	r.recordSaleOrderLines(ctx, orderId, lines)
+	return true, nil
+}
+
+// CancelSaleOrder cancels the sales order and releases the stock it holds.
+func (r *repository) CancelSaleOrder(ctx context.Context, salesOrderId int32) error {
+	defer log.Info("[Odoo - Connector - CancelSaleOrder] End")
+	log.Info("[Odoo - Connector - CancelSaleOrder] Start")
+
+	if _, err := r.executeKw(ctx, "action_cancel", "sale.order", []interface{}{
+		[]interface{}{salesOrderId},
+	}, nil); err != nil {
+		return err
+	}
+
+	return r.ReleaseStockReservation(ctx, salesOrderId, "", model.ReservationReleased)
+}
+
+// saleOrderProductLines returns the IDs of the product lines of the sales
+// order, leaving out coupon and reward lines, the booking fee and other
+// service lines, and section or note lines. It fails with model.ErrOrderLocked
+// unless the order is a quotation.
+func (r *repository) saleOrderProductLines(ctx context.Context, salesOrderId int32) (ids []interface{}, err error) {
+	orders, err := r.executeKw(ctx, "read", "sale.order", []interface{}{
+		[]interface{}{salesOrderId},
+	}, map[string]interface{}{"fields": []string{"state"}})
+	if err != nil {
+		return nil, err
+	}
+	records := rpcRecords(orders)
+	if len(records) == 0 {
+		return nil, fmt.Errorf("sale order %d not found", salesOrderId)
+	}
+	if state, _ := records[0]["state"].(string); state != "draft" && state != "sent" {
+		return nil, fmt.Errorf("%w: sale order %d is %s", model.ErrOrderLocked, salesOrderId, state)
+	}
+
+	lines, err := r.executeKw(ctx, "search_read", "sale.order.line", []interface{}{
+		[]interface{}{
+			[]interface{}{"order_id", "=", salesOrderId},
+			[]interface{}{"is_reward_line", "=", false},
+			[]interface{}{"display_type", "=", false},
+			[]interface{}{"product_type", "!=", "service"},
+		},
+	}, map[string]interface{}{"fields": []string{"id"}})
+	if err != nil {
+		return nil, err
+	}
+	for _, line := range rpcRecords(lines) {
+		ids = append(ids, line["id"])
+	}
+
+	return ids, nil
+}
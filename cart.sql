@@ -0,0 +1,19 @@
+-- Schema and sqlc queries for the order lines last written to each sales
+-- order, used to tell when a confirmation changes them.
+
+CREATE TABLE IF NOT EXISTS sales_order_carts (
+    sales_order_id INTEGER     PRIMARY KEY,
+    -- SHA-256 of the resolved order lines.
+    lines_hash     VARCHAR(64) NOT NULL,
+    updated_time   TIMESTAMP   NOT NULL DEFAULT NOW()
+);
+
+-- name: GetSalesOrderCart :one
+SELECT * FROM sales_order_carts
+WHERE sales_order_id = $1;
+
+-- name: UpsertSalesOrderCart :exec
+INSERT INTO sales_order_carts (sales_order_id, lines_hash)
+VALUES ($1, $2)
+ON CONFLICT (sales_order_id) DO UPDATE
+SET lines_hash = EXCLUDED.lines_hash, updated_time = NOW();
@@ -0,0 +1,118 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"reflect"
+	"testing"
+
+	"zebrax.id/emi/integration/erp/connector/odoo/model"
+	"zebrax.id/emi/integration/erp/connector/odoo/odootest"
+	"zebrax.id/emi/integration/erp/connector/odoo/repository/query"
+)
+
+func TestSaleOrderProductLines(t *testing.T) {
+	r, srv := newTestRepository(t)
+	state := "draft"
+	srv.Handle("sale.order", "read", func(call odootest.Call) (interface{}, error) {
+		return []interface{}{map[string]interface{}{"id": 15, "state": state}}, nil
+	})
+	srv.Handle("sale.order.line", "search_read", func(call odootest.Call) (interface{}, error) {
+		domain, _ := call.Args[0].([]interface{})
+		if len(domain) != 4 {
+			t.Errorf("domain = %v, want the order and the product line filters", domain)
+		}
+		return []interface{}{
+			map[string]interface{}{"id": 101},
+			map[string]interface{}{"id": 102},
+		}, nil
+	})
+
+	ids, err := r.saleOrderProductLines(context.Background(), 15)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []interface{}{101, 102}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("ids = %v, want %v", ids, want)
+	}
+
+	for _, state = range []string{"sale", "done", "cancel"} {
+		if _, err = r.saleOrderProductLines(context.Background(), 15); !errors.Is(err, model.ErrOrderLocked) {
+			t.Errorf("state %s: err = %v, want ErrOrderLocked", state, err)
+		}
+	}
+}
+
+// cartQueries keeps the recorded lines hash of one sales order and resolves
+// every product code to the same product.
+type cartQueries struct {
+	query.Querier
+	hash string
+}
+
+func (q *cartQueries) GetSalesOrderCart(ctx context.Context, salesOrderID int32) (query.SalesOrderCart, error) {
+	if q.hash == "" {
+		return query.SalesOrderCart{}, sql.ErrNoRows
+	}
+	return query.SalesOrderCart{SalesOrderID: salesOrderID, LinesHash: q.hash}, nil
+}
+
+func (q *cartQueries) UpsertSalesOrderCart(ctx context.Context, arg *query.UpsertSalesOrderCartParams) error {
+	q.hash = arg.LinesHash
+	return nil
+}
+
+func (q *cartQueries) GetProductId(ctx context.Context, arg *query.GetProductIdParams) (string, error) {
+	return "0|Searching Product Succesfully " + arg.FnGetProductIDV2_3 + "|104|33000000|1|EV-V Sporty Single Battery|4", nil
+}
+
+func TestUpdateSaleOrderLines(t *testing.T) {
+	r, srv := newTestRepository(t)
+	qry := &cartQueries{}
+	r.qry = qry
+	srv.Handle("sale.order", "read", func(call odootest.Call) (interface{}, error) {
+		return []interface{}{map[string]interface{}{"id": 15, "state": "draft"}}, nil
+	})
+	srv.Handle("sale.order.line", "search_read", func(call odootest.Call) (interface{}, error) {
+		return []interface{}{map[string]interface{}{"id": 101}}, nil
+	})
+	writes := 0
+	srv.Handle("sale.order", "write", func(call odootest.Call) (interface{}, error) {
+		writes++
+		return true, nil
+	})
+	srv.Handle("sale.order", "recompute_coupon_lines", func(call odootest.Call) (interface{}, error) {
+		return true, nil
+	})
+
+	params := model.PurchaseParams{
+		CustomerID:   "7",
+		DealerID:     "3",
+		SalesOrderID: "15",
+		Orders:       []model.Order{{ProductCode: "A11113", Qty: 1}},
+	}
+	tests := []struct {
+		name       string
+		qty        int32
+		wantChange bool
+	}{
+		{name: "no lines recorded", qty: 1, wantChange: true},
+		{name: "same lines", qty: 1, wantChange: false},
+		{name: "quantity changed", qty: 2, wantChange: true},
+	}
+	for _, tt := range tests {
+		params.Orders[0].Qty = tt.qty
+		before := writes
+		changed, err := r.UpdateSaleOrderLines(context.Background(), params)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if changed != tt.wantChange || (writes > before) != tt.wantChange {
+			t.Errorf("%s: changed = %v with %d writes, want %v", tt.name, changed, writes-before, tt.wantChange)
+		}
+		if qry.hash == "" {
+			t.Errorf("%s: lines not recorded", tt.name)
+		}
+	}
+}
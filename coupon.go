@@ -0,0 +1,163 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+	"zebrax.id/emi/integration/core/proto"
+	utils "zebrax.id/emi/integration/core/utils"
+	odooConnectorModel "zebrax.id/emi/integration/erp/connector/odoo/model"
+)
+
+// isVoucherError reports whether err rejects the vouchers of a request rather
+// than failing it.
+func isVoucherError(err error) bool {
+	return errors.Is(err, odooConnectorModel.ErrVoucherUnavailable) ||
+		errors.Is(err, odooConnectorModel.ErrVoucherStacking) ||
+		errors.Is(err, odooConnectorModel.ErrVoucherNotApplied)
+}
+
+// requestedVouchers collects VoucherIDs and the single VoucherID older clients
+// send.
+func requestedVouchers(in *proto.PurchaseParam) (voucherIDs []int32) {
+	for _, id := range append([]string{in.VoucherID}, in.VoucherIDs...) {
+		if voucherID, _ := utils.StringToInt32(id); voucherID != 0 {
+			voucherIDs = append(voucherIDs, voucherID)
+		}
+	}
+	return voucherIDs
+}
+
+// updateOrderLines writes changed order lines to the existing sales order and
+// checks its vouchers again, returning the ones that no longer apply.
+func (r *useCase) updateOrderLines(ctx context.Context, purchaseParams odooConnectorModel.PurchaseParams) ([]odooConnectorModel.VoucherEligibility, error) {
+	if len(purchaseParams.Orders) == 0 {
+		return nil, nil
+	}
+
+	changed, err := r.oRepo.UpdateSaleOrderLines(ctx, purchaseParams)
+	if err != nil || !changed {
+		return nil, err
+	}
+	return r.oRepo.RevalidateVouchers(ctx, purchaseParams)
+}
+
+// applyVouchers removes the vouchers of RemoveVoucherIDs from the sales order,
+// then applies the requested ones.
+func (r *useCase) applyVouchers(ctx context.Context, salesOrderID int32, in *proto.PurchaseParam) (orderConfirmation odooConnectorModel.OrderConfirmationResponses, err error) {
+	for _, id := range in.RemoveVoucherIDs {
+		voucherID, _ := utils.StringToInt32(id)
+		if orderConfirmation, err = r.oRepo.RemoveVoucher(ctx, salesOrderID, voucherID); err != nil {
+			return orderConfirmation, err
+		}
+	}
+
+	if voucherIDs := requestedVouchers(in); len(voucherIDs) > 0 {
+		return r.oRepo.SetVoucherRedeems(ctx, salesOrderID, voucherIDs)
+	}
+	return orderConfirmation, nil
+}
+
+// appliedVouchers lists the vouchers applied to the sales order with the
+// amount each takes off it.
+func (r *useCase) appliedVouchers(ctx context.Context, soID string) []*proto.AppliedVoucher {
+	list := []*proto.AppliedVoucher{}
+	salesOrderID, _ := utils.StringToInt32(soID)
+	if salesOrderID == 0 {
+		return list
+	}
+
+	vouchers, err := r.oRepo.GetVoucherReductions(ctx, salesOrderID)
+	if err != nil {
+		log.Error("[GetVoucherReductions] Error: ", err)
+		return list
+	}
+	for _, voucher := range vouchers {
+		list = append(list, &proto.AppliedVoucher{
+			VoucherID:   fmt.Sprintf("%d", voucher.VoucherID),
+			VoucherCode: voucher.VoucherCode,
+			Amount:      voucher.Amount.LegacyInt32(),
+			AmountMoney: protoMoney(voucher.Amount),
+		})
+	}
+	return list
+}
+
+// VoucherEligibility tells whether in.VoucherID can be applied to the sales
+// order of in, or to its order lines before the order exists, and the
+// discount it would give. Nothing is redeemed.
+func (r *useCase) VoucherEligibility(ctx context.Context, in *proto.PurchaseParam) (result *proto.VoucherEligibilityResponse, err error) {
+	log.Info("[Voucher Eligibility] Start")
+	defer log.Debug("[Voucher Eligibility] Response: ", result, err)
+
+	result = new(proto.VoucherEligibilityResponse)
+
+	purchaseParams := odooConnectorModel.PurchaseParams{}
+	utils.CopyObject(in, &purchaseParams)
+	voucherID, _ := utils.StringToInt32(in.VoucherID)
+
+	eligibility, err := r.oRepo.CheckVoucherEligibility(ctx, purchaseParams, voucherID)
+	if err != nil {
+		log.Error("[Voucher Eligibility] Error: ", err)
+		result.Status = utils.ConstructStatus(err, "Odoo Request Error", false)
+		return result, err
+	}
+
+	result.Status = utils.ConstructStatus(nil, eligibility.Message, true)
+	result.VoucherID = in.VoucherID
+	result.VoucherCode = eligibility.VoucherCode
+	result.Eligible = eligibility.Eligible
+	result.ReasonCode = string(eligibility.Reason)
+	result.Message = eligibility.Message
+	result.Discount = eligibility.Discount.LegacyInt32()
+	result.DiscountMoney = protoMoney(eligibility.Discount)
+	result.OrderTotalMoney = protoMoney(eligibility.OrderTotal)
+
+	return result, nil
+}
+
+func protoDroppedVouchers(dropped []odooConnectorModel.VoucherEligibility) []*proto.DroppedVoucher {
+	list := []*proto.DroppedVoucher{}
+	for _, voucher := range dropped {
+		list = append(list, &proto.DroppedVoucher{
+			VoucherID:   fmt.Sprintf("%d", voucher.VoucherID),
+			VoucherCode: voucher.VoucherCode,
+			ReasonCode:  string(voucher.Reason),
+			Message:     voucher.Message,
+		})
+	}
+	return list
+}
+
+// RemoveVoucher takes in.VoucherID off the sales order of in.
+func (r *useCase) RemoveVoucher(ctx context.Context, in *proto.PurchaseParam) (result *proto.PurchaseDetailResponse, err error) {
+	log.Info("[Remove Voucher] Start")
+	defer log.Info("[Remove Voucher] End")
+
+	result = new(proto.PurchaseDetailResponse)
+	salesOrderID, _ := utils.StringToInt32(in.SalesOrderID)
+	voucherID, _ := utils.StringToInt32(in.VoucherID)
+
+	orderConfirmation, err := r.oRepo.RemoveVoucher(ctx, salesOrderID, voucherID)
+	if isVoucherError(err) {
+		log.Info("[Remove Voucher] Voucher: ", err.Error())
+		result.Status = utils.ConstructStatus(err, err.Error(), false)
+		return result, nil
+	}
+	if err != nil {
+		log.Error("[Remove Voucher] Error: ", err)
+		return result, err
+	}
+
+	result.Status = utils.ConstructStatus(nil, orderConfirmation.Message, orderConfirmation.Code == "0")
+	result.OrderData, err = orderComponents(orderConfirmation)
+	if err != nil {
+		log.Error("[Remove Voucher] Order Amount Error: ", err)
+		result.Status = amountStatus(err)
+	}
+	result.OrderData.AppliedVouchers = r.appliedVouchers(ctx, in.SalesOrderID)
+
+	return result, nil
+}
@@ -0,0 +1,310 @@
+package usecase
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"zebrax.id/emi/integration/core/proto"
+	utils "zebrax.id/emi/integration/core/utils"
+	odooConnectorModel "zebrax.id/emi/integration/erp/connector/odoo/model"
+)
+
+// dealerFilter holds the DealerList search filters; zero fields match every
+// dealer.
+type dealerFilter struct {
+	City  string
+	State string
+	// MaxDistance is in kilometres.
+	MaxDistance float64
+	OpenNow     bool
+	ProductCode string
+	Text        string
+}
+
+func newDealerFilter(in *proto.DealerListParams) dealerFilter {
+	return dealerFilter{
+		City:        strings.TrimSpace(in.City),
+		State:       strings.TrimSpace(in.State),
+		MaxDistance: in.MaxDistance,
+		OpenNow:     in.OpenNow,
+		ProductCode: strings.TrimSpace(in.ProductCode),
+		Text:        strings.ToLower(strings.TrimSpace(in.Query)),
+	}
+}
+
+// matches applies the filters that need no further lookup.
+func (f dealerFilter) matches(dealer odooConnectorModel.Dealer, now time.Time) bool {
+	if f.City != "" && !strings.EqualFold(strings.TrimSpace(dealer.City), f.City) {
+		return false
+	}
+	if f.State != "" && !strings.EqualFold(strings.TrimSpace(dealer.Province), f.State) {
+		return false
+	}
+	if f.MaxDistance > 0 && dealer.DistanceKm() > f.MaxDistance {
+		return false
+	}
+	if f.Text != "" {
+		haystack := strings.ToLower(strings.Join([]string{dealer.Name, dealer.Address1, dealer.Address2, dealer.City}, " "))
+		if !strings.Contains(haystack, f.Text) {
+			return false
+		}
+	}
+	if f.OpenNow {
+		schedule, err := odooConnectorModel.ParseOperatingHours(dealer.OperatingHours)
+		if err != nil || !schedule.In(odooConnectorModel.DealerLocation(dealer.Province, dealer.Longitude)).OpenAt(now) {
+			return false
+		}
+	}
+	return true
+}
+
+// filterDealers keeps the dealers matching f. The stock filter runs last, on
+// the dealers left, as it asks Odoo once per dealer; a dealer whose stock
+// cannot be read is left out rather than failing the list. It reads the
+// variant matrix of the product: the stock of a product code without its
+// attributes matches no variant.
+func (r *useCase) filterDealers(ctx context.Context, dealers []odooConnectorModel.Dealer, f dealerFilter) (filtered []odooConnectorModel.Dealer, err error) {
+	now := time.Now()
+	for _, dealer := range dealers {
+		if f.matches(dealer, now) {
+			filtered = append(filtered, dealer)
+		}
+	}
+	if f.ProductCode == "" || len(filtered) == 0 {
+		return filtered, nil
+	}
+
+	stocks := r.dealerStocks(filtered, func(dealerID int) (float64, error) {
+		return r.dealerProductStock(ctx, dealerID, f.ProductCode)
+	})
+	stocked := filtered[:0]
+	for i, dealer := range filtered {
+		if stocks[i].err == nil && stocks[i].qty > 0 {
+			stocked = append(stocked, dealer)
+		}
+	}
+
+	return stocked, nil
+}
+
+// maxDealerStockLookups bounds the stock lookups one request runs at once.
+const maxDealerStockLookups = 8
+
+type dealerStockResult struct {
+	qty float64
+	err error
+}
+
+// dealerStocks runs lookup for every dealer, at most maxDealerStockLookups at
+// a time, returning the results in the order of dealers.
+func (r *useCase) dealerStocks(dealers []odooConnectorModel.Dealer, lookup func(dealerID int) (float64, error)) []dealerStockResult {
+	results := make([]dealerStockResult, len(dealers))
+	slots := make(chan struct{}, maxDealerStockLookups)
+
+	var wg sync.WaitGroup
+	for i, dealer := range dealers {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(i int, dealerID int) {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+			qty, err := lookup(dealerID)
+			results[i] = dealerStockResult{qty: qty, err: err}
+		}(i, dealer.Id)
+	}
+	wg.Wait()
+
+	return results
+}
+
+// dealerProductStock returns how many units of any variant of productCode the
+// dealer has available.
+func (r *useCase) dealerProductStock(ctx context.Context, dealerID int, productCode string) (float64, error) {
+	matrices, err := r.oRepo.GetProductVariantMatrix(ctx, int32(dealerID), productCode)
+	if err != nil {
+		log.Info(fmt.Sprintf("[DealerStock] Variant Matrix of dealer %d Error : %s", dealerID, err.Error()))
+		return 0, err
+	}
+
+	var (
+		qty     float64
+		unknown bool
+	)
+	for _, matrix := range matrices {
+		for _, variant := range matrix.Variants {
+			unknown = unknown || variant.StockUnknown
+			if variant.Available && variant.Stock > 0 {
+				qty += float64(variant.Stock)
+			}
+		}
+	}
+	if qty == 0 && unknown {
+		return 0, fmt.Errorf("stock of %s at dealer %d is unknown", productCode, dealerID)
+	}
+	return qty, nil
+}
+
+// dealerStock returns how many units of the variant of order the dealer has
+// available.
+func (r *useCase) dealerStock(ctx context.Context, dealerID int, order odooConnectorModel.Order) (float64, error) {
+	stocks, err := r.oRepo.GetProductStock(ctx, odooConnectorModel.PurchaseParams{
+		DealerID: fmt.Sprintf("%d", dealerID),
+		Orders:   []odooConnectorModel.Order{order},
+	})
+	if err != nil {
+		log.Info(fmt.Sprintf("[DealerStock] Product Stock of dealer %d Error : %s", dealerID, err.Error()))
+		return 0, err
+	}
+
+	for _, stock := range stocks {
+		qty, _ := strconv.ParseFloat(strings.TrimSpace(stock.Qty), 64)
+		if stock.Code == "0" && qty > 0 {
+			return qty, nil
+		}
+	}
+	return 0, nil
+}
+
+// DefaultNearbyStockDealers is how many dealers NearbyStock checks when the
+// request sets no limit.
+const DefaultNearbyStockDealers = 10
+
+// NearbyStock returns the stock of a product variant at the dealers nearest to
+// the customer, ranked by distance, so the app can suggest another dealer when
+// the chosen one has none. Distances, and in.MaxDistance, are in kilometres.
+func (r *useCase) NearbyStock(ctx context.Context, in *proto.NearbyStockParams) (result *proto.NearbyStockResponse, err error) {
+	log.Info("[NearbyStock] Start")
+	defer log.Debug("[NearbyStock] Response: ", result, err)
+
+	result = &proto.NearbyStockResponse{Dealers: []*proto.DealerStock{}}
+	if strings.TrimSpace(in.ProductCode) == "" {
+		result.Status = utils.ConstructStatus(nil, "product code is required", false)
+		return result, nil
+	}
+
+	dealers, err := r.oRepo.GetDealerAndDefault(ctx, in.OdooID, in.Longitude, in.Latitude)
+	if err != nil {
+		result.Status = utils.ConstructStatus(err, "Odoo Request Error", false)
+		return result, err
+	}
+	distances := make(map[int]float64, len(dealers))
+	for _, dealer := range dealers {
+		distances[dealer.Id] = dealerDistanceKm(dealer, in.Latitude, in.Longitude)
+	}
+	sort.SliceStable(dealers, func(i, j int) bool {
+		return distances[dealers[i].Id] < distances[dealers[j].Id]
+	})
+
+	limit := int(in.Limit)
+	if limit <= 0 {
+		limit = DefaultNearbyStockDealers
+	}
+	if limit > odooConnectorModel.MaxPageSize {
+		limit = odooConnectorModel.MaxPageSize
+	}
+
+	n := 0
+	for n < len(dealers) && n < limit && (in.MaxDistance <= 0 || distances[dealers[n].Id] <= in.MaxDistance) {
+		n++
+	}
+	nearby := dealers[:n]
+
+	order := odooConnectorModel.Order{ProductCode: strings.TrimSpace(in.ProductCode), Qty: 1}
+	utils.CopyObject(in.Attributes, &order.Attributes)
+	stocks := r.dealerStocks(nearby, func(dealerID int) (float64, error) {
+		return r.dealerStock(ctx, dealerID, order)
+	})
+
+	now := time.Now()
+	for i, dealer := range nearby {
+		if stocks[i].err != nil {
+			// One dealer failing should not hide the others.
+			continue
+		}
+		result.Dealers = append(result.Dealers, &proto.DealerStock{
+			Dealer:    protoDealer(dealer, now),
+			Qty:       int32(stocks[i].qty),
+			Available: stocks[i].qty > 0,
+		})
+	}
+
+	result.Status = utils.ConstructStatus(nil, "", true)
+	return result, nil
+}
+
+// dealerDistanceKm is the distance in kilometres from the customer at latitude
+// and longitude to the dealer, or the distance Odoo reports when either
+// position cannot be read.
+func dealerDistanceKm(dealer odooConnectorModel.Dealer, latitude string, longitude string) float64 {
+	from, err := odooConnectorModel.ParseGeoPoint(latitude, longitude)
+	if err != nil {
+		return dealer.DistanceKm()
+	}
+	to, err := odooConnectorModel.ParseGeoPoint(dealer.Latitude, dealer.Longitude)
+	if err != nil {
+		return dealer.DistanceKm()
+	}
+	return odooConnectorModel.DistanceKm(from, to)
+}
+
+// protoDealer converts a dealer, with its operating hours read at now.
+func protoDealer(each odooConnectorModel.Dealer, now time.Time) *proto.DealerData {
+	zipCode, _ := utils.StringToInt32(each.ZipCode)
+	dealer := &proto.DealerData{
+		Id:             int32(each.Id),
+		Location:       each.Name,
+		Address1:       each.Address1,
+		Address2:       each.Address2,
+		City:           each.City,
+		State:          each.Province,
+		Country:        each.Country,
+		Latitude:       each.Latitude,
+		Longitude:      each.Longitude,
+		OperatingHours: each.OperatingHours,
+		ZipCode:        zipCode,
+		Code:           each.Code,
+		Distance:       each.Distance,
+		DistanceLable:  each.DistanceUnit,
+		Default:        each.Default,
+	}
+	if schedule, ok := odooConnectorModel.ParseOperatingSchedule(each.OperatingHours, odooConnectorModel.DealerLocation(each.Province, each.Longitude), now); ok {
+		dealer.Schedule = protoOperatingSchedule(schedule)
+		dealer.OpenNow = schedule.OpenNow
+		if !schedule.NextOpening.IsZero() {
+			dealer.NextOpeningTime = schedule.NextOpening.Format(time.RFC3339)
+		}
+	}
+	return dealer
+}
+
+// protoOperatingSchedule converts the structured operating hours of a dealer.
+func protoOperatingSchedule(schedule odooConnectorModel.OperatingSchedule) *proto.OperatingSchedule {
+	result := &proto.OperatingSchedule{
+		HolidaySet: schedule.HolidaySet,
+		Holiday:    protoOpeningPeriods(schedule.Holiday),
+	}
+	for _, day := range schedule.Days {
+		result.Days = append(result.Days, &proto.OpeningDay{
+			Weekday: int32(day.Weekday),
+			Day:     day.Weekday.String(),
+			Periods: protoOpeningPeriods(day.Periods),
+		})
+	}
+	return result
+}
+
+func protoOpeningPeriods(periods []odooConnectorModel.OpeningPeriod) []*proto.OpeningPeriod {
+	list := []*proto.OpeningPeriod{}
+	for _, period := range periods {
+		list = append(list, &proto.OpeningPeriod{Open: period.Open, Close: period.Close})
+	}
+	return list
+}
@@ -0,0 +1,238 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"math"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"zebrax.id/emi/integration/core/proto"
+	odooConnectorModel "zebrax.id/emi/integration/erp/connector/odoo/model"
+)
+
+func TestDealerDistanceKm(t *testing.T) {
+	// Monas to Bundaran HI, about 2.2 km.
+	dealer := odooConnectorModel.Dealer{Latitude: "-6.1950", Longitude: "106.8230", Distance: 2200, DistanceUnit: "m"}
+
+	tests := []struct {
+		name      string
+		latitude  string
+		longitude string
+		want      float64
+	}{
+		{name: "from coordinates", latitude: "-6.1754", longitude: "106.8272", want: 2.26},
+		{name: "reported meters without a position", latitude: "", longitude: "", want: 2.2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := dealerDistanceKm(dealer, tt.latitude, tt.longitude); math.Abs(got-tt.want) > 0.05 {
+				t.Errorf("distance = %.2f km, want %.2f km", got, tt.want)
+			}
+		})
+	}
+
+	dealer.Latitude = "somewhere"
+	if got := dealerDistanceKm(dealer, "-6.1754", "106.8272"); got != 2.2 {
+		t.Errorf("dealer without a position: distance = %.2f km, want 2.20 km", got)
+	}
+}
+
+func TestDealerFilterMatches(t *testing.T) {
+	dealer := odooConnectorModel.Dealer{
+		Id:             1,
+		Name:           "Zebrax Bintaro",
+		Address1:       "Jl. Al Hidayah No.44",
+		City:           "Kota Tangerang Selatan",
+		Province:       "Banten",
+		Longitude:      "106.72046",
+		Distance:       12.5,
+		DistanceUnit:   "km",
+		OperatingHours: "Mon - Fri 09.00 - 17.00",
+	}
+	// A Monday at 10:00 and 18:00 WIB.
+	open := time.Date(2022, 3, 7, 10, 0, 0, 0, odooConnectorModel.WIB)
+	closed := time.Date(2022, 3, 7, 18, 0, 0, 0, odooConnectorModel.WIB)
+
+	tests := []struct {
+		name   string
+		filter dealerFilter
+		now    time.Time
+		want   bool
+	}{
+		{name: "no filter", filter: dealerFilter{}, now: closed, want: true},
+		{name: "city in another case", filter: dealerFilter{City: "kota tangerang selatan"}, now: open, want: true},
+		{name: "other city", filter: dealerFilter{City: "Jakarta Selatan"}, now: open},
+		{name: "state", filter: dealerFilter{State: "BANTEN"}, now: open, want: true},
+		{name: "other state", filter: dealerFilter{State: "DKI Jakarta"}, now: open},
+		{name: "within distance", filter: dealerFilter{MaxDistance: 15}, now: open, want: true},
+		{name: "too far", filter: dealerFilter{MaxDistance: 10}, now: open},
+		{name: "text in the name", filter: dealerFilter{Text: "bintaro"}, now: open, want: true},
+		{name: "text in the address", filter: dealerFilter{Text: "al hidayah"}, now: open, want: true},
+		{name: "text nowhere", filter: dealerFilter{Text: "kemang"}, now: open},
+		{name: "open now", filter: dealerFilter{OpenNow: true}, now: open, want: true},
+		{name: "closed now", filter: dealerFilter{OpenNow: true}, now: closed},
+		{name: "every filter", filter: dealerFilter{City: "Kota Tangerang Selatan", State: "Banten", MaxDistance: 15, Text: "zebrax", OpenNow: true}, now: open, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.filter.matches(dealer, tt.now); got != tt.want {
+				t.Errorf("matches = %t, want %t", got, tt.want)
+			}
+		})
+	}
+
+	dealer.OperatingHours = "By appointment"
+	if (dealerFilter{OpenNow: true}).matches(dealer, open) {
+		t.Error("dealer with unreadable hours matched open now")
+	}
+}
+
+// dealerStockRepo keeps the variant matrix of every dealer. Like
+// fn_get_product_stock, GetProductStock finds no stock for a product code
+// without the attributes of a variant.
+type dealerStockRepo struct {
+	odooRepo
+	dealers  []odooConnectorModel.Dealer
+	matrices map[int32][]odooConnectorModel.ProductTemplateMatrix
+}
+
+func (f *dealerStockRepo) GetDealerAndDefault(ctx context.Context, odooID int32, lon, lat string) ([]odooConnectorModel.Dealer, error) {
+	return append([]odooConnectorModel.Dealer(nil), f.dealers...), nil
+}
+
+func (f *dealerStockRepo) GetProductVariantMatrix(ctx context.Context, dealerId int32, productCode string) ([]odooConnectorModel.ProductTemplateMatrix, error) {
+	matrices, ok := f.matrices[dealerId]
+	if !ok {
+		return nil, errors.New("dealer unavailable")
+	}
+	return matrices, nil
+}
+
+func (f *dealerStockRepo) GetProductStock(ctx context.Context, p odooConnectorModel.PurchaseParams) (stocks []odooConnectorModel.PurchaseStock, err error) {
+	matrices, ok := f.matrices[int32(dealerIDOf(p.DealerID))]
+	if !ok {
+		return nil, errors.New("dealer unavailable")
+	}
+	for _, order := range p.Orders {
+		stock := odooConnectorModel.PurchaseStock{Code: "1"}
+		for _, matrix := range matrices {
+			for _, variant := range matrix.Variants {
+				if len(order.Attributes) > 0 && reflect.DeepEqual(variant.Attributes, order.Attributes) {
+					stock = odooConnectorModel.PurchaseStock{Code: "0", Qty: strconv.Itoa(variant.Stock)}
+				}
+			}
+		}
+		stocks = append(stocks, stock)
+	}
+	return stocks, nil
+}
+
+func dealerIDOf(id string) int {
+	dealerID, _ := strconv.Atoi(id)
+	return dealerID
+}
+
+func stockMatrix(stocks ...int) []odooConnectorModel.ProductTemplateMatrix {
+	matrix := odooConnectorModel.ProductTemplateMatrix{}
+	for i, stock := range stocks {
+		matrix.Variants = append(matrix.Variants, odooConnectorModel.VariantCombination{
+			Attributes: []odooConnectorModel.Attribute{{AttributeID: "1", VariantID: strconv.Itoa(i + 1)}},
+			Stock:      stock,
+			Available:  true,
+		})
+	}
+	return []odooConnectorModel.ProductTemplateMatrix{matrix}
+}
+
+func TestFilterDealersByStock(t *testing.T) {
+	unknown := stockMatrix(0)
+	unknown[0].Variants[0].StockUnknown = true
+	repo := &dealerStockRepo{matrices: map[int32][]odooConnectorModel.ProductTemplateMatrix{
+		1: stockMatrix(0, 2),
+		2: stockMatrix(0, 0),
+		4: unknown,
+		5: stockMatrix(1),
+	}}
+	r := &useCase{oRepo: repo}
+
+	var dealers []odooConnectorModel.Dealer
+	for _, id := range []int{1, 2, 3, 4, 5} {
+		dealers = append(dealers, odooConnectorModel.Dealer{Id: id})
+	}
+
+	// The product code alone finds no stock anywhere.
+	for _, dealer := range dealers {
+		if qty, _ := r.dealerStock(context.Background(), dealer.Id, odooConnectorModel.Order{ProductCode: "EV-V", Qty: 1}); qty != 0 {
+			t.Fatalf("bare product code: dealer %d stock = %v, want 0", dealer.Id, qty)
+		}
+	}
+
+	filtered, err := r.filterDealers(context.Background(), dealers, dealerFilter{ProductCode: "EV-V"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []int
+	for _, dealer := range filtered {
+		ids = append(ids, dealer.Id)
+	}
+	if want := []int{1, 5}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("dealers with stock = %v, want %v", ids, want)
+	}
+}
+
+func TestNearbyStock(t *testing.T) {
+	// Dealers 1 km apart going east from the customer, listed out of order.
+	dealer := func(id int, km float64) odooConnectorModel.Dealer {
+		return odooConnectorModel.Dealer{Id: id, Latitude: "0", Longitude: strconv.FormatFloat(km/111.195, 'f', 6, 64)}
+	}
+	repo := &dealerStockRepo{
+		dealers: []odooConnectorModel.Dealer{dealer(3, 3), dealer(1, 1), dealer(5, 5), dealer(2, 2), dealer(4, 4)},
+		matrices: map[int32][]odooConnectorModel.ProductTemplateMatrix{
+			1: stockMatrix(0),
+			3: stockMatrix(2),
+			4: stockMatrix(1),
+			5: stockMatrix(7),
+		},
+	}
+	r := &useCase{oRepo: repo}
+	in := &proto.NearbyStockParams{
+		Latitude:    "0",
+		Longitude:   "0",
+		ProductCode: "EV-V",
+		Attributes:  []*proto.Attribute{{AttributeID: "1", VariantID: "1"}},
+	}
+
+	tests := []struct {
+		name        string
+		limit       int32
+		maxDistance float64
+		want        []int32
+	}{
+		// Dealer 2 has no matrix, so its stock lookup fails and it is left out.
+		{name: "all dealers", want: []int32{1, 3, 4, 5}},
+		{name: "limit before lookup", limit: 3, want: []int32{1, 3}},
+		{name: "max distance", maxDistance: 3.5, want: []int32{1, 3}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			in.Limit, in.MaxDistance = tt.limit, tt.maxDistance
+			result, err := r.NearbyStock(context.Background(), in)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var ids []int32
+			for _, stock := range result.Dealers {
+				ids = append(ids, stock.Dealer.Id)
+				if stock.Available != (stock.Qty > 0) {
+					t.Errorf("dealer %d: %d in stock, available %v", stock.Dealer.Id, stock.Qty, stock.Available)
+				}
+			}
+			if !reflect.DeepEqual(ids, tt.want) {
+				t.Errorf("dealers = %v, want %v", ids, tt.want)
+			}
+		})
+	}
+}
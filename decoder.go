@@ -0,0 +1,275 @@
+package repository
+
+import (
+	"encoding"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"zebrax.id/emi/integration/core/utils"
+	"zebrax.id/emi/integration/erp/connector/odoo/model"
+)
+
+// odooSuccessCode is the status code the Odoo stored functions put in the
+// first field of their output when the call succeeded.
+const odooSuccessCode = "0"
+
+// odooStatus is the leading "code|message" pair shared by every pipe-delimited
+// result. Result structs embed it so callers can check the outcome before
+// reading the payload fields.
+type odooStatus struct {
+	Code    string `odoo:"0"`
+	Message string `odoo:"1"`
+}
+
+func (s odooStatus) ok() bool {
+	return s.Code == odooSuccessCode
+}
+
+// DecodeError describes why a stored function output could not be decoded.
+type DecodeError struct {
+	Function string
+	Field    string
+	Index    int
+	Value    string
+	Got      int
+	Want     int
+	Err      error
+}
+
+func (e *DecodeError) Error() string {
+	if e.Err == nil {
+		return fmt.Sprintf("odoo: %s returned %d fields, want at least %d", e.Function, e.Got, e.Want)
+	}
+
+	return fmt.Sprintf("odoo: %s field %s (index %d) value %q: %s", e.Function, e.Field, e.Index, e.Value, e.Err.Error())
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// decodeOdooResult splits raw on utils.ConnectorOdooSeparator and assigns each
+// item to the field of dst tagged with its position, e.g. `odoo:"2"`.
+//
+// dst must be a pointer to a struct embedding odooStatus. When the status code
+// is not successful only the status is decoded, because the stored functions
+// leave the remaining fields empty on error. Empty items decode to the zero
+// value of numeric fields since that is how the functions report NULL. Fields
+// implementing encoding.TextUnmarshaler, such as model.Money, decode themselves.
+func decodeOdooResult(function string, raw string, dst interface{}) error {
+	rv := reflect.ValueOf(dst)
+	if rv.Kind() != reflect.Ptr || rv.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("odoo: decode %s into non-struct pointer %T", function, dst)
+	}
+
+	items := strings.Split(raw, utils.ConnectorOdooSeparator)
+	fields := odooFields(rv.Elem())
+
+	status := odooStatus{}
+	if len(items) >= 2 {
+		status = odooStatus{Code: items[0], Message: items[1]}
+	}
+	want := 2
+	if status.ok() {
+		for _, f := range fields {
+			if f.index+1 > want {
+				want = f.index + 1
+			}
+		}
+	}
+	if len(items) < want {
+		return &DecodeError{Function: function, Got: len(items), Want: want}
+	}
+
+	for _, f := range fields {
+		if f.index >= want {
+			continue
+		}
+		if err := setOdooField(f.value, items[f.index]); err != nil {
+			return &DecodeError{Function: function, Field: f.name, Index: f.index, Value: items[f.index], Err: err}
+		}
+	}
+
+	return nil
+}
+
+type odooField struct {
+	name  string
+	index int
+	value reflect.Value
+}
+
+// odooFields collects the tagged fields of v, descending into embedded structs.
+func odooFields(v reflect.Value) (fields []odooField) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if sf.Anonymous && sf.Type.Kind() == reflect.Struct {
+			fields = append(fields, odooFields(v.Field(i))...)
+			continue
+		}
+
+		tag, ok := sf.Tag.Lookup("odoo")
+		if !ok {
+			continue
+		}
+		index, err := strconv.Atoi(tag)
+		if err != nil || index < 0 {
+			panic(fmt.Sprintf("odoo: invalid position tag %q on %s.%s", tag, t.Name(), sf.Name))
+		}
+		fields = append(fields, odooField{name: sf.Name, index: index, value: v.Field(i)})
+	}
+
+	return fields
+}
+
+func setOdooField(v reflect.Value, item string) error {
+	item = strings.TrimSpace(item)
+
+	if u, ok := v.Addr().Interface().(encoding.TextUnmarshaler); ok {
+		return u.UnmarshalText([]byte(item))
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(item)
+	case reflect.Int, reflect.Int32, reflect.Int64:
+		if item == "" {
+			v.SetInt(0)
+			return nil
+		}
+		n, err := strconv.ParseInt(item, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Float64:
+		if item == "" {
+			v.SetFloat(0)
+			return nil
+		}
+		n, err := strconv.ParseFloat(item, 64)
+		if err != nil {
+			return err
+		}
+		v.SetFloat(n)
+	case reflect.Bool:
+		// The functions flag with Y or N, and leave NULL flags empty.
+		switch item {
+		case "Y":
+			v.SetBool(true)
+		case "N", "":
+			v.SetBool(false)
+		default:
+			return fmt.Errorf("invalid flag, want Y or N")
+		}
+	default:
+		return fmt.Errorf("unsupported field type %s", v.Type())
+	}
+
+	return nil
+}
+
+// bookingTestDriveResult is the output of fn_booking_testdrive_v2,
+// fn_booking_testdrive_onwheels_v2 and fn_booking_testdrive_reschedule_v2.
+// Sample : "0|Inserting Succesfully TD/D0202/22/00187|733|1|Product 1|TD/D0202/22/00187|2022-03-01|2022-03-01T11:00:00+07:00|2022-03-01T12:00:00+07:00|1|Indy Office Bintaro|Jl. Al Hidayah No.44, Pd. Jaya, Kec. Pd. Aren |-6.27466|106.72046|Kota Tangerang Selatan|Banten|Indonesia|Everydays 10.00 - 18.00"
+type bookingTestDriveResult struct {
+	odooStatus
+	BookingID      int    `odoo:"2"`
+	ProductID      string `odoo:"3"`
+	ProductName    string `odoo:"4"`
+	BookingCode    string `odoo:"5"`
+	Date           string `odoo:"6"`
+	StartTime      string `odoo:"7"`
+	EndTime        string `odoo:"8"`
+	LocationID     string `odoo:"9"`
+	LocationName   string `odoo:"10"`
+	Address        string `odoo:"11"`
+	Latitude       string `odoo:"12"`
+	Longitude      string `odoo:"13"`
+	City           string `odoo:"14"`
+	State          string `odoo:"15"`
+	Country        string `odoo:"16"`
+	OperatingHours string `odoo:"17"`
+}
+
+// fill copies the decoded booking into the connector response.
+func (b bookingTestDriveResult) fill(list *model.BookingTestDriveResponse) {
+	list.ProductID = b.ProductID
+	list.ProductName = b.ProductName
+	list.BookingID = fmt.Sprintf("%d", b.BookingID)
+	list.BookingCode = b.BookingCode
+	list.Date = b.Date
+	list.StartTime = b.StartTime
+	list.EndTime = b.EndTime
+	list.LocationID = b.LocationID
+	list.LocationName = b.LocationName
+	list.Address = b.Address
+	list.Longitude = b.Longitude
+	list.Latitude = b.Latitude
+	list.City = b.City
+	list.State = b.State
+	list.Country = b.Country
+	list.OperatingHours = b.OperatingHours
+}
+
+// cancelBookingTestDriveResult is the output of sp_booking_testdrive_cancel.
+type cancelBookingTestDriveResult struct {
+	odooStatus
+}
+
+// productIdResult is the output of fn_get_product_id_v2.
+// Sample : "0|Searching Product Succesfully A11113|104|33000000|1|EV-V Sporty Single Battery|4"
+type productIdResult struct {
+	odooStatus
+	ProductID   int     `odoo:"2"`
+	UnitPrice   float64 `odoo:"3"`
+	UomID       int     `odoo:"4"`
+	ProductName string  `odoo:"5"`
+	PriceListID int     `odoo:"6"`
+}
+
+// productGuestResult is the output of fn_get_product_id_guest.
+// Sample : "0|Searching Product Succesfully A11113|104|33000000|1|EV-V Sporty Single Battery|4|A11113|29|Grey|1|Color|30.000.000||0|33000000||0|3000000|30000000|30000000"
+type productGuestResult struct {
+	odooStatus
+	UnitPrice      model.Money `odoo:"3"`
+	ProductName    string      `odoo:"5"`
+	VariantID      string      `odoo:"8"`
+	VariantName    string      `odoo:"9"`
+	AttributeID    string      `odoo:"10"`
+	AttributeName  string      `odoo:"11"`
+	PriceLabel     string      `odoo:"12"`
+	ReductionName  string      `odoo:"13"`
+	ReductionValue model.Money `odoo:"14"`
+	GrandTotal     model.Money `odoo:"15"`
+	ReductionLabel string      `odoo:"17"`
+	Tax            model.Money `odoo:"18"`
+	AmountUntaxed  model.Money `odoo:"19"`
+	PurchaseTotal  model.Money `odoo:"20"`
+}
+
+// productStockResult is the output of fn_get_product_stock.
+type productStockResult struct {
+	odooStatus
+	ProductPrice string `odoo:"3"`
+	Qty          string `odoo:"7"`
+	ProductCode  string `odoo:"8"`
+}
+
+// voucherCodeResult is the output of fn_get_voucher_code.
+// Sample : "0|Searching Get Succesfully 6968773680224492744|6968773680224492744|Y"
+type voucherCodeResult struct {
+	odooStatus
+	VoucherCode string `odoo:"2"`
+	Redeemed    bool   `odoo:"3"`
+}
+
+// voucherLineResult is the output of fn_get_voucher_line.
+// Sample : "0|Searching Get Succesfully |10900|15"
+type voucherLineResult struct {
+	odooStatus
+	LineID int `odoo:"2"`
+}
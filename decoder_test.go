@@ -0,0 +1,138 @@
+package repository
+
+import (
+	"errors"
+	"testing"
+
+	"zebrax.id/emi/integration/erp/connector/odoo/model"
+)
+
+func TestDecodeOdooResult(t *testing.T) {
+	tests := []struct {
+		name    string
+		raw     string
+		want    productIdResult
+		wantErr bool
+	}{
+		{
+			name: "success",
+			raw:  "0|Searching Product Succesfully A11113|104|33000000|1|EV-V Sporty Single Battery|4",
+			want: productIdResult{
+				odooStatus:  odooStatus{Code: "0", Message: "Searching Product Succesfully A11113"},
+				ProductID:   104,
+				UnitPrice:   33000000,
+				UomID:       1,
+				ProductName: "EV-V Sporty Single Battery",
+				PriceListID: 4,
+			},
+		},
+		{
+			name: "empty numeric items are zero",
+			raw:  "0|Searching Product Succesfully A11113|104||1|EV-V Sporty Single Battery|",
+			want: productIdResult{
+				odooStatus:  odooStatus{Code: "0", Message: "Searching Product Succesfully A11113"},
+				ProductID:   104,
+				UomID:       1,
+				ProductName: "EV-V Sporty Single Battery",
+			},
+		},
+		{
+			name: "error status decodes only the status",
+			raw:  "1| Product not found",
+			want: productIdResult{odooStatus: odooStatus{Code: "1", Message: "Product not found"}},
+		},
+		{
+			name:    "success with missing fields",
+			raw:     "0|Searching Product Succesfully A11113|104|33000000",
+			wantErr: true,
+		},
+		{
+			name:    "invalid number",
+			raw:     "0|Searching Product Succesfully A11113|abc|33000000|1|EV-V Sporty Single Battery|4",
+			wantErr: true,
+		},
+		{
+			name:    "no status",
+			raw:     "",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got productIdResult
+			err := decodeOdooResult("fn_get_product_id_v2", tt.raw, &got)
+			if tt.wantErr {
+				var decodeErr *DecodeError
+				if !errors.As(err, &decodeErr) {
+					t.Fatalf("err = %v, want a *DecodeError", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeOdooResultMoneyAndBool(t *testing.T) {
+	var guest productGuestResult
+	raw := "0|Searching Product Succesfully A11113|104|33000000|1|EV-V Sporty Single Battery|4|A11113|29|Grey|1|Color|30.000.000||0|33000000||0|3000000|30000000|30000000"
+	if err := decodeOdooResult("fn_get_product_id_guest", raw, &guest); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := model.NewMoney(33000000, model.IDR); guest.UnitPrice != want {
+		t.Errorf("UnitPrice = %v, want %v", guest.UnitPrice, want)
+	}
+	if want := model.NewMoney(3000000, model.IDR); guest.Tax != want {
+		t.Errorf("Tax = %v, want %v", guest.Tax, want)
+	}
+	if !guest.ReductionValue.IsZero() {
+		t.Errorf("ReductionValue = %v, want zero", guest.ReductionValue)
+	}
+
+	for _, redeemed := range []string{"Y", "N", ""} {
+		var voucher voucherCodeResult
+		raw := "0|Searching Get Succesfully 6968773680224492744|6968773680224492744|" + redeemed
+		if err := decodeOdooResult("fn_get_voucher_code", raw, &voucher); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if voucher.Redeemed != (redeemed == "Y") {
+			t.Errorf("Redeemed = %t for %q", voucher.Redeemed, redeemed)
+		}
+	}
+}
+
+func TestDecodeOdooResultInvalidBool(t *testing.T) {
+	for _, redeemed := range []string{"y", "1", "yes", "T"} {
+		var voucher voucherCodeResult
+		raw := "0|Searching Get Succesfully 6968773680224492744|6968773680224492744|" + redeemed
+		err := decodeOdooResult("fn_get_voucher_code", raw, &voucher)
+
+		var decodeErr *DecodeError
+		if !errors.As(err, &decodeErr) || decodeErr.Field != "Redeemed" {
+			t.Errorf("%q: err = %v, want a *DecodeError on Redeemed", redeemed, err)
+		}
+	}
+}
+
+func TestDecodeOdooResultInvalidMoney(t *testing.T) {
+	var guest productGuestResult
+	raw := "0|msg|104|33.00.00|1|name|4|A11113|29|Grey|1|Color|label||0|0||0|0|0|0"
+	err := decodeOdooResult("fn_get_product_id_guest", raw, &guest)
+
+	var decodeErr *DecodeError
+	if !errors.As(err, &decodeErr) {
+		t.Fatalf("err = %v, want a *DecodeError", err)
+	}
+	if decodeErr.Field != "UnitPrice" || decodeErr.Index != 3 {
+		t.Errorf("error on %s (index %d), want UnitPrice (index 3)", decodeErr.Field, decodeErr.Index)
+	}
+	if !errors.Is(err, model.ErrInvalidAmount) {
+		t.Errorf("err = %v, want it to wrap model.ErrInvalidAmount", err)
+	}
+}
@@ -0,0 +1,41 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+var ErrInvalidCoordinates = errors.New("invalid coordinates")
+
+const earthRadiusKm = 6371.0
+
+// GeoPoint is a position in decimal degrees.
+type GeoPoint struct {
+	Lat float64
+	Lon float64
+}
+
+// ParseGeoPoint reads a position from the latitude and longitude strings Odoo
+// and the app exchange.
+func ParseGeoPoint(latitude string, longitude string) (p GeoPoint, err error) {
+	if p.Lat, err = strconv.ParseFloat(strings.TrimSpace(latitude), 64); err != nil || p.Lat < -90 || p.Lat > 90 {
+		return p, fmt.Errorf("%w: latitude %q", ErrInvalidCoordinates, latitude)
+	}
+	if p.Lon, err = strconv.ParseFloat(strings.TrimSpace(longitude), 64); err != nil || p.Lon < -180 || p.Lon > 180 {
+		return p, fmt.Errorf("%w: longitude %q", ErrInvalidCoordinates, longitude)
+	}
+	return p, nil
+}
+
+// DistanceKm is the great-circle distance between a and b.
+func DistanceKm(a GeoPoint, b GeoPoint) float64 {
+	lat1, lat2 := a.Lat*math.Pi/180, b.Lat*math.Pi/180
+	dLat := lat2 - lat1
+	dLon := (b.Lon - a.Lon) * math.Pi / 180
+
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadiusKm * math.Asin(math.Min(1, math.Sqrt(h)))
+}
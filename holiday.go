@@ -0,0 +1,89 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"zebrax.id/emi/integration/erp/connector/odoo/model"
+)
+
+// odooDateTime is the layout of Odoo datetime fields, in UTC.
+const odooDateTime = "2006-01-02 15:04:05"
+
+// parseOdooTime reads a timestamp as Odoo formats it: RFC 3339, or
+// odooDateTime in UTC.
+func parseOdooTime(text string) (time.Time, bool) {
+	text = strings.TrimSpace(text)
+	for _, layout := range []string{time.RFC3339, odooDateTime} {
+		if t, err := time.ParseInLocation(layout, text, time.UTC); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// LoadHolidayCalendar reads the public holidays from the global leaves of the
+// Odoo working calendars, from a year back, and returns them with configured
+// as "2006-01-02" dates.
+func (r *repository) LoadHolidayCalendar(ctx context.Context, configured []string) (dates []string, err error) {
+	defer log.Info("[Odoo - Connector - LoadHolidayCalendar] End")
+	log.Info("[Odoo - Connector - LoadHolidayCalendar] Start")
+
+	from := time.Now().AddDate(-1, 0, 0).UTC().Format(odooDateTime)
+	response, err := r.executeKw(ctx, "search_read", "resource.calendar.leaves", []interface{}{
+		[]interface{}{
+			[]interface{}{"resource_id", "=", false},
+			[]interface{}{"date_to", ">=", from},
+		},
+	}, map[string]interface{}{"fields": []string{"name", "date_from", "date_to"}})
+	if err != nil {
+		return configured, err
+	}
+
+	seen := make(map[string]bool)
+	for _, date := range configured {
+		if date = strings.TrimSpace(date); date != "" && !seen[date] {
+			seen[date] = true
+			dates = append(dates, date)
+		}
+	}
+	for _, leave := range rpcRecords(response) {
+		days, err := leaveDays(leave["date_from"], leave["date_to"])
+		if err != nil {
+			log.Info(fmt.Sprintf("[Odoo - Connector - LoadHolidayCalendar] Leave %v Error: %s", leave["name"], err.Error()))
+			continue
+		}
+		for _, day := range days {
+			if !seen[day] {
+				seen[day] = true
+				dates = append(dates, day)
+			}
+		}
+	}
+
+	return dates, nil
+}
+
+// leaveDays returns the days, in WIB, a leave from dateFrom to dateTo covers.
+func leaveDays(dateFrom interface{}, dateTo interface{}) (days []string, err error) {
+	fromText, _ := dateFrom.(string)
+	toText, _ := dateTo.(string)
+	from, err := time.ParseInLocation(odooDateTime, fromText, time.UTC)
+	if err != nil {
+		return nil, err
+	}
+	to, err := time.ParseInLocation(odooDateTime, toText, time.UTC)
+	if err != nil {
+		return nil, err
+	}
+
+	from, to = from.In(model.WIB), to.In(model.WIB)
+	day := time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, model.WIB)
+	for ; !day.After(to); day = day.AddDate(0, 0, 1) {
+		days = append(days, day.Format("2006-01-02"))
+	}
+	return days, nil
+}
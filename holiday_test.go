@@ -0,0 +1,32 @@
+package repository
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"zebrax.id/emi/integration/erp/connector/odoo/odootest"
+)
+
+func TestLoadHolidayCalendar(t *testing.T) {
+	r, srv := newTestRepository(t)
+	srv.Handle("resource.calendar.leaves", "search_read", func(call odootest.Call) (interface{}, error) {
+		return []interface{}{
+			// Independence Day, midnight to midnight WIB.
+			map[string]interface{}{"name": "Hari Kemerdekaan", "date_from": "2026-08-16 17:00:00", "date_to": "2026-08-17 16:59:59"},
+			// Two days of Idul Fitri.
+			map[string]interface{}{"name": "Idul Fitri", "date_from": "2026-03-19 17:00:00", "date_to": "2026-03-21 16:59:59"},
+			map[string]interface{}{"name": "Broken", "date_from": false, "date_to": false},
+		}, nil
+	})
+
+	dates, err := r.LoadHolidayCalendar(context.Background(), []string{"2026-12-25", "2026-08-17"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"2026-12-25", "2026-08-17", "2026-03-20", "2026-03-21"}
+	if !reflect.DeepEqual(dates, want) {
+		t.Errorf("dates = %v, want %v", dates, want)
+	}
+}
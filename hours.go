@@ -0,0 +1,442 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrInvalidOperatingHours = errors.New("invalid operating hours")
+
+// TimeRange is an opening period within a day, in minutes after midnight.
+// Close may pass 24*60 for periods that end after midnight.
+type TimeRange struct {
+	Open  int
+	Close int
+}
+
+// Contains reports whether minute, in minutes after midnight, is within r.
+func (r TimeRange) Contains(minute int) bool {
+	return minute >= r.Open && minute < r.Close
+}
+
+// Indonesian time zones. None of them observes daylight saving time.
+var (
+	WIB  = time.FixedZone("WIB", 7*60*60)
+	WITA = time.FixedZone("WITA", 8*60*60)
+	WIT  = time.FixedZone("WIT", 9*60*60)
+)
+
+// witaProvinces and witProvinces name the provinces outside WIB, in lower
+// case, by their English and Indonesian names.
+var (
+	witaProvinces = []string{"bali", "nusa tenggara", "south kalimantan", "kalimantan selatan",
+		"east kalimantan", "kalimantan timur", "north kalimantan", "kalimantan utara", "sulawesi"}
+	witProvinces = []string{"maluku", "papua"}
+)
+
+// DealerLocation returns the time zone of a dealer from its province, or from
+// its longitude when the province is unknown. It defaults to WIB.
+func DealerLocation(province string, longitude string) *time.Location {
+	province = strings.ToLower(strings.TrimSpace(province))
+	if province != "" {
+		for _, name := range witProvinces {
+			if strings.Contains(province, name) {
+				return WIT
+			}
+		}
+		for _, name := range witaProvinces {
+			if strings.Contains(province, name) {
+				return WITA
+			}
+		}
+		if !strings.Contains(province, "kalimantan") {
+			return WIB
+		}
+	}
+
+	// Roughly the meridians between the zones.
+	lon, err := strconv.ParseFloat(strings.TrimSpace(longitude), 64)
+	switch {
+	case err != nil:
+		return WIB
+	case lon >= 127:
+		return WIT
+	case lon >= 115:
+		return WITA
+	}
+	return WIB
+}
+
+// Schedule is the weekly opening schedule of a dealer, indexed by
+// time.Weekday, with the exceptions that apply on public holidays.
+type Schedule struct {
+	// Location is the time zone of the dealer; nil reads the schedule in WIB.
+	Location *time.Location
+	Weekly   [7][]TimeRange
+	// HolidaySet is true when the hours name their own holiday opening; the
+	// holiday is then open during Holiday only, or closed when it is empty.
+	// Otherwise holidays follow Weekly.
+	HolidaySet bool
+	Holiday    []TimeRange
+}
+
+// HolidayCalendar is the set of public holidays, as "2006-01-02" dates.
+type HolidayCalendar map[string]bool
+
+var (
+	holidayMu       sync.RWMutex
+	holidayCalendar = HolidayCalendar{}
+)
+
+// SetHolidayCalendar replaces the public holidays used by Schedule.
+func SetHolidayCalendar(dates []string) {
+	calendar := HolidayCalendar{}
+	for _, date := range dates {
+		calendar[strings.TrimSpace(date)] = true
+	}
+
+	holidayMu.Lock()
+	defer holidayMu.Unlock()
+	holidayCalendar = calendar
+}
+
+func isHoliday(day time.Time) bool {
+	holidayMu.RLock()
+	defer holidayMu.RUnlock()
+	return holidayCalendar[day.Format("2006-01-02")]
+}
+
+// In returns the schedule read in the time zone loc.
+func (s Schedule) In(loc *time.Location) Schedule {
+	s.Location = loc
+	return s
+}
+
+func (s Schedule) location() *time.Location {
+	if s.Location == nil {
+		return WIB
+	}
+	return s.Location
+}
+
+// PeriodsOn returns the opening periods of the day of t.
+func (s Schedule) PeriodsOn(t time.Time) []TimeRange {
+	if s.HolidaySet && isHoliday(t) {
+		return s.Holiday
+	}
+	return s.Weekly[t.Weekday()]
+}
+
+// OpenAt reports whether the schedule is open at t, read in the time zone of
+// the dealer.
+func (s Schedule) OpenAt(t time.Time) bool {
+	t = t.In(s.location())
+	minute := t.Hour()*60 + t.Minute()
+	for _, period := range s.PeriodsOn(t) {
+		if period.Contains(minute) {
+			return true
+		}
+	}
+	// A period of the previous day may run past midnight.
+	for _, period := range s.PeriodsOn(t.AddDate(0, 0, -1)) {
+		if period.Close > 24*60 && minute < period.Close-24*60 {
+			return true
+		}
+	}
+	return false
+}
+
+// nextOpeningDays bounds the search of NextOpening.
+const nextOpeningDays = 14
+
+// NextOpening returns when the schedule next opens after t. It reports false
+// when the schedule does not open within two weeks.
+func (s Schedule) NextOpening(t time.Time) (time.Time, bool) {
+	t = t.In(s.location())
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	for i := 0; i <= nextOpeningDays; i++ {
+		day := midnight.AddDate(0, 0, i)
+		var next time.Time
+		for _, period := range s.PeriodsOn(day) {
+			open := day.Add(time.Duration(period.Open) * time.Minute)
+			if open.After(t) && (next.IsZero() || open.Before(next)) {
+				next = open
+			}
+		}
+		if !next.IsZero() {
+			return next, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// Empty reports whether the schedule never opens.
+func (s Schedule) Empty() bool {
+	for _, periods := range s.Weekly {
+		if len(periods) > 0 {
+			return false
+		}
+	}
+	return len(s.Holiday) == 0
+}
+
+// Clock formats minutes after midnight as "15:04", wrapping past midnight.
+func Clock(minutes int) string {
+	minutes %= 24 * 60
+	return fmt.Sprintf("%02d:%02d", minutes/60, minutes%60)
+}
+
+// OpeningPeriod is a TimeRange as "15:04" clock times.
+type OpeningPeriod struct {
+	Open  string
+	Close string
+}
+
+// OpeningDay holds the opening periods of a weekday; a day without periods is
+// closed.
+type OpeningDay struct {
+	Weekday time.Weekday
+	Periods []OpeningPeriod
+}
+
+// OperatingSchedule is the structured form of operating hours handed to
+// clients next to the original text.
+type OperatingSchedule struct {
+	Days []OpeningDay
+	// HolidaySet is true when holidays do not follow Days; they are then
+	// open during Holiday only.
+	HolidaySet bool
+	Holiday    []OpeningPeriod
+	// OpenNow and NextOpening are computed at the time given to Structured.
+	OpenNow     bool
+	NextOpening time.Time
+}
+
+// Structured returns the schedule as clock times, with whether it is open at
+// now and when it next opens.
+func (s Schedule) Structured(now time.Time) OperatingSchedule {
+	structured := OperatingSchedule{
+		HolidaySet: s.HolidaySet,
+		Holiday:    openingPeriods(s.Holiday),
+		OpenNow:    s.OpenAt(now),
+	}
+	for day, periods := range s.Weekly {
+		structured.Days = append(structured.Days, OpeningDay{
+			Weekday: time.Weekday(day),
+			Periods: openingPeriods(periods),
+		})
+	}
+	if next, ok := s.NextOpening(now); ok {
+		structured.NextOpening = next
+	}
+
+	return structured
+}
+
+func openingPeriods(periods []TimeRange) []OpeningPeriod {
+	list := []OpeningPeriod{}
+	for _, period := range periods {
+		list = append(list, OpeningPeriod{Open: Clock(period.Open), Close: Clock(period.Close)})
+	}
+	return list
+}
+
+// ParseOperatingSchedule parses operating hours into their structured form at
+// now, read in the time zone loc. It reports false when the text cannot be
+// parsed, leaving clients with the text alone.
+func ParseOperatingSchedule(text string, loc *time.Location, now time.Time) (OperatingSchedule, bool) {
+	schedule, err := ParseOperatingHours(text)
+	if err != nil {
+		return OperatingSchedule{}, false
+	}
+	return schedule.In(loc).Structured(now), true
+}
+
+var (
+	timeRangePattern = regexp.MustCompile(`(\d{1,2})[.:](\d{2})\s*(?:-|–|s/d|to|sampai)\s*(\d{1,2})[.:](\d{2})`)
+	dayRangePattern  = regexp.MustCompile(`^([a-z]+)\s*(?:-|–|to|s/d|sampai)\s*([a-z]+)$`)
+	holidayPattern   = regexp.MustCompile(`holiday|libur|tanggal merah|hari raya`)
+	// closedPattern matches the words around the days of "Sunday closed" or
+	// "Tutup: Minggu".
+	closedPattern = regexp.MustCompile(`\b(?:closed|tutup|on|pada)\b|:`)
+)
+
+// weekdayNames maps English and Indonesian day names and their abbreviations.
+var weekdayNames = map[string]time.Weekday{
+	"sun": time.Sunday, "sunday": time.Sunday, "min": time.Sunday, "minggu": time.Sunday,
+	"mon": time.Monday, "monday": time.Monday, "sen": time.Monday, "senin": time.Monday,
+	"tue": time.Tuesday, "tues": time.Tuesday, "tuesday": time.Tuesday, "sel": time.Tuesday, "selasa": time.Tuesday,
+	"wed": time.Wednesday, "wednesday": time.Wednesday, "rab": time.Wednesday, "rabu": time.Wednesday,
+	"thu": time.Thursday, "thur": time.Thursday, "thurs": time.Thursday, "thursday": time.Thursday, "kam": time.Thursday, "kamis": time.Thursday,
+	"fri": time.Friday, "friday": time.Friday, "jum": time.Friday, "jumat": time.Friday,
+	"sat": time.Saturday, "saturday": time.Saturday, "sab": time.Saturday, "sabtu": time.Saturday,
+}
+
+var everyDayNames = map[string]bool{
+	"": true, "everyday": true, "everydays": true, "every day": true, "daily": true,
+	"setiap hari": true, "tiap hari": true, "mon-sun": true, "monday-sunday": true, "senin-minggu": true,
+}
+
+// ParseOperatingHours parses operating hours such as "Everydays 10.00 - 18.00"
+// or "Mon - Fri 09.00 - 17.00; Sat 09.00 - 14.00; Public holidays closed".
+// Segments are separated by ";", "," or new lines; a segment without days
+// applies to every day.
+func ParseOperatingHours(text string) (schedule Schedule, err error) {
+	segments := strings.FieldsFunc(text, func(r rune) bool {
+		return r == ';' || r == ',' || r == '\n' || r == '|'
+	})
+	var (
+		found   bool
+		pending []time.Weekday
+	)
+	for _, segment := range segments {
+		segment = strings.ToLower(strings.TrimSpace(segment))
+		if segment == "" {
+			continue
+		}
+
+		closed := strings.Contains(segment, "closed") || strings.Contains(segment, "tutup")
+		if holidayPattern.MatchString(segment) {
+			if err = parseHoliday(&schedule, segment, closed); err != nil {
+				return schedule, fmt.Errorf("%w: %q", ErrInvalidOperatingHours, text)
+			}
+			pending, found = nil, true
+			continue
+		}
+		if closed {
+			// "Everydays 10.00 - 18.00; Sunday closed" closes the days an
+			// earlier segment opened.
+			days := pending
+			if dayText := strings.TrimSpace(closedPattern.ReplaceAllString(segment, " ")); dayText != "" {
+				parsed, err := parseDays(dayText)
+				if err != nil {
+					return schedule, fmt.Errorf("%w: %q", ErrInvalidOperatingHours, text)
+				}
+				days = append(days, parsed...)
+			}
+			for _, day := range days {
+				schedule.Weekly[day] = nil
+			}
+			pending = nil
+			continue
+		}
+
+		// "Mon, Wed 10.00 - 18.00" lists days in segments of their own.
+		match := timeRangePattern.FindStringSubmatchIndex(segment)
+		if match == nil {
+			days, err := parseDays(segment)
+			if err != nil {
+				return schedule, fmt.Errorf("%w: %q", ErrInvalidOperatingHours, text)
+			}
+			pending = append(pending, days...)
+			continue
+		}
+		period, err := parseTimeRange(segment[match[0]:match[1]])
+		if err != nil {
+			return schedule, fmt.Errorf("%w: %q", ErrInvalidOperatingHours, text)
+		}
+		dayText := strings.TrimSpace(strings.Trim(segment[:match[0]], ": "))
+		days := pending
+		if dayText != "" || len(pending) == 0 {
+			parsed, err := parseDays(dayText)
+			if err != nil {
+				return schedule, fmt.Errorf("%w: %q", ErrInvalidOperatingHours, text)
+			}
+			days = append(days, parsed...)
+		}
+		for _, day := range days {
+			schedule.Weekly[day] = append(schedule.Weekly[day], period)
+		}
+		pending, found = nil, true
+	}
+	if !found {
+		return schedule, fmt.Errorf("%w: %q", ErrInvalidOperatingHours, text)
+	}
+
+	return schedule, nil
+}
+
+// parseHoliday reads a segment such as "Public holidays closed" or
+// "Libur nasional 10.00 - 15.00".
+func parseHoliday(schedule *Schedule, segment string, closed bool) error {
+	schedule.HolidaySet = true
+	if closed {
+		schedule.Holiday = nil
+		return nil
+	}
+
+	matches := timeRangePattern.FindAllString(segment, -1)
+	if len(matches) == 0 {
+		return ErrInvalidOperatingHours
+	}
+	for _, match := range matches {
+		period, err := parseTimeRange(match)
+		if err != nil {
+			return err
+		}
+		schedule.Holiday = append(schedule.Holiday, period)
+	}
+	return nil
+}
+
+func parseTimeRange(text string) (period TimeRange, err error) {
+	parts := timeRangePattern.FindStringSubmatch(text)
+	values := make([]int, 4)
+	for i := range values {
+		if values[i], err = strconv.Atoi(parts[i+1]); err != nil {
+			return period, err
+		}
+	}
+	if values[0] > 24 || values[1] > 59 || values[2] > 24 || values[3] > 59 {
+		return period, ErrInvalidOperatingHours
+	}
+
+	period = TimeRange{Open: values[0]*60 + values[1], Close: values[2]*60 + values[3]}
+	if period.Close <= period.Open {
+		// Closes after midnight.
+		period.Close += 24 * 60
+	}
+	return period, nil
+}
+
+func parseDays(text string) ([]time.Weekday, error) {
+	text = strings.Join(strings.Fields(text), " ")
+	if everyDayNames[text] || everyDayNames[strings.ReplaceAll(text, " ", "")] {
+		return []time.Weekday{time.Sunday, time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday, time.Saturday}, nil
+	}
+
+	if match := dayRangePattern.FindStringSubmatch(text); match != nil {
+		from, okFrom := weekdayNames[match[1]]
+		to, okTo := weekdayNames[match[2]]
+		if !okFrom || !okTo {
+			return nil, ErrInvalidOperatingHours
+		}
+		days := []time.Weekday{from}
+		for day := from; day != to; {
+			day = (day + 1) % 7
+			days = append(days, day)
+		}
+		return days, nil
+	}
+
+	var days []time.Weekday
+	for _, name := range strings.FieldsFunc(text, func(r rune) bool { return r == '&' || r == '/' || r == ' ' }) {
+		if name == "and" || name == "dan" {
+			continue
+		}
+		day, ok := weekdayNames[name]
+		if !ok {
+			return nil, ErrInvalidOperatingHours
+		}
+		days = append(days, day)
+	}
+	if len(days) == 0 {
+		return nil, ErrInvalidOperatingHours
+	}
+	return days, nil
+}
@@ -0,0 +1,228 @@
+package model
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseOperatingHours(t *testing.T) {
+	weekdays := func(periods ...TimeRange) (weekly [7][]TimeRange) {
+		for day := time.Monday; day <= time.Friday; day++ {
+			weekly[day] = periods
+		}
+		return weekly
+	}
+	everyDay := func(periods ...TimeRange) (weekly [7][]TimeRange) {
+		for day := range weekly {
+			weekly[day] = periods
+		}
+		return weekly
+	}
+
+	tests := []struct {
+		name    string
+		text    string
+		want    Schedule
+		wantErr bool
+	}{
+		{
+			name: "every day",
+			text: "Everydays 10.00 - 18.00",
+			want: Schedule{Weekly: everyDay(TimeRange{600, 1080})},
+		},
+		{
+			name: "no days applies to every day",
+			text: "08:30-17:00",
+			want: Schedule{Weekly: everyDay(TimeRange{510, 1020})},
+		},
+		{
+			name: "weekdays, saturday and closed holidays",
+			text: "Mon - Fri 09.00 - 17.00; Sat 09.00 - 14.00; Public holidays closed",
+			want: func() Schedule {
+				s := Schedule{Weekly: weekdays(TimeRange{540, 1020}), HolidaySet: true}
+				s.Weekly[time.Saturday] = []TimeRange{{540, 840}}
+				return s
+			}(),
+		},
+		{
+			name: "indonesian names and holiday hours",
+			text: "Senin s/d Jumat 08.00 - 16.00\nLibur nasional 10.00 - 15.00",
+			want: Schedule{
+				Weekly:     weekdays(TimeRange{480, 960}),
+				HolidaySet: true,
+				Holiday:    []TimeRange{{600, 900}},
+			},
+		},
+		{
+			name: "days listed in their own segments",
+			text: "Mon, Wed 10.00 - 18.00",
+			want: func() Schedule {
+				var s Schedule
+				s.Weekly[time.Monday] = []TimeRange{{600, 1080}}
+				s.Weekly[time.Wednesday] = []TimeRange{{600, 1080}}
+				return s
+			}(),
+		},
+		{
+			name: "closes after midnight",
+			text: "Sat 18.00 - 02.00",
+			want: func() Schedule {
+				var s Schedule
+				s.Weekly[time.Saturday] = []TimeRange{{1080, 1560}}
+				return s
+			}(),
+		},
+		{
+			name: "closed day after every day",
+			text: "Everydays 10.00 - 18.00; Sunday closed",
+			want: func() Schedule {
+				s := Schedule{Weekly: everyDay(TimeRange{600, 1080})}
+				s.Weekly[time.Sunday] = nil
+				return s
+			}(),
+		},
+		{
+			name: "closed days in indonesian",
+			text: "Setiap hari 10.00 - 18.00\nTutup: Sabtu & Minggu",
+			want: Schedule{Weekly: weekdays(TimeRange{600, 1080})},
+		},
+		{
+			name: "closed days listed in their own segments",
+			text: "Everydays 10.00 - 18.00; Sat, Sun closed",
+			want: Schedule{Weekly: weekdays(TimeRange{600, 1080})},
+		},
+		{name: "unknown closed day", text: "Everydays 10.00 - 18.00; Funday closed", wantErr: true},
+		{name: "unknown day", text: "Funday 10.00 - 18.00", wantErr: true},
+		{name: "invalid time", text: "Mon 25.00 - 18.00", wantErr: true},
+		{name: "no hours", text: "By appointment", wantErr: true},
+		{name: "empty", text: "", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseOperatingHours(tt.text)
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidOperatingHours) {
+					t.Fatalf("err = %v, want ErrInvalidOperatingHours", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestScheduleOpenAt(t *testing.T) {
+	schedule, err := ParseOperatingHours("Mon - Fri 09.00 - 17.00; Sat 18.00 - 02.00; Public holidays closed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetHolidayCalendar([]string{"2024-08-16"})
+	defer SetHolidayCalendar(nil)
+
+	at := func(day, hour, minute int) time.Time {
+		// August 2024 starts on a Thursday.
+		return time.Date(2024, time.August, day, hour, minute, 0, 0, WIB)
+	}
+	tests := []struct {
+		name string
+		t    time.Time
+		want bool
+	}{
+		{"weekday open", at(14, 9, 0), true},
+		{"weekday closing minute", at(14, 17, 0), false},
+		{"weekday before opening", at(14, 8, 59), false},
+		{"holiday closed", at(16, 10, 0), false},
+		{"saturday evening", at(17, 23, 30), true},
+		{"past midnight into sunday", at(18, 1, 30), true},
+		{"sunday after the late period", at(18, 2, 0), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := schedule.OpenAt(tt.t); got != tt.want {
+				t.Errorf("OpenAt(%s) = %t, want %t", tt.t, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestScheduleNextOpening(t *testing.T) {
+	schedule, err := ParseOperatingHours("Mon - Fri 09.00 - 17.00; Public holidays closed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetHolidayCalendar([]string{"2024-08-16"})
+	defer SetHolidayCalendar(nil)
+
+	at := func(day, hour int) time.Time {
+		return time.Date(2024, time.August, day, hour, 0, 0, 0, WIB)
+	}
+	tests := []struct {
+		name string
+		t    time.Time
+		want time.Time
+	}{
+		{"before opening", at(14, 7), at(14, 9)},
+		{"after closing", at(14, 18), at(15, 9)},
+		{"skips the holiday and the weekend", at(15, 18), at(19, 9)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := schedule.NextOpening(tt.t)
+			if !ok || !got.Equal(tt.want) {
+				t.Errorf("NextOpening(%s) = %s, %t, want %s", tt.t, got, ok, tt.want)
+			}
+		})
+	}
+
+	if _, ok := (Schedule{}).NextOpening(at(14, 7)); ok {
+		t.Error("NextOpening of an empty schedule reported an opening")
+	}
+}
+
+func TestScheduleInDealerLocation(t *testing.T) {
+	schedule, err := ParseOperatingHours("Everydays 10.00 - 18.00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 09:30 WIB is 10:30 WITA and 11:30 WIT.
+	at := time.Date(2024, time.August, 14, 9, 30, 0, 0, WIB)
+
+	tests := []struct {
+		name      string
+		province  string
+		longitude string
+		want      *time.Location
+		open      bool
+	}{
+		{"jakarta", "DKI Jakarta", "106.8", WIB, false},
+		{"bali", "Bali", "115.2", WITA, true},
+		{"south sulawesi", "Sulawesi Selatan", "119.4", WITA, true},
+		{"papua", "Papua", "140.7", WIT, true},
+		{"west kalimantan", "Kalimantan Barat", "109.3", WIB, false},
+		{"east kalimantan", "Kalimantan Timur", "117.1", WITA, true},
+		{"longitude only", "", "128.2", WIT, true},
+		{"nothing known", "", "", WIB, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			loc := DealerLocation(tt.province, tt.longitude)
+			if loc != tt.want {
+				t.Fatalf("DealerLocation(%q, %q) = %s, want %s", tt.province, tt.longitude, loc, tt.want)
+			}
+			if got := schedule.In(loc).OpenAt(at); got != tt.open {
+				t.Errorf("OpenAt = %t, want %t", got, tt.open)
+			}
+		})
+	}
+}
@@ -0,0 +1,148 @@
+package usecase
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/tabbed/pqtype"
+	"zebrax.id/emi/integration/core/proto"
+	"zebrax.id/emi/integration/erp/adapter/repository/query"
+)
+
+const (
+	idempotencyOrderConfirmation = "order_confirmation"
+	idempotencyPayment           = "payment"
+)
+
+// idempotencyLease is how long a key stays in progress without a response. A
+// retry after it takes the key over, so a crashed request does not block its
+// key forever.
+const idempotencyLease = 5 * time.Minute
+
+var (
+	// ErrIdempotencyKeyReused is returned when a key is sent again with a
+	// different payload than the request that first used it.
+	ErrIdempotencyKeyReused = errors.New("idempotency key reused with a different payload")
+
+	// ErrIdempotencyInProgress is returned when a retry arrives while the first
+	// request with the same key is still running.
+	ErrIdempotencyInProgress = errors.New("request with this idempotency key is still in progress")
+)
+
+// idempotent runs fn once per operation and key and stores its response. Later
+// calls with the same key replay the stored response instead of calling fn.
+// Requests without a key always run fn.
+//
+// fn reports whether its response may be replayed. Responses that are not
+// replayable, errors and panics release the key so the client can retry.
+func (r *useCase) idempotent(ctx context.Context, operation string, key string, request interface{}, fn func() (result *proto.PurchaseDetailResponse, replayable bool, err error)) (result *proto.PurchaseDetailResponse, err error) {
+	if key == "" {
+		result, _, err := fn()
+		return result, err
+	}
+
+	requestHash, err := idempotencyHash(request)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	created, err := r.repo.CreateIdempotencyKey(ctx, &query.CreateIdempotencyKeyParams{
+		Operation:   operation,
+		Key:         key,
+		RequestHash: requestHash,
+		CreatedTime: now,
+		StaleTime:   now.Add(-idempotencyLease),
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		return r.replayIdempotent(ctx, operation, key, requestHash)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	replayable := false
+	defer func() {
+		if replayable {
+			return
+		}
+		// ctx may be done already; the key has to be released regardless.
+		deleteErr := r.repo.DeleteIdempotencyKey(context.Background(), &query.DeleteIdempotencyKeyParams{
+			Operation:   operation,
+			Key:         key,
+			CreatedTime: created.CreatedTime,
+		})
+		if deleteErr != nil {
+			log.Error("[Idempotency] Release Key Error: ", deleteErr)
+		}
+	}()
+
+	result, replayable, err = fn()
+	if err != nil {
+		replayable = false
+	}
+	if !replayable {
+		return result, err
+	}
+
+	response, err := json.Marshal(result)
+	if err != nil {
+		replayable = false
+		return result, err
+	}
+	err = r.repo.UpdateIdempotencyKeyResponse(ctx, &query.UpdateIdempotencyKeyResponseParams{
+		Operation:     operation,
+		Key:           key,
+		Response:      pqtype.NullRawMessage{RawMessage: response, Valid: true},
+		CompletedTime: sql.NullTime{Time: time.Now(), Valid: true},
+		CreatedTime:   created.CreatedTime,
+	})
+	if err != nil {
+		// The key is taken over once its lease passes.
+		log.Error("[Idempotency] Store Response Error: ", err)
+	}
+
+	return result, nil
+}
+
+// replayIdempotent returns the stored response of an earlier request that used
+// the same key.
+func (r *useCase) replayIdempotent(ctx context.Context, operation string, key string, requestHash string) (*proto.PurchaseDetailResponse, error) {
+	stored, err := r.repo.GetIdempotencyKey(ctx, &query.GetIdempotencyKeyParams{Operation: operation, Key: key})
+	if err != nil {
+		return nil, err
+	}
+	if stored.RequestHash != requestHash {
+		log.Info("[Idempotency] Key Reused: ", operation, " ", key)
+		return nil, ErrIdempotencyKeyReused
+	}
+	if !stored.Response.Valid {
+		return nil, ErrIdempotencyInProgress
+	}
+
+	log.Info("[Idempotency] Replay Response: ", operation, " ", key)
+	result := new(proto.PurchaseDetailResponse)
+	if err = json.Unmarshal(stored.Response.RawMessage, result); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// idempotencyHash fingerprints a request payload so a reused key can be
+// detected.
+func idempotencyHash(request interface{}) (string, error) {
+	payload, err := json.Marshal(request)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(payload)
+
+	return hex.EncodeToString(sum[:]), nil
+}
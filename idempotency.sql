@@ -0,0 +1,39 @@
+-- Schema and sqlc queries for the purchase idempotency keys.
+
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+    operation      VARCHAR(64)  NOT NULL,
+    key            VARCHAR(255) NOT NULL,
+    request_hash   CHAR(64)     NOT NULL,
+    response       JSONB,
+    created_time   TIMESTAMP    NOT NULL DEFAULT NOW(),
+    completed_time TIMESTAMP,
+    PRIMARY KEY (operation, key)
+);
+
+-- Creates the key, or takes over a key of the same request that stored no
+-- response since stale_time, e.g. because its process crashed. No row is
+-- returned while the key is completed or still in progress.
+-- name: CreateIdempotencyKey :one
+INSERT INTO idempotency_keys AS k (operation, key, request_hash, created_time)
+VALUES (sqlc.arg(operation), sqlc.arg(key), sqlc.arg(request_hash), sqlc.arg(created_time))
+ON CONFLICT (operation, key) DO UPDATE
+SET created_time = EXCLUDED.created_time
+WHERE k.response IS NULL
+  AND k.request_hash = EXCLUDED.request_hash
+  AND k.created_time < sqlc.arg(stale_time)
+RETURNING *;
+
+-- name: GetIdempotencyKey :one
+SELECT * FROM idempotency_keys
+WHERE operation = $1 AND key = $2;
+
+-- The created_time of the attempt keeps an attempt whose key was taken over
+-- from touching it.
+-- name: UpdateIdempotencyKeyResponse :exec
+UPDATE idempotency_keys
+SET response = $3, completed_time = $4
+WHERE operation = $1 AND key = $2 AND created_time = $5;
+
+-- name: DeleteIdempotencyKey :exec
+DELETE FROM idempotency_keys
+WHERE operation = $1 AND key = $2 AND created_time = $3 AND response IS NULL;
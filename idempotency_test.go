@@ -0,0 +1,184 @@
+package usecase
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/tabbed/pqtype"
+	"zebrax.id/emi/integration/core/proto"
+	"zebrax.id/emi/integration/erp/adapter/repository/query"
+)
+
+// idempotencyStore keeps idempotency keys in memory with the semantics of
+// idempotency.sql.
+type idempotencyStore struct {
+	query.Store
+	keys map[string]query.IdempotencyKey
+}
+
+func newIdempotencyStore() *idempotencyStore {
+	return &idempotencyStore{keys: map[string]query.IdempotencyKey{}}
+}
+
+func (s *idempotencyStore) CreateIdempotencyKey(ctx context.Context, arg *query.CreateIdempotencyKeyParams) (query.IdempotencyKey, error) {
+	id := arg.Operation + "/" + arg.Key
+	if key, ok := s.keys[id]; ok {
+		if key.Response.Valid || key.RequestHash != arg.RequestHash || !key.CreatedTime.Before(arg.StaleTime) {
+			return query.IdempotencyKey{}, sql.ErrNoRows
+		}
+	}
+	key := query.IdempotencyKey{Operation: arg.Operation, Key: arg.Key, RequestHash: arg.RequestHash, CreatedTime: arg.CreatedTime}
+	s.keys[id] = key
+	return key, nil
+}
+
+func (s *idempotencyStore) GetIdempotencyKey(ctx context.Context, arg *query.GetIdempotencyKeyParams) (query.IdempotencyKey, error) {
+	key, ok := s.keys[arg.Operation+"/"+arg.Key]
+	if !ok {
+		return query.IdempotencyKey{}, sql.ErrNoRows
+	}
+	return key, nil
+}
+
+func (s *idempotencyStore) UpdateIdempotencyKeyResponse(ctx context.Context, arg *query.UpdateIdempotencyKeyResponseParams) error {
+	id := arg.Operation + "/" + arg.Key
+	if key, ok := s.keys[id]; ok && key.CreatedTime.Equal(arg.CreatedTime) {
+		key.Response = arg.Response
+		key.CompletedTime = arg.CompletedTime
+		s.keys[id] = key
+	}
+	return nil
+}
+
+func (s *idempotencyStore) DeleteIdempotencyKey(ctx context.Context, arg *query.DeleteIdempotencyKeyParams) error {
+	id := arg.Operation + "/" + arg.Key
+	if key, ok := s.keys[id]; ok && key.CreatedTime.Equal(arg.CreatedTime) && !key.Response.Valid {
+		delete(s.keys, id)
+	}
+	return nil
+}
+
+func TestIdempotentReplay(t *testing.T) {
+	store := newIdempotencyStore()
+	r := &useCase{repo: store}
+	ctx := context.Background()
+
+	calls := 0
+	fn := func() (*proto.PurchaseDetailResponse, bool, error) {
+		calls++
+		return &proto.PurchaseDetailResponse{OrderData: &proto.Order{SalesOrderID: "15"}}, true, nil
+	}
+
+	for i := 0; i < 2; i++ {
+		result, err := r.idempotent(ctx, idempotencyPayment, "key-1", "INV/1", fn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.OrderData.SalesOrderID != "15" {
+			t.Errorf("call %d: sales order = %q, want the stored 15", i, result.OrderData.SalesOrderID)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("fn ran %d times, want once", calls)
+	}
+
+	_, err := r.idempotent(ctx, idempotencyPayment, "key-1", "INV/2", fn)
+	if !errors.Is(err, ErrIdempotencyKeyReused) {
+		t.Errorf("other payload: err = %v, want ErrIdempotencyKeyReused", err)
+	}
+}
+
+func TestIdempotentInProgressAndStaleTakeover(t *testing.T) {
+	store := newIdempotencyStore()
+	r := &useCase{repo: store}
+	ctx := context.Background()
+
+	hash, err := idempotencyHash("INV/1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A request that is still running.
+	store.keys[idempotencyPayment+"/key-1"] = query.IdempotencyKey{
+		Operation: idempotencyPayment, Key: "key-1", RequestHash: hash, CreatedTime: time.Now().Add(-time.Minute),
+	}
+
+	calls := 0
+	fn := func() (*proto.PurchaseDetailResponse, bool, error) {
+		calls++
+		return &proto.PurchaseDetailResponse{}, true, nil
+	}
+	if _, err = r.idempotent(ctx, idempotencyPayment, "key-1", "INV/1", fn); !errors.Is(err, ErrIdempotencyInProgress) {
+		t.Errorf("err = %v, want ErrIdempotencyInProgress", err)
+	}
+
+	// A request that crashed is taken over once its lease has run out.
+	store.keys[idempotencyPayment+"/key-1"] = query.IdempotencyKey{
+		Operation: idempotencyPayment, Key: "key-1", RequestHash: hash, CreatedTime: time.Now().Add(-idempotencyLease - time.Minute),
+	}
+	if _, err = r.idempotent(ctx, idempotencyPayment, "key-1", "INV/1", fn); err != nil {
+		t.Fatal(err)
+	}
+	if calls != 1 {
+		t.Errorf("fn ran %d times, want once after the takeover", calls)
+	}
+	if !store.keys[idempotencyPayment+"/key-1"].Response.Valid {
+		t.Error("the response of the takeover was not stored")
+	}
+}
+
+func TestIdempotentReleasesKey(t *testing.T) {
+	tests := []struct {
+		name string
+		fn   func() (*proto.PurchaseDetailResponse, bool, error)
+	}{
+		{name: "error", fn: func() (*proto.PurchaseDetailResponse, bool, error) {
+			return nil, true, errors.New("odoo is down")
+		}},
+		{name: "not replayable", fn: func() (*proto.PurchaseDetailResponse, bool, error) {
+			return &proto.PurchaseDetailResponse{}, false, nil
+		}},
+		{name: "panic", fn: func() (*proto.PurchaseDetailResponse, bool, error) {
+			panic("boom")
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newIdempotencyStore()
+			r := &useCase{repo: store}
+
+			func() {
+				defer func() { _ = recover() }()
+				_, _ = r.idempotent(context.Background(), idempotencyOrderConfirmation, "key-1", "SO/1", tt.fn)
+			}()
+			if _, ok := store.keys[idempotencyOrderConfirmation+"/key-1"]; ok {
+				t.Error("key was kept, want it released for a retry")
+			}
+		})
+	}
+}
+
+func TestIdempotentStoredResponseIsKept(t *testing.T) {
+	store := newIdempotencyStore()
+	r := &useCase{repo: store}
+	store.keys[idempotencyPayment+"/key-1"] = query.IdempotencyKey{
+		Operation:   idempotencyPayment,
+		Key:         "key-1",
+		RequestHash: "other",
+		Response:    pqtype.NullRawMessage{RawMessage: []byte(`{}`), Valid: true},
+	}
+
+	_, err := r.idempotent(context.Background(), idempotencyPayment, "key-1", "INV/1", func() (*proto.PurchaseDetailResponse, bool, error) {
+		t.Error("fn ran for a completed key")
+		return nil, false, nil
+	})
+	if !errors.Is(err, ErrIdempotencyKeyReused) {
+		t.Errorf("err = %v, want ErrIdempotencyKeyReused", err)
+	}
+	if !store.keys[idempotencyPayment+"/key-1"].Response.Valid {
+		t.Error("a completed key was released")
+	}
+}
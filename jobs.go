@@ -0,0 +1,29 @@
+package usecase
+
+import (
+	"context"
+
+	log "github.com/sirupsen/logrus"
+	odooConnectorRepository "zebrax.id/emi/integration/erp/connector/odoo/repository"
+)
+
+// JobsConfig configures the background jobs started by StartJobs.
+type JobsConfig struct {
+	Odoo   odooConnectorRepository.JobsConfig
+	Outbox OutboxConfig
+}
+
+var DefaultJobsConfig = JobsConfig{
+	Odoo:   odooConnectorRepository.DefaultJobsConfig,
+	Outbox: DefaultOutboxConfig,
+}
+
+// StartJobs starts the background jobs of the Odoo connector and the outbox
+// dispatcher, and returns; they run until ctx is done. Every instance may
+// start them.
+func (r *useCase) StartJobs(ctx context.Context, config JobsConfig) {
+	log.Info("[StartJobs] Start")
+
+	r.oRepo.StartJobs(ctx, config.Odoo)
+	go r.RunOutboxDispatcher(ctx, config.Outbox)
+}
@@ -0,0 +1,54 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"zebrax.id/emi/integration/erp/connector/odoo/repository/query"
+)
+
+// instanceID names this process as a lease holder.
+var instanceID = func() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	return fmt.Sprintf("%s-%d-%08x", host, os.Getpid(), rand.Uint32())
+}()
+
+// acquireLease takes or renews the lease of a background job for ttl. It
+// reports false while another instance holds an unexpired lease.
+func (r *repository) acquireLease(ctx context.Context, name string, ttl time.Duration) (bool, error) {
+	now := time.Now()
+	_, err := r.qry.AcquireJobLease(ctx, &query.AcquireJobLeaseParams{
+		Name:        name,
+		Holder:      instanceID,
+		ExpiresTime: now.Add(ttl),
+		Now:         now,
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// releaseLease gives up the lease so another instance can take over at once.
+func (r *repository) releaseLease(ctx context.Context, name string) {
+	err := r.qry.ReleaseJobLease(ctx, &query.ReleaseJobLeaseParams{
+		Name:   name,
+		Holder: instanceID,
+	})
+	if err != nil {
+		log.Error(fmt.Sprintf("[Odoo - Connector - Lease] Release %s Error: ", name), err)
+	}
+}
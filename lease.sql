@@ -0,0 +1,22 @@
+-- Schema and sqlc queries for the leases that elect one instance to run a
+-- background job.
+
+CREATE TABLE IF NOT EXISTS job_leases (
+    name         VARCHAR(64)  PRIMARY KEY,
+    holder       VARCHAR(255) NOT NULL,
+    expires_time TIMESTAMPTZ  NOT NULL
+);
+
+-- Takes or renews the lease; no row is returned while another holder's lease
+-- is still valid.
+-- name: AcquireJobLease :one
+INSERT INTO job_leases AS l (name, holder, expires_time)
+VALUES (sqlc.arg(name), sqlc.arg(holder), sqlc.arg(expires_time))
+ON CONFLICT (name) DO UPDATE
+SET holder = EXCLUDED.holder, expires_time = EXCLUDED.expires_time
+WHERE l.holder = EXCLUDED.holder OR l.expires_time < sqlc.arg(now)
+RETURNING *;
+
+-- name: ReleaseJobLease :exec
+DELETE FROM job_leases
+WHERE name = $1 AND holder = $2;
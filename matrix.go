@@ -0,0 +1,144 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+	"zebrax.id/emi/integration/erp/connector/odoo/model"
+)
+
+// MaxVariantCombinations caps the variant matrix of one product template.
+const MaxVariantCombinations = 256
+
+// matrixSlots is the order the attributes of a variant combination are listed
+// in.
+var matrixSlots = []VariantSlot{VariantColor, VariantBattery, VariantWheel, VariantMirror}
+
+// GetProductVariantMatrix returns every product template of productCode at the
+// dealer with the price and stock of each combination of its attributes. The
+// combinations of a template are passed to GetProductStock together, which
+// still queries fn_get_product_stock once per combination. When the stock of
+// a template cannot be read its combinations are listed at the minimum price,
+// marked StockUnknown. A template whose amounts cannot be read is left out;
+// only when every template is left out does the matrix fail.
+func (r *repository) GetProductVariantMatrix(ctx context.Context, dealerId int32, productCode string) (list []model.ProductTemplateMatrix, err error) {
+	defer log.Info("[Odoo - Connector - GetProductVariantMatrix] End")
+	log.Info("[Odoo - Connector - GetProductVariantMatrix] Start")
+
+	templates, err := r.GetProductTemplatePrice(ctx, dealerId, productCode)
+	if err != nil {
+		return nil, err
+	}
+
+	var templateErr error
+	for _, template := range templates {
+		matrix, err := r.templateMatrix(ctx, dealerId, template)
+		if err != nil {
+			log.Error("[Odoo - Connector - GetProductVariantMatrix] Skip Template: ", err)
+			if templateErr == nil {
+				templateErr = err
+			}
+			continue
+		}
+		list = append(list, matrix)
+	}
+	if len(list) == 0 && templateErr != nil {
+		return nil, templateErr
+	}
+
+	return list, nil
+}
+
+// templateMatrix prices every combination of the attributes of the template
+// and reads its stock at the dealer.
+func (r *repository) templateMatrix(ctx context.Context, dealerId int32, template model.ProductTemplate) (matrix model.ProductTemplateMatrix, err error) {
+	matrix = model.ProductTemplateMatrix{ProductTemplate: template}
+	if matrix.MinPrice, err = model.ParseOdooAmount(template.MinUnitPrice, model.DefaultCurrency); err != nil {
+		return matrix, fmt.Errorf("%s min unit price: %w", template.ProductTemplateCode, err)
+	}
+	if matrix.BookingFee, err = model.ParseOdooAmount(template.BookingFeeAmount, model.DefaultCurrency); err != nil {
+		return matrix, fmt.Errorf("%s booking fee amount: %w", template.ProductTemplateCode, err)
+	}
+
+	combinations := variantCombinations(currentAttributeRegistry(), template.Attributes)
+	matrix.Combinations = len(combinations)
+	if len(combinations) > MaxVariantCombinations {
+		log.Info(fmt.Sprintf("[Odoo - Connector - GetProductVariantMatrix] %s has %d combinations, keeping %d", template.ProductTemplateCode, len(combinations), MaxVariantCombinations))
+		combinations = combinations[:MaxVariantCombinations]
+		matrix.Truncated = true
+	}
+
+	orders := make([]model.Order, len(combinations))
+	for i, attributes := range combinations {
+		orders[i] = model.Order{ProductCode: template.ProductTemplateCode, Qty: 1, Attributes: attributes}
+	}
+	stocks, err := r.GetProductStock(ctx, model.PurchaseParams{
+		DealerID: fmt.Sprintf("%d", dealerId),
+		Orders:   orders,
+	})
+	stockUnknown := err != nil
+	if stockUnknown {
+		log.Error(fmt.Sprintf("[Odoo - Connector - GetProductVariantMatrix] %s Stock Error: ", template.ProductTemplateCode), err)
+	}
+
+	for i, attributes := range combinations {
+		variant := model.VariantCombination{
+			Attributes: attributes,
+			Price:      model.Money{Currency: matrix.MinPrice.Currency},
+			PriceDelta: model.Money{Currency: matrix.MinPrice.Currency},
+		}
+		if stockUnknown {
+			variant.Price = matrix.MinPrice
+			variant.Available = true
+			variant.StockUnknown = true
+		} else if i < len(stocks) && stocks[i].Code == odooSuccessCode {
+			if variant.Price, err = model.ParseOdooAmount(stocks[i].ProductPrice, matrix.MinPrice.Currency); err != nil {
+				return matrix, fmt.Errorf("%s price: %w", template.ProductTemplateCode, err)
+			}
+			variant.PriceDelta, _ = variant.Price.Add(matrix.MinPrice.Neg())
+			variant.Stock = stockQty(stocks[i])
+			variant.Available = true
+		}
+		matrix.Variants = append(matrix.Variants, variant)
+	}
+
+	return matrix, nil
+}
+
+// variantCombinations returns every combination of the values of the
+// attributes mapped to a variant slot, one value per slot. Attributes of
+// other IDs are left out.
+func variantCombinations(registry *AttributeRegistry, attributes []model.Attribute) [][]model.Attribute {
+	values := map[VariantSlot][]model.Attribute{}
+	seen := map[string]bool{}
+	for _, attribute := range attributes {
+		slot, ok := registry.Slot(attribute.AttributeID)
+		key := attribute.AttributeID + ":" + attribute.VariantID
+		if !ok || seen[key] {
+			continue
+		}
+		seen[key] = true
+		values[slot] = append(values[slot], attribute)
+	}
+
+	combinations := [][]model.Attribute{{}}
+	for _, slot := range matrixSlots {
+		if len(values[slot]) == 0 {
+			continue
+		}
+		next := make([][]model.Attribute, 0, len(combinations)*len(values[slot]))
+		for _, combination := range combinations {
+			for _, value := range values[slot] {
+				extended := append(append([]model.Attribute{}, combination...), value)
+				next = append(next, extended)
+			}
+		}
+		combinations = next
+	}
+	if len(combinations) == 1 && len(combinations[0]) == 0 {
+		return nil
+	}
+
+	return combinations
+}
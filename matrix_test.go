@@ -0,0 +1,99 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+
+	"zebrax.id/emi/integration/erp/connector/odoo/model"
+	"zebrax.id/emi/integration/erp/connector/odoo/repository/query"
+)
+
+// matrixQueries serves product templates and fails every stock lookup.
+type matrixQueries struct {
+	query.Querier
+	templates []model.ProductTemplate
+}
+
+func (q *matrixQueries) GetProductTemplate(ctx context.Context, arg *query.GetProductTemplateParams) (string, error) {
+	templates, err := json.Marshal(q.templates)
+	return string(templates), err
+}
+
+func (q *matrixQueries) GetProductStock(ctx context.Context, arg *query.GetProductStockParams) (string, error) {
+	return "", errors.New("connection reset")
+}
+
+func matrixTemplate(colors int, batteries int) model.ProductTemplate {
+	template := model.ProductTemplate{
+		ProductTemplateCode: "ZX1",
+		MinUnitPrice:        "25000000",
+		BookingFeeAmount:    "500000",
+	}
+	for i := 0; i < colors; i++ {
+		template.Attributes = append(template.Attributes, model.Attribute{AttributeID: "10", VariantID: fmt.Sprintf("c%d", i)})
+	}
+	for i := 0; i < batteries; i++ {
+		template.Attributes = append(template.Attributes, model.Attribute{AttributeID: "11", VariantID: fmt.Sprintf("b%d", i)})
+	}
+	return template
+}
+
+func TestGetProductVariantMatrixWithoutStock(t *testing.T) {
+	r := &repository{qry: &matrixQueries{templates: []model.ProductTemplate{matrixTemplate(2, 2)}}}
+
+	list, err := r.GetProductVariantMatrix(context.Background(), 7, "ZX1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 || len(list[0].Variants) != 4 {
+		t.Fatalf("matrix = %+v, want one template with 4 variants", list)
+	}
+
+	matrix := list[0]
+	if matrix.Combinations != 4 || matrix.Truncated {
+		t.Errorf("combinations = %d, truncated = %v, want 4 and false", matrix.Combinations, matrix.Truncated)
+	}
+	for _, variant := range matrix.Variants {
+		if !variant.StockUnknown || !variant.Available || variant.Price != matrix.MinPrice || !variant.PriceDelta.IsZero() {
+			t.Errorf("variant = %+v, want the minimum price with the stock unknown", variant)
+		}
+	}
+}
+
+func TestGetProductVariantMatrixTruncated(t *testing.T) {
+	r := &repository{qry: &matrixQueries{templates: []model.ProductTemplate{matrixTemplate(17, 16)}}}
+
+	list, err := r.GetProductVariantMatrix(context.Background(), 7, "ZX1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	matrix := list[0]
+	if !matrix.Truncated || matrix.Combinations != 272 || len(matrix.Variants) != MaxVariantCombinations {
+		t.Errorf("truncated = %v, combinations = %d, variants = %d, want true, 272 and %d",
+			matrix.Truncated, matrix.Combinations, len(matrix.Variants), MaxVariantCombinations)
+	}
+}
+
+func TestGetProductVariantMatrixSkipsUnreadableTemplates(t *testing.T) {
+	badPrice, badFee, good := matrixTemplate(1, 1), matrixTemplate(1, 1), matrixTemplate(2, 1)
+	badPrice.ProductTemplateCode, badPrice.MinUnitPrice = "ZX1-S", "25.00.000"
+	badFee.ProductTemplateCode, badFee.BookingFeeAmount = "ZX1-L", "free"
+	r := &repository{qry: &matrixQueries{templates: []model.ProductTemplate{badPrice, good, badFee}}}
+
+	list, err := r.GetProductVariantMatrix(context.Background(), 7, "ZX1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 || list[0].ProductTemplateCode != "ZX1" || len(list[0].Variants) != 2 {
+		t.Fatalf("matrix = %+v, want only ZX1 with 2 variants", list)
+	}
+
+	r.qry = &matrixQueries{templates: []model.ProductTemplate{badPrice, badFee}}
+	if _, err = r.GetProductVariantMatrix(context.Background(), 7, "ZX1"); !errors.Is(err, model.ErrInvalidAmount) {
+		t.Errorf("err = %v, want ErrInvalidAmount when no template can be read", err)
+	}
+}
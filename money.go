@@ -0,0 +1,290 @@
+package model
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Currency is an ISO 4217 currency with the number of digits of its minor unit.
+type Currency struct {
+	Code     string
+	Exponent int
+}
+
+// IDR is the currency of every Odoo company we integrate with.
+var IDR = Currency{Code: "IDR", Exponent: 2}
+
+// DefaultCurrency is used when an amount comes from Odoo without a currency.
+var DefaultCurrency = IDR
+
+var (
+	ErrInvalidAmount    = errors.New("invalid amount")
+	ErrCurrencyMismatch = errors.New("currency mismatch")
+)
+
+// Money is an amount in the minor unit of its currency, e.g. sen for IDR.
+type Money struct {
+	Currency Currency
+	Units    int64
+}
+
+// NewMoney returns an amount of whole major units, e.g. rupiah.
+func NewMoney(major int64, currency Currency) Money {
+	return Money{Currency: currency, Units: major * pow10(currency.Exponent)}
+}
+
+// ParseOdooAmount reads an amount as Odoo formats it: "33000000",
+// "33000000.0", "30.000.000", "30,000,000.50" or "Rp 1.250.000,00".
+//
+// A separator that appears more than once, or once between a leading group of
+// one to three digits and exactly three digits, is a thousands separator. When
+// both "." and "," appear, the last one is the decimal separator. Decimals
+// beyond the currency exponent must be zero.
+func ParseOdooAmount(amount string, currency Currency) (Money, error) {
+	raw := amount
+	amount = strings.TrimSpace(amount)
+	amount = strings.TrimPrefix(amount, "Rp")
+	amount = strings.TrimPrefix(amount, currency.Code)
+	amount = strings.TrimSpace(amount)
+	if amount == "" {
+		return Money{Currency: currency}, nil
+	}
+
+	negative := false
+	if strings.HasPrefix(amount, "-") {
+		negative = true
+		amount = strings.TrimSpace(amount[1:])
+	}
+
+	integer, fraction, err := splitAmount(amount)
+	if err != nil {
+		return Money{}, fmt.Errorf("%w %q: %s", ErrInvalidAmount, raw, err.Error())
+	}
+	if integer == "" {
+		integer = "0"
+	}
+
+	for len(fraction) > currency.Exponent {
+		if fraction[len(fraction)-1] != '0' {
+			return Money{}, fmt.Errorf("%w %q: more than %d decimals for %s", ErrInvalidAmount, raw, currency.Exponent, currency.Code)
+		}
+		fraction = fraction[:len(fraction)-1]
+	}
+	fraction += strings.Repeat("0", currency.Exponent-len(fraction))
+
+	units, err := strconv.ParseInt(integer+fraction, 10, 64)
+	if err != nil {
+		return Money{}, fmt.Errorf("%w %q: %s", ErrInvalidAmount, raw, err.Error())
+	}
+	if negative {
+		units = -units
+	}
+
+	return Money{Currency: currency, Units: units}, nil
+}
+
+func splitAmount(amount string) (integer string, fraction string, err error) {
+	for _, c := range amount {
+		if (c < '0' || c > '9') && c != '.' && c != ',' {
+			return "", "", fmt.Errorf("unexpected character %q", c)
+		}
+	}
+
+	dot, comma := strings.LastIndex(amount, "."), strings.LastIndex(amount, ",")
+	decimal := -1
+	switch {
+	case dot >= 0 && comma >= 0:
+		decimal = dot
+		if comma > dot {
+			decimal = comma
+		}
+	case dot >= 0:
+		decimal = singleDecimal(amount, ".")
+	case comma >= 0:
+		decimal = singleDecimal(amount, ",")
+	}
+
+	integer = amount
+	if decimal >= 0 {
+		integer, fraction = amount[:decimal], amount[decimal+1:]
+	}
+	if strings.ContainsAny(fraction, ".,") {
+		return "", "", errors.New("separator after decimal point")
+	}
+
+	integer, err = stripGroups(integer)
+	return integer, fraction, err
+}
+
+// singleDecimal returns the index of sep when it is the decimal separator, or
+// -1 when it only groups thousands.
+func singleDecimal(amount string, sep string) int {
+	if strings.Count(amount, sep) > 1 {
+		return -1
+	}
+	i := strings.Index(amount, sep)
+	// Three digits after the separator group thousands unless what comes
+	// before could not be a leading group, as in "1500000.000" or "0.500".
+	if leading := amount[:i]; len(amount)-i-1 == 3 && len(leading) >= 1 && len(leading) <= 3 && leading != "0" {
+		return -1
+	}
+
+	return i
+}
+
+// stripGroups removes the thousands separators from integer after checking
+// that every group after the first has three digits.
+func stripGroups(integer string) (string, error) {
+	sep := ""
+	switch {
+	case strings.Contains(integer, ".") && strings.Contains(integer, ","):
+		return "", errors.New("mixed thousands separators")
+	case strings.Contains(integer, "."):
+		sep = "."
+	case strings.Contains(integer, ","):
+		sep = ","
+	default:
+		return integer, nil
+	}
+
+	groups := strings.Split(integer, sep)
+	for i, group := range groups {
+		if group == "" || len(group) > 3 || (i > 0 && len(group) != 3) {
+			return "", fmt.Errorf("malformed digit group %q", group)
+		}
+	}
+
+	return strings.Join(groups, ""), nil
+}
+
+func pow10(exponent int) int64 {
+	n := int64(1)
+	for i := 0; i < exponent; i++ {
+		n *= 10
+	}
+
+	return n
+}
+
+// Add returns m + o. Both amounts must be in the same currency.
+func (m Money) Add(o Money) (Money, error) {
+	if m.Currency != o.Currency {
+		return m, fmt.Errorf("%w: %s + %s", ErrCurrencyMismatch, m.Currency.Code, o.Currency.Code)
+	}
+
+	return Money{Currency: m.Currency, Units: m.Units + o.Units}, nil
+}
+
+// Mul returns m multiplied by a quantity.
+func (m Money) Mul(qty int64) Money {
+	return Money{Currency: m.Currency, Units: m.Units * qty}
+}
+
+// Neg returns -m.
+func (m Money) Neg() Money {
+	return Money{Currency: m.Currency, Units: -m.Units}
+}
+
+func (m Money) IsZero() bool {
+	return m.Units == 0
+}
+
+// Major returns the amount in whole major units, rounded half away from zero.
+func (m Money) Major() int64 {
+	div := pow10(m.Currency.Exponent)
+	q, r := m.Units/div, m.Units%div
+	if r*2 >= div {
+		q++
+	} else if r*2 <= -div {
+		q--
+	}
+
+	return q
+}
+
+// LegacyInt32 returns the major units for the int32 proto fields that predate
+// Money, saturating instead of wrapping around when the amount does not fit.
+func (m Money) LegacyInt32() int32 {
+	major := m.Major()
+	switch {
+	case major > math.MaxInt32:
+		return math.MaxInt32
+	case major < math.MinInt32:
+		return math.MinInt32
+	}
+
+	return int32(major)
+}
+
+// Decimal formats m without grouping, e.g. "33000000" or "33000000.50", which
+// ParseOdooAmount reads back unchanged.
+func (m Money) Decimal() string {
+	if m.Currency.Exponent == 0 {
+		return strconv.FormatInt(m.Units, 10)
+	}
+
+	sign, units := "", m.Units
+	if units < 0 {
+		sign, units = "-", -units
+	}
+	div := pow10(m.Currency.Exponent)
+	major, minor := units/div, units%div
+	if minor == 0 {
+		return fmt.Sprintf("%s%d", sign, major)
+	}
+
+	return fmt.Sprintf("%s%d.%0*d", sign, major, m.Currency.Exponent, minor)
+}
+
+func (m Money) String() string {
+	return m.Currency.Code + " " + m.Decimal()
+}
+
+// UnmarshalText parses an Odoo formatted amount in DefaultCurrency.
+func (m *Money) UnmarshalText(text []byte) (err error) {
+	*m, err = ParseOdooAmount(string(text), DefaultCurrency)
+	return err
+}
+
+// MarshalJSON encodes m as {"currency":"IDR","units":3300000000}.
+func (m Money) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Currency string `json:"currency"`
+		Units    int64  `json:"units"`
+	}{m.Currency.Code, m.Units})
+}
+
+// UnmarshalJSON accepts the MarshalJSON form, or an amount as Odoo returns it
+// in its JSON views, either a formatted string or a number.
+func (m *Money) UnmarshalJSON(data []byte) error {
+	var encoded struct {
+		Currency string `json:"currency"`
+		Units    int64  `json:"units"`
+	}
+	if len(data) > 0 && data[0] == '{' {
+		if err := json.Unmarshal(data, &encoded); err != nil {
+			return err
+		}
+		currency := DefaultCurrency
+		if encoded.Currency != "" && encoded.Currency != currency.Code {
+			return fmt.Errorf("%w: unsupported currency %s", ErrCurrencyMismatch, encoded.Currency)
+		}
+		*m = Money{Currency: currency, Units: encoded.Units}
+		return nil
+	}
+
+	var text string
+	if err := json.Unmarshal(data, &text); err != nil {
+		var number json.Number
+		if err := json.Unmarshal(data, &number); err != nil {
+			return err
+		}
+		text = number.String()
+	}
+
+	return m.UnmarshalText([]byte(text))
+}
@@ -0,0 +1,53 @@
+package model
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseOdooAmount(t *testing.T) {
+	tests := []struct {
+		amount  string
+		want    int64 // in sen
+		wantErr bool
+	}{
+		{amount: "", want: 0},
+		{amount: "33000000", want: 3300000000},
+		{amount: "1500000.0", want: 150000000},
+		{amount: "1500000.00", want: 150000000},
+		{amount: "1500000.000", want: 150000000},
+		{amount: "1.500.000", want: 150000000},
+		{amount: "1,500,000", want: 150000000},
+		{amount: "1,500,000.50", want: 150000050},
+		{amount: "1.500.000,50", want: 150000050},
+		{amount: "Rp 1.250.000,00", want: 125000000},
+		{amount: "IDR 1250000", want: 125000000},
+		{amount: "-3000000", want: -300000000},
+		{amount: "1.500", want: 150000},
+		{amount: "1.5", want: 150},
+		{amount: "0.500", want: 50},
+		{amount: "1500000.05", want: 150000005},
+		{amount: "1500000.055", wantErr: true},
+		{amount: "1.50.000", wantErr: true},
+		{amount: "1.500,000.00", wantErr: true},
+		{amount: "12,50.00", wantErr: true},
+		{amount: "1500000 IDR", wantErr: true},
+		{amount: "abc", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseOdooAmount(tt.amount, IDR)
+		if tt.wantErr {
+			if !errors.Is(err, ErrInvalidAmount) {
+				t.Errorf("ParseOdooAmount(%q) err = %v, want ErrInvalidAmount", tt.amount, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseOdooAmount(%q) err = %v", tt.amount, err)
+			continue
+		}
+		if got.Units != tt.want || got.Currency != IDR {
+			t.Errorf("ParseOdooAmount(%q) = %d %s, want %d IDR", tt.amount, got.Units, got.Currency.Code, tt.want)
+		}
+	}
+}
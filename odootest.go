@@ -0,0 +1,365 @@
+// Package odootest runs an in-process fake of the Odoo XML-RPC API so the
+// connector can be exercised without a live Odoo.
+//
+// The server answers the /xmlrpc/2/common login calls and execute_kw on
+// /xmlrpc/2/object. Each model method is answered by a Handler; the models the
+// connector calls have defaults, and tests script them with Handle, Respond or
+// Fail. Every execute_kw call is recorded and can be read back with Calls.
+package odootest
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+
+	"zebrax.id/emi/integration/erp/connector/odoo/xmlrpc"
+)
+
+const (
+	// DefaultDB, DefaultUsername and DefaultPassword are the credentials the
+	// server accepts unless changed before the first call.
+	DefaultDB       = "odoo"
+	DefaultUsername = "admin"
+	DefaultPassword = "admin"
+	// DefaultUID is returned by authenticate and login.
+	DefaultUID = 2
+)
+
+// Call is one execute_kw call received by the server.
+type Call struct {
+	Model  string
+	Method string
+	Args   []interface{}
+	Kwargs map[string]interface{}
+}
+
+// Handler answers an execute_kw call. A returned error is sent as an XML-RPC
+// fault; use Fault to choose the fault code.
+type Handler func(call Call) (interface{}, error)
+
+// Fault is an XML-RPC fault returned by a Handler.
+type Fault struct {
+	Code   int
+	String string
+}
+
+func (f *Fault) Error() string {
+	return fmt.Sprintf("odootest: fault %d: %s", f.Code, f.String)
+}
+
+// Server is a fake Odoo XML-RPC server.
+type Server struct {
+	// URL is the base URL of the server, e.g. http://127.0.0.1:54321.
+	URL string
+
+	DB       string
+	Username string
+	Password string
+	UID      int
+
+	srv *httptest.Server
+
+	mu       sync.Mutex
+	handlers map[string]Handler
+	calls    []Call
+	nextID   int
+}
+
+// NewServer starts a server with the default handlers. Close it when done.
+func NewServer() *Server {
+	s := &Server{
+		DB:       DefaultDB,
+		Username: DefaultUsername,
+		Password: DefaultPassword,
+		UID:      DefaultUID,
+		handlers: make(map[string]Handler),
+		nextID:   1000,
+	}
+	s.registerDefaults()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/xmlrpc/2/common", s.serveCommon)
+	mux.HandleFunc("/xmlrpc/2/object", s.serveObject)
+	s.srv = httptest.NewServer(mux)
+	s.URL = s.srv.URL
+
+	return s
+}
+
+// Close shuts the server down.
+func (s *Server) Close() {
+	s.srv.Close()
+}
+
+// Handle sets the handler of model.method, replacing any default.
+func (s *Server) Handle(model string, method string, handler Handler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.handlers[handlerKey(model, method)] = handler
+}
+
+// Respond makes model.method always return value.
+func (s *Server) Respond(model string, method string, value interface{}) {
+	s.Handle(model, method, func(Call) (interface{}, error) {
+		return value, nil
+	})
+}
+
+// Fail makes model.method always return a fault.
+func (s *Server) Fail(model string, method string, code int, message string) {
+	s.Handle(model, method, func(Call) (interface{}, error) {
+		return nil, &Fault{Code: code, String: message}
+	})
+}
+
+// Calls returns the execute_kw calls received so far, oldest first.
+func (s *Server) Calls() []Call {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Call(nil), s.calls...)
+}
+
+// CallsTo returns the received calls of model.method.
+func (s *Server) CallsTo(model string, method string) (calls []Call) {
+	for _, call := range s.Calls() {
+		if call.Model == model && call.Method == method {
+			calls = append(calls, call)
+		}
+	}
+	return calls
+}
+
+// Reset forgets the recorded calls and restores the default handlers.
+func (s *Server) Reset() {
+	s.mu.Lock()
+	s.calls = nil
+	s.handlers = make(map[string]Handler)
+	s.mu.Unlock()
+	s.registerDefaults()
+}
+
+// NextID returns a fresh record ID, as used by the default create handlers.
+func (s *Server) NextID() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nextID++
+	return s.nextID
+}
+
+func handlerKey(model string, method string) string {
+	return model + "." + method
+}
+
+// registerDefaults answers the models the connector calls with the smallest
+// successful responses.
+func (s *Server) registerDefaults() {
+	ok := func(Call) (interface{}, error) { return true, nil }
+
+	// create is called with a list of values and returns a list of IDs.
+	create := func(call Call) (interface{}, error) {
+		count := 1
+		if len(call.Args) > 0 {
+			if values, isList := call.Args[0].([]interface{}); isList {
+				count = len(values)
+			}
+		}
+		ids := make([]interface{}, 0, count)
+		for i := 0; i < count; i++ {
+			ids = append(ids, s.NextID())
+		}
+		return ids, nil
+	}
+
+	for _, model := range []string{"sale.order", "sale.order.line"} {
+		s.Handle(model, "create", create)
+		s.Handle(model, "unlink", ok)
+		s.Handle(model, "write", ok)
+	}
+	s.Handle("sale.order", "action_cancel", ok)
+	s.Handle("sale.order", "action_confirm", ok)
+	s.Handle("sale.order", "recompute_coupon_lines", ok)
+	s.Handle("sale.order.line", "compute_amount", ok)
+
+	s.Handle("em.appointment.system", "action_confirm", ok)
+	s.Handle("em.appointment.system", "action_cancel", ok)
+
+	s.Handle("sale.coupon.apply.code", "process_coupon_so", ok)
+	none := func(Call) (interface{}, error) {
+		return []interface{}{}, nil
+	}
+	s.Handle("sale.order", "search", none)
+	s.Handle("sale.coupon", "search", none)
+	s.Handle("sale.coupon", "search_read", none)
+	s.Handle("sale.coupon", "write", ok)
+	s.Handle("sale.coupon.program", "search_read", none)
+	s.Handle("resource.calendar.leaves", "search_read", none)
+
+	bookingFee := func(call Call) (interface{}, error) {
+		return map[string]interface{}{
+			"Code":           "0",
+			"Message":        call.Method + " success",
+			"ResponseDetail": map[string]interface{}{},
+		}, nil
+	}
+	for _, method := range []string{"create_booking_fee", "view_booking_fee", "set_payment_method", "reset_payment_method", "confirm_booking_fee"} {
+		s.Handle("x.booking.fee", method, bookingFee)
+	}
+}
+
+func (s *Server) serveCommon(w http.ResponseWriter, req *http.Request) {
+	method, params, err := readMethodCall(req)
+	if err != nil {
+		writeFault(w, 1, err.Error())
+		return
+	}
+
+	switch method {
+	case "version":
+		writeResponse(w, map[string]interface{}{
+			"server_version":      "14.0",
+			"server_version_info": []interface{}{14, 0, 0, "final", 0, ""},
+			"server_serie":        "14.0",
+			"protocol_version":    1,
+		})
+	case "login", "authenticate":
+		if len(params) < 3 || params[0] != s.DB || params[1] != s.Username || params[2] != s.Password {
+			writeResponse(w, false)
+			return
+		}
+		writeResponse(w, s.UID)
+	default:
+		writeFault(w, 1, fmt.Sprintf("method %q is not supported", method))
+	}
+}
+
+func (s *Server) serveObject(w http.ResponseWriter, req *http.Request) {
+	method, params, err := readMethodCall(req)
+	if err != nil {
+		writeFault(w, 1, err.Error())
+		return
+	}
+	if method != "execute_kw" && method != "execute" {
+		writeFault(w, 1, fmt.Sprintf("method %q is not supported", method))
+		return
+	}
+	if len(params) < 5 {
+		writeFault(w, 1, "execute_kw expects db, uid, password, model and method")
+		return
+	}
+	if params[0] != s.DB || params[1] != s.UID || params[2] != s.Password {
+		writeFault(w, 3, "Access Denied")
+		return
+	}
+
+	call := Call{}
+	call.Model, _ = params[3].(string)
+	call.Method, _ = params[4].(string)
+	if method == "execute" {
+		call.Args = params[5:]
+	} else {
+		if len(params) > 5 {
+			call.Args, _ = params[5].([]interface{})
+		}
+		if len(params) > 6 {
+			call.Kwargs, _ = params[6].(map[string]interface{})
+		}
+	}
+
+	s.mu.Lock()
+	s.calls = append(s.calls, call)
+	handler, found := s.handlers[handlerKey(call.Model, call.Method)]
+	s.mu.Unlock()
+
+	if !found {
+		writeFault(w, 2, fmt.Sprintf("The method '%s' does not exist on the model '%s'", call.Method, call.Model))
+		return
+	}
+
+	response, err := handler(call)
+	if err != nil {
+		var fault *Fault
+		if errors.As(err, &fault) {
+			writeFault(w, fault.Code, fault.String)
+			return
+		}
+		writeFault(w, 1, err.Error())
+		return
+	}
+	writeResponse(w, response)
+}
+
+// readMethodCall decodes an XML-RPC methodCall into its method name and
+// parameters. Integers decode as int, doubles as float64, structs as
+// map[string]interface{} and arrays as []interface{}.
+func readMethodCall(req *http.Request) (method string, params []interface{}, err error) {
+	if req.Method != http.MethodPost {
+		return "", nil, fmt.Errorf("XML-RPC expects POST, got %s", req.Method)
+	}
+
+	decoder := xml.NewDecoder(req.Body)
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", nil, err
+		}
+
+		start, isStart := token.(xml.StartElement)
+		if !isStart {
+			continue
+		}
+		switch start.Name.Local {
+		case "methodName":
+			if err = decoder.DecodeElement(&method, &start); err != nil {
+				return "", nil, err
+			}
+			method = strings.TrimSpace(method)
+		case "value":
+			value, err := xmlrpc.DecodeValue(decoder)
+			if err != nil {
+				return "", nil, err
+			}
+			params = append(params, value)
+		}
+	}
+	if method == "" {
+		return "", nil, errors.New("methodCall without methodName")
+	}
+
+	return method, params, nil
+}
+
+func writeResponse(w http.ResponseWriter, value interface{}) {
+	var body bytes.Buffer
+	body.WriteString(`<?xml version="1.0"?><methodResponse><params><param>`)
+	if err := xmlrpc.EncodeValue(&body, value); err != nil {
+		writeFault(w, 1, err.Error())
+		return
+	}
+	body.WriteString(`</param></params></methodResponse>`)
+	writeXML(w, body.Bytes())
+}
+
+func writeFault(w http.ResponseWriter, code int, message string) {
+	var body bytes.Buffer
+	body.WriteString(`<?xml version="1.0"?><methodResponse><fault>`)
+	xmlrpc.EncodeValue(&body, map[string]interface{}{
+		"faultCode":   code,
+		"faultString": message,
+	})
+	body.WriteString(`</fault></methodResponse>`)
+	writeXML(w, body.Bytes())
+}
+
+func writeXML(w http.ResponseWriter, body []byte) {
+	w.Header().Set("Content-Type", "text/xml")
+	w.Write(body)
+}
@@ -6,7 +6,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"strings"
+	"strconv"
 	"time"
 
 	log "github.com/sirupsen/logrus"
@@ -21,25 +21,34 @@ func jMarshal(response interface{}) (result model.PreOrderResponse) {
 		log.Error(err)
 	}
 
-	list := new(model.PreOrderResponse)
 	if err := json.Unmarshal(jsonStr, &result); err != nil {
 		log.Error(err)
 	}
 
-	return *list
+	return result
 }
 
-func (r *repository) SetBookingTestDrive(bookParams model.BookParams) (list model.BookingTestDriveResponse, err error) {
+func (r *repository) SetBookingTestDrive(ctx context.Context, bookParams model.BookParams) (list model.BookingTestDriveResponse, err error) {
 	defer log.Info("[Odoo - Connector - SetBookingTestDrive] End")
 	log.Info("[Odoo - Connector - SetBookingTestDrive] Start Type : ", bookParams.BookingTypeID)
 	// Set Booking Test Drive into DB
 	// Success Output Sample : "0|Inserting Succesfully TD/D0202/22/00187|733|1|Product 1|TD/D0202/22/00187|2022-03-01|2022-03-01T11:00:00+07:00|2022-03-01T12:00:00+07:00|1|Indy Office Bintaro|Jl. Al Hidayah No.44, Pd. Jaya, Kec. Pd. Aren |-6.27466|106.72046|Kota Tangerang Selatan|Banten|Indonesia|Everydays 10.00 - 18.00"
 	// Error Output Sample : "1| Slot ID not exists in database|0|||||||||||||||"
-	// the output should be split and get each item for the return value
-	result := ""
+	// the output is decoded into bookingTestDriveResult
+	hold, err := r.checkTestDriveHold(ctx, bookParams)
+	if err != nil {
+		list.Code = "1"
+		list.Message = err.Error()
+		if errors.Is(err, model.ErrSlotHeld) {
+			return list, nil
+		}
+		return list, err
+	}
+
+	result, function := "", "fn_booking_testdrive_v2"
 	if bookParams.BookingTypeID == 1 {
 		log.Info("[Odoo - Connector - SetBookingTestDrive] Function SetBookingTestDriveV2")
-		result, err = r.qry.SetBookingTestDriveV2(context.Background(), &query.SetBookingTestDriveV2Params{
+		result, err = r.qry.SetBookingTestDriveV2(ctx, &query.SetBookingTestDriveV2Params{
 			FnBookingTestdriveV2:   "I",
 			FnBookingTestdriveV2_2: bookParams.EcID,
 			FnBookingTestdriveV2_3: bookParams.ProductID,
@@ -50,7 +59,17 @@ func (r *repository) SetBookingTestDrive(bookParams model.BookParams) (list mode
 		})
 	} else {
 		log.Info("[Odoo - Connector - SetBookingTestDrive] Function SetBookingTestDriveOnWheel")
-		result, err = r.qry.SetBookingTestDriveOnWheel(context.Background(), &query.SetBookingTestDriveOnWheelParams{
+		if err = r.checkServiceArea(ctx, bookParams); err != nil {
+			log.Info("[Odoo - Connector - SetBookingTestDrive] Service Area ", err.Error())
+			list.Code = "1"
+			list.Message = err.Error()
+			if errors.Is(err, ErrOutsideServiceArea) || errors.Is(err, ErrInvalidCoordinates) {
+				return list, nil
+			}
+			return list, err
+		}
+		function = "fn_booking_testdrive_onwheels_v2"
+		result, err = r.qry.SetBookingTestDriveOnWheel(ctx, &query.SetBookingTestDriveOnWheelParams{
 			FnBookingTestdriveOnwheelsV2:    "I",
 			FnBookingTestdriveOnwheelsV2_2:  bookParams.EcID,
 			FnBookingTestdriveOnwheelsV2_3:  bookParams.ProductID,
@@ -70,14 +89,17 @@ func (r *repository) SetBookingTestDrive(bookParams model.BookParams) (list mode
 		log.Info("[Odoo - Connector - SetBookingTestDrive] Error ", err.Error())
 		return list, err
 	}
-	bookResult := strings.Split(result, utils.ConnectorOdooSeparator)
+	bookResult := bookingTestDriveResult{}
+	if err = decodeOdooResult(function, result, &bookResult); err != nil {
+		log.Info("[Odoo - Connector - SetBookingTestDrive] Decode Error ", err.Error())
+		return list, err
+	}
 
-	list.Code = bookResult[0]
-	if bookResult[0] == "0" {
-		log.Info("[Odoo - Connector - SetBookingTestDrive] RPC em.appointment.system -  action_confirm: ", bookResult[2])
-		bookingId, _ := utils.StringToInt(bookResult[2])
-		_, err := r.rpc.ExecuteKw("action_confirm", "em.appointment.system", []interface{}{
-			[]interface{}{bookingId},
+	list.Code = bookResult.Code
+	if bookResult.ok() {
+		log.Info("[Odoo - Connector - SetBookingTestDrive] RPC em.appointment.system -  action_confirm: ", bookResult.BookingID)
+		_, err := r.executeKw(ctx, "action_confirm", "em.appointment.system", []interface{}{
+			[]interface{}{bookResult.BookingID},
 		}, nil)
 
 		if err != nil {
@@ -87,38 +109,35 @@ func (r *repository) SetBookingTestDrive(bookParams model.BookParams) (list mode
 			return list, err
 		}
 
-		list.Message = bookResult[1] + " " + bookResult[5]
-		list.ProductID = bookResult[3]
-		list.ProductName = bookResult[4]
-		list.BookingID = bookResult[2]
-		list.BookingCode = bookResult[5]
-		list.Date = bookResult[6]
-		list.StartTime = bookResult[7]
-		list.EndTime = bookResult[8]
-		list.LocationID = bookResult[9]
-		list.LocationName = bookResult[10]
-		list.Address = bookResult[11]
-		list.Longitude = bookResult[13]
-		list.Latitude = bookResult[12]
-		list.City = bookResult[14]
-		list.State = bookResult[15]
-		list.Country = bookResult[16]
-		list.OperatingHours = bookResult[17]
+		bookResult.fill(&list)
+		list.Message = bookResult.Message + " " + bookResult.BookingCode
 		list.Notes = ""
+		r.claimTestDriveHold(ctx, hold)
 	} else {
-		list.Message = bookResult[1]
-		log.Info("[Odoo - Connector - SetBookingTestDrive] Error ", bookResult[1])
+		list.Message = bookResult.Message
+		log.Info("[Odoo - Connector - SetBookingTestDrive] Error ", bookResult.Message)
 	}
 
 	return list, nil
 }
 
-func (r *repository) SetRescheduleBookingTestDrive(bookParams model.BookParams) (list model.BookingTestDriveResponse, err error) {
+func (r *repository) SetRescheduleBookingTestDrive(ctx context.Context, bookParams model.BookParams) (list model.BookingTestDriveResponse, err error) {
 	// Set Reschedule Booking Test Drive into DB
 	// Success Output Sample : "0|Inserting Succesfully TD/D0202/22/00187|733|1|Product 1|TD/D0202/22/00187|2022-03-01|2022-03-01T11:00:00+07:00|2022-03-01T12:00:00+07:00|1|Indy Office Bintaro|Jl. Al Hidayah No.44, Pd. Jaya, Kec. Pd. Aren |-6.27466|106.72046|Kota Tangerang Selatan|Banten|Indonesia|Everydays 10.00 - 18.00"
 	// Error Output Sample : "1| Slot ID not exists in database|0|||||||||||||||"
-	// the output should be split and get each item for the return value
-	result, err := r.qry.SetReschedulerBookingTestDrive(context.Background(), &query.SetReschedulerBookingTestDriveParams{
+	// the output is decoded into bookingTestDriveResult
+	hold, err := r.checkTestDriveHold(ctx, bookParams)
+	if err != nil {
+		list.Code = "1"
+		list.Message = err.Error()
+		if errors.Is(err, model.ErrSlotHeld) {
+			return list, nil
+		}
+		return list, err
+	}
+	freed, found := r.testDriveSlotOfBooking(ctx, bookParams.BookingID)
+
+	result, err := r.qry.SetReschedulerBookingTestDrive(ctx, &query.SetReschedulerBookingTestDriveParams{
 		FnBookingTestdriveRescheduleV2:   bookParams.BookingID,
 		FnBookingTestdriveRescheduleV2_2: bookParams.EcID,
 		FnBookingTestdriveRescheduleV2_3: bookParams.ProductID,
@@ -134,38 +153,32 @@ func (r *repository) SetRescheduleBookingTestDrive(bookParams model.BookParams)
 		return list, err
 	}
 
-	bookResult := strings.Split(result, utils.ConnectorOdooSeparator)
-
-	list.Code = bookResult[0]
-	if bookResult[0] == "0" {
-		list.Message = bookResult[1]
-		list.ProductID = bookResult[3]
-		list.ProductName = bookResult[4]
-		list.BookingID = bookResult[2]
-		list.BookingCode = bookResult[5]
-		list.Date = bookResult[6]
-		list.StartTime = bookResult[7]
-		list.EndTime = bookResult[8]
-		list.LocationID = bookResult[9]
-		list.LocationName = bookResult[10]
-		list.Address = bookResult[11]
-		list.Longitude = bookResult[13]
-		list.Latitude = bookResult[12]
-		list.City = bookResult[14]
-		list.State = bookResult[15]
-		list.Country = bookResult[16]
-		list.OperatingHours = bookResult[17]
-	} else {
-		list.Message = bookResult[1]
+	bookResult := bookingTestDriveResult{}
+	if err = decodeOdooResult("fn_booking_testdrive_reschedule_v2", result, &bookResult); err != nil {
+		list.Code = "1"
+		list.Message = err.Error()
+		return list, err
+	}
+
+	list.Code = bookResult.Code
+	list.Message = bookResult.Message
+	if bookResult.ok() {
+		bookResult.fill(&list)
+		r.cancelTestDriveReminders(ctx, bookParams.BookingID)
+		r.claimTestDriveHold(ctx, hold)
+		if found {
+			r.offerTestDriveSlot(ctx, freed)
+		}
 	}
 
 	return list, nil
 }
 
-func (r *repository) SetCancelBookingTestDrive(bookParams model.CancelBookingTestDriveParams) (list model.BookingTestDriveResponse, err error) {
+func (r *repository) SetCancelBookingTestDrive(ctx context.Context, bookParams model.CancelBookingTestDriveParams) (list model.BookingTestDriveResponse, err error) {
 	// Set Cancel Booking Test Drive into DB
-	// the output should be split and get each item for the return value
-	result, err := r.qry.SetCancelBookingTestDrive(context.Background(), &query.SetCancelBookingTestDriveParams{
+	// the output is decoded into cancelBookingTestDriveResult
+	freed, found := r.testDriveSlotOfBooking(ctx, bookParams.BookingID)
+	result, err := r.qry.SetCancelBookingTestDrive(ctx, &query.SetCancelBookingTestDriveParams{
 		SpBookingTestdriveCancel:   bookParams.BookingID,
 		SpBookingTestdriveCancel_2: bookParams.CategoryID,
 		SpBookingTestdriveCancel_3: bookParams.Comment,
@@ -180,70 +193,41 @@ func (r *repository) SetCancelBookingTestDrive(bookParams model.CancelBookingTes
 		return list, err
 	}
 
-	cancelResult := strings.Split(result, utils.ConnectorOdooSeparator)
+	cancelResult := cancelBookingTestDriveResult{}
+	if err = decodeOdooResult("sp_booking_testdrive_cancel", result, &cancelResult); err != nil {
+		list.Code = "1"
+		list.Message = err.Error()
+		return list, err
+	}
 
-	list.Code = cancelResult[0]
-	list.Message = cancelResult[1]
+	list.Code = cancelResult.Code
+	list.Message = cancelResult.Message
+	if cancelResult.ok() {
+		r.cancelTestDriveReminders(ctx, bookParams.BookingID)
+		if found {
+			r.offerTestDriveSlot(ctx, freed)
+		}
+	}
 
 	return list, nil
 }
 
-func (r *repository) SetVoucherRedeem(salesOrderId int32, voucherId int32) (list model.OrderConfirmationResponses, err error) {
+// SetVoucherRedeem applies a single voucher to the sales order; see
+// SetVoucherRedeems.
+func (r *repository) SetVoucherRedeem(ctx context.Context, salesOrderId int32, voucherId int32) (list model.OrderConfirmationResponses, err error) {
 	if salesOrderId == 0 || voucherId == 0 {
 		return list, err
 	}
 
-	// Check Availability of vouchers based on SoId
-	// Output Sample : "0|Searching Get Succesfully 6968773680224492744|6968773680224492744|Y"
-	// the output should be split, take the second and third index
-	resultGetVoucherCode, _ := r.qry.GetVoucherCodeBySoIdAndVoucherId(context.Background(), &query.GetVoucherCodeBySoIdAndVoucherIdParams{
-		FnGetVoucherCode:   salesOrderId,
-		FnGetVoucherCode_2: voucherId,
-	})
-	vouchers := strings.Split(resultGetVoucherCode, utils.ConnectorOdooSeparator)
-
-	if vouchers[3] == "N" {
-		log.Info(fmt.Sprintf("[Odoo - Connector - SetVoucherRedeemSetVoucherRedeem] sale.coupon.apply.code - process_coupon_so Params SalesOrderId: %d, couponCode: %s\n\n", salesOrderId, vouchers[2]))
-		_, err = r.rpc.ExecuteKw("process_coupon_so", "sale.coupon.apply.code", []interface{}{
-			map[string]interface{}{
-				"order_id":    salesOrderId,
-				"coupon_code": vouchers[2],
-			},
-		}, nil)
-		if err != nil {
-			fmt.Printf("[Odoo - Connector - SetVoucherRedeemSetVoucherRedeem] Error : \n%s\n", err.Error())
-			return list, err
-		}
-
-		// Output Sample : "0|Searching Get Succesfully |10900|15"
-		// the output should be split, take the second index for computing the amount
-		getVoucherLine, _ := r.qry.GetVoucherLineBySoId(context.Background(), salesOrderId)
-		voucherLine := strings.Split(getVoucherLine, utils.ConnectorOdooSeparator)
-		voucherLineId, _ := utils.StringToInt(voucherLine[2])
-		log.Info(fmt.Sprintf("[Odoo - Connector - SetVoucherRedeemSetVoucherRedeem] sale.order.line - compute_amount Params couponCode: %s\n\n", voucherLine[2]))
-		_, err = r.rpc.ExecuteKw("compute_amount", "sale.order.line", []interface{}{
-			[]interface{}{voucherLineId},
-		}, nil)
-		if err != nil {
-			fmt.Printf("[Odoo - Connector - SetVoucherRedeemSetVoucherRedeem] sale.order.line - compute_amount : \n%s\n", err.Error())
-			return list, err
-		}
-	}
-
-	soDetail, _ := r.qry.GetSoDetailBySoId(context.Background(), salesOrderId)
-	if soDetail != "" {
-		json.Unmarshal([]byte(soDetail), &list)
-	}
-
-	return list, nil
+	return r.SetVoucherRedeems(ctx, salesOrderId, []int32{voucherId})
 }
 
-func (r *repository) GetEvAvailable() (list []model.EvAvailable, err error) {
+func (r *repository) GetEvAvailable(ctx context.Context) (list []model.EvAvailable, err error) {
 	var (
 		mapping = make(map[int32]*model.EvAvailable)
 	)
 
-	getList, err := r.qry.GetAllEvAvailable(context.Background())
+	getList, err := r.qry.GetAllEvAvailable(ctx)
 	for _, row := range getList {
 		if _, ok := mapping[row.LocationID]; !ok {
 			mapping[row.LocationID] = &model.EvAvailable{
@@ -269,12 +253,13 @@ func (r *repository) GetEvAvailable() (list []model.EvAvailable, err error) {
 	for _, row := range mapping {
 		list = append(list, *row)
 	}
+	model.SortEvAvailable(list)
 
 	return list, err
 }
 
-func (r *repository) GetProductTemplatePrice(dealerId int32, productCode string) (list []model.ProductTemplate, err error) {
-	productTemplate, err := r.qry.GetProductTemplate(context.Background(), &query.GetProductTemplateParams{
+func (r *repository) GetProductTemplatePrice(ctx context.Context, dealerId int32, productCode string) (list []model.ProductTemplate, err error) {
+	productTemplate, err := r.qry.GetProductTemplate(ctx, &query.GetProductTemplateParams{
 		FnApiProducttemplatePricelist:   dealerId,
 		FnApiProducttemplatePricelist_2: productCode,
 	})
@@ -285,8 +270,8 @@ func (r *repository) GetProductTemplatePrice(dealerId int32, productCode string)
 	return
 }
 
-func (r *repository) GetVoucherList(salesOrderId int32) (list model.Voucher, err error) {
-	voucherList, err := r.qry.GetVoucherList(context.Background(), salesOrderId)
+func (r *repository) GetVoucherList(ctx context.Context, salesOrderId int32) (list model.Voucher, err error) {
+	voucherList, err := r.qry.GetVoucherList(ctx, salesOrderId)
 	if voucherList != "" {
 		json.Unmarshal([]byte(voucherList), &list)
 	}
@@ -294,204 +279,228 @@ func (r *repository) GetVoucherList(salesOrderId int32) (list model.Voucher, err
 	return
 }
 
-func (r *repository) SetOrderConfirmation(purchaseParams model.PurchaseParams) (result model.OrderConfirmationResponses, err error) {
+// orderLine is one entry of PurchaseParams.Orders with its attributes
+// resolved to variant slots.
+type orderLine struct {
+	ProductCode string
+	Qty         int
+	Variants    variantSet
+}
+
+// resolveOrderLines resolves every entry of purchaseParams.Orders. A missing
+// quantity counts as one unit.
+func resolveOrderLines(purchaseParams model.PurchaseParams) (lines []orderLine, err error) {
+	registry := currentAttributeRegistry()
+	for _, order := range purchaseParams.Orders {
+		variants, err := registry.Resolve(order.Attributes)
+		if err != nil {
+			return nil, fmt.Errorf("product %s: %w", order.ProductCode, err)
+		}
+
+		qty := int(order.Qty)
+		if qty <= 0 {
+			qty = 1
+		}
+		lines = append(lines, orderLine{
+			ProductCode: order.ProductCode,
+			Qty:         qty,
+			Variants:    variants,
+		})
+	}
+
+	return lines, nil
+}
+
+func (r *repository) SetOrderConfirmation(ctx context.Context, purchaseParams model.PurchaseParams) (result model.OrderConfirmationResponses, err error) {
 	defer log.Info("[Odoo - Connector - SetOrderConfirmation] End")
 	log.Info("[Odoo - Connector - SetOrderConfirmation] Start")
 
-	var (
-		colorVariantId, batteryVariantId, wheelVariantId, mirrorVarianId string = "0", "0", "0", "0"
-		vehicleCode                                                      string = ""
-	)
-
 	uId, _ := utils.StringToInt(purchaseParams.CustomerID)
 	dealerId, _ := utils.StringToInt(purchaseParams.DealerID)
 	orderId, _ := utils.StringToInt(purchaseParams.SalesOrderID)
 
-	for _, order := range purchaseParams.Orders {
-		vehicleCode = order.ProductCode
-		for _, attr := range order.Attributes {
-			switch attr.AttributeID {
-			case "4":
-				mirrorVarianId = attr.VariantID
-			case "5":
-				wheelVariantId = attr.VariantID
-			case "10":
-				colorVariantId = attr.VariantID
-			case "11":
-				batteryVariantId = attr.VariantID
-			}
-		}
+	lines, err := resolveOrderLines(purchaseParams)
+	if err != nil {
+		log.Info("[Odoo - Connector - SetOrderConfirmation] Error ", err.Error())
+		return result, err
 	}
 
 	if purchaseParams.CustomerID == "0" || purchaseParams.CustomerID == "" {
 		log.Info("[Odoo - Connector - SetOrderConfirmation] For Guest")
-		getProductResult, err := r.qry.GetProductIdAsGuest(context.Background(), &query.GetProductIdAsGuestParams{
-			FnGetProductIDGuest:   dealerId,
-			FnGetProductIDGuest_2: uId,
-			FnGetProductIDGuest_3: vehicleCode,
-			FnGetProductIDGuest_4: colorVariantId,
-			FnGetProductIDGuest_5: batteryVariantId,
-			FnGetProductIDGuest_6: mirrorVarianId,
-			FnGetProductIDGuest_7: wheelVariantId,
-		})
-		if err != nil {
-			return result, err
-		}
-
-		// Sample Output : 0|Searching Product Succesfully A11113|104|33000000|1|EV-V Sporty Single Battery|4|A11113|29|Grey|1|Color|30.000.000||0|33000000||0|3000000|30000000|30000000
-		productResult := strings.Split(getProductResult, utils.ConnectorOdooSeparator)
-
-		result.Code = productResult[0]
-		result.Message = productResult[1]
+		return r.guestOrderConfirmation(ctx, dealerId, uId, lines)
+	}
 
-		// If Code == "1" then return
-		if productResult[0] == "1" {
-			log.Info("[Odoo - Connector - SetOrderConfirmation] Error ", productResult[1])
-			return result, errors.New(productResult[1])
+	if orderId == 0 {
+		if len(lines) == 0 {
+			return result, errors.New("order confirmation without order lines")
 		}
 
-		grandTotal, _ := utils.StringToInt(productResult[15])
-
-		attributes := []model.Attribute{}
-		attributes = append(attributes, model.Attribute{
-			AttributeID:   productResult[10],
-			AttributeName: productResult[11],
-			VariantID:     productResult[8],
-			VariantName:   productResult[9],
-			Label:         "Included",
-			ProductCode:   "",
-			Stock:         "",
-		})
-
-		result.SoID = "0"
-		result.SoNumber = ""
-		result.AmountUntaxed = productResult[19]
-		result.Tax = productResult[18]
-		result.Total = int32(grandTotal)
-		result.GrandTotal = productResult[15]
-		result.Purchase.Total = productResult[20]
-		result.Purchase.Items = append(result.Purchase.Items, model.OrderConfirmationAttributes{
-			OdooName:   productResult[5],
-			OdooValue:  productResult[3],
-			Label:      productResult[12],
-			Attributes: attributes,
-		})
-
-		result.Administrations.Total = productResult[18]
-		result.Tax = productResult[18]
+		products, status, err := r.resolveProductIds(ctx, dealerId, uId, lines)
+		result.Code = status.Code
+		result.Message = status.Message
+		if err != nil || !status.ok() {
+			return result, err
+		}
 
-		//If voucher applied
-		if productResult[14] != "0" {
-			reductionValue, _ := utils.StringToInt(productResult[14])
-			result.Reductions.Items = append(result.Reductions.Items, model.OrderConfirmationAttributes{
-				Name:          productResult[13],
-				Value:         (int64(reductionValue) * -1),
-				Label:         productResult[17],
-				ReductionType: "discount",
-			})
-			result.Reductions.Total = fmt.Sprintf("-%d", (int64(reductionValue) * -1))
+		orderId, err = r.createSaleOrder(ctx, uId, dealerId, lines, products)
+		if err != nil {
+			log.Info("[Odoo - Connector - SetOrderConfirmation] Create Sale Order Error: ", err.Error())
+			return result, err
 		}
+		r.recordSaleOrderLines(ctx, int32(orderId), lines)
+	}
 
-		return result, err
+	log.Info("[Odoo - Connector - SetOrderConfirmation] Get So Detail By SoId : ", orderId)
+	soDetail, _ := r.qry.GetSoDetailBySoId(ctx, orderId)
+	if soDetail != "" {
+		json.Unmarshal([]byte(soDetail), &result)
 	}
 
-	if orderId == 0 {
+	return result, err
+}
+
+// resolveProductIds looks up the Odoo product of every order line. It stops at
+// the first line Odoo has no product for and returns its status.
+func (r *repository) resolveProductIds(ctx context.Context, dealerId int, uId int, lines []orderLine) (products []productIdResult, status odooStatus, err error) {
+	products = make([]productIdResult, 0, len(lines))
+	for _, line := range lines {
 		paramsGetProductId := &query.GetProductIdParams{
 			FnGetProductIDV2:   dealerId,
 			FnGetProductIDV2_2: uId,
-			FnGetProductIDV2_3: vehicleCode,
-			FnGetProductIDV2_4: colorVariantId,
-			FnGetProductIDV2_5: batteryVariantId,
-			FnGetProductIDV2_6: mirrorVarianId,
-			FnGetProductIDV2_7: wheelVariantId,
+			FnGetProductIDV2_3: line.ProductCode,
+			FnGetProductIDV2_4: line.Variants.Color,
+			FnGetProductIDV2_5: line.Variants.Battery,
+			FnGetProductIDV2_6: line.Variants.Mirror,
+			FnGetProductIDV2_7: line.Variants.Wheel,
 		}
 		log.Info(fmt.Sprintf("[Odoo - Connector - SetOrderConfirmation] GetProductId Params : \n%#v\n", paramsGetProductId))
-		getProductSoResult, err := r.qry.GetProductId(context.Background(), paramsGetProductId)
+		getProductSoResult, err := r.qry.GetProductId(ctx, paramsGetProductId)
 		if err != nil {
 			log.Info(fmt.Sprintf("[Odoo - Connector - SetOrderConfirmation] GetProductId Query Error: \n%s\n", err.Error()))
-			return result, err
+			return products, status, err
 		}
 
 		// Sample Output : 0|Searching Product Succesfully A11113|104|33000000|1|EV-V Sporty Single Battery|4
 		log.Info(fmt.Sprintf("[Odoo - Connector - SetOrderConfirmation] GetProductId ouput string: \n%s\n", getProductSoResult))
-		productSoResult := strings.Split(getProductSoResult, utils.ConnectorOdooSeparator)
-
-		result.Code = productSoResult[0]
-		result.Message = productSoResult[1]
+		productSoResult := productIdResult{}
+		if err = decodeOdooResult("fn_get_product_id_v2", getProductSoResult, &productSoResult); err != nil {
+			log.Info(fmt.Sprintf("[Odoo - Connector - SetOrderConfirmation] GetProductId Decode Error: \n%s\n", err.Error()))
+			return products, status, err
+		}
 
+		status = productSoResult.odooStatus
 		// If Code == "1" then return
-		if productSoResult[0] == "1" {
-			log.Info(fmt.Sprintf("[Odoo - Connector - SetOrderConfirmation] GetProductId Error : \n%s\n", productSoResult[1]))
-			return result, err
+		if !productSoResult.ok() {
+			log.Info(fmt.Sprintf("[Odoo - Connector - SetOrderConfirmation] GetProductId Error : \n%s\n", productSoResult.Message))
+			return products, status, nil
 		}
+		products = append(products, productSoResult)
+	}
 
-		priceListId, _ := utils.StringToInt(productSoResult[6])
-		productId, _ := utils.StringToInt(productSoResult[2])
-		unitPrice, _ := utils.StringToFloat64(productSoResult[3])
-		uomId, _ := utils.StringToInt(productSoResult[4])
-		prodName := productSoResult[5]
-
-		log.Info("[Odoo - Connector - SetOrderConfirmation] Execute Sale.Order - Create")
-		params := map[string]interface{}{
-			"partner_id":            uId,
-			"sale_order_type":       2,
-			"company_id":            dealerId,
-			"pricelist_id":          priceListId,
-			"show_update_pricelist": true,
-			"state":                 "draft",
-		}
-		log.Info(fmt.Sprintf("[Odoo - Connector - SetOrderConfirmation] Execute Sale.Order with Params: \n%#v\n", params))
-		getOrderId, err := r.rpc.ExecuteKw("create", "sale.order", []interface{}{
-			[]interface{}{
-				params,
-			},
-		}, nil)
+	return products, status, nil
+}
 
+// guestOrderConfirmation prices the order lines for a customer without an
+// Odoo partner. No sale order is created, so the totals are summed here from
+// the per-unit amounts fn_get_product_id_guest returns for each line, the
+// reduction included.
+func (r *repository) guestOrderConfirmation(ctx context.Context, dealerId int, uId int, lines []orderLine) (result model.OrderConfirmationResponses, err error) {
+	var (
+		currency       = model.DefaultCurrency
+		grandTotal     = model.Money{Currency: currency}
+		amountUntaxed  = model.Money{Currency: currency}
+		tax            = model.Money{Currency: currency}
+		purchaseTotal  = model.Money{Currency: currency}
+		reductionTotal = model.Money{Currency: currency}
+	)
+
+	for _, line := range lines {
+		getProductResult, err := r.qry.GetProductIdAsGuest(ctx, &query.GetProductIdAsGuestParams{
+			FnGetProductIDGuest:   dealerId,
+			FnGetProductIDGuest_2: uId,
+			FnGetProductIDGuest_3: line.ProductCode,
+			FnGetProductIDGuest_4: line.Variants.Color,
+			FnGetProductIDGuest_5: line.Variants.Battery,
+			FnGetProductIDGuest_6: line.Variants.Mirror,
+			FnGetProductIDGuest_7: line.Variants.Wheel,
+		})
 		if err != nil {
 			return result, err
 		}
 
-		log.Info("[Odoo - Connector - SetOrderConfirmation] Execute Sale.Order.Line - Create Order Id Original : ", getOrderId)
-		orderId, _ = utils.StringToInt(removeFirstAndLastChar(fmt.Sprintf("%d", getOrderId)))
-		params = map[string]interface{}{
-			"order_id":        orderId,
-			"product_id":      productId,
-			"name":            prodName,
-			"product_uom":     uomId,
-			"product_uom_qty": 1,
-			"price_unit":      unitPrice,
-			"price_total":     unitPrice,
-		}
-		log.Info(fmt.Sprintf("[Odoo - Connector - SetOrderConfirmation] Execute Sale.Order.Line with Params: \n%#v\n", params))
-		_, err = r.rpc.ExecuteKw("create", "sale.order.line", []interface{}{
-			[]interface{}{
-				params,
-			},
-		}, nil)
-
-		if err != nil {
+		// Sample Output : 0|Searching Product Succesfully A11113|104|33000000|1|EV-V Sporty Single Battery|4|A11113|29|Grey|1|Color|30.000.000||0|33000000||0|3000000|30000000|30000000
+		productResult := productGuestResult{}
+		if err = decodeOdooResult("fn_get_product_id_guest", getProductResult, &productResult); err != nil {
 			return result, err
 		}
 
-		log.Info("[Odoo - Connector - SetOrderConfirmation] Execute Sale.Order - recompute_coupon_lines params order Id: ", orderId)
-		_, err = r.rpc.ExecuteKw("recompute_coupon_lines", "sale.order", []interface{}{
-			[]interface{}{
-				orderId,
-			},
-		}, nil)
+		result.Code = productResult.Code
+		result.Message = productResult.Message
 
-		if err != nil {
-			return result, err
+		// If Code == "1" then return
+		if !productResult.ok() {
+			log.Info("[Odoo - Connector - SetOrderConfirmation] Error ", productResult.Message)
+			return result, errors.New(productResult.Message)
+		}
+
+		qty := int64(line.Qty)
+		for _, sum := range []struct {
+			total  *model.Money
+			amount model.Money
+		}{
+			{&grandTotal, productResult.GrandTotal.Mul(qty)},
+			{&amountUntaxed, productResult.AmountUntaxed.Mul(qty)},
+			{&tax, productResult.Tax.Mul(qty)},
+			{&purchaseTotal, productResult.PurchaseTotal.Mul(qty)},
+			{&reductionTotal, productResult.ReductionValue.Mul(qty).Neg()},
+		} {
+			if *sum.total, err = sum.total.Add(sum.amount); err != nil {
+				return result, err
+			}
+		}
+
+		attributes := []model.Attribute{}
+		attributes = append(attributes, model.Attribute{
+			AttributeID:   productResult.AttributeID,
+			AttributeName: productResult.AttributeName,
+			VariantID:     productResult.VariantID,
+			VariantName:   productResult.VariantName,
+			Label:         "Included",
+			ProductCode:   "",
+			Stock:         "",
+		})
+
+		result.Purchase.Items = append(result.Purchase.Items, model.OrderConfirmationAttributes{
+			OdooName:   productResult.ProductName,
+			OdooValue:  productResult.UnitPrice.Mul(qty).Decimal(),
+			Label:      productResult.PriceLabel,
+			Attributes: attributes,
+		})
+
+		//If voucher applied
+		if !productResult.ReductionValue.IsZero() {
+			result.Reductions.Items = append(result.Reductions.Items, model.OrderConfirmationAttributes{
+				Name:          productResult.ReductionName,
+				Value:         productResult.ReductionValue.Mul(qty).Neg().Major(),
+				Label:         productResult.ReductionLabel,
+				ReductionType: "discount",
+			})
 		}
 	}
 
-	log.Info("[Odoo - Connector - SetOrderConfirmation] Get So Detail By SoId : ", orderId)
-	soDetail, _ := r.qry.GetSoDetailBySoId(context.Background(), orderId)
-	if soDetail != "" {
-		json.Unmarshal([]byte(soDetail), &result)
+	result.SoID = "0"
+	result.SoNumber = ""
+	result.AmountUntaxed = amountUntaxed.Decimal()
+	result.Total = grandTotal.LegacyInt32()
+	result.GrandTotal = grandTotal.Decimal()
+	result.Purchase.Total = purchaseTotal.Decimal()
+	result.Administrations.Total = tax.Decimal()
+	result.Tax = tax.Decimal()
+	if !reductionTotal.IsZero() {
+		result.Reductions.Total = reductionTotal.Decimal()
 	}
 
-	return result, err
+	return result, nil
 }
 
 func removeFirstAndLastChar(a string) string {
@@ -499,13 +508,13 @@ func removeFirstAndLastChar(a string) string {
 	return removeFirst[:len(removeFirst)-1]
 }
 
-func (r *repository) GetTestDriveListByUid(uId string) (list []model.BookingTestDriveResponse, err error) {
+func (r *repository) GetTestDriveListByUid(ctx context.Context, uId string) (list []model.BookingTestDriveResponse, err error) {
 	defer log.Info("[Odoo - Connector - GetTestDriveListByUid] End")
 	log.Info("[Odoo - Connector - GetTestDriveListByUid] Start")
 
 	log.Info(fmt.Sprintf("[Odoo - Connector - GetTestDriveListByUid] Get Data Test Drive : User: %s", uId))
 	userId, _ := utils.StringToInt32(uId)
-	listTestDrives, err := r.qry.GetTestDriveListByCustomerView(context.Background(), sql.NullInt32{Int32: userId, Valid: true})
+	listTestDrives, err := r.qry.GetTestDriveListByCustomerView(ctx, sql.NullInt32{Int32: userId, Valid: true})
 	for _, row := range listTestDrives {
 		list = append(list, model.BookingTestDriveResponse{
 			ProductID:          fmt.Sprintf("%d", row.ProductID.Int32),
@@ -540,36 +549,53 @@ func (r *repository) GetTestDriveListByUid(uId string) (list []model.BookingTest
 	return list, nil
 }
 
-func (r *repository) GetTestDriveTimeSlot(productId string, EcId int32, startDate string, endDate string, appointmentTypeId int32) (list []model.SlotTimeResponses, err error) {
+func (r *repository) GetTestDriveTimeSlot(ctx context.Context, productId string, EcId int32, startDate string, endDate string, appointmentTypeId int32) (list []model.SlotTimeResponses, err error) {
 	defer log.Info("[Odoo - Connector - GetTestDriveTimeSlot] End")
 	log.Info("[Odoo - Connector - GetTestDriveTimeSlot] Start")
 
-	log.Info("[Odoo - Connector - GetTestDriveTimeSlot] Exec Disable the previous day's slotTime")
-	err = r.qry.SetSlotTimeDisable(context.Background())
-	if err != nil {
-		log.Info("[Odoo - Connector - GetTestDriveTimeSlot] Exec Disable the previous day's slotTime Error: ", err.Error())
-		return nil, err
-	}
-
 	layout := "2006-01-02"
 	startDateFormat, _ := time.Parse(layout, startDate)
 	endDateFormat, _ := time.Parse(layout, endDate)
+	// Days before today in WIB are not read at all; the slots of today that
+	// already started are left out below.
+	if today, _ := time.Parse(layout, time.Now().In(model.WIB).Format(layout)); startDateFormat.Before(today) {
+		startDateFormat = today
+	}
 	pId, _ := utils.StringToInt32(productId)
 	if appointmentTypeId == 2 {
 		log.Info(fmt.Sprintf("[Odoo - Connector - GetTestDriveTimeSlot Onwheels] Get Data Slot with Params ProductId : %s, EcId: %d, startDate: %s, endDate: %s, AppointmentTypeId: %d", productId, EcId, startDate, endDate, appointmentTypeId))
-		return r.timeSlotOnWheels(pId, EcId, startDateFormat, endDateFormat, appointmentTypeId)
+		return r.timeSlotOnWheels(ctx, pId, EcId, startDateFormat, endDateFormat, appointmentTypeId)
 	}
 
 	log.Info(fmt.Sprintf("[Odoo - Connector - GetTestDriveTimeSlot Standard] Get Data Slot with Params ProductId : %s, EcId: %d, startDate: %s, endDate: %s, AppointmentTypeId: %d", productId, EcId, startDate, endDate, appointmentTypeId))
-	return r.timeSlot(pId, EcId, startDateFormat, endDateFormat, appointmentTypeId)
+	return r.timeSlot(ctx, pId, EcId, startDateFormat, endDateFormat, appointmentTypeId)
+}
+
+// GetExperienceCenterSchedule returns the structured operating hours of an
+// experience center at now. ok is false when the hours are unknown or cannot
+// be read.
+func (r *repository) GetExperienceCenterSchedule(ctx context.Context, ecId int32, now time.Time) (schedule model.OperatingSchedule, ok bool, err error) {
+	defer log.Info("[Odoo - Connector - GetExperienceCenterSchedule] End")
+	log.Info("[Odoo - Connector - GetExperienceCenterSchedule] Start")
+
+	row, err := r.qry.GetExperienceCenterHours(ctx, sql.NullInt32{Int32: ecId, Valid: true})
+	if errors.Is(err, sql.ErrNoRows) {
+		return schedule, false, nil
+	}
+	if err != nil {
+		return schedule, false, err
+	}
+
+	schedule, ok = model.ParseOperatingSchedule(row.OperationalHours.String, model.DealerLocation(row.StateName.String, row.EcLongitude.String), now)
+	return schedule, ok, nil
 }
 
-func (r *repository) timeSlot(productId int32, EcId int32, startDateFormat time.Time, endDateFormat time.Time, appointmentTypeId int32) (list []model.SlotTimeResponses, err error) {
+func (r *repository) timeSlot(ctx context.Context, productId int32, EcId int32, startDateFormat time.Time, endDateFormat time.Time, appointmentTypeId int32) (list []model.SlotTimeResponses, err error) {
 	var (
 		mapping = make(map[string]*model.SlotTimeResponses)
 	)
 
-	slotTimeRow, err := r.qry.GetSlotTime(context.Background(), &query.GetSlotTimeParams{
+	slotTimeRow, err := r.qry.GetSlotTime(ctx, &query.GetSlotTimeParams{
 		ID:                productId,
 		ID_2:              EcId,
 		SlotDate:          startDateFormat,
@@ -581,7 +607,14 @@ func (r *repository) timeSlot(productId int32, EcId int32, startDateFormat time.
 		return list, err
 	}
 
+	holds := r.testDriveSlotHolds(ctx, productId, EcId, appointmentTypeId, startDateFormat.AddDate(0, 0, -1), endDateFormat.AddDate(0, 0, 2))
+	now := time.Now()
 	for _, row := range slotTimeRow {
+		// RunSlotDisableJob only runs every few minutes; skip the slots that
+		// started since.
+		if !slotBookable(row.StartTimeIso, now) {
+			continue
+		}
 		stringIdx := utils.InterfaceToString(row.Combination)
 		if _, ok := mapping[stringIdx]; !ok {
 			mapping[stringIdx] = &model.SlotTimeResponses{
@@ -600,23 +633,24 @@ func (r *repository) timeSlot(productId int32, EcId int32, startDateFormat time.
 			EndTime:      row.Etime,
 			IsoStartTime: row.StartTimeIso,
 			IsoEndTime:   row.EndTimeIso,
-			Available:    fmt.Sprintf("%d", row.Jml),
+			Available:    availableSeats(row.Jml, row.StartTimeIso, holds),
 		})
 	}
 
 	for _, row := range mapping {
 		list = append(list, *row)
 	}
+	model.SortSlotTimes(list)
 
 	return list, err
 }
 
-func (r *repository) timeSlotOnWheels(productId int32, EcId int32, startDateFormat time.Time, endDateFormat time.Time, appointmentTypeId int32) (list []model.SlotTimeResponses, err error) {
+func (r *repository) timeSlotOnWheels(ctx context.Context, productId int32, EcId int32, startDateFormat time.Time, endDateFormat time.Time, appointmentTypeId int32) (list []model.SlotTimeResponses, err error) {
 	var (
 		mapping = make(map[string]*model.SlotTimeResponses)
 	)
 
-	slotTimeRow, err := r.qry.GetSlotTimeOnwheels(context.Background(), &query.GetSlotTimeOnwheelsParams{
+	slotTimeRow, err := r.qry.GetSlotTimeOnwheels(ctx, &query.GetSlotTimeOnwheelsParams{
 		ID:         productId,
 		ID_2:       EcId,
 		SlotDate:   startDateFormat,
@@ -627,7 +661,14 @@ func (r *repository) timeSlotOnWheels(productId int32, EcId int32, startDateForm
 		return list, err
 	}
 
+	holds := r.testDriveSlotHolds(ctx, productId, EcId, appointmentTypeId, startDateFormat.AddDate(0, 0, -1), endDateFormat.AddDate(0, 0, 2))
+	now := time.Now()
 	for _, row := range slotTimeRow {
+		// RunSlotDisableJob only runs every few minutes; skip the slots that
+		// started since.
+		if !slotBookable(row.StartTimeIso, now) {
+			continue
+		}
 		stringIdx := utils.InterfaceToString(row.Combination)
 		if _, ok := mapping[stringIdx]; !ok {
 			mapping[stringIdx] = &model.SlotTimeResponses{
@@ -646,84 +687,122 @@ func (r *repository) timeSlotOnWheels(productId int32, EcId int32, startDateForm
 			EndTime:      row.Etime,
 			IsoStartTime: row.StartTimeIso,
 			IsoEndTime:   row.EndTimeIso,
-			Available:    fmt.Sprintf("%d", row.Jml),
+			Available:    availableSeats(row.Jml, row.StartTimeIso, holds),
 		})
 	}
 
 	for _, row := range mapping {
 		list = append(list, *row)
 	}
+	model.SortSlotTimes(list)
 
 	return list, err
 }
 
-func (r *repository) GetProductStock(purchaseParams model.PurchaseParams) (result model.PurchaseStock, err error) {
+// slotBookable reports whether a slot starting at isoStart is still ahead.
+// Slots with an unreadable start are kept, as before.
+func slotBookable(isoStart string, now time.Time) bool {
+	start, err := time.Parse(time.RFC3339, isoStart)
+	return err != nil || start.After(now)
+}
+
+// GetProductStock returns the stock of every order line, in the order of
+// purchaseParams.Orders, less the stock reserved for other sales orders than
+// purchaseParams.SalesOrderID.
+func (r *repository) GetProductStock(ctx context.Context, purchaseParams model.PurchaseParams) (list []model.PurchaseStock, err error) {
 	defer log.Info("[Odoo - Connector - GetProductStock] End")
 
 	log.Info("[Odoo - Connector - GetProductStock] Start")
-	var (
-		colorVariantId, batteryVariantId, wheelVariantId, mirrorVarianId string = "0", "0", "0", "0"
-		vehicleCode                                                      string = ""
-		uId                                                              int    = 0
-	)
+	list, err = r.odooStock(ctx, purchaseParams)
+	if err != nil {
+		return list, err
+	}
 
-	dealerId, _ := utils.StringToInt(purchaseParams.DealerID)
-	for _, order := range purchaseParams.Orders {
-		vehicleCode = order.ProductCode
-		for _, attr := range order.Attributes {
-			switch attr.AttributeID {
-			case "4":
-				mirrorVarianId = attr.VariantID
-			case "5":
-				wheelVariantId = attr.VariantID
-			case "10":
-				colorVariantId = attr.VariantID
-			case "11":
-				batteryVariantId = attr.VariantID
+	dealerId, _ := utils.StringToInt32(purchaseParams.DealerID)
+	salesOrderId, _ := utils.StringToInt32(purchaseParams.SalesOrderID)
+	lines, _ := resolveOrderLines(purchaseParams)
+	for i, line := range lines {
+		if list[i].Qty == "" {
+			continue
+		}
+		reserved, err := reservedStock(ctx, r.qry, dealerId, line, salesOrderId, time.Now())
+		if err != nil {
+			log.Error("[Odoo - Connector - GetProductStock] Reserved Stock Error: ", err)
+			continue
+		}
+		if reserved > 0 {
+			available := stockQty(list[i]) - reserved
+			if available < 0 {
+				available = 0
 			}
+			list[i].Qty = strconv.Itoa(available)
 		}
 	}
 
-	log.Info(fmt.Sprintf("[Odoo - Connector - GetProductStock] Get Data Product Stock dealer: %d, product: %s, colorId: %s, Battrery: %s, Mirror: %s, Wheel: %s",
-		dealerId, vehicleCode, colorVariantId, batteryVariantId, mirrorVarianId, wheelVariantId,
-	))
-	getProductSoResult, err := r.qry.GetProductStock(context.Background(), &query.GetProductStockParams{
-		FnGetProductStock:   dealerId,
-		FnGetProductStock_2: uId,
-		FnGetProductStock_3: vehicleCode,
-		FnGetProductStock_4: colorVariantId,
-		FnGetProductStock_5: batteryVariantId,
-		FnGetProductStock_6: mirrorVarianId,
-		FnGetProductStock_7: wheelVariantId,
-	})
+	return list, nil
+}
 
+// odooStock returns the stock on hand Odoo reports for every order line.
+func (r *repository) odooStock(ctx context.Context, purchaseParams model.PurchaseParams) (list []model.PurchaseStock, err error) {
+	var (
+		uId int = 0
+	)
+
+	dealerId, _ := utils.StringToInt(purchaseParams.DealerID)
+	lines, err := resolveOrderLines(purchaseParams)
 	if err != nil {
-		return result, err
+		log.Info("[Odoo - Connector - GetProductStock] Error ", err.Error())
+		return list, err
 	}
 
-	// Sample Output : 0|Searching Product Succesfully A11113|104|33000000|1|EV-V Sporty Single Battery|4
-	productSoResult := strings.Split(getProductSoResult, utils.ConnectorOdooSeparator)
+	for _, line := range lines {
+		log.Info(fmt.Sprintf("[Odoo - Connector - GetProductStock] Get Data Product Stock dealer: %d, product: %s, colorId: %s, Battrery: %s, Mirror: %s, Wheel: %s",
+			dealerId, line.ProductCode, line.Variants.Color, line.Variants.Battery, line.Variants.Mirror, line.Variants.Wheel,
+		))
+		getProductSoResult, err := r.qry.GetProductStock(ctx, &query.GetProductStockParams{
+			FnGetProductStock:   dealerId,
+			FnGetProductStock_2: uId,
+			FnGetProductStock_3: line.ProductCode,
+			FnGetProductStock_4: line.Variants.Color,
+			FnGetProductStock_5: line.Variants.Battery,
+			FnGetProductStock_6: line.Variants.Mirror,
+			FnGetProductStock_7: line.Variants.Wheel,
+		})
 
-	result.Code = productSoResult[0]
-	result.Message = productSoResult[1]
+		if err != nil {
+			return list, err
+		}
 
-	// If Code == "1" then return
-	if productSoResult[0] == "1" {
-		return result, err
-	}
+		productSoResult := productStockResult{}
+		if err = decodeOdooResult("fn_get_product_stock", getProductSoResult, &productSoResult); err != nil {
+			return list, err
+		}
 
-	result = model.PurchaseStock{
-		ProductCode:  productSoResult[8],
-		Qty:          productSoResult[7],
-		ProductPrice: productSoResult[3],
+		// If Code == "1" the line has no stock record
+		if !productSoResult.ok() {
+			list = append(list, model.PurchaseStock{
+				Code:        productSoResult.Code,
+				Message:     productSoResult.Message,
+				ProductCode: line.ProductCode,
+			})
+			continue
+		}
+
+		list = append(list, model.PurchaseStock{
+			Code:         productSoResult.Code,
+			Message:      productSoResult.Message,
+			ProductCode:  productSoResult.ProductCode,
+			Qty:          productSoResult.Qty,
+			ProductPrice: productSoResult.ProductPrice,
+		})
 	}
 
-	return result, err
+	return list, nil
 }
 
-func (r *repository) GetBookingServiceList(uID string) (list []model.ServiceBookingResponse, err error) {
+func (r *repository) GetBookingServiceList(ctx context.Context, uID string) (list []model.ServiceBookingResponse, err error) {
 	log.Info(fmt.Sprintf("[Odoo - Connector - GetBookingServiceList] Get Data Booking Service : \n%s\n", uID))
-	stringResult, err := r.qry.GetBookingServiceList(context.Background(), uID)
+	stringResult, err := r.qry.GetBookingServiceList(ctx, uID)
 	if err != nil {
 		log.Info(fmt.Sprintf("[Odoo - Connector - GetBookingServiceList] Error : \n%s\n", err.Error()))
 		return list, err
@@ -736,7 +815,7 @@ func (r *repository) GetBookingServiceList(uID string) (list []model.ServiceBook
 	return list, err
 }
 
-func (r *repository) SetPreOrderConfirmation(purchaseParams model.PurchaseParams) (result model.PreOrderResponse, err error) {
+func (r *repository) SetPreOrderConfirmation(ctx context.Context, purchaseParams model.PurchaseParams) (result model.PreOrderResponse, err error) {
 	defer log.Info("[Odoo - Connector - SetPreOrderConfirmation] End")
 	log.Info("[Odoo - Connector - SetPreOrderConfirmation] Start")
 
@@ -763,7 +842,7 @@ func (r *repository) SetPreOrderConfirmation(purchaseParams model.PurchaseParams
 
 		log.Info("[Odoo - Connector - SetPreOrderConfirmation] Execute X.Booking.Fee - CreatdealerIde")
 		log.Info(fmt.Sprintf("[Odoo - Connector - SetPreOrderConfirmation] Execute create_booking_fee with Params 1: \n%#v\n", preOrderParamJSONMap))
-		bookingFeeResponse, err := r.rpc.ExecuteKw("create_booking_fee", "x.booking.fee", []interface{}{preOrderParamJSONMap}, nil)
+		bookingFeeResponse, err := r.executeKw(ctx, "create_booking_fee", "x.booking.fee", []interface{}{preOrderParamJSONMap}, nil)
 		if err != nil {
 			return result, err
 		}
@@ -786,7 +865,7 @@ func (r *repository) SetPreOrderConfirmation(purchaseParams model.PurchaseParams
 	params := map[string]interface{}{
 		"booking_fee_id": orderId,
 	}
-	viewResponse, err := r.rpc.ExecuteKw("view_booking_fee", "x.booking.fee", []interface{}{params}, nil)
+	viewResponse, err := r.executeKw(ctx, "view_booking_fee", "x.booking.fee", []interface{}{params}, nil)
 	// jsonStr, err := json.Marshal(viewResponse)
 	// if err != nil {
 	// 	log.Error(err)
@@ -801,7 +880,7 @@ func (r *repository) SetPreOrderConfirmation(purchaseParams model.PurchaseParams
 	return preOrderResult, nil
 }
 
-func (r *repository) SetPreOrderPaymentMethod(salesOrderId int32, paymentMethodCode string) (result model.PreOrderResponse, err error) {
+func (r *repository) SetPreOrderPaymentMethod(ctx context.Context, salesOrderId int32, paymentMethodCode string) (result model.PreOrderResponse, err error) {
 	defer log.Info("[Odoo - Connector - SetPreOrderPaymentMethod] End")
 	log.Info("[Odoo - Connector - SetPreOrderPaymentMethod] Start")
 
@@ -810,7 +889,7 @@ func (r *repository) SetPreOrderPaymentMethod(salesOrderId int32, paymentMethodC
 		"product_code":   paymentMethodCode,
 	}
 	log.Info("[Odoo - Connector - SetPreOrderConfirmation] Set PaymentMethod for BookingFeeID : ", salesOrderId)
-	paymentResponse, err := r.rpc.ExecuteKw("set_payment_method", "x.booking.fee", []interface{}{params}, nil)
+	paymentResponse, err := r.executeKw(ctx, "set_payment_method", "x.booking.fee", []interface{}{params}, nil)
 	// jsonStr, err := json.Marshal(paymentResponse)
 	// if err != nil {
 	// 	log.Error(err)
@@ -825,7 +904,7 @@ func (r *repository) SetPreOrderPaymentMethod(salesOrderId int32, paymentMethodC
 	return preOrderResult, nil
 }
 
-func (r *repository) ResetPreOrderPaymentMethod(salesOrderId int32) (result model.PreOrderResponse, err error) {
+func (r *repository) ResetPreOrderPaymentMethod(ctx context.Context, salesOrderId int32) (result model.PreOrderResponse, err error) {
 	defer log.Info("[Odoo - Connector - ResetPreOrderPaymentMethod] End")
 	log.Info("[Odoo - Connector - ResetPreOrderPaymentMethod] Start")
 
@@ -833,7 +912,7 @@ func (r *repository) ResetPreOrderPaymentMethod(salesOrderId int32) (result mode
 		"booking_fee_id": salesOrderId,
 	}
 	log.Info("[Odoo - Connector - SetPreOrderConfirmation] Reset PaymentMethod for BookingFeeID : ", salesOrderId)
-	paymentResponse, err := r.rpc.ExecuteKw("reset_payment_method", "x.booking.fee", []interface{}{params}, nil)
+	paymentResponse, err := r.executeKw(ctx, "reset_payment_method", "x.booking.fee", []interface{}{params}, nil)
 	// jsonStr, err := json.Marshal(paymentResponse)
 	// if err != nil {
 	// 	log.Error(err)
@@ -848,7 +927,7 @@ func (r *repository) ResetPreOrderPaymentMethod(salesOrderId int32) (result mode
 	return preOrderResult, nil
 }
 
-func (r *repository) PreOrderPaymentConfirm(salesOrderId int32) (result model.PreOrderResponse, err error) {
+func (r *repository) PreOrderPaymentConfirm(ctx context.Context, salesOrderId int32) (result model.PreOrderResponse, err error) {
 	defer log.Info("[Odoo - Connector - PreOrderPaymentConfirm] End")
 	log.Info("[Odoo - Connector - PreOrderPaymentConfirm] Start")
 
@@ -856,7 +935,7 @@ func (r *repository) PreOrderPaymentConfirm(salesOrderId int32) (result model.Pr
 		"booking_fee_id": salesOrderId,
 	}
 	log.Info("[Odoo - Connector - PreOrderPaymentConfirm] Set PaymentConfirm for BookingFeeID : ", salesOrderId)
-	paymentResponse, err := r.rpc.ExecuteKw("confirm_booking_fee", "x.booking.fee", []interface{}{params}, nil)
+	paymentResponse, err := r.executeKw(ctx, "confirm_booking_fee", "x.booking.fee", []interface{}{params}, nil)
 	// jsonStr, err := json.Marshal(paymentResponse)
 	// if err != nil {
 	// 	log.Error(err)
@@ -0,0 +1,71 @@
+package repository
+
+import (
+	"context"
+	"testing"
+
+	"zebrax.id/emi/integration/erp/connector/odoo/model"
+)
+
+// TestPreOrderFlow runs the booking fee flow end to end against the Odoo test
+// server over XML-RPC.
+func TestPreOrderFlow(t *testing.T) {
+	r, srv := newTestRepository(t)
+	ctx := context.Background()
+
+	created, err := r.SetPreOrderConfirmation(ctx, model.PurchaseParams{
+		CustomerID: "42",
+		DealerID:   "3",
+		Orders:     []model.Order{{ProductCode: "A11113", Qty: 1}},
+	})
+	if err != nil {
+		t.Fatalf("SetPreOrderConfirmation: %v", err)
+	}
+	if created.Code != "0" || created.Message != "create_booking_fee success" {
+		t.Errorf("SetPreOrderConfirmation = %+v", created)
+	}
+	if calls := srv.CallsTo("x.booking.fee", "create_booking_fee"); len(calls) != 1 {
+		t.Fatalf("got %d create_booking_fee calls, want 1", len(calls))
+	}
+
+	viewed, err := r.SetPreOrderConfirmation(ctx, model.PurchaseParams{SalesOrderID: "55"})
+	if err != nil {
+		t.Fatalf("SetPreOrderConfirmation (view): %v", err)
+	}
+	if viewed.Message != "view_booking_fee success" {
+		t.Errorf("SetPreOrderConfirmation (view) = %+v", viewed)
+	}
+
+	steps := []struct {
+		method string
+		run    func() (model.PreOrderResponse, error)
+	}{
+		{"set_payment_method", func() (model.PreOrderResponse, error) { return r.SetPreOrderPaymentMethod(ctx, 55, "VA_BCA") }},
+		{"reset_payment_method", func() (model.PreOrderResponse, error) { return r.ResetPreOrderPaymentMethod(ctx, 55) }},
+		{"confirm_booking_fee", func() (model.PreOrderResponse, error) { return r.PreOrderPaymentConfirm(ctx, 55) }},
+	}
+	for _, step := range steps {
+		result, err := step.run()
+		if err != nil {
+			t.Fatalf("%s: %v", step.method, err)
+		}
+		if result.Message != step.method+" success" {
+			t.Errorf("%s = %+v", step.method, result)
+		}
+
+		calls := srv.CallsTo("x.booking.fee", step.method)
+		if len(calls) != 1 {
+			t.Fatalf("got %d %s calls, want 1", len(calls), step.method)
+		}
+		params, _ := calls[0].Args[0].(map[string]interface{})
+		if params["booking_fee_id"] != 55 {
+			t.Errorf("%s params = %#v", step.method, params)
+		}
+	}
+	if calls := srv.CallsTo("x.booking.fee", "set_payment_method"); len(calls) == 1 {
+		params, _ := calls[0].Args[0].(map[string]interface{})
+		if params["product_code"] != "VA_BCA" {
+			t.Errorf("set_payment_method product_code = %v", params["product_code"])
+		}
+	}
+}
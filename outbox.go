@@ -0,0 +1,258 @@
+package usecase
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/tabbed/pqtype"
+	"zebrax.id/emi/integration/core/proto"
+	"zebrax.id/emi/integration/erp/adapter/repository/query"
+)
+
+// Outbox message kinds, one per Vendure notification.
+const (
+	OutboxOrderStatus = "vendure.order_status"
+	OutboxPlateStatus = "vendure.plate_status"
+)
+
+// OutboxConfig controls the outbox dispatcher.
+type OutboxConfig struct {
+	// PollInterval is how often the outbox is checked for due messages.
+	PollInterval time.Duration
+	// BatchSize is the number of messages claimed per poll.
+	BatchSize int32
+	// Lease is how long a claimed message is hidden from other dispatchers. It
+	// is renewed right before each delivery, so it only has to cover one.
+	Lease time.Duration
+	// MaxAttempts moves a message to the dead letter table once reached.
+	MaxAttempts int32
+	// BaseBackoff doubles after each failed attempt, up to MaxBackoff.
+	BaseBackoff time.Duration
+	MaxBackoff  time.Duration
+}
+
+// DefaultOutboxConfig retries for roughly a day before giving up.
+var DefaultOutboxConfig = OutboxConfig{
+	PollInterval: 5 * time.Second,
+	BatchSize:    50,
+	Lease:        time.Minute,
+	MaxAttempts:  20,
+	BaseBackoff:  5 * time.Second,
+	MaxBackoff:   2 * time.Hour,
+}
+
+var (
+	outboxConfigMu sync.RWMutex
+	outboxConfig   = DefaultOutboxConfig
+)
+
+func currentOutboxConfig() OutboxConfig {
+	outboxConfigMu.RLock()
+	defer outboxConfigMu.RUnlock()
+	return outboxConfig
+}
+
+// outboxHolder names this process as the holder of claimed messages.
+var outboxHolder = func() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	return fmt.Sprintf("%s-%d-%08x", host, os.Getpid(), rand.Uint32())
+}()
+
+// outboxMessage builds the outbox row of a notification. Messages with the
+// same aggregateKey are delivered in the order they were written.
+func outboxMessage(kind string, aggregateKey string, payload interface{}) (query.CreateOutboxMessageParams, error) {
+	payloadJSON, err := json.Marshal(payload)
+	if err != nil {
+		return query.CreateOutboxMessageParams{}, err
+	}
+
+	now := time.Now()
+	return query.CreateOutboxMessageParams{
+		Kind:            kind,
+		AggregateKey:    aggregateKey,
+		Payload:         pqtype.NullRawMessage{RawMessage: payloadJSON, Valid: true},
+		CreatedTime:     now,
+		NextAttemptTime: now,
+	}, nil
+}
+
+// RunOutboxDispatcher delivers outbox messages to Vendure until ctx is done.
+// Several instances may run it; claimed messages are leased to one of them.
+func (r *useCase) RunOutboxDispatcher(ctx context.Context, config OutboxConfig) {
+	log.Info("[Outbox] Dispatcher Start")
+	defer log.Info("[Outbox] Dispatcher End")
+
+	outboxConfigMu.Lock()
+	outboxConfig = config
+	outboxConfigMu.Unlock()
+
+	ticker := time.NewTicker(config.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		for {
+			claimed, err := r.dispatchOutbox(ctx, config)
+			if err != nil {
+				log.Error("[Outbox] Dispatch Error: ", err)
+				break
+			}
+			if claimed < int(config.BatchSize) {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// dispatchOutbox claims one batch of due messages and delivers them. It
+// returns the number of messages claimed.
+func (r *useCase) dispatchOutbox(ctx context.Context, config OutboxConfig) (int, error) {
+	now := time.Now()
+	messages, err := r.repo.ClaimOutboxMessages(ctx, &query.ClaimOutboxMessagesParams{
+		Now:         now,
+		LockedUntil: sql.NullTime{Time: now.Add(config.Lease), Valid: true},
+		LockedBy:    outboxHolder,
+		Limit:       config.BatchSize,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	for _, message := range messages {
+		if ctx.Err() != nil {
+			return len(messages), ctx.Err()
+		}
+
+		// The batch may outlast the lease of its later messages; deliver a
+		// message only while it is still ours.
+		now := time.Now()
+		renewed, err := r.repo.RenewOutboxLease(ctx, &query.RenewOutboxLeaseParams{
+			ID:          message.ID,
+			LockedBy:    outboxHolder,
+			LockedUntil: sql.NullTime{Time: now.Add(config.Lease), Valid: true},
+			Now:         sql.NullTime{Time: now, Valid: true},
+		})
+		if err != nil {
+			return len(messages), err
+		}
+		if renewed == 0 {
+			log.Info(fmt.Sprintf("[Outbox] Lease of %s #%d Lost", message.Kind, message.ID))
+			continue
+		}
+
+		r.deliverOutbox(ctx, config, message)
+	}
+
+	return len(messages), nil
+}
+
+// deliverOutboxNow delivers a message right after it was written, unless an
+// earlier message of its aggregate is still pending, and returns Vendure's
+// response. Messages it cannot deliver are left to RunOutboxDispatcher.
+func (r *useCase) deliverOutboxNow(ctx context.Context, message query.OutboxMessage) (response interface{}) {
+	config := currentOutboxConfig()
+	now := time.Now()
+	claimed, err := r.repo.ClaimOutboxMessage(ctx, &query.ClaimOutboxMessageParams{
+		ID:          message.ID,
+		Now:         sql.NullTime{Time: now, Valid: true},
+		LockedUntil: sql.NullTime{Time: now.Add(config.Lease), Valid: true},
+		LockedBy:    outboxHolder,
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		log.Info(fmt.Sprintf("[Outbox] %s #%d Queued behind %s", message.Kind, message.ID, message.AggregateKey))
+		return nil
+	}
+	if err != nil {
+		log.Error("[Outbox] Claim Message Error: ", err)
+		return nil
+	}
+
+	return r.deliverOutbox(ctx, config, claimed)
+}
+
+// deliverOutbox delivers a claimed message and deletes it, or schedules the
+// next attempt with backoff. It returns Vendure's response when delivered.
+func (r *useCase) deliverOutbox(ctx context.Context, config OutboxConfig, message query.OutboxMessage) (response interface{}) {
+	response, deliverErr := r.deliverOutboxMessage(message)
+	if deliverErr == nil {
+		if err := r.repo.DeleteOutboxMessage(ctx, message.ID); err != nil {
+			log.Error("[Outbox] Delete Message Error: ", err)
+		}
+		return response
+	}
+
+	attempts := message.Attempts + 1
+	log.Info(fmt.Sprintf("[Outbox] Deliver %s #%d attempt %d Error: %s", message.Kind, message.ID, attempts, deliverErr.Error()))
+	if attempts >= config.MaxAttempts {
+		err := r.repo.MoveOutboxMessageToDeadLetter(ctx, &query.MoveOutboxMessageToDeadLetterParams{
+			ID:         message.ID,
+			Attempts:   attempts,
+			LastError:  deliverErr.Error(),
+			FailedTime: time.Now(),
+		})
+		if err != nil {
+			log.Error("[Outbox] Dead Letter Error: ", err)
+		}
+		return nil
+	}
+
+	err := r.repo.RescheduleOutboxMessage(ctx, &query.RescheduleOutboxMessageParams{
+		ID:              message.ID,
+		Attempts:        attempts,
+		NextAttemptTime: time.Now().Add(outboxBackoff(config, attempts)),
+		LastError:       sql.NullString{String: deliverErr.Error(), Valid: true},
+	})
+	if err != nil {
+		log.Error("[Outbox] Reschedule Message Error: ", err)
+	}
+	return nil
+}
+
+func (r *useCase) deliverOutboxMessage(message query.OutboxMessage) (response interface{}, err error) {
+	switch message.Kind {
+	case OutboxOrderStatus:
+		input := new(proto.StatusNotificationInput)
+		if err = json.Unmarshal(message.Payload.RawMessage, input); err != nil {
+			return nil, err
+		}
+		return r.vendureClient.SendOrderStatus(input)
+	case OutboxPlateStatus:
+		input := new(proto.LicensePlateStatusNotificationInput)
+		if err = json.Unmarshal(message.Payload.RawMessage, input); err != nil {
+			return nil, err
+		}
+		return r.vendureClient.SendPlateStatus(input)
+	default:
+		return nil, fmt.Errorf("unknown outbox message kind %q", message.Kind)
+	}
+}
+
+// outboxBackoff returns the delay before the next attempt, doubling from
+// BaseBackoff with up to 20% jitter so failed messages do not retry in step.
+func outboxBackoff(config OutboxConfig, attempts int32) time.Duration {
+	backoff := config.BaseBackoff
+	for i := int32(1); i < attempts && backoff < config.MaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > config.MaxBackoff {
+		backoff = config.MaxBackoff
+	}
+
+	return backoff + time.Duration(rand.Int63n(int64(backoff)/5+1))
+}
@@ -0,0 +1,99 @@
+-- Schema and sqlc queries for the Vendure notification outbox.
+
+CREATE TABLE IF NOT EXISTS outbox_messages (
+    id                BIGSERIAL    PRIMARY KEY,
+    kind              VARCHAR(64)  NOT NULL,
+    -- Messages of one aggregate, e.g. the status updates of one invoice, are
+    -- delivered one at a time in id order; empty for unordered messages.
+    aggregate_key     VARCHAR(255) NOT NULL DEFAULT '',
+    payload           JSONB,
+    attempts          INTEGER      NOT NULL DEFAULT 0,
+    last_error        TEXT,
+    created_time      TIMESTAMP    NOT NULL DEFAULT NOW(),
+    next_attempt_time TIMESTAMP    NOT NULL DEFAULT NOW(),
+    locked_until      TIMESTAMP,
+    locked_by         VARCHAR(255) NOT NULL DEFAULT ''
+);
+
+CREATE INDEX IF NOT EXISTS outbox_messages_next_attempt_time_idx ON outbox_messages (next_attempt_time);
+CREATE INDEX IF NOT EXISTS outbox_messages_aggregate_key_idx ON outbox_messages (aggregate_key, id);
+
+CREATE TABLE IF NOT EXISTS outbox_dead_letters (
+    id            BIGINT       PRIMARY KEY,
+    kind          VARCHAR(64)  NOT NULL,
+    aggregate_key VARCHAR(255) NOT NULL DEFAULT '',
+    payload       JSONB,
+    attempts     INTEGER     NOT NULL,
+    last_error   TEXT        NOT NULL,
+    created_time TIMESTAMP   NOT NULL,
+    failed_time  TIMESTAMP   NOT NULL
+);
+
+-- name: CreateOutboxMessage :one
+INSERT INTO outbox_messages (kind, aggregate_key, payload, created_time, next_attempt_time)
+VALUES ($1, $2, $3, $4, $5)
+RETURNING *;
+
+-- Claims due messages for locked_by. Only the oldest message of an aggregate
+-- is claimed, so a later message waits until the earlier one is delivered or
+-- dead-lettered.
+-- name: ClaimOutboxMessages :many
+UPDATE outbox_messages
+SET locked_until = sqlc.arg(locked_until), locked_by = sqlc.arg(locked_by)
+WHERE id IN (
+    SELECT m.id FROM outbox_messages m
+    WHERE m.next_attempt_time <= sqlc.arg(now)
+      AND (m.locked_until IS NULL OR m.locked_until < sqlc.arg(now))
+      AND (m.aggregate_key = '' OR NOT EXISTS (
+          SELECT 1 FROM outbox_messages e
+          WHERE e.aggregate_key = m.aggregate_key AND e.id < m.id
+      ))
+    ORDER BY m.id
+    LIMIT sqlc.arg('limit')
+    FOR UPDATE SKIP LOCKED
+)
+RETURNING *;
+
+-- Claims one message, under the same rules as ClaimOutboxMessages, to deliver
+-- it right after it was written.
+-- name: ClaimOutboxMessage :one
+UPDATE outbox_messages
+SET locked_until = sqlc.arg(locked_until), locked_by = sqlc.arg(locked_by)
+WHERE id = (
+    SELECT m.id FROM outbox_messages m
+    WHERE m.id = sqlc.arg(id)
+      AND (m.locked_until IS NULL OR m.locked_until < sqlc.arg(now))
+      AND (m.aggregate_key = '' OR NOT EXISTS (
+          SELECT 1 FROM outbox_messages e
+          WHERE e.aggregate_key = m.aggregate_key AND e.id < m.id
+      ))
+    FOR UPDATE SKIP LOCKED
+)
+RETURNING *;
+
+-- Extends the lease of a claimed message right before it is delivered; no row
+-- is updated once the lease passed and another dispatcher claimed it.
+-- name: RenewOutboxLease :execrows
+UPDATE outbox_messages
+SET locked_until = sqlc.arg(locked_until)
+WHERE id = sqlc.arg(id) AND locked_by = sqlc.arg(locked_by)
+  AND locked_until >= sqlc.arg(now);
+
+-- name: DeleteOutboxMessage :exec
+DELETE FROM outbox_messages
+WHERE id = $1;
+
+-- name: RescheduleOutboxMessage :exec
+UPDATE outbox_messages
+SET attempts = $2, next_attempt_time = $3, last_error = $4, locked_until = NULL, locked_by = ''
+WHERE id = $1;
+
+-- name: MoveOutboxMessageToDeadLetter :exec
+WITH moved AS (
+    DELETE FROM outbox_messages
+    WHERE outbox_messages.id = sqlc.arg(id)
+    RETURNING id, kind, aggregate_key, payload, created_time
+)
+INSERT INTO outbox_dead_letters (id, kind, aggregate_key, payload, attempts, last_error, created_time, failed_time)
+SELECT id, kind, aggregate_key, payload, sqlc.arg(attempts), sqlc.arg(last_error), created_time, sqlc.arg(failed_time)
+FROM moved;
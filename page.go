@@ -0,0 +1,219 @@
+package model
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// MaxPageSize caps the page size a client may ask for.
+const MaxPageSize = 100
+
+var ErrInvalidPageToken = errors.New("invalid page token")
+
+// sortKey is the position of an item in a sorted list. Its fields are
+// compared in order, each as a string; numbers are encoded by intKey and
+// floatKey so that they compare as strings in numeric order.
+type sortKey []string
+
+// compare returns -1, 0 or 1 as k sorts before, with or after other.
+func (k sortKey) compare(other sortKey) int {
+	for i := 0; i < len(k) && i < len(other); i++ {
+		if c := strings.Compare(k[i], other[i]); c != 0 {
+			return c
+		}
+	}
+	switch {
+	case len(k) < len(other):
+		return -1
+	case len(k) > len(other):
+		return 1
+	}
+	return 0
+}
+
+// intKey encodes v so that keys of smaller numbers sort first.
+func intKey(v int64) string {
+	return fmt.Sprintf("%016x", uint64(v)^(1<<63))
+}
+
+// floatKey encodes f so that keys of smaller numbers sort first.
+func floatKey(f float64) string {
+	bits := math.Float64bits(f)
+	if bits&(1<<63) != 0 {
+		bits = ^bits
+	} else {
+		bits |= 1 << 63
+	}
+	return fmt.Sprintf("%016x", bits)
+}
+
+func boolKey(first bool) string {
+	if first {
+		return "0"
+	}
+	return "1"
+}
+
+// pageToken is the cursor handed to clients: the sort key of the last item of
+// the page they received.
+type pageToken struct {
+	After sortKey `json:"k"`
+}
+
+func encodePageToken(after sortKey) string {
+	raw, _ := json.Marshal(pageToken{After: after})
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+func decodePageToken(token string, fields int) (sortKey, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, ErrInvalidPageToken
+	}
+	cursor := pageToken{}
+	if err = json.Unmarshal(raw, &cursor); err != nil || len(cursor.After) != fields {
+		return nil, ErrInvalidPageToken
+	}
+	return cursor.After, nil
+}
+
+// pageBounds returns the items [start, end) of the page after pageToken in a
+// list sorted by keys, each of fields fields, and the token of the next page,
+// empty on the last page. The page starts at the first item sorting after the
+// key in the token, so paging goes on when the last item of the previous page
+// is gone. A page size of zero returns everything after the token, for clients
+// that do not page.
+func pageBounds(keys []sortKey, fields int, pageSize int32, token string) (start int, end int, next string, err error) {
+	if token != "" {
+		after, err := decodePageToken(token, fields)
+		if err != nil {
+			return 0, 0, "", err
+		}
+		start = sort.Search(len(keys), func(i int) bool {
+			return keys[i].compare(after) > 0
+		})
+	}
+
+	end = len(keys)
+	if pageSize > MaxPageSize {
+		pageSize = MaxPageSize
+	}
+	if pageSize > 0 && start+int(pageSize) < end {
+		end = start + int(pageSize)
+		next = encodePageToken(keys[end-1])
+	}
+
+	return start, end, next, nil
+}
+
+// DistanceKm returns the distance of the dealer in kilometres. Odoo reports
+// it in the unit named by DistanceUnit, meters for nearby dealers.
+func (d Dealer) DistanceKm() float64 {
+	switch strings.ToLower(strings.TrimSpace(d.DistanceUnit)) {
+	case "m", "meter", "meters", "metre", "metres":
+		return d.Distance / 1000
+	case "mi", "mile", "miles":
+		return d.Distance * 1.609344
+	}
+	return d.Distance
+}
+
+// dealerKey puts the default dealer first, then orders dealers by distance,
+// name and ID.
+func dealerKey(d Dealer) sortKey {
+	return sortKey{boolKey(d.Default), floatKey(d.DistanceKm()), d.Name, intKey(int64(d.Id))}
+}
+
+// SortDealers orders dealers with the default dealer first, then by distance,
+// name and ID.
+func SortDealers(dealers []Dealer) {
+	sort.SliceStable(dealers, func(i, j int) bool {
+		return dealerKey(dealers[i]).compare(dealerKey(dealers[j])) < 0
+	})
+}
+
+// PageDealers returns one page of dealers sorted by SortDealers.
+func PageDealers(dealers []Dealer, pageSize int32, token string) (page []Dealer, next string, err error) {
+	keys := make([]sortKey, len(dealers))
+	for i, dealer := range dealers {
+		keys[i] = dealerKey(dealer)
+	}
+	start, end, next, err := pageBounds(keys, len(dealerKey(Dealer{})), pageSize, token)
+	if err != nil {
+		return nil, "", err
+	}
+	return dealers[start:end], next, nil
+}
+
+// SortEvAvailable orders locations by state, city, company name and ID, and
+// the products of each location by code.
+func SortEvAvailable(list []EvAvailable) {
+	for _, location := range list {
+		products := location.Products
+		sort.SliceStable(products, func(i, j int) bool {
+			if products[i].ProductCode != products[j].ProductCode {
+				return products[i].ProductCode < products[j].ProductCode
+			}
+			return products[i].ProductID < products[j].ProductID
+		})
+	}
+	sort.SliceStable(list, func(i, j int) bool {
+		return evAvailableKey(list[i]).compare(evAvailableKey(list[j])) < 0
+	})
+}
+
+func evAvailableKey(location EvAvailable) sortKey {
+	return sortKey{location.State, location.City, location.CompanyName, intKey(int64(location.LocationID))}
+}
+
+// PageEvAvailable returns one page of locations sorted by SortEvAvailable.
+func PageEvAvailable(list []EvAvailable, pageSize int32, token string) (page []EvAvailable, next string, err error) {
+	keys := make([]sortKey, len(list))
+	for i, location := range list {
+		keys[i] = evAvailableKey(location)
+	}
+	start, end, next, err := pageBounds(keys, len(evAvailableKey(EvAvailable{})), pageSize, token)
+	if err != nil {
+		return nil, "", err
+	}
+	return list[start:end], next, nil
+}
+
+// SortSlotTimes orders slot days by date, location, product and appointment
+// type, and the slots of each day by start time.
+func SortSlotTimes(list []SlotTimeResponses) {
+	for _, day := range list {
+		slots := day.TimeSlots
+		sort.SliceStable(slots, func(i, j int) bool {
+			if slots[i].IsoStartTime != slots[j].IsoStartTime {
+				return slots[i].IsoStartTime < slots[j].IsoStartTime
+			}
+			return slots[i].StartTime < slots[j].StartTime
+		})
+	}
+	sort.SliceStable(list, func(i, j int) bool {
+		return slotTimeKey(list[i]).compare(slotTimeKey(list[j])) < 0
+	})
+}
+
+func slotTimeKey(day SlotTimeResponses) sortKey {
+	return sortKey{day.Date, day.LocationName, intKey(int64(day.LocationID)), intKey(int64(day.ProductID)), intKey(int64(day.AppointmentTypeID))}
+}
+
+// PageSlotTimes returns one page of slot days sorted by SortSlotTimes.
+func PageSlotTimes(list []SlotTimeResponses, pageSize int32, token string) (page []SlotTimeResponses, next string, err error) {
+	keys := make([]sortKey, len(list))
+	for i, day := range list {
+		keys[i] = slotTimeKey(day)
+	}
+	start, end, next, err := pageBounds(keys, len(slotTimeKey(SlotTimeResponses{})), pageSize, token)
+	if err != nil {
+		return nil, "", err
+	}
+	return list[start:end], next, nil
+}
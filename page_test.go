@@ -0,0 +1,203 @@
+package model
+
+import (
+	"encoding/base64"
+	"errors"
+	"math"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestSortKeyNumbers(t *testing.T) {
+	ints := []int64{math.MinInt64, -100, -1, 0, 1, 9, 10, 100, math.MaxInt64}
+	if !sort.SliceIsSorted(ints, func(i, j int) bool { return intKey(ints[i]) < intKey(ints[j]) }) {
+		t.Error("intKey does not keep the order of integers")
+	}
+	floats := []float64{math.Inf(-1), -2.5, -0.1, 0, 0.1, 0.25, 2.5, 10, math.Inf(1)}
+	if !sort.SliceIsSorted(floats, func(i, j int) bool { return floatKey(floats[i]) < floatKey(floats[j]) }) {
+		t.Error("floatKey does not keep the order of floats")
+	}
+
+	tests := []struct {
+		a, b sortKey
+		want int
+	}{
+		{a: sortKey{"a", "b"}, b: sortKey{"a", "b"}, want: 0},
+		{a: sortKey{"a", "b"}, b: sortKey{"a", "c"}, want: -1},
+		{a: sortKey{"b"}, b: sortKey{"a", "c"}, want: 1},
+		{a: sortKey{"a"}, b: sortKey{"a", "c"}, want: -1},
+	}
+	for _, tt := range tests {
+		if got := tt.a.compare(tt.b); got != tt.want {
+			t.Errorf("%v.compare(%v) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func pageDealers() []Dealer {
+	return []Dealer{
+		{Id: 5, Name: "Zebrax Kemang", Distance: 1200, DistanceUnit: "m"},
+		{Id: 3, Name: "Zebrax Bintaro", Distance: 12, DistanceUnit: "km"},
+		{Id: 9, Name: "Zebrax Menteng", Distance: 800, DistanceUnit: "m", Default: true},
+		{Id: 2, Name: "Zebrax Bekasi", Distance: 12, DistanceUnit: "km"},
+		{Id: 7, Name: "Zebrax Bekasi", Distance: 12, DistanceUnit: "km"},
+		{Id: 4, Name: "Zebrax Depok", Distance: 2.5, DistanceUnit: "km"},
+	}
+}
+
+func dealerIDs(dealers []Dealer) (ids []int) {
+	for _, dealer := range dealers {
+		ids = append(ids, dealer.Id)
+	}
+	return ids
+}
+
+func TestSortDealers(t *testing.T) {
+	dealers := pageDealers()
+	SortDealers(dealers)
+	if got, want := dealerIDs(dealers), []int{9, 5, 4, 2, 7, 3}; !reflect.DeepEqual(got, want) {
+		t.Errorf("order = %v, want %v", got, want)
+	}
+}
+
+func TestPageDealers(t *testing.T) {
+	dealers := pageDealers()
+	SortDealers(dealers)
+
+	var (
+		ids   []int
+		token string
+		pages int
+	)
+	for {
+		page, next, err := PageDealers(dealers, 4, token)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, dealerIDs(page)...)
+		pages++
+		if next == "" {
+			break
+		}
+		token = next
+	}
+	if want := dealerIDs(dealers); !reflect.DeepEqual(ids, want) || pages != 2 {
+		t.Errorf("paged %v in %d pages, want %v in 2", ids, pages, want)
+	}
+
+	all, next, err := PageDealers(dealers, 0, "")
+	if err != nil || len(all) != len(dealers) || next != "" {
+		t.Errorf("page size 0: %d dealers, next %q, err %v, want all of them", len(all), next, err)
+	}
+}
+
+func TestPageDealersLastItemGone(t *testing.T) {
+	dealers := pageDealers()
+	SortDealers(dealers)
+
+	first, token, err := PageDealers(dealers, 3, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := dealerIDs(first), []int{9, 5, 4}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("first page = %v, want %v", got, want)
+	}
+
+	// Depok closes and Bekasi 2 moves closer before the next page is read.
+	var changed []Dealer
+	for _, dealer := range dealers {
+		switch dealer.Id {
+		case 4:
+			continue
+		case 2:
+			dealer.Distance = 1
+		}
+		changed = append(changed, dealer)
+	}
+	SortDealers(changed)
+
+	second, _, err := PageDealers(changed, 3, token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := dealerIDs(second), []int{7, 3}; !reflect.DeepEqual(got, want) {
+		t.Errorf("second page = %v, want %v", got, want)
+	}
+}
+
+func TestPageInvalidToken(t *testing.T) {
+	dealers := pageDealers()
+	SortDealers(dealers)
+	_, slotToken, err := PageSlotTimes([]SlotTimeResponses{{Date: "2022-03-01"}, {Date: "2022-03-02"}}, 1, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]string{
+		"not base64":           "%%%",
+		"not json":             base64.RawURLEncoding.EncodeToString([]byte("dealer 3")),
+		"id of the last item":  base64.RawURLEncoding.EncodeToString([]byte(`{"a":"3"}`)),
+		"token of a slot list": slotToken,
+	}
+	for name, token := range tests {
+		if _, _, err := PageDealers(dealers, 2, token); !errors.Is(err, ErrInvalidPageToken) {
+			t.Errorf("%s: err = %v, want ErrInvalidPageToken", name, err)
+		}
+	}
+}
+
+func TestPageSizeCapped(t *testing.T) {
+	list := make([]EvAvailable, MaxPageSize+5)
+	for i := range list {
+		list[i] = EvAvailable{LocationID: int32(i + 1), State: "DKI Jakarta"}
+	}
+	SortEvAvailable(list)
+
+	page, next, err := PageEvAvailable(list, MaxPageSize*2, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page) != MaxPageSize || next == "" {
+		t.Fatalf("page of %d, next %q, want %d and a next page", len(page), next, MaxPageSize)
+	}
+	rest, next, err := PageEvAvailable(list, MaxPageSize*2, next)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rest) != 5 || next != "" || rest[0].LocationID != MaxPageSize+1 {
+		t.Errorf("last page of %d from location %d, next %q, want 5 from %d", len(rest), rest[0].LocationID, next, MaxPageSize+1)
+	}
+}
+
+func TestPageSlotTimes(t *testing.T) {
+	list := []SlotTimeResponses{
+		{Date: "2022-03-02", LocationName: "Indy Bintaro", LocationID: 1, ProductID: 104, AppointmentTypeID: 1},
+		{Date: "2022-03-01", LocationName: "Indy Bintaro", LocationID: 1, ProductID: 104, AppointmentTypeID: 2},
+		{Date: "2022-03-01", LocationName: "Indy Bintaro", LocationID: 1, ProductID: 104, AppointmentTypeID: 1},
+		{Date: "2022-03-01", LocationName: "Indy Bintaro", LocationID: 1, ProductID: 11, AppointmentTypeID: 1},
+	}
+	SortSlotTimes(list)
+
+	var (
+		got   [][2]interface{}
+		token string
+	)
+	for {
+		page, next, err := PageSlotTimes(list, 1, token)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, day := range page {
+			got = append(got, [2]interface{}{day.Date, day.ProductID*10 + day.AppointmentTypeID})
+		}
+		if next == "" {
+			break
+		}
+		token = next
+	}
+	want := [][2]interface{}{{"2022-03-01", 111}, {"2022-03-01", 1041}, {"2022-03-01", 1042}, {"2022-03-02", 1041}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("paged %v, want %v", got, want)
+	}
+}
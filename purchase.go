@@ -2,9 +2,10 @@ package usecase
 
 import (
 	"context"
-	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"net/http"
 	"strconv"
 	"strings"
 	"time"
@@ -21,54 +22,123 @@ import (
 func OrderData(orderConfirm odooConnectorModel.PreOrderResponse) (result proto.PurchaseDetailResponse) {
 
 	responseDetail := orderConfirm.ResponseDetail
-	preOrderResponseDetail := orderConfirm.ResponseDetail.OrderConfirmationResponses
 
 	log.Info(fmt.Printf("[PreOrder Confirmation] Response: %#v\n", orderConfirm))
 
 	result.Status = utils.ConstructStatus(nil, orderConfirm.Message, orderConfirm.Code == "0")
 
+	orderData, err := orderComponents(responseDetail.OrderConfirmationResponses)
+	if err != nil {
+		log.Error("[PreOrder Confirmation] Order Amount Error: ", err)
+		result.Status = amountStatus(err)
+	}
+
+	remainingAmount, err := orderAmount(responseDetail.RemainingAmount)
+	if err != nil {
+		log.Error("[PreOrder Confirmation] Remaining Amount Error: ", err)
+	}
+	bookingFeeAmount, err := orderAmount(responseDetail.BookingFeeAmount)
+	if err != nil {
+		log.Error("[PreOrder Confirmation] Booking Fee Amount Error: ", err)
+	}
+
+	orderData.RemainingAmount = remainingAmount.LegacyInt32()
+	orderData.RemainingAmountMoney = protoMoney(remainingAmount)
+	result.OrderData = orderData
+	result.Product = &proto.ProductVariant{
+		BookingFeeAmount:      bookingFeeAmount.LegacyInt32(),
+		BookingFeeAmountMoney: protoMoney(bookingFeeAmount),
+	}
+
+	return result
+}
+
+// orderAmount parses an amount of an Odoo order response.
+func orderAmount(amount string) (odooConnectorModel.Money, error) {
+	return odooConnectorModel.ParseOdooAmount(amount, odooConnectorModel.DefaultCurrency)
+}
+
+func protoMoney(amount odooConnectorModel.Money) *proto.Money {
+	return &proto.Money{
+		CurrencyCode: amount.Currency.Code,
+		Units:        amount.Units,
+		Exponent:     int32(amount.Currency.Exponent),
+	}
+}
+
+// orderComponents collects the purchase, administration and reduction totals
+// of an Odoo order response into a proto.Order.
+//
+// The order always comes back with its IDs: by the time the totals are read
+// the order exists in Odoo, and failing the request would only make the client
+// create another one. An amount that cannot be read is reported as zero and
+// the first such error, wrapping model.ErrInvalidAmount, is returned with the
+// order; callers put it in the response status.
+func orderComponents(orderConfirmation odooConnectorModel.OrderConfirmationResponses) (order *proto.Order, err error) {
+	amount := func(value string) odooConnectorModel.Money {
+		money, amountErr := orderAmount(value)
+		if amountErr != nil {
+			if err == nil {
+				err = amountErr
+			}
+			return odooConnectorModel.Money{Currency: odooConnectorModel.DefaultCurrency}
+		}
+		return money
+	}
+	items := func(attrs []odooConnectorModel.OrderConfirmationAttributes) (orderItem []*proto.OrderItem, discountOrderItem []*proto.OrderItem) {
+		orderItem, discountOrderItem, itemsErr := extractAttributes(attrs)
+		if itemsErr != nil && err == nil {
+			err = itemsErr
+		}
+		return orderItem, discountOrderItem
+	}
+
 	// Collect Purchase
-	totalItemPurchase, _ := utils.StringToInt32(strings.ReplaceAll(responseDetail.Purchase.Total, ".", ""))
-	itemsPurchase, _ := extractAttributes(responseDetail.Purchase.Items)
+	totalItemPurchase := amount(orderConfirmation.Purchase.Total)
+	itemsPurchase, _ := items(orderConfirmation.Purchase.Items)
 
 	// Collect Administration
-	totalAdmsPurchase, _ := utils.StringToInt32(strings.ReplaceAll(responseDetail.Administrations.Total, ".", ""))
-	addAdminTax(&preOrderResponseDetail)
-	admsPurchase, _ := extractAttributes(responseDetail.Administrations.Items)
+	totalAdmsPurchase := amount(orderConfirmation.Administrations.Total)
+	if taxErr := addAdminTax(&orderConfirmation); taxErr != nil && err == nil {
+		err = taxErr
+	}
+	admsPurchase, _ := items(orderConfirmation.Administrations.Items)
 
 	// Collect Reductions
-	totalReductionsPurchase, _ := utils.StringToInt32(strings.ReplaceAll(responseDetail.Reductions.Total, ".", ""))
-	reductionsVoucherPurchase, reductionsDiscountPurchase := extractAttributes(responseDetail.Reductions.Items)
+	totalReductionsPurchase := amount(orderConfirmation.Reductions.Total)
+	reductionsVoucherPurchase, reductionsDiscountPurchase := items(orderConfirmation.Reductions.Items)
+	totalReductionsPurchase = totalReductionsPurchase.Neg() //temporarily using this method
 
-	grandTotal, _ := utils.StringToInt32(responseDetail.GrandTotal)
+	grandTotal := amount(orderConfirmation.GrandTotal)
 
-	remainingAmount, _ := strconv.Atoi(responseDetail.RemainingAmount)
-	bookingFeeAmount, _ := strconv.Atoi(responseDetail.BookingFeeAmount)
-
-	result.OrderData = &proto.Order{
+	return &proto.Order{
 		Purchase: &proto.OrderComponent{
-			Items: itemsPurchase,
-			Total: totalItemPurchase,
+			Items:      itemsPurchase,
+			Total:      totalItemPurchase.LegacyInt32(),
+			TotalMoney: protoMoney(totalItemPurchase),
 		},
 		Administration: &proto.OrderComponent{
-			Items: admsPurchase,
-			Total: totalAdmsPurchase,
+			Items:      admsPurchase,
+			Total:      totalAdmsPurchase.LegacyInt32(),
+			TotalMoney: protoMoney(totalAdmsPurchase),
 		},
 		Reduction: &proto.OrderComponent{
-			Vouchers:  reductionsVoucherPurchase,
-			Discounts: reductionsDiscountPurchase,
-			Total:     totalReductionsPurchase * -1, //temporarily using this method
+			Vouchers:   reductionsVoucherPurchase,
+			Discounts:  reductionsDiscountPurchase,
+			Total:      totalReductionsPurchase.LegacyInt32(),
+			TotalMoney: protoMoney(totalReductionsPurchase),
 		},
-		Total:            grandTotal,
-		SalesOrderID:     responseDetail.SoID,
-		SalesOrderNumber: responseDetail.SoNumber,
-		RemainingAmount:  int32(remainingAmount),
-	}
-	result.Product = &proto.ProductVariant{
-		BookingFeeAmount: int32(bookingFeeAmount),
-	}
+		Total:            grandTotal.LegacyInt32(),
+		TotalMoney:       protoMoney(grandTotal),
+		SalesOrderID:     orderConfirmation.SoID,
+		SalesOrderNumber: orderConfirmation.SoNumber,
+	}, err
+}
 
-	return result
+// amountStatus is the status of a response whose order amounts could not all
+// be read.
+func amountStatus(err error) *proto.Status {
+	return utils.ConstructStatus(err, "order amounts could not be read: "+err.Error(), false)
 }
 
 func (r *useCase) DealerList(ctx context.Context, in *proto.DealerListParams) (result *proto.PurchaseListResponse, err error) {
@@ -80,36 +150,34 @@ func (r *useCase) DealerList(ctx context.Context, in *proto.DealerListParams) (r
 		respCode     = 200
 	)
 
-	dealers, err := r.oRepo.GetDealerAndDefault(in.OdooID, in.Longitude, in.Latitude)
+	dealers, err := r.oRepo.GetDealerAndDefault(ctx, in.OdooID, in.Longitude, in.Latitude)
 	if err != nil {
 		respCode = 500
 		return result, err
 	}
 
+	dealers, err = r.filterDealers(ctx, dealers, newDealerFilter(in))
+	if err != nil {
+		respCode = 500
+		return result, err
+	}
+
+	odooConnectorModel.SortDealers(dealers)
+	dealers, nextPageToken, err := odooConnectorModel.PageDealers(dealers, in.PageSize, in.PageToken)
+	if err != nil {
+		respCode = 400
+		return result, err
+	}
+
+	now := time.Now()
 	for _, each := range dealers {
-		zipCode, _ := utils.StringToInt32(each.ZipCode)
-		protoDealers = append(protoDealers, &proto.DealerData{
-			Id:             int32(each.Id),
-			Location:       each.Name,
-			Address1:       each.Address1,
-			Address2:       each.Address2,
-			City:           each.City,
-			State:          each.Province,
-			Country:        each.Country,
-			Latitude:       each.Latitude,
-			Longitude:      each.Longitude,
-			OperatingHours: each.OperatingHours,
-			ZipCode:        zipCode,
-			Code:           each.Code,
-			Distance:       each.Distance,
-			DistanceLable:  each.DistanceUnit,
-			Default:        each.Default,
-		})
+		protoDealers = append(protoDealers, protoDealer(each, now))
 	}
 
 	result = &proto.PurchaseListResponse{
-		DealerData: protoDealers,
-		EvData:     []*proto.EvData{},
+		DealerData:    protoDealers,
+		EvData:        []*proto.EvData{},
+		NextPageToken: nextPageToken,
 	}
 
 	result.Status = utils.ConstructStatus(nil, "Odoo Request Error", respCode != 200)
@@ -120,46 +188,82 @@ func (r *useCase) ProductPrice(ctx context.Context, in *proto.PurchaseParam) (re
 	log.Info("Start Product Price")
 	defer log.Debug("Product Price Response: ", result, err)
 
-	result = new(proto.PurchaseDetailResponse)
+	result = &proto.PurchaseDetailResponse{
+		Products: []*proto.ProductVariant{},
+	}
+
 	dealerID, _ := strconv.Atoi(in.DealerID)
-	productTemplates, err := r.oRepo.GetProductTemplatePrice(int32(dealerID), in.ProductCode)
+	matrices, err := r.oRepo.GetProductVariantMatrix(ctx, int32(dealerID), in.ProductCode)
 	if err != nil {
-		log.Error("[GetProductTemplatePrice] Error:", err)
+		log.Error("[GetProductVariantMatrix] Error:", err)
+		result.Status = utils.ConstructStatus(err, "Odoo Request Error", false)
+		return result, err
 	}
-
-	price := 0.0
-	code := ""
-	name := ""
-	bookingFeeAmount := 0.0
-	templateAttributes := []*proto.Attribute{}
-	for _, item := range productTemplates {
-		templateAttributes = []*proto.Attribute{}
-		utils.CopyObject(item.Attributes, &templateAttributes)
-		price, _ = utils.StringToFloat64(item.MinUnitPrice)
-		code = item.ProductTemplateCode
-		name = item.ProductTemplateName
-		bookingFeeAmount, _ = utils.StringToFloat64(item.BookingFeeAmount)
+	for _, matrix := range matrices {
+		result.Products = append(result.Products, protoProductTemplate(matrix))
 	}
 
-	result = &proto.PurchaseDetailResponse{
-		Product: &proto.ProductVariant{
-			Code:             code,
-			Name:             name,
-			MinPrice:         int32(price),
-			Attributes:       templateAttributes,
-			BookingFeeAmount: int32(bookingFeeAmount),
-		},
+	// Product keeps the last template for clients that predate Products.
+	if len(result.Products) > 0 {
+		result.Product = result.Products[len(result.Products)-1]
+	} else {
+		result.Product = protoProductTemplate(odooConnectorModel.ProductTemplateMatrix{
+			MinPrice:   odooConnectorModel.Money{Currency: odooConnectorModel.DefaultCurrency},
+			BookingFee: odooConnectorModel.Money{Currency: odooConnectorModel.DefaultCurrency},
+		})
 	}
 
 	return result, nil
 }
 
+// protoProductTemplate converts a product template with its variant matrix.
+func protoProductTemplate(matrix odooConnectorModel.ProductTemplateMatrix) *proto.ProductVariant {
+	templateAttributes := []*proto.Attribute{}
+	utils.CopyObject(matrix.Attributes, &templateAttributes)
+
+	product := &proto.ProductVariant{
+		Code:                  matrix.ProductTemplateCode,
+		Name:                  matrix.ProductTemplateName,
+		MinPrice:              matrix.MinPrice.LegacyInt32(),
+		MinPriceMoney:         protoMoney(matrix.MinPrice),
+		Attributes:            templateAttributes,
+		BookingFeeAmount:      matrix.BookingFee.LegacyInt32(),
+		BookingFeeAmountMoney: protoMoney(matrix.BookingFee),
+		Variants:              []*proto.VariantCombination{},
+		VariantCount:          int32(matrix.Combinations),
+		VariantsTruncated:     matrix.Truncated,
+	}
+	for _, variant := range matrix.Variants {
+		attributes := []*proto.Attribute{}
+		utils.CopyObject(variant.Attributes, &attributes)
+		product.Variants = append(product.Variants, &proto.VariantCombination{
+			Attributes:      attributes,
+			Price:           variant.Price.LegacyInt32(),
+			PriceMoney:      protoMoney(variant.Price),
+			PriceDeltaMoney: protoMoney(variant.PriceDelta),
+			Stock:           int32(variant.Stock),
+			Available:       variant.Available,
+			StockUnknown:    variant.StockUnknown,
+		})
+	}
+
+	return product
+}
+
 func (r *useCase) OrderConfirmation(ctx context.Context, in *proto.PurchaseParam) (result *proto.PurchaseDetailResponse, err error) {
+	return r.idempotent(ctx, idempotencyOrderConfirmation, in.IdempotencyKey, in, func() (*proto.PurchaseDetailResponse, bool, error) {
+		result, err := r.orderConfirmation(ctx, in)
+		return result, err == nil && result.Status != nil && result.Status.Success, err
+	})
+}
+
+func (r *useCase) orderConfirmation(ctx context.Context, in *proto.PurchaseParam) (result *proto.PurchaseDetailResponse, err error) {
 	log.Info("[Order Confirmation] Start")
 	defer log.Info("[Order Confirmation] End")
 
 	var (
 		orderConfirmation = odooConnectorModel.OrderConfirmationResponses{}
+		dropped           []odooConnectorModel.VoucherEligibility
 	)
 
 	result = new(proto.PurchaseDetailResponse)
@@ -170,28 +274,41 @@ func (r *useCase) OrderConfirmation(ctx context.Context, in *proto.PurchaseParam
 	log.Info(fmt.Printf("[Order Confirmation] Start params: %#v\n", purchaseParams))
 	if in.SalesOrderID != "" {
 		salesOrderID, _ := utils.StringToInt32(in.SalesOrderID)
-		if in.VoucherID != "" {
-			voucherID, _ := utils.StringToInt32(in.VoucherID)
-			orderConfirmation, err = r.oRepo.SetVoucherRedeem(salesOrderID, voucherID)
-			if err != nil {
-				log.Error("[Error SetVoucherRedeem Order Confirmation]-", err)
-				return result, err
-			}
+		dropped, err = r.updateOrderLines(ctx, purchaseParams)
+		if errors.Is(err, odooConnectorModel.ErrOrderLocked) {
+			log.Info("[Order Confirmation] Order: ", err.Error())
+			result.Status = utils.ConstructStatus(err, err.Error(), false)
+			return result, nil
+		}
+		if err != nil {
+			log.Error("[Error UpdateSaleOrderLines Order Confirmation]-", err)
+			return result, err
+		}
+
+		orderConfirmation, err = r.applyVouchers(ctx, salesOrderID, in)
+		if isVoucherError(err) {
+			log.Info("[Order Confirmation] Voucher: ", err.Error())
+			result.Status = utils.ConstructStatus(err, err.Error(), false)
+			return result, nil
+		}
+		if err != nil {
+			log.Error("[Error SetVoucherRedeem Order Confirmation]-", err)
+			return result, err
 		}
 
 		if in.PaymentTypeID != "" {
-			orderConfirmation, err = r.oRepo.SetPaymentMethod(salesOrderID, in.PaymentTypeID)
+			orderConfirmation, err = r.oRepo.SetPaymentMethod(ctx, salesOrderID, in.PaymentTypeID)
 			if err != nil {
 				log.Error("[Error SetPaymentMethod Order Confirmation]-", err)
 				return result, err
 			}
 		} else {
-			r.oRepo.ResetPaymentMethod(salesOrderID)
+			r.oRepo.ResetPaymentMethod(ctx, salesOrderID)
 		}
 
 	}
 
-	orderConfirmation, err = r.oRepo.SetOrderConfirmation(purchaseParams)
+	orderConfirmation, err = r.oRepo.SetOrderConfirmation(ctx, purchaseParams)
 	if err != nil {
 		log.Error("[Error SetOrderConfirmation Order Confirmation]-", err)
 		return result, err
@@ -201,38 +318,36 @@ func (r *useCase) OrderConfirmation(ctx context.Context, in *proto.PurchaseParam
 
 	result.Status = utils.ConstructStatus(nil, orderConfirmation.Message, orderConfirmation.Code == "0")
 
-	// Collect Purchase
-	totalItemPurchase, _ := utils.StringToInt32(strings.ReplaceAll(orderConfirmation.Purchase.Total, ".", ""))
-	itemsPurchase, _ := extractAttributes(orderConfirmation.Purchase.Items)
-
-	// Collect Administration
-	totalAdmsPurchase, _ := utils.StringToInt32(strings.ReplaceAll(orderConfirmation.Administrations.Total, ".", ""))
-	addAdminTax(&orderConfirmation)
-	admsPurchase, _ := extractAttributes(orderConfirmation.Administrations.Items)
-
-	// Collect Reductions
-	totalReductionsPurchase, _ := utils.StringToInt32(strings.ReplaceAll(orderConfirmation.Reductions.Total, ".", ""))
-	reductionsVoucherPurchase, reductionsDiscountPurchase := extractAttributes(orderConfirmation.Reductions.Items)
-
-	grandTotal, _ := utils.StringToInt32(orderConfirmation.GrandTotal)
-
-	result.OrderData = &proto.Order{
-		Purchase: &proto.OrderComponent{
-			Items: itemsPurchase,
-			Total: totalItemPurchase,
-		},
-		Administration: &proto.OrderComponent{
-			Items: admsPurchase,
-			Total: totalAdmsPurchase,
-		},
-		Reduction: &proto.OrderComponent{
-			Vouchers:  reductionsVoucherPurchase,
-			Discounts: reductionsDiscountPurchase,
-			Total:     totalReductionsPurchase * -1, //temporarily using this method
-		},
-		Total:            grandTotal,
-		SalesOrderID:     orderConfirmation.SoID,
-		SalesOrderNumber: orderConfirmation.SoNumber,
+	result.OrderData, err = orderComponents(orderConfirmation)
+	if err != nil {
+		log.Error("[Error Order Amount Order Confirmation]-", err)
+		result.Status = amountStatus(err)
+	}
+	result.OrderData.AppliedVouchers = r.appliedVouchers(ctx, orderConfirmation.SoID)
+	result.OrderData.DroppedVouchers = protoDroppedVouchers(dropped)
+
+	// Stock is reserved for any order Odoo confirmed, also when its amounts
+	// could not all be read.
+	if orderConfirmation.Code == "0" && orderConfirmation.SoID != "" {
+		purchaseParams.SalesOrderID = orderConfirmation.SoID
+		err = r.oRepo.ReserveStock(ctx, purchaseParams)
+		if errors.Is(err, odooConnectorModel.ErrInsufficientStock) {
+			log.Info("[Order Confirmation] Reserve Stock: ", err.Error())
+			result.Status = utils.ConstructStatus(err, err.Error(), false)
+			if in.SalesOrderID == "" {
+				// The order was just created for lines the dealer cannot
+				// cover; cancel it rather than leave a quotation the client
+				// never sees again.
+				salesOrderID, _ := utils.StringToInt32(orderConfirmation.SoID)
+				if cancelErr := r.oRepo.CancelSaleOrder(ctx, salesOrderID); cancelErr != nil {
+					log.Error("[Error CancelSaleOrder Order Confirmation]-", cancelErr)
+				}
+				result.OrderData = nil
+			}
+		} else if err != nil {
+			// The order stays usable; PurchaseStock just cannot count it.
+			log.Error("[Error ReserveStock Order Confirmation]-", err)
+		}
 	}
 
 	return result, nil
@@ -247,21 +362,30 @@ func (r *useCase) PurchaseStock(ctx context.Context, in *proto.PurchaseParam) (r
 	templateAttributes := odooConnectorModel.PurchaseParams{}
 	utils.CopyObject(in, &templateAttributes)
 
-	purchaseStock, _ := r.oRepo.GetProductStock(templateAttributes)
+	purchaseStocks, _ := r.oRepo.GetProductStock(ctx, templateAttributes)
 
 	result.Product = &proto.ProductVariant{
-		Attributes: []*proto.Attribute{
-			{
-				ProductCode: purchaseStock.ProductCode,
-				Stock:       purchaseStock.Qty,
-			},
-		},
+		Attributes: []*proto.Attribute{},
+	}
+	for _, purchaseStock := range purchaseStocks {
+		result.Product.Attributes = append(result.Product.Attributes, &proto.Attribute{
+			ProductCode: purchaseStock.ProductCode,
+			Stock:       purchaseStock.Qty,
+		})
 	}
 
 	return result, nil
 }
 
 func (r *useCase) Payment(ctx context.Context, in *proto.PaymentParams) (result *proto.PurchaseDetailResponse, err error) {
+	return r.idempotent(ctx, idempotencyPayment, in.IdempotencyKey, in, func() (*proto.PurchaseDetailResponse, bool, error) {
+		return r.payment(ctx, in)
+	})
+}
+
+// payment also reports whether a payment was created, so only those responses
+// are replayed for the idempotency key.
+func (r *useCase) payment(ctx context.Context, in *proto.PaymentParams) (result *proto.PurchaseDetailResponse, created bool, err error) {
 	log.Info("Start Payment")
 	defer log.Debug("Payment Response: ", result, err)
 
@@ -269,13 +393,14 @@ func (r *useCase) Payment(ctx context.Context, in *proto.PaymentParams) (result
 
 	paymentParams := odooConnectorModel.PaymentParams{}
 	utils.CopyObject(in, &paymentParams)
-	orderConfirmation, err := r.oRepo.SetPayment(paymentParams)
+	orderConfirmation, err := r.oRepo.SetPayment(ctx, paymentParams)
 	if err != nil {
 		log.Info(orderConfirmation.Error)
-		return result, nil
+		return result, false, nil
 	}
 
-	if orderConfirmation.Code == "1" {
+	created = orderConfirmation.Code != "1"
+	if !created {
 		log.Info(orderConfirmation.Message)
 	} else {
 		log.Info("[Payment] Insert into Purchase Log")
@@ -292,48 +417,27 @@ func (r *useCase) Payment(ctx context.Context, in *proto.PaymentParams) (result
 			log.Info("[Payment] Insert into Purchase Log Error : ", err.Error())
 		}
 
+		salesOrderID, _ := utils.StringToInt32(orderConfirmation.SoID)
+		if err = r.oRepo.ExtendStockReservation(ctx, salesOrderID, orderConfirmation.InvoiceNumber, orderConfirmation.ExpiredTime); err != nil {
+			log.Error("[Payment] Extend Stock Reservation Error: ", err)
+		}
 	}
 
-	// Collect Purchase
-	totalItemPurchase, _ := utils.StringToInt32(strings.ReplaceAll(orderConfirmation.Purchase.Total, ".", ""))
-	itemsPurchase, _ := extractAttributes(orderConfirmation.Purchase.Items)
-
-	// Collect Administration
-	totalAdmsPurchase, _ := utils.StringToInt32(strings.ReplaceAll(orderConfirmation.Administrations.Total, ".", ""))
-	addAdminTax(&orderConfirmation)
-	admsPurchase, _ := extractAttributes(orderConfirmation.Administrations.Items)
-
-	// Collect Reductions
-	totalReductionsPurchase, _ := utils.StringToInt32(strings.ReplaceAll(orderConfirmation.Reductions.Total, ".", ""))
-	reductionsVoucherPurchase, reductionsDiscountPurchase := extractAttributes(orderConfirmation.Reductions.Items)
-
-	grandTotal, _ := utils.StringToInt32(orderConfirmation.GrandTotal)
+	orderData, err := orderComponents(orderConfirmation)
+	orderData.InvoiceID = orderConfirmation.InvoiceID
+	orderData.InvoiceNumber = orderConfirmation.InvoiceNumber
+	orderData.ExpiredTime = orderConfirmation.ExpiredTime
 
 	result = &proto.PurchaseDetailResponse{
-		OrderData: &proto.Order{
-			Purchase: &proto.OrderComponent{
-				Items: itemsPurchase,
-				Total: totalItemPurchase,
-			},
-			Administration: &proto.OrderComponent{
-				Items: admsPurchase,
-				Total: totalAdmsPurchase,
-			},
-			Reduction: &proto.OrderComponent{
-				Vouchers:  reductionsVoucherPurchase,
-				Discounts: reductionsDiscountPurchase,
-				Total:     totalReductionsPurchase * -1, //temporarily using this method
-			},
-			Total:            grandTotal,
-			SalesOrderID:     orderConfirmation.SoID,
-			SalesOrderNumber: orderConfirmation.SoNumber,
-			InvoiceID:        orderConfirmation.InvoiceID,
-			InvoiceNumber:    orderConfirmation.InvoiceNumber,
-			ExpiredTime:      orderConfirmation.ExpiredTime,
-		},
+		OrderData: orderData,
+	}
+	if err != nil {
+		// The payment exists; its invoice is still returned.
+		log.Error("[Payment] Order Amount Error: ", err)
+		result.Status = amountStatus(err)
 	}
 
-	return result, nil
+	return result, created, nil
 }
 
 func (r *useCase) PaymentNotification(ctx context.Context, in *proto.PaymentParams) (result *proto.PurchaseDetailResponse, err error) {
@@ -346,7 +450,7 @@ func (r *useCase) PaymentNotification(ctx context.Context, in *proto.PaymentPara
 
 	paymentParams := odooConnectorModel.PaymentParams{}
 	utils.CopyObject(in, &paymentParams)
-	paymentNotification, err := r.oRepo.SetPaymentNotification(paymentParams)
+	paymentNotification, err := r.oRepo.SetPaymentNotification(ctx, paymentParams)
 	if err != nil {
 		code = false
 		log.Info(err.Error())
@@ -356,20 +460,21 @@ func (r *useCase) PaymentNotification(ctx context.Context, in *proto.PaymentPara
 		log.Info(paymentNotification.Message)
 	}
 
-	err = r.repo.UpdatePurchaseLogState(ctx, &query.UpdatePurchaseLogStateParams{
-		InvoiceID:   paymentParams.InvoiceNumber,
-		State:       sql.NullString{String: paymentParams.Status, Valid: true},
-		UpdatedTime: sql.NullTime{Time: utils.TimeToRoundNanoSecond(time.Now()), Valid: true},
-	})
+	message := paymentNotification.Message
+	err = r.transitionPurchase(ctx, paymentParams.InvoiceNumber, paymentParams.Status, "payment_notification", nil)
 	if err != nil {
 		log.Info("[PaymentNotification] Update Purchase Log State Error : ", err.Error())
+		if errors.Is(err, ErrIllegalTransition) || errors.Is(err, ErrUnknownPurchaseStatus) {
+			code = false
+			message = err.Error()
+		}
 	}
 
 	result = new(proto.PurchaseDetailResponse)
 
 	result = &proto.PurchaseDetailResponse{
 		Success: code,
-		Message: paymentNotification.Message,
+		Message: message,
 	}
 
 	return result, nil
@@ -380,7 +485,7 @@ func (r *useCase) VoucherList(ctx context.Context, in *proto.PurchaseParam) (res
 	defer log.Debug("VoucherList Response: ", result, err)
 
 	salesOrderID, _ := strconv.Atoi(in.SalesOrderID)
-	voucherList, err := r.oRepo.GetVoucherList(int32(salesOrderID))
+	voucherList, err := r.oRepo.GetVoucherList(ctx, int32(salesOrderID))
 
 	result = new(proto.PurchaseListResponse)
 	result.Status = utils.ConstructStatus(nil, "Odoo error", err != nil)
@@ -419,80 +524,147 @@ func (r *useCase) VoucherList(ctx context.Context, in *proto.PurchaseParam) (res
 func (r *useCase) BOStatusOrder(ctx echo.Context) (result *proto.StatusNotificationResponse, err error) {
 	log.Info("[Webhook] OrderStatus Start")
 
+	body, delivery, err := r.verifyWebhook(ctx, "order_status")
+	if err != nil {
+		return nil, err
+	}
+
 	json_map := new(proto.StatusNotificationInput)
 
-	if err = json.NewDecoder(ctx.Request().Body).Decode(&json_map); err != nil {
+	if err = json.Unmarshal(body, &json_map); err != nil {
 		log.Error("[Webhook] OrderStatus Parsing Payload Error: ", err)
 		return nil, err
 	}
 	log.Debug("[Webhook] OrderStatus Param: ", json_map)
 
-	err = r.repo.UpdatePurchaseLogState(ctx.Request().Context(), &query.UpdatePurchaseLogStateParams{
-		InvoiceID:   json_map.InvoiceNumber,
-		State:       sql.NullString{String: json_map.Status, Valid: true},
-		UpdatedTime: sql.NullTime{Time: time.Now(), Valid: true},
+	outbox, err := outboxMessage(OutboxOrderStatus, json_map.InvoiceNumber, json_map)
+	if err != nil {
+		return nil, err
+	}
+
+	// The state change, the delivery and the Vendure notification are
+	// committed together; the notification is then delivered at once, or by
+	// RunOutboxDispatcher when Vendure is down or an earlier status of the
+	// invoice is pending.
+	var message query.OutboxMessage
+	err = r.transitionPurchase(ctx.Request().Context(), json_map.InvoiceNumber, json_map.Status, "bo_status_order", func(q *query.Queries) (err error) {
+		if err = recordWebhookDelivery(ctx.Request().Context(), q, delivery); err != nil {
+			return err
+		}
+		message, err = q.CreateOutboxMessage(ctx.Request().Context(), &outbox)
+		return err
 	})
 	if err != nil {
 		log.Info("[BOStatusOrder] Update Purchase Log State Error : ", err.Error())
+		if errors.Is(err, ErrIllegalTransition) || errors.Is(err, ErrUnknownPurchaseStatus) {
+			return nil, echo.NewHTTPError(http.StatusConflict, err.Error())
+		}
+		return nil, err
 	}
+	r.pruneWebhookDeliveries(ctx.Request().Context(), delivery)
 
-	return r.vendureClient.SendOrderStatus(json_map)
+	if response, ok := r.deliverOutboxNow(ctx.Request().Context(), message).(*proto.StatusNotificationResponse); ok && response != nil {
+		return response, nil
+	}
+	return &proto.StatusNotificationResponse{}, nil
 }
 
 func (r *useCase) LicenceStatus(ctx echo.Context) (result *proto.LicensePlateStatusNotificationResponse, err error) {
 	log.Info("[Webhook] LicenceStatus Start")
 
+	body, delivery, err := r.verifyWebhook(ctx, "licence_status")
+	if err != nil {
+		return nil, err
+	}
+
 	json_map := new(proto.LicensePlateStatusNotificationInput)
 
-	if err = json.NewDecoder(ctx.Request().Body).Decode(&json_map); err != nil {
+	if err = json.Unmarshal(body, &json_map); err != nil {
 		log.Error("[Webhook] LicenceStatus Parsing Payload Error: ", err)
 		return nil, err
 	}
 	log.Debug("[Webhook] LicenceStatus Param: ", json_map)
 
-	return r.vendureClient.SendPlateStatus(json_map)
+	// Plate notifications carry no invoice to order them by.
+	outbox, err := outboxMessage(OutboxPlateStatus, "", json_map)
+	if err != nil {
+		return nil, err
+	}
+	var message query.OutboxMessage
+	err = r.repo.ExecTx(ctx.Request().Context(), func(q *query.Queries) (err error) {
+		if err = recordWebhookDelivery(ctx.Request().Context(), q, delivery); err != nil {
+			return err
+		}
+		message, err = q.CreateOutboxMessage(ctx.Request().Context(), &outbox)
+		return err
+	})
+	if err != nil {
+		log.Info("[LicenceStatus] Create Outbox Message Error : ", err.Error())
+		return nil, err
+	}
+	r.pruneWebhookDeliveries(ctx.Request().Context(), delivery)
+
+	if response, ok := r.deliverOutboxNow(ctx.Request().Context(), message).(*proto.LicensePlateStatusNotificationResponse); ok && response != nil {
+		return response, nil
+	}
+	return &proto.LicensePlateStatusNotificationResponse{}, nil
 }
 
-func extractAttributes(attrs []odooConnectorModel.OrderConfirmationAttributes) (orderItem []*proto.OrderItem, discountOrderItem []*proto.OrderItem) {
+func extractAttributes(attrs []odooConnectorModel.OrderConfirmationAttributes) (orderItem []*proto.OrderItem, discountOrderItem []*proto.OrderItem, err error) {
 	for _, item := range attrs {
 		attributeItems := []*proto.Attribute{}
 		utils.CopyObject(item.Attributes, &attributeItems)
 
-		value, _ := utils.StringToInt32(item.OdooValue)
+		value, err := orderAmount(item.OdooValue)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", item.OdooName, err)
+		}
 		label := item.Label
-		if value < 0 {
-			value *= -1
+		if value.Units < 0 {
+			value = value.Neg()
 			label = strings.ReplaceAll(item.Label, "-", "")
 		}
 		itemPurchase := &proto.OrderItem{
 			Name:       item.OdooName,
-			Value:      value,
+			Value:      value.LegacyInt32(),
+			ValueMoney: protoMoney(value),
 			Label:      label,
 			Attributes: attributeItems,
 		}
 
 		if item.ReductionType == "discount" {
-			discountOrderItem = append(orderItem, itemPurchase)
+			discountOrderItem = append(discountOrderItem, itemPurchase)
 		} else {
 			orderItem = append(orderItem, itemPurchase)
 		}
 	}
 
-	return orderItem, discountOrderItem
+	return orderItem, discountOrderItem, nil
 }
 
-func addAdminTax(result *odooConnectorModel.OrderConfirmationResponses) {
+func addAdminTax(result *odooConnectorModel.OrderConfirmationResponses) error {
 	if result.Tax != "" {
-		tax, _ := utils.StringToInt(result.Tax)
-		total, _ := utils.StringToInt(result.Administrations.Total)
+		tax, err := orderAmount(result.Tax)
+		if err != nil {
+			return err
+		}
+		total, err := orderAmount(result.Administrations.Total)
+		if err != nil {
+			return err
+		}
 		result.Administrations.Items = append(result.Administrations.Items, odooConnectorModel.OrderConfirmationAttributes{
 			OdooName:  "Tax",
 			OdooValue: result.Tax,
 			Label:     result.Tax,
 		})
-		total += tax
-		result.Administrations.Total = fmt.Sprintf("%d", total)
+		total, err = total.Add(tax)
+		if err != nil {
+			return err
+		}
+		result.Administrations.Total = total.Decimal()
 	}
+
+	return nil
 }
 
 func (r *useCase) SetPreOrderPaymentStatus(ctx context.Context, in *proto.PaymentParams) (result *proto.PurchaseDetailResponse, err error) {
@@ -505,7 +677,7 @@ func (r *useCase) SetPreOrderPaymentStatus(ctx context.Context, in *proto.Paymen
 
 	paymentParams := odooConnectorModel.PaymentParams{}
 	utils.CopyObject(in, &paymentParams)
-	paymentNotification, err := r.oRepo.SetPreOrderPaymentStatus(paymentParams)
+	paymentNotification, err := r.oRepo.SetPreOrderPaymentStatus(ctx, paymentParams)
 	if err != nil {
 		code = false
 		log.Info(err.Error())
@@ -543,18 +715,18 @@ func (r *useCase) PreOrderConfirmation(ctx context.Context, in *proto.PurchasePa
 		salesOrderID, _ := utils.StringToInt32(in.SalesOrderID)
 
 		if in.PaymentTypeID != "" {
-			orderConfirmation, err = r.oRepo.SetPreOrderPaymentMethod(salesOrderID, in.PaymentTypeID)
+			orderConfirmation, err = r.oRepo.SetPreOrderPaymentMethod(ctx, salesOrderID, in.PaymentTypeID)
 			if err != nil {
 				log.Error("[Error SetPaymentMethod PreOrder Confirmation]-", err)
 				return result, err
 			}
 		} else {
-			r.oRepo.ResetPreOrderPaymentMethod(salesOrderID)
+			r.oRepo.ResetPreOrderPaymentMethod(ctx, salesOrderID)
 		}
 
 	}
 
-	orderConfirmation, err = r.oRepo.SetPreOrderConfirmation(purchaseParams)
+	orderConfirmation, err = r.oRepo.SetPreOrderConfirmation(ctx, purchaseParams)
 	if err != nil {
 		log.Error("[Error SetPreOrderConfirmation PreOrder Confirmation]-", err)
 		return result, err
@@ -580,7 +752,7 @@ func (r *useCase) PreOrderPaymentConfirm(ctx context.Context, in *proto.Purchase
 
 	salesOrderID, _ := utils.StringToInt32(in.SalesOrderID)
 
-	orderConfirmation, err = r.oRepo.PreOrderPaymentConfirm(salesOrderID)
+	orderConfirmation, err = r.oRepo.PreOrderPaymentConfirm(ctx, salesOrderID)
 	if err != nil {
 		log.Error("[Error SetPreOrderConfirmation PreOrder Confirmation]-", err)
 		return result, err
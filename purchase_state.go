@@ -0,0 +1,229 @@
+package usecase
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	utils "zebrax.id/emi/integration/core/utils"
+	"zebrax.id/emi/integration/erp/adapter/repository/query"
+	odooConnectorModel "zebrax.id/emi/integration/erp/connector/odoo/model"
+)
+
+// PurchaseState is the lifecycle state stored on the purchase log.
+type PurchaseState string
+
+const (
+	PurchaseDraft           PurchaseState = "draft"
+	PurchaseConfirmed       PurchaseState = "confirmed"
+	PurchaseAwaitingPayment PurchaseState = "awaiting_payment"
+	PurchasePaid            PurchaseState = "paid"
+	PurchaseFulfilled       PurchaseState = "fulfilled"
+	PurchaseCancelled       PurchaseState = "cancelled"
+	PurchaseRefunded        PurchaseState = "refunded"
+	PurchaseExpired         PurchaseState = "expired"
+)
+
+// purchaseTransitions lists the states each state may move to. Cancelled,
+// refunded and expired are final.
+var purchaseTransitions = map[PurchaseState][]PurchaseState{
+	PurchaseDraft:           {PurchaseConfirmed, PurchaseCancelled, PurchaseExpired},
+	PurchaseConfirmed:       {PurchaseAwaitingPayment, PurchaseCancelled, PurchaseExpired},
+	PurchaseAwaitingPayment: {PurchasePaid, PurchaseCancelled, PurchaseExpired},
+	PurchasePaid:            {PurchaseFulfilled, PurchaseRefunded},
+	PurchaseFulfilled:       {PurchaseRefunded},
+}
+
+// purchaseStatusAliases maps the status strings sent by the payment gateway
+// and the BO webhook onto purchase states. A captured card payment is paid;
+// denied and failed payments end the purchase like a cancellation.
+var purchaseStatusAliases = map[string]PurchaseState{
+	"draft":            PurchaseDraft,
+	"confirmed":        PurchaseConfirmed,
+	"sale":             PurchaseConfirmed,
+	"awaiting_payment": PurchaseAwaitingPayment,
+	"pending":          PurchaseAwaitingPayment,
+	"unpaid":           PurchaseAwaitingPayment,
+	"authorize":        PurchaseAwaitingPayment,
+	"paid":             PurchasePaid,
+	"settlement":       PurchasePaid,
+	"settled":          PurchasePaid,
+	"capture":          PurchasePaid,
+	"success":          PurchasePaid,
+	"fulfilled":        PurchaseFulfilled,
+	"done":             PurchaseFulfilled,
+	"delivered":        PurchaseFulfilled,
+	"cancelled":        PurchaseCancelled,
+	"canceled":         PurchaseCancelled,
+	"cancel":           PurchaseCancelled,
+	"deny":             PurchaseCancelled,
+	"denied":           PurchaseCancelled,
+	"failure":          PurchaseCancelled,
+	"failed":           PurchaseCancelled,
+	"refunded":         PurchaseRefunded,
+	"refund":           PurchaseRefunded,
+	"chargeback":       PurchaseRefunded,
+	"expired":          PurchaseExpired,
+	"expire":           PurchaseExpired,
+}
+
+// purchaseInitialState is assumed for purchase logs written before states
+// were tracked; a purchase log is only created once a payment is requested.
+const purchaseInitialState = PurchaseAwaitingPayment
+
+var (
+	ErrUnknownPurchaseStatus = errors.New("unknown purchase status")
+	ErrIllegalTransition     = errors.New("illegal purchase state transition")
+)
+
+// ParsePurchaseState maps an incoming status string to a purchase state.
+func ParsePurchaseState(status string) (PurchaseState, error) {
+	state, ok := purchaseStatusAliases[strings.ToLower(strings.TrimSpace(status))]
+	if !ok {
+		return "", fmt.Errorf("%w %q", ErrUnknownPurchaseStatus, status)
+	}
+
+	return state, nil
+}
+
+// storedPurchaseState reads the state stored on the purchase log of invoiceID.
+// Logs without a state, and logs whose state can no longer be read, are taken
+// to be in purchaseInitialState so they do not block every later transition.
+func storedPurchaseState(current sql.NullString, invoiceID string) PurchaseState {
+	if !current.Valid || current.String == "" {
+		return purchaseInitialState
+	}
+	state, err := ParsePurchaseState(current.String)
+	if err != nil {
+		log.Error(fmt.Sprintf("[Purchase State] Stored State of %s, Assuming %s: ", invoiceID, purchaseInitialState), err)
+		return purchaseInitialState
+	}
+
+	return state
+}
+
+// CanTransitionTo reports whether s may move to next. Staying in the same
+// state is allowed so repeated notifications are harmless.
+func (s PurchaseState) CanTransitionTo(next PurchaseState) bool {
+	if s == next {
+		return true
+	}
+	for _, allowed := range purchaseTransitions[s] {
+		if allowed == next {
+			return true
+		}
+	}
+
+	return false
+}
+
+// transitionPurchase moves the purchase log of invoiceID to the state named by
+// status and records the transition in the history, in one transaction with
+// then, which may add writes that must only happen on an accepted transition.
+// Rejected transitions are recorded in the history and returned as
+// ErrIllegalTransition or ErrUnknownPurchaseStatus.
+func (r *useCase) transitionPurchase(ctx context.Context, invoiceID string, status string, source string, then func(q *query.Queries) error) (err error) {
+	var (
+		from, to PurchaseState
+		rejected error
+	)
+
+	err = r.repo.ExecTx(ctx, func(q *query.Queries) error {
+		current, err := q.GetPurchaseLogStateForUpdate(ctx, invoiceID)
+		if errors.Is(err, sql.ErrNoRows) {
+			log.Info(fmt.Sprintf("[Purchase State] %s No Purchase Log for %s", source, invoiceID))
+			if then == nil {
+				return nil
+			}
+			return then(q)
+		}
+		if err != nil {
+			return err
+		}
+
+		from = storedPurchaseState(current, invoiceID)
+
+		to, rejected = ParsePurchaseState(status)
+		if rejected == nil && !from.CanTransitionTo(to) {
+			rejected = fmt.Errorf("%w: %s -> %s", ErrIllegalTransition, from, to)
+		}
+		if rejected != nil {
+			return rejected
+		}
+
+		now := utils.TimeToRoundNanoSecond(time.Now())
+		err = q.UpdatePurchaseLogState(ctx, &query.UpdatePurchaseLogStateParams{
+			InvoiceID:   invoiceID,
+			State:       sql.NullString{String: string(to), Valid: true},
+			UpdatedTime: sql.NullTime{Time: now, Valid: true},
+		})
+		if err != nil {
+			return err
+		}
+
+		err = q.CreatePurchaseStateTransition(ctx, &query.CreatePurchaseStateTransitionParams{
+			InvoiceID:       invoiceID,
+			FromState:       string(from),
+			ToState:         string(to),
+			RequestedStatus: status,
+			Source:          source,
+			Accepted:        true,
+			CreatedTime:     now,
+		})
+		if err != nil {
+			return err
+		}
+
+		if then == nil {
+			return nil
+		}
+		return then(q)
+	})
+
+	if err == nil && to != "" {
+		r.settleStockReservation(ctx, invoiceID, to)
+	}
+
+	if rejected != nil {
+		log.Info(fmt.Sprintf("[Purchase State] %s Rejected for %s: %s", source, invoiceID, rejected.Error()))
+		recordErr := r.repo.CreatePurchaseStateTransition(ctx, &query.CreatePurchaseStateTransitionParams{
+			InvoiceID:       invoiceID,
+			FromState:       string(from),
+			ToState:         string(to),
+			RequestedStatus: status,
+			Source:          source,
+			Accepted:        false,
+			Reason:          sql.NullString{String: rejected.Error(), Valid: true},
+			CreatedTime:     utils.TimeToRoundNanoSecond(time.Now()),
+		})
+		if recordErr != nil {
+			log.Error("[Purchase State] Record Rejected Transition Error: ", recordErr)
+		}
+	}
+
+	return err
+}
+
+// settleStockReservation follows the stock reserved for the invoice to the
+// new purchase state: kept while payment is pending, consumed once paid and
+// released when the purchase ends unpaid.
+func (r *useCase) settleStockReservation(ctx context.Context, invoiceID string, state PurchaseState) {
+	var err error
+	switch state {
+	case PurchaseAwaitingPayment:
+		err = r.oRepo.ExtendStockReservation(ctx, 0, invoiceID, "")
+	case PurchasePaid, PurchaseFulfilled:
+		err = r.oRepo.ReleaseStockReservation(ctx, 0, invoiceID, odooConnectorModel.ReservationConsumed)
+	case PurchaseCancelled, PurchaseRefunded, PurchaseExpired:
+		err = r.oRepo.ReleaseStockReservation(ctx, 0, invoiceID, odooConnectorModel.ReservationReleased)
+	default:
+		return
+	}
+	if err != nil {
+		log.Error(fmt.Sprintf("[Purchase State] Stock Reservation of %s Error: ", invoiceID), err)
+	}
+}
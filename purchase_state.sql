@@ -0,0 +1,29 @@
+-- Schema and sqlc queries for the purchase state history.
+
+CREATE TABLE IF NOT EXISTS purchase_state_transitions (
+    id               BIGSERIAL    PRIMARY KEY,
+    invoice_id       VARCHAR(255) NOT NULL,
+    from_state       VARCHAR(32)  NOT NULL,
+    to_state         VARCHAR(32)  NOT NULL,
+    requested_status VARCHAR(64)  NOT NULL,
+    source           VARCHAR(64)  NOT NULL,
+    accepted         BOOLEAN      NOT NULL,
+    reason           TEXT,
+    created_time     TIMESTAMP    NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS purchase_state_transitions_invoice_id_idx ON purchase_state_transitions (invoice_id);
+
+-- name: GetPurchaseLogStateForUpdate :one
+SELECT state FROM purchase_logs
+WHERE invoice_id = $1
+FOR UPDATE;
+
+-- name: CreatePurchaseStateTransition :exec
+INSERT INTO purchase_state_transitions (invoice_id, from_state, to_state, requested_status, source, accepted, reason, created_time)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8);
+
+-- name: ListPurchaseStateTransitions :many
+SELECT * FROM purchase_state_transitions
+WHERE invoice_id = $1
+ORDER BY id;
@@ -0,0 +1,89 @@
+package usecase
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+)
+
+func TestParsePurchaseState(t *testing.T) {
+	tests := []struct {
+		status string
+		want   PurchaseState
+	}{
+		{status: "pending", want: PurchaseAwaitingPayment},
+		{status: "authorize", want: PurchaseAwaitingPayment},
+		{status: "capture", want: PurchasePaid},
+		{status: "settlement", want: PurchasePaid},
+		{status: " PAID ", want: PurchasePaid},
+		{status: "deny", want: PurchaseCancelled},
+		{status: "failure", want: PurchaseCancelled},
+		{status: "cancel", want: PurchaseCancelled},
+		{status: "expire", want: PurchaseExpired},
+		{status: "refund", want: PurchaseRefunded},
+		{status: "delivered", want: PurchaseFulfilled},
+	}
+	for _, tt := range tests {
+		t.Run(tt.status, func(t *testing.T) {
+			got, err := ParsePurchaseState(tt.status)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("state = %s, want %s", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := ParsePurchaseState("on_hold"); !errors.Is(err, ErrUnknownPurchaseStatus) {
+		t.Errorf("err = %v, want ErrUnknownPurchaseStatus", err)
+	}
+}
+
+func TestPurchaseStateCanTransitionTo(t *testing.T) {
+	tests := []struct {
+		from PurchaseState
+		to   PurchaseState
+		want bool
+	}{
+		{from: PurchaseDraft, to: PurchaseConfirmed, want: true},
+		{from: PurchaseDraft, to: PurchasePaid, want: false},
+		{from: PurchaseConfirmed, to: PurchaseAwaitingPayment, want: true},
+		{from: PurchaseAwaitingPayment, to: PurchasePaid, want: true},
+		{from: PurchaseAwaitingPayment, to: PurchaseCancelled, want: true},
+		{from: PurchaseAwaitingPayment, to: PurchaseExpired, want: true},
+		{from: PurchaseAwaitingPayment, to: PurchaseRefunded, want: false},
+		{from: PurchasePaid, to: PurchasePaid, want: true},
+		{from: PurchasePaid, to: PurchaseFulfilled, want: true},
+		{from: PurchasePaid, to: PurchaseCancelled, want: false},
+		{from: PurchaseFulfilled, to: PurchaseRefunded, want: true},
+		{from: PurchaseCancelled, to: PurchasePaid, want: false},
+		{from: PurchaseExpired, to: PurchaseAwaitingPayment, want: false},
+		{from: PurchaseRefunded, to: PurchaseFulfilled, want: false},
+	}
+	for _, tt := range tests {
+		if got := tt.from.CanTransitionTo(tt.to); got != tt.want {
+			t.Errorf("%s -> %s = %t, want %t", tt.from, tt.to, got, tt.want)
+		}
+	}
+}
+
+func TestStoredPurchaseState(t *testing.T) {
+	tests := []struct {
+		name   string
+		stored sql.NullString
+		want   PurchaseState
+	}{
+		{name: "null", want: purchaseInitialState},
+		{name: "empty", stored: sql.NullString{Valid: true}, want: purchaseInitialState},
+		{name: "known", stored: sql.NullString{String: "paid", Valid: true}, want: PurchasePaid},
+		{name: "unreadable", stored: sql.NullString{String: "on_hold", Valid: true}, want: purchaseInitialState},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := storedPurchaseState(tt.stored, "INV/2023/0001"); got != tt.want {
+				t.Errorf("state = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
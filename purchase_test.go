@@ -0,0 +1,135 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"zebrax.id/emi/integration/core/proto"
+	odooConnectorModel "zebrax.id/emi/integration/erp/connector/odoo/model"
+)
+
+func TestOrderComponents(t *testing.T) {
+	order := odooConnectorModel.OrderConfirmationResponses{
+		SoID:       "15",
+		SoNumber:   "S00015",
+		GrandTotal: "33.000.000",
+		Purchase: odooConnectorModel.OrderComponent{
+			Total: "33,000,000.00",
+		},
+	}
+
+	data, err := orderComponents(order)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data.TotalMoney.Units != 3300000000 || data.Purchase.TotalMoney.Units != 3300000000 {
+		t.Errorf("totals = %d and %d, want 3300000000", data.TotalMoney.Units, data.Purchase.TotalMoney.Units)
+	}
+
+	// An unreadable amount keeps the order and reports the error.
+	order.GrandTotal = "33.00.000"
+	data, err = orderComponents(order)
+	if !errors.Is(err, odooConnectorModel.ErrInvalidAmount) {
+		t.Errorf("err = %v, want ErrInvalidAmount", err)
+	}
+	if data == nil || data.SalesOrderID != "15" || data.SalesOrderNumber != "S00015" {
+		t.Fatalf("order = %+v, want the sales order kept", data)
+	}
+	if data.TotalMoney.Units != 0 || data.Purchase.TotalMoney.Units != 3300000000 {
+		t.Errorf("totals = %d and %d, want 0 and 3300000000", data.TotalMoney.Units, data.Purchase.TotalMoney.Units)
+	}
+}
+
+// orderRepo creates sales order 15 and reserves its stock against stock.
+type orderRepo struct {
+	odooRepo
+	grandTotal string
+	stock      int32
+	reserved   []string
+	cancelled  []int32
+}
+
+func (r *orderRepo) SetOrderConfirmation(ctx context.Context, p odooConnectorModel.PurchaseParams) (odooConnectorModel.OrderConfirmationResponses, error) {
+	return odooConnectorModel.OrderConfirmationResponses{Code: "0", Message: "Created", SoID: "15", GrandTotal: r.grandTotal}, nil
+}
+
+func (r *orderRepo) GetVoucherReductions(ctx context.Context, salesOrderId int32) ([]odooConnectorModel.AppliedVoucher, error) {
+	return nil, nil
+}
+
+func (r *orderRepo) ReserveStock(ctx context.Context, p odooConnectorModel.PurchaseParams) error {
+	r.reserved = append(r.reserved, p.SalesOrderID)
+	if p.Orders[0].Qty > r.stock {
+		return &odooConnectorModel.StockShortageError{ProductCode: p.Orders[0].ProductCode, Requested: int(p.Orders[0].Qty), Available: int(r.stock)}
+	}
+	return nil
+}
+
+func (r *orderRepo) CancelSaleOrder(ctx context.Context, salesOrderId int32) error {
+	r.cancelled = append(r.cancelled, salesOrderId)
+	return nil
+}
+
+func TestOrderConfirmationReservesStock(t *testing.T) {
+	in := &proto.PurchaseParam{
+		CustomerID: "7",
+		DealerID:   "3",
+		Orders:     []*proto.OrderParam{{ProductCode: "A11113", Qty: 2}},
+	}
+
+	t.Run("in stock", func(t *testing.T) {
+		repo := &orderRepo{grandTotal: "33.000.000", stock: 2}
+		result, err := (&useCase{oRepo: repo}).orderConfirmation(context.Background(), in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !result.Status.Success || len(repo.reserved) != 1 || len(repo.cancelled) != 0 {
+			t.Errorf("status %+v, reserved %v, cancelled %v, want the order reserved", result.Status, repo.reserved, repo.cancelled)
+		}
+	})
+
+	t.Run("unreadable amounts", func(t *testing.T) {
+		repo := &orderRepo{grandTotal: "33.00.000", stock: 2}
+		result, err := (&useCase{oRepo: repo}).orderConfirmation(context.Background(), in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Status.Success || len(repo.reserved) != 1 {
+			t.Errorf("status %+v, reserved %v, want a failed status and the order reserved", result.Status, repo.reserved)
+		}
+	})
+
+	t.Run("short of stock", func(t *testing.T) {
+		repo := &orderRepo{grandTotal: "33.000.000", stock: 1}
+		result, err := (&useCase{oRepo: repo}).orderConfirmation(context.Background(), in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Status.Success || result.OrderData != nil {
+			t.Errorf("status %+v, order %+v, want a failed status without the order", result.Status, result.OrderData)
+		}
+		if len(repo.cancelled) != 1 || repo.cancelled[0] != 15 {
+			t.Errorf("cancelled %v, want sales order 15", repo.cancelled)
+		}
+	})
+}
+
+// matrixRepo fails every variant matrix lookup.
+type matrixRepo struct {
+	odooRepo
+}
+
+func (r *matrixRepo) GetProductVariantMatrix(ctx context.Context, dealerId int32, productCode string) ([]odooConnectorModel.ProductTemplateMatrix, error) {
+	return nil, errors.New("connection refused")
+}
+
+func TestProductPriceError(t *testing.T) {
+	result, err := (&useCase{oRepo: &matrixRepo{}}).ProductPrice(context.Background(), &proto.PurchaseParam{DealerID: "3", ProductCode: "ZX1"})
+	if err == nil {
+		t.Fatal("err = nil, want the matrix error")
+	}
+	if result == nil || result.Status == nil || result.Status.Success {
+		t.Errorf("result = %+v, want a failed status", result)
+	}
+}
@@ -0,0 +1,66 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrVoucherUnavailable = errors.New("voucher is not available for this order")
+	ErrVoucherStacking    = errors.New("vouchers cannot be combined")
+	ErrVoucherNotApplied  = errors.New("voucher is not applied to this order")
+	ErrOrderLocked        = errors.New("order can no longer be changed")
+)
+
+// VoucherStackingError names the voucher that breaks a stacking rule.
+type VoucherStackingError struct {
+	VoucherCode string
+	Reason      string
+}
+
+func (e *VoucherStackingError) Error() string {
+	return fmt.Sprintf("%s: %s %s", ErrVoucherStacking.Error(), e.VoucherCode, e.Reason)
+}
+
+func (e *VoucherStackingError) Unwrap() error {
+	return ErrVoucherStacking
+}
+
+// AppliedVoucher is a voucher applied to a sales order.
+type AppliedVoucher struct {
+	VoucherID   int32
+	VoucherCode string
+	// Amount is what the voucher takes off the order; it is only set by
+	// GetVoucherReductions.
+	Amount Money
+}
+
+// VoucherReason is the machine readable outcome of a voucher eligibility
+// check.
+type VoucherReason string
+
+const (
+	VoucherEligible       VoucherReason = "eligible"
+	VoucherNotFound       VoucherReason = "not_found"
+	VoucherUnavailable    VoucherReason = "unavailable"
+	VoucherExpired        VoucherReason = "expired"
+	VoucherWrongDealer    VoucherReason = "wrong_dealer"
+	VoucherBelowMinimum   VoucherReason = "below_minimum"
+	VoucherAlreadyApplied VoucherReason = "already_applied"
+	VoucherNotStackable   VoucherReason = "not_stackable"
+	// VoucherNoLongerApplies is given for an applied voucher Odoo took off
+	// the order when recomputing its coupon lines.
+	VoucherNoLongerApplies VoucherReason = "no_longer_applies"
+)
+
+// VoucherEligibility tells whether a voucher can be applied to an order and
+// the discount it would give, without applying it.
+type VoucherEligibility struct {
+	VoucherID   int32
+	VoucherCode string
+	Eligible    bool
+	Reason      VoucherReason
+	Message     string
+	OrderTotal  Money
+	Discount    Money
+}
@@ -0,0 +1,302 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"zebrax.id/emi/integration/erp/connector/odoo/model"
+	"zebrax.id/emi/integration/erp/connector/odoo/repository/query"
+)
+
+// Reminder statuses stored in testdrive_reminders.
+const (
+	ReminderPending   = "pending"
+	ReminderSent      = "sent"
+	ReminderFailed    = "failed"
+	ReminderCancelled = "cancelled"
+)
+
+// TestDriveReminder is one reminder handed to a ReminderNotifier.
+type TestDriveReminder struct {
+	BookingID    int
+	BookingCode  string
+	UID          int
+	ProductName  string
+	LocationName string
+	Address      string
+	StartTime    time.Time
+	// Offset is how long before StartTime the reminder is due.
+	Offset time.Duration
+}
+
+// ReminderNotifier delivers test drive reminders to the customer.
+type ReminderNotifier interface {
+	SendTestDriveReminder(ctx context.Context, reminder TestDriveReminder) error
+}
+
+// logReminderNotifier only logs reminders; it is used until a real notifier
+// is set with SetReminderNotifier.
+type logReminderNotifier struct{}
+
+func (logReminderNotifier) SendTestDriveReminder(ctx context.Context, reminder TestDriveReminder) error {
+	log.Info(fmt.Sprintf("[Odoo - Connector - Reminder] Booking %s for user %d at %s (%s before)", reminder.BookingCode, reminder.UID, reminder.StartTime.Format(time.RFC3339), reminder.Offset))
+	return nil
+}
+
+var (
+	reminderNotifierMu sync.RWMutex
+	reminderNotifier   ReminderNotifier = logReminderNotifier{}
+)
+
+// SetReminderNotifier replaces the notifier used by RunTestDriveReminders.
+func SetReminderNotifier(notifier ReminderNotifier) {
+	reminderNotifierMu.Lock()
+	defer reminderNotifierMu.Unlock()
+	reminderNotifier = notifier
+}
+
+func currentReminderNotifier() ReminderNotifier {
+	reminderNotifierMu.RLock()
+	defer reminderNotifierMu.RUnlock()
+	return reminderNotifier
+}
+
+// ReminderConfig controls the test drive reminder scheduler.
+type ReminderConfig struct {
+	// Offsets are how long before the start of a booking reminders are sent.
+	Offsets []time.Duration
+	// PollInterval is how often bookings are read and due reminders sent.
+	PollInterval time.Duration
+	// MaxLateness drops a reminder found more than this long after it was
+	// due, e.g. a 24h reminder for a booking made 3 hours ahead.
+	MaxLateness time.Duration
+	// BatchSize is the number of due reminders claimed per poll.
+	BatchSize int32
+	// MaxAttempts marks a reminder failed once reached.
+	MaxAttempts int32
+	// RetryBackoff is the wait after the first failed delivery; it doubles
+	// with every further attempt.
+	RetryBackoff time.Duration
+	// Retention is how long reminders are kept after the booking started.
+	Retention time.Duration
+}
+
+// DefaultReminderConfig reminds customers a day and two hours ahead.
+var DefaultReminderConfig = ReminderConfig{
+	Offsets:      []time.Duration{24 * time.Hour, 2 * time.Hour},
+	PollInterval: time.Minute,
+	MaxLateness:  30 * time.Minute,
+	BatchSize:    100,
+	MaxAttempts:  5,
+	RetryBackoff: 2 * time.Minute,
+	Retention:    30 * 24 * time.Hour,
+}
+
+var ErrInvalidReminderOffset = errors.New("invalid reminder offset")
+
+// ParseReminderOffsets parses a comma separated list of durations such as
+// "24h,2h".
+func ParseReminderOffsets(spec string) (offsets []time.Duration, err error) {
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		offset, err := time.ParseDuration(item)
+		if err != nil || offset <= 0 {
+			return nil, fmt.Errorf("%w %q", ErrInvalidReminderOffset, item)
+		}
+		offsets = append(offsets, offset)
+	}
+	if len(offsets) == 0 {
+		return nil, fmt.Errorf("%w %q", ErrInvalidReminderOffset, spec)
+	}
+	sort.Slice(offsets, func(i, j int) bool { return offsets[i] > offsets[j] })
+
+	return offsets, nil
+}
+
+// RunTestDriveReminders schedules and sends test drive reminders until ctx is
+// done. Several instances may run it; each reminder is claimed by one of them.
+func (r *repository) RunTestDriveReminders(ctx context.Context, config ReminderConfig) {
+	log.Info("[Odoo - Connector - Reminder] Scheduler Start")
+	defer log.Info("[Odoo - Connector - Reminder] Scheduler End")
+
+	ticker := time.NewTicker(config.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := r.scheduleTestDriveReminders(ctx, config); err != nil {
+			log.Error("[Odoo - Connector - Reminder] Schedule Error: ", err)
+		}
+		for {
+			claimed, err := r.sendTestDriveReminders(ctx, config)
+			if err != nil {
+				log.Error("[Odoo - Connector - Reminder] Send Error: ", err)
+				break
+			}
+			if claimed < int(config.BatchSize) {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// scheduleTestDriveReminders creates the reminders of the upcoming bookings.
+// Existing reminders are left alone, so a booking keeps its sent and
+// cancelled reminders while a rescheduled one gets new reminders for its new
+// start time.
+func (r *repository) scheduleTestDriveReminders(ctx context.Context, config ReminderConfig) error {
+	if len(config.Offsets) == 0 {
+		return nil
+	}
+
+	now := time.Now()
+	longest := config.Offsets[0]
+	for _, offset := range config.Offsets {
+		if offset > longest {
+			longest = offset
+		}
+	}
+
+	// Odoo dates the bookings in WIB, whatever the zone of this host.
+	rows, err := r.qry.GetUpcomingTestDrives(ctx, &query.GetUpcomingTestDrivesParams{
+		FromDate: sql.NullString{String: now.In(model.WIB).Format("2006-01-02"), Valid: true},
+		ToDate:   sql.NullString{String: now.Add(longest + config.PollInterval).In(model.WIB).Format("2006-01-02"), Valid: true},
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, row := range rows {
+		if model.BookingStatusCancelled(row.BookingStatus.String) {
+			continue
+		}
+		start, err := model.ParseBookingTime(row.Date.String, row.StartTime.String)
+		if err != nil {
+			log.Info(fmt.Sprintf("[Odoo - Connector - Reminder] Booking %s Start Time Error: %s", row.BookingCode.String, err.Error()))
+			continue
+		}
+		if !start.After(now) {
+			continue
+		}
+
+		for _, offset := range config.Offsets {
+			remindTime := start.Add(-offset)
+			if remindTime.After(now.Add(config.PollInterval)) || now.Sub(remindTime) > config.MaxLateness {
+				continue
+			}
+			err = r.qry.CreateTestDriveReminder(ctx, &query.CreateTestDriveReminderParams{
+				BookingID:     row.BookingID.Int32,
+				BookingCode:   row.BookingCode.String,
+				Uid:           row.Uid.Int32,
+				ProductName:   row.ProductName.String,
+				LocationName:  row.EcName.String,
+				Address:       row.EcAddress.String,
+				OffsetMinutes: int32(offset / time.Minute),
+				StartTime:     start,
+				RemindTime:    remindTime,
+				CreatedTime:   now,
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if err = r.qry.ExpireTestDriveReminders(ctx, now); err != nil {
+		return err
+	}
+	if config.Retention > 0 {
+		return r.qry.DeleteTestDriveRemindersBefore(ctx, now.Add(-config.Retention))
+	}
+	return nil
+}
+
+// sendTestDriveReminders claims one batch of due reminders and sends them. It
+// returns the number of reminders claimed.
+func (r *repository) sendTestDriveReminders(ctx context.Context, config ReminderConfig) (int, error) {
+	now := time.Now()
+	reminders, err := r.qry.ClaimDueTestDriveReminders(ctx, &query.ClaimDueTestDriveRemindersParams{
+		Now:   now,
+		Limit: config.BatchSize,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	notifier := currentReminderNotifier()
+	for _, reminder := range reminders {
+		sendErr := notifier.SendTestDriveReminder(ctx, TestDriveReminder{
+			BookingID:    int(reminder.BookingID),
+			BookingCode:  reminder.BookingCode,
+			UID:          int(reminder.Uid),
+			ProductName:  reminder.ProductName,
+			LocationName: reminder.LocationName,
+			Address:      reminder.Address,
+			StartTime:    reminder.StartTime,
+			Offset:       time.Duration(reminder.OffsetMinutes) * time.Minute,
+		})
+		if sendErr == nil {
+			continue
+		}
+
+		log.Info(fmt.Sprintf("[Odoo - Connector - Reminder] Send %s attempt %d Error: %s", reminder.BookingCode, reminder.Attempts, sendErr.Error()))
+		next := now.Add(reminderBackoff(config.RetryBackoff, reminder.Attempts))
+		status := ReminderPending
+		if reminder.Attempts >= config.MaxAttempts || !next.Before(reminder.StartTime) {
+			status = ReminderFailed
+		}
+		err = r.qry.ReleaseTestDriveReminder(ctx, &query.ReleaseTestDriveReminderParams{
+			ID:              reminder.ID,
+			Status:          status,
+			LastError:       sql.NullString{String: sendErr.Error(), Valid: true},
+			NextAttemptTime: next,
+		})
+		if err != nil {
+			log.Error("[Odoo - Connector - Reminder] Release Error: ", err)
+		}
+	}
+
+	return len(reminders), nil
+}
+
+// maxReminderBackoff caps the wait between two delivery attempts.
+const maxReminderBackoff = time.Hour
+
+// reminderBackoff returns the wait before the next delivery of a reminder that
+// failed attempts times.
+func reminderBackoff(base time.Duration, attempts int32) time.Duration {
+	if base <= 0 {
+		return 0
+	}
+	backoff := base
+	for i := int32(1); i < attempts && backoff < maxReminderBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > maxReminderBackoff {
+		backoff = maxReminderBackoff
+	}
+	return backoff
+}
+
+// cancelTestDriveReminders stops the pending reminders of a cancelled or
+// rescheduled booking.
+func (r *repository) cancelTestDriveReminders(ctx context.Context, bookingID int32) {
+	if err := r.qry.CancelTestDriveReminders(ctx, bookingID); err != nil {
+		log.Error(fmt.Sprintf("[Odoo - Connector - Reminder] Cancel Booking %d Error: ", bookingID), err)
+	}
+}
@@ -0,0 +1,83 @@
+-- Schema and sqlc queries for the test drive reminders.
+
+CREATE TABLE IF NOT EXISTS testdrive_reminders (
+    id             BIGSERIAL    PRIMARY KEY,
+    booking_id     INTEGER      NOT NULL,
+    booking_code   VARCHAR(64)  NOT NULL,
+    uid            INTEGER      NOT NULL,
+    product_name   VARCHAR(255) NOT NULL DEFAULT '',
+    location_name  VARCHAR(255) NOT NULL DEFAULT '',
+    address        TEXT         NOT NULL DEFAULT '',
+    offset_minutes INTEGER      NOT NULL,
+    start_time     TIMESTAMPTZ  NOT NULL,
+    remind_time    TIMESTAMPTZ  NOT NULL,
+    -- When the reminder is next tried; pushed back after a failed delivery.
+    next_attempt_time TIMESTAMPTZ NOT NULL,
+    status         VARCHAR(16)  NOT NULL DEFAULT 'pending',
+    attempts       INTEGER      NOT NULL DEFAULT 0,
+    last_error     TEXT,
+    sent_time      TIMESTAMPTZ,
+    created_time   TIMESTAMPTZ  NOT NULL DEFAULT NOW(),
+    UNIQUE (booking_id, offset_minutes, start_time)
+);
+
+CREATE INDEX IF NOT EXISTS testdrive_reminders_due_idx ON testdrive_reminders (next_attempt_time) WHERE status = 'pending';
+
+-- Reads the same view as GetTestDriveListByCustomerView, for every customer.
+-- name: GetUpcomingTestDrives :many
+SELECT uid, product_name, booking_id, booking_code, date, start_time, ec_name, ec_address, booking_status
+FROM v_testdrive_list_by_customer
+WHERE date BETWEEN sqlc.arg(from_date) AND sqlc.arg(to_date)
+ORDER BY date, start_time;
+
+-- The view carries the operating hours of each experience center with its
+-- bookings; centers that were never booked have no row.
+-- name: GetExperienceCenterHours :one
+SELECT ec_id, state_name, ec_longitude, operational_hours
+FROM v_testdrive_list_by_customer
+WHERE ec_id = $1 AND operational_hours IS NOT NULL
+LIMIT 1;
+
+-- name: CreateTestDriveReminder :exec
+INSERT INTO testdrive_reminders (booking_id, booking_code, uid, product_name, location_name, address, offset_minutes, start_time, remind_time, next_attempt_time, created_time)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $9, $10)
+ON CONFLICT (booking_id, offset_minutes, start_time) DO NOTHING;
+
+-- Due reminders are marked sent before delivery so each is sent at most once;
+-- a failed delivery is put back with ReleaseTestDriveReminder. Reminders of
+-- bookings that already started are left to ExpireTestDriveReminders.
+-- name: ClaimDueTestDriveReminders :many
+UPDATE testdrive_reminders
+SET status = 'sent', sent_time = sqlc.arg(now), attempts = attempts + 1
+WHERE id IN (
+    SELECT id FROM testdrive_reminders
+    WHERE status = 'pending'
+      AND next_attempt_time <= sqlc.arg(now)
+      AND start_time > sqlc.arg(now)
+    ORDER BY next_attempt_time
+    LIMIT sqlc.arg('limit')
+    FOR UPDATE SKIP LOCKED
+)
+RETURNING *;
+
+-- name: ReleaseTestDriveReminder :exec
+UPDATE testdrive_reminders
+SET status = $2, sent_time = NULL, last_error = $3, next_attempt_time = $4
+WHERE id = $1;
+
+-- Gives up the pending reminders of bookings that already started.
+-- name: ExpireTestDriveReminders :exec
+UPDATE testdrive_reminders
+SET status = 'failed', last_error = 'booking started before the reminder was sent'
+WHERE status = 'pending'
+  AND start_time <= $1;
+
+-- name: CancelTestDriveReminders :exec
+UPDATE testdrive_reminders
+SET status = 'cancelled'
+WHERE booking_id = $1
+  AND status = 'pending';
+
+-- name: DeleteTestDriveRemindersBefore :exec
+DELETE FROM testdrive_reminders
+WHERE start_time < $1;
@@ -0,0 +1,264 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"zebrax.id/emi/integration/erp/connector/odoo/model"
+	"zebrax.id/emi/integration/erp/connector/odoo/repository/query"
+)
+
+// reminderQueries keeps reminders in memory the way reminder.sql keeps them in
+// testdrive_reminders.
+type reminderQueries struct {
+	query.Querier
+	upcoming  []query.GetUpcomingTestDrivesRow
+	bounds    *query.GetUpcomingTestDrivesParams
+	reminders []query.TestdriveReminder
+}
+
+func (q *reminderQueries) GetUpcomingTestDrives(ctx context.Context, arg *query.GetUpcomingTestDrivesParams) ([]query.GetUpcomingTestDrivesRow, error) {
+	q.bounds = arg
+	return q.upcoming, nil
+}
+
+func (q *reminderQueries) CreateTestDriveReminder(ctx context.Context, arg *query.CreateTestDriveReminderParams) error {
+	for _, reminder := range q.reminders {
+		if reminder.BookingID == arg.BookingID && reminder.OffsetMinutes == arg.OffsetMinutes && reminder.StartTime.Equal(arg.StartTime) {
+			return nil
+		}
+	}
+	q.reminders = append(q.reminders, query.TestdriveReminder{
+		ID:              int64(len(q.reminders) + 1),
+		BookingID:       arg.BookingID,
+		BookingCode:     arg.BookingCode,
+		Uid:             arg.Uid,
+		OffsetMinutes:   arg.OffsetMinutes,
+		StartTime:       arg.StartTime,
+		RemindTime:      arg.RemindTime,
+		NextAttemptTime: arg.RemindTime,
+		Status:          ReminderPending,
+	})
+	return nil
+}
+
+func (q *reminderQueries) ExpireTestDriveReminders(ctx context.Context, startTime time.Time) error {
+	return nil
+}
+
+func (q *reminderQueries) DeleteTestDriveRemindersBefore(ctx context.Context, startTime time.Time) error {
+	return nil
+}
+
+func (q *reminderQueries) ClaimDueTestDriveReminders(ctx context.Context, arg *query.ClaimDueTestDriveRemindersParams) (claimed []query.TestdriveReminder, err error) {
+	for i := range q.reminders {
+		reminder := &q.reminders[i]
+		if reminder.Status != ReminderPending || reminder.NextAttemptTime.After(arg.Now) || !reminder.StartTime.After(arg.Now) {
+			continue
+		}
+		if len(claimed) == int(arg.Limit) {
+			break
+		}
+		reminder.Status = ReminderSent
+		reminder.Attempts++
+		claimed = append(claimed, *reminder)
+	}
+	return claimed, nil
+}
+
+func (q *reminderQueries) ReleaseTestDriveReminder(ctx context.Context, arg *query.ReleaseTestDriveReminderParams) error {
+	for i := range q.reminders {
+		if q.reminders[i].ID == arg.ID {
+			q.reminders[i].Status = arg.Status
+			q.reminders[i].LastError = arg.LastError
+			q.reminders[i].NextAttemptTime = arg.NextAttemptTime
+		}
+	}
+	return nil
+}
+
+func (q *reminderQueries) CancelTestDriveReminders(ctx context.Context, bookingID int32) error {
+	for i := range q.reminders {
+		if q.reminders[i].BookingID == bookingID && q.reminders[i].Status == ReminderPending {
+			q.reminders[i].Status = ReminderCancelled
+		}
+	}
+	return nil
+}
+
+func (q *reminderQueries) GetTestDriveSlotByBookingId(ctx context.Context, bookingID sql.NullInt32) (query.GetTestDriveSlotByBookingIdRow, error) {
+	return query.GetTestDriveSlotByBookingIdRow{}, sql.ErrNoRows
+}
+
+func (q *reminderQueries) SetCancelBookingTestDrive(ctx context.Context, arg *query.SetCancelBookingTestDriveParams) (string, error) {
+	return "0|Cancel Succesfully", nil
+}
+
+// recordingNotifier fails the first failures deliveries.
+type recordingNotifier struct {
+	failures int
+	sent     []TestDriveReminder
+}
+
+func (n *recordingNotifier) SendTestDriveReminder(ctx context.Context, reminder TestDriveReminder) error {
+	if n.failures > 0 {
+		n.failures--
+		return errors.New("push service unavailable")
+	}
+	n.sent = append(n.sent, reminder)
+	return nil
+}
+
+func useReminderNotifier(t *testing.T, notifier ReminderNotifier) {
+	SetReminderNotifier(notifier)
+	t.Cleanup(func() { SetReminderNotifier(logReminderNotifier{}) })
+}
+
+func TestScheduleTestDriveReminders(t *testing.T) {
+	start := time.Now().Add(100 * time.Minute).In(model.WIB).Truncate(time.Minute)
+	qry := &reminderQueries{upcoming: []query.GetUpcomingTestDrivesRow{
+		{
+			BookingID:   sql.NullInt32{Int32: 733, Valid: true},
+			BookingCode: sql.NullString{String: "TD/D0202/22/00187", Valid: true},
+			Date:        sql.NullString{String: start.Format("2006-01-02"), Valid: true},
+			StartTime:   sql.NullString{String: start.Format(time.RFC3339), Valid: true},
+		},
+		{
+			BookingID:     sql.NullInt32{Int32: 734, Valid: true},
+			Date:          sql.NullString{String: start.Format("2006-01-02"), Valid: true},
+			StartTime:     sql.NullString{String: start.Format(time.RFC3339), Valid: true},
+			BookingStatus: sql.NullString{String: "Cancelled", Valid: true},
+		},
+	}}
+	r := &repository{qry: qry}
+
+	config := DefaultReminderConfig
+	if err := r.scheduleTestDriveReminders(context.Background(), config); err != nil {
+		t.Fatal(err)
+	}
+
+	if want := time.Now().In(model.WIB).Format("2006-01-02"); qry.bounds.FromDate.String != want {
+		t.Errorf("from date = %s, want %s in WIB", qry.bounds.FromDate.String, want)
+	}
+	// The 2h reminder is 20 minutes late, within MaxLateness; the 24h one is
+	// long gone and the cancelled booking gets none.
+	if len(qry.reminders) != 1 || qry.reminders[0].BookingID != 733 || qry.reminders[0].OffsetMinutes != 120 {
+		t.Fatalf("reminders = %+v, want the 2h reminder of booking 733", qry.reminders)
+	}
+}
+
+func TestSendTestDriveRemindersClaimsOnce(t *testing.T) {
+	notifier := &recordingNotifier{}
+	useReminderNotifier(t, notifier)
+
+	now := time.Now()
+	qry := &reminderQueries{reminders: []query.TestdriveReminder{
+		{ID: 1, BookingID: 733, Status: ReminderPending, StartTime: now.Add(time.Hour), NextAttemptTime: now.Add(-time.Minute)},
+		{ID: 2, BookingID: 734, Status: ReminderPending, StartTime: now.Add(time.Hour), NextAttemptTime: now.Add(time.Hour)},
+	}}
+	r := &repository{qry: qry}
+
+	for i := 0; i < 2; i++ {
+		if _, err := r.sendTestDriveReminders(context.Background(), DefaultReminderConfig); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(notifier.sent) != 1 || notifier.sent[0].BookingID != 733 {
+		t.Errorf("sent = %+v, want booking 733 once", notifier.sent)
+	}
+	if qry.reminders[0].Status != ReminderSent || qry.reminders[1].Status != ReminderPending {
+		t.Errorf("statuses = %s and %s, want sent and pending", qry.reminders[0].Status, qry.reminders[1].Status)
+	}
+}
+
+func TestSendTestDriveRemindersBackoff(t *testing.T) {
+	notifier := &recordingNotifier{failures: 1}
+	useReminderNotifier(t, notifier)
+
+	now := time.Now()
+	config := DefaultReminderConfig
+	tests := []struct {
+		name     string
+		reminder query.TestdriveReminder
+		status   string
+	}{
+		{
+			name:     "retried later",
+			reminder: query.TestdriveReminder{ID: 1, Attempts: 0, StartTime: now.Add(time.Hour)},
+			status:   ReminderPending,
+		},
+		{
+			name:     "out of attempts",
+			reminder: query.TestdriveReminder{ID: 1, Attempts: config.MaxAttempts - 1, StartTime: now.Add(time.Hour)},
+			status:   ReminderFailed,
+		},
+		{
+			name:     "retry after the start",
+			reminder: query.TestdriveReminder{ID: 1, Attempts: 0, StartTime: now.Add(time.Minute)},
+			status:   ReminderFailed,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			notifier.failures = 1
+			tt.reminder.Status = ReminderPending
+			tt.reminder.NextAttemptTime = now.Add(-time.Second)
+			qry := &reminderQueries{reminders: []query.TestdriveReminder{tt.reminder}}
+			r := &repository{qry: qry}
+
+			if _, err := r.sendTestDriveReminders(context.Background(), config); err != nil {
+				t.Fatal(err)
+			}
+			got := qry.reminders[0]
+			if got.Status != tt.status {
+				t.Errorf("status = %s, want %s", got.Status, tt.status)
+			}
+			if !got.LastError.Valid {
+				t.Error("the delivery error was not recorded")
+			}
+			if tt.status == ReminderPending && got.NextAttemptTime.Before(now.Add(config.RetryBackoff)) {
+				t.Errorf("next attempt at %s, want at least %s later", got.NextAttemptTime, config.RetryBackoff)
+			}
+		})
+	}
+}
+
+func TestReminderBackoff(t *testing.T) {
+	tests := []struct {
+		attempts int32
+		want     time.Duration
+	}{
+		{attempts: 1, want: 2 * time.Minute},
+		{attempts: 2, want: 4 * time.Minute},
+		{attempts: 4, want: 16 * time.Minute},
+		{attempts: 10, want: maxReminderBackoff},
+	}
+	for _, tt := range tests {
+		if got := reminderBackoff(2*time.Minute, tt.attempts); got != tt.want {
+			t.Errorf("backoff after %d attempts = %s, want %s", tt.attempts, got, tt.want)
+		}
+	}
+}
+
+func TestCancelBookingCancelsReminders(t *testing.T) {
+	now := time.Now()
+	qry := &reminderQueries{reminders: []query.TestdriveReminder{
+		{ID: 1, BookingID: 733, Status: ReminderPending, StartTime: now.Add(time.Hour)},
+		{ID: 2, BookingID: 733, Status: ReminderSent, StartTime: now.Add(time.Hour)},
+		{ID: 3, BookingID: 734, Status: ReminderPending, StartTime: now.Add(time.Hour)},
+	}}
+	r := &repository{qry: qry}
+
+	_, err := r.SetCancelBookingTestDrive(context.Background(), model.CancelBookingTestDriveParams{BookingID: 733})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []string{ReminderCancelled, ReminderSent, ReminderPending} {
+		if qry.reminders[i].Status != want {
+			t.Errorf("reminder %d status = %s, want %s", qry.reminders[i].ID, qry.reminders[i].Status, want)
+		}
+	}
+}
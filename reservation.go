@@ -0,0 +1,301 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	utils "zebrax.id/emi/integration/core/utils"
+	"zebrax.id/emi/integration/erp/connector/odoo/model"
+	"zebrax.id/emi/integration/erp/connector/odoo/repository/query"
+)
+
+// StockReservationExpiryJob is the lease name of the job that expires stock
+// reservations.
+const StockReservationExpiryJob = "stock.reservation_expiry"
+
+// StockReservationConfig controls how long stock stays reserved for a sales
+// order.
+type StockReservationConfig struct {
+	// Hold is how long stock stays reserved after order confirmation.
+	Hold time.Duration
+	// PaymentHold is how long a reservation is extended while its payment is
+	// pending.
+	PaymentHold time.Duration
+}
+
+var DefaultStockReservationConfig = StockReservationConfig{
+	Hold:        15 * time.Minute,
+	PaymentHold: time.Hour,
+}
+
+var (
+	stockReservationMu     sync.RWMutex
+	stockReservationConfig = DefaultStockReservationConfig
+)
+
+// SetStockReservation replaces how long stock stays reserved.
+func SetStockReservation(config StockReservationConfig) {
+	stockReservationMu.Lock()
+	defer stockReservationMu.Unlock()
+	stockReservationConfig = config
+}
+
+func currentStockReservation() StockReservationConfig {
+	stockReservationMu.RLock()
+	defer stockReservationMu.RUnlock()
+	return stockReservationConfig
+}
+
+func (v variantSet) key() string {
+	return strings.Join([]string{v.Color, v.Battery, v.Mirror, v.Wheel}, "|")
+}
+
+// ReserveStock holds the stock of the order lines of purchaseParams for its
+// sales order, replacing what the order held before. Without order lines the
+// reservations of the order are only extended. A line the dealer cannot cover
+// once other orders' reservations are taken out fails with a
+// StockShortageError, and the order keeps what it held before.
+func (r *repository) ReserveStock(ctx context.Context, purchaseParams model.PurchaseParams) (err error) {
+	defer log.Info("[Odoo - Connector - ReserveStock] End")
+	log.Info("[Odoo - Connector - ReserveStock] Start")
+
+	salesOrderId, _ := utils.StringToInt32(purchaseParams.SalesOrderID)
+	dealerId, _ := utils.StringToInt32(purchaseParams.DealerID)
+	if salesOrderId == 0 {
+		return nil
+	}
+
+	lines, err := resolveOrderLines(purchaseParams)
+	if err != nil {
+		return err
+	}
+	config := currentStockReservation()
+	if len(lines) == 0 {
+		return r.extendStockReservation(ctx, salesOrderId, "", config.Hold)
+	}
+
+	stocks, err := r.odooStock(ctx, purchaseParams)
+	if err != nil {
+		return err
+	}
+	available := make([]int, len(lines))
+	for i := range lines {
+		available[i] = stockQty(stocks[i])
+	}
+
+	now := time.Now()
+	err = r.execTx(ctx, func(q *query.Queries) error {
+		return reserveLines(ctx, q, salesOrderId, dealerId, lines, available, now, config.Hold)
+	})
+	if err != nil {
+		return err
+	}
+
+	log.Info(fmt.Sprintf("[Odoo - Connector - ReserveStock] Reserved %d lines for sale order %d until %s", len(lines), salesOrderId, now.Add(config.Hold).Format(time.RFC3339)))
+	return nil
+}
+
+// reserveLines replaces the reservations of the sales order with lines, where
+// available is the stock on hand of each line. Lines of the same variant are
+// reserved together, so their quantities count against the stock as one. It
+// runs in one transaction and first locks the variants of lines, in a fixed
+// order so that orders sharing variants cannot deadlock; concurrent orders
+// then reserve one after the other instead of both counting the same stock as
+// free.
+func reserveLines(ctx context.Context, q query.Querier, salesOrderId int32, dealerId int32, lines []orderLine, available []int, now time.Time, hold time.Duration) error {
+	type variant struct{ productCode, key string }
+	variants := []variant{}
+	first := map[variant]int{}
+	qty := map[variant]int{}
+	for i, line := range lines {
+		v := variant{line.ProductCode, line.Variants.key()}
+		if _, ok := first[v]; !ok {
+			first[v] = i
+			variants = append(variants, v)
+		}
+		qty[v] += line.Qty
+	}
+	sort.Slice(variants, func(i, j int) bool {
+		if variants[i].productCode != variants[j].productCode {
+			return variants[i].productCode < variants[j].productCode
+		}
+		return variants[i].key < variants[j].key
+	})
+	for _, v := range variants {
+		err := q.LockStockVariant(ctx, &query.LockStockVariantParams{
+			DealerID:    dealerId,
+			ProductCode: v.productCode,
+			VariantKey:  v.key,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if _, err := q.ReleaseStockReservations(ctx, &query.ReleaseStockReservationsParams{
+		SalesOrderID: salesOrderId,
+		Status:       model.ReservationReleased,
+		Now:          now,
+	}); err != nil {
+		return err
+	}
+
+	for i, line := range lines {
+		v := variant{line.ProductCode, line.Variants.key()}
+		if first[v] != i {
+			continue
+		}
+		_, err := q.CreateStockReservation(ctx, &query.CreateStockReservationParams{
+			SalesOrderID: salesOrderId,
+			DealerID:     dealerId,
+			ProductCode:  line.ProductCode,
+			VariantKey:   v.key,
+			Qty:          int32(qty[v]),
+			ExpiresTime:  now.Add(hold),
+			Now:          now,
+			Available:    int32(available[i]),
+		})
+		if errors.Is(err, sql.ErrNoRows) {
+			reserved, _ := reservedStock(ctx, q, dealerId, line, salesOrderId, now)
+			return &model.StockShortageError{
+				ProductCode: line.ProductCode,
+				Requested:   qty[v],
+				Available:   available[i] - reserved,
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// extendStockReservation keeps the stock of the sales order reserved for hold
+// from now, and ties it to invoiceNumber when given.
+func (r *repository) extendStockReservation(ctx context.Context, salesOrderId int32, invoiceNumber string, hold time.Duration) error {
+	now := time.Now()
+	extended, err := r.qry.ExtendStockReservations(ctx, &query.ExtendStockReservationsParams{
+		SalesOrderID:  salesOrderId,
+		InvoiceNumber: invoiceNumber,
+		ExpiresTime:   now.Add(hold),
+		Now:           now,
+	})
+	if err != nil {
+		return err
+	}
+
+	log.Info(fmt.Sprintf("[Odoo - Connector - ReserveStock] Extended %d reservations of sale order %d, invoice %s", extended, salesOrderId, invoiceNumber))
+	return nil
+}
+
+// ExtendStockReservation keeps the stock of the sales order, or of the order
+// invoiced as invoiceNumber, reserved while the invoice is waiting for
+// payment: until expiredTime, when the invoice expires, or for the payment
+// hold when expiredTime is empty, unreadable or past.
+func (r *repository) ExtendStockReservation(ctx context.Context, salesOrderId int32, invoiceNumber string, expiredTime string) error {
+	hold := currentStockReservation().PaymentHold
+	if expires, ok := parseOdooTime(expiredTime); ok {
+		if until := time.Until(expires); until > 0 {
+			hold = until
+		}
+	}
+	return r.extendStockReservation(ctx, salesOrderId, invoiceNumber, hold)
+}
+
+// ReleaseStockReservation ends the reservations of the sales order, or of the
+// order invoiced as invoiceNumber, with status: released when the order is
+// cancelled, consumed once it is paid and Odoo moves the stock itself.
+func (r *repository) ReleaseStockReservation(ctx context.Context, salesOrderId int32, invoiceNumber string, status string) error {
+	released, err := r.qry.ReleaseStockReservations(ctx, &query.ReleaseStockReservationsParams{
+		SalesOrderID:  salesOrderId,
+		InvoiceNumber: invoiceNumber,
+		Status:        status,
+		Now:           time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+
+	log.Info(fmt.Sprintf("[Odoo - Connector - ReserveStock] %s %d reservations of sale order %d, invoice %s", status, released, salesOrderId, invoiceNumber))
+	return nil
+}
+
+// StockReservationJobConfig controls the job that expires stock reservations.
+type StockReservationJobConfig struct {
+	Interval time.Duration
+	LeaseTTL time.Duration
+}
+
+var DefaultStockReservationJobConfig = StockReservationJobConfig{
+	Interval: time.Minute,
+	LeaseTTL: 5 * time.Minute,
+}
+
+// RunStockReservationExpiry expires the reservations past their hold until
+// ctx is done. Only the instance holding the lease does the work.
+func (r *repository) RunStockReservationExpiry(ctx context.Context, config StockReservationJobConfig) {
+	log.Info("[Odoo - Connector - StockReservationExpiry] Start")
+	defer log.Info("[Odoo - Connector - StockReservationExpiry] End")
+
+	ticker := time.NewTicker(config.Interval)
+	defer ticker.Stop()
+
+	leader := false
+	defer func() {
+		if leader {
+			r.releaseLease(context.Background(), StockReservationExpiryJob)
+		}
+	}()
+
+	for {
+		acquired, err := r.acquireLease(ctx, StockReservationExpiryJob, config.LeaseTTL)
+		if err != nil {
+			log.Error("[Odoo - Connector - StockReservationExpiry] Lease Error: ", err)
+		}
+		leader = acquired
+
+		if leader {
+			expired, err := r.qry.ExpireStockReservations(ctx, time.Now())
+			if err != nil {
+				log.Error("[Odoo - Connector - StockReservationExpiry] Error: ", err)
+			} else if expired > 0 {
+				log.Info(fmt.Sprintf("[Odoo - Connector - StockReservationExpiry] Expired %d reservations", expired))
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// reservedStock is the stock of line held at now for other sales orders than
+// salesOrderId.
+func reservedStock(ctx context.Context, q query.Querier, dealerId int32, line orderLine, salesOrderId int32, now time.Time) (int, error) {
+	reserved, err := q.SumReservedStock(ctx, &query.SumReservedStockParams{
+		DealerID:     dealerId,
+		ProductCode:  line.ProductCode,
+		VariantKey:   line.Variants.key(),
+		Now:          now,
+		SalesOrderID: salesOrderId,
+	})
+	return int(reserved), err
+}
+
+// stockQty reads the quantity on hand Odoo reports for a line.
+func stockQty(stock model.PurchaseStock) int {
+	qty, _ := strconv.ParseFloat(strings.TrimSpace(stock.Qty), 64)
+	return int(math.Floor(qty))
+}
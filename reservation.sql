@@ -0,0 +1,75 @@
+-- Schema and sqlc queries for the stock held for sales orders during
+-- checkout.
+
+CREATE TABLE IF NOT EXISTS stock_reservations (
+    id             BIGSERIAL    PRIMARY KEY,
+    sales_order_id INTEGER      NOT NULL,
+    invoice_number VARCHAR(255) NOT NULL DEFAULT '',
+    dealer_id      INTEGER      NOT NULL,
+    product_code   VARCHAR(64)  NOT NULL,
+    -- color|battery|mirror|wheel of the reserved variant.
+    variant_key    VARCHAR(255) NOT NULL,
+    qty            INTEGER      NOT NULL,
+    -- held, released, consumed or expired.
+    status         VARCHAR(16)  NOT NULL DEFAULT 'held',
+    expires_time   TIMESTAMPTZ  NOT NULL,
+    created_time   TIMESTAMPTZ  NOT NULL DEFAULT NOW(),
+    updated_time   TIMESTAMPTZ  NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS stock_reservations_variant_idx
+    ON stock_reservations (dealer_id, product_code, variant_key)
+    WHERE status = 'held';
+
+CREATE INDEX IF NOT EXISTS stock_reservations_sales_order_idx
+    ON stock_reservations (sales_order_id);
+
+CREATE INDEX IF NOT EXISTS stock_reservations_invoice_idx
+    ON stock_reservations (invoice_number)
+    WHERE invoice_number <> '';
+
+-- Serializes the reservations of one variant at a dealer until the end of the
+-- transaction.
+-- name: LockStockVariant :exec
+SELECT pg_advisory_xact_lock(hashtext(concat_ws(':', 'stock_variant',
+    sqlc.arg(dealer_id)::INTEGER, sqlc.arg(product_code)::TEXT, sqlc.arg(variant_key)::TEXT)));
+
+-- Reserves qty only while the held reservations of other sales orders leave
+-- enough of available; returns no row otherwise. Run under LockStockVariant.
+-- name: CreateStockReservation :one
+INSERT INTO stock_reservations (sales_order_id, dealer_id, product_code, variant_key, qty, status, expires_time, created_time, updated_time)
+SELECT @sales_order_id::int, @dealer_id::int, @product_code::text, @variant_key::text, @qty::int, 'held', @expires_time::timestamptz, @now::timestamptz, @now::timestamptz
+WHERE (
+    SELECT COALESCE(SUM(qty), 0) FROM stock_reservations
+    WHERE dealer_id = @dealer_id::int AND product_code = @product_code::text AND variant_key = @variant_key::text
+      AND status = 'held' AND expires_time > @now::timestamptz
+      AND sales_order_id <> @sales_order_id::int
+) + @qty::int <= @available::int
+RETURNING *;
+
+-- name: SumReservedStock :one
+SELECT COALESCE(SUM(qty), 0)::bigint FROM stock_reservations
+WHERE dealer_id = @dealer_id::int AND product_code = @product_code::text AND variant_key = @variant_key::text
+  AND status = 'held' AND expires_time > @now::timestamptz
+  AND sales_order_id <> @sales_order_id::int;
+
+-- A reservation past its hold is not revived, even before the expiry job
+-- marks it expired; its stock may already be reserved by another order.
+-- name: ExtendStockReservations :execrows
+UPDATE stock_reservations
+SET expires_time = @expires_time::timestamptz,
+    invoice_number = CASE WHEN @invoice_number::text = '' THEN invoice_number ELSE @invoice_number::text END,
+    updated_time = @now::timestamptz
+WHERE status = 'held' AND expires_time > @now::timestamptz
+  AND (sales_order_id = @sales_order_id::int OR (@invoice_number::text <> '' AND invoice_number = @invoice_number::text));
+
+-- name: ReleaseStockReservations :execrows
+UPDATE stock_reservations
+SET status = @status::text, updated_time = @now::timestamptz
+WHERE status = 'held'
+  AND (sales_order_id = @sales_order_id::int OR (@invoice_number::text <> '' AND invoice_number = @invoice_number::text));
+
+-- name: ExpireStockReservations :execrows
+UPDATE stock_reservations
+SET status = 'expired', updated_time = $1
+WHERE status = 'held' AND expires_time <= $1;
@@ -0,0 +1,214 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"zebrax.id/emi/integration/erp/connector/odoo/model"
+	"zebrax.id/emi/integration/erp/connector/odoo/repository/query"
+)
+
+// reservationQueries keeps stock reservations in memory the way the
+// reservation queries keep them in stock_reservations.
+type reservationQueries struct {
+	query.Querier
+	rows  []query.StockReservation
+	locks []string
+}
+
+func (q *reservationQueries) LockStockVariant(ctx context.Context, arg *query.LockStockVariantParams) error {
+	q.locks = append(q.locks, arg.ProductCode+"/"+arg.VariantKey)
+	return nil
+}
+
+func (q *reservationQueries) ReleaseStockReservations(ctx context.Context, arg *query.ReleaseStockReservationsParams) (released int64, err error) {
+	for i := range q.rows {
+		if q.rows[i].Status == "held" && q.rows[i].SalesOrderID == arg.SalesOrderID {
+			q.rows[i].Status = arg.Status
+			released++
+		}
+	}
+	return released, nil
+}
+
+func (q *reservationQueries) SumReservedStock(ctx context.Context, arg *query.SumReservedStockParams) (sum int64, err error) {
+	for _, row := range q.rows {
+		if row.Status == "held" && row.ExpiresTime.After(arg.Now) && row.SalesOrderID != arg.SalesOrderID &&
+			row.DealerID == arg.DealerID && row.ProductCode == arg.ProductCode && row.VariantKey == arg.VariantKey {
+			sum += int64(row.Qty)
+		}
+	}
+	return sum, nil
+}
+
+func (q *reservationQueries) CreateStockReservation(ctx context.Context, arg *query.CreateStockReservationParams) (query.StockReservation, error) {
+	reserved, _ := q.SumReservedStock(ctx, &query.SumReservedStockParams{
+		DealerID:     arg.DealerID,
+		ProductCode:  arg.ProductCode,
+		VariantKey:   arg.VariantKey,
+		Now:          arg.Now,
+		SalesOrderID: arg.SalesOrderID,
+	})
+	if reserved+int64(arg.Qty) > int64(arg.Available) {
+		return query.StockReservation{}, sql.ErrNoRows
+	}
+
+	row := query.StockReservation{
+		SalesOrderID: arg.SalesOrderID,
+		DealerID:     arg.DealerID,
+		ProductCode:  arg.ProductCode,
+		VariantKey:   arg.VariantKey,
+		Qty:          arg.Qty,
+		Status:       "held",
+		ExpiresTime:  arg.ExpiresTime,
+	}
+	q.rows = append(q.rows, row)
+	return row, nil
+}
+
+func (q *reservationQueries) held(salesOrderId int32) (qty int32) {
+	for _, row := range q.rows {
+		if row.Status == "held" && row.SalesOrderID == salesOrderId {
+			qty += row.Qty
+		}
+	}
+	return qty
+}
+
+func TestReserveLines(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2026, time.October, 1, 10, 0, 0, 0, model.WIB)
+	blue := variantSet{Color: "blue", Battery: "2"}
+	red := variantSet{Color: "red", Battery: "2"}
+
+	t.Run("locks each variant once in order", func(t *testing.T) {
+		q := &reservationQueries{}
+		lines := []orderLine{
+			{ProductCode: "ZX2", Qty: 1, Variants: red},
+			{ProductCode: "ZX1", Qty: 1, Variants: blue},
+			{ProductCode: "ZX2", Qty: 1, Variants: red},
+		}
+		if err := reserveLines(ctx, q, 1, 7, lines, []int{5, 5, 5}, now, time.Minute); err != nil {
+			t.Fatal(err)
+		}
+		want := []string{"ZX1/" + blue.key(), "ZX2/" + red.key()}
+		if !reflect.DeepEqual(q.locks, want) {
+			t.Errorf("locks = %v, want %v", q.locks, want)
+		}
+	})
+
+	t.Run("lines of one variant are reserved together", func(t *testing.T) {
+		q := &reservationQueries{}
+		lines := []orderLine{
+			{ProductCode: "ZX1", Qty: 2, Variants: blue},
+			{ProductCode: "ZX1", Qty: 1, Variants: red},
+			{ProductCode: "ZX1", Qty: 2, Variants: blue},
+		}
+		err := reserveLines(ctx, q, 1, 7, lines, []int{3, 3, 3}, now, time.Minute)
+		var shortage *model.StockShortageError
+		if !errors.As(err, &shortage) {
+			t.Fatalf("err = %v, want a StockShortageError", err)
+		}
+		if shortage.Requested != 4 || shortage.Available != 3 {
+			t.Errorf("shortage = %+v, want 4 requested and 3 available", shortage)
+		}
+
+		if err = reserveLines(ctx, q, 1, 7, lines, []int{4, 3, 4}, now, time.Minute); err != nil {
+			t.Fatal(err)
+		}
+		if len(q.rows) != 2 || q.held(1) != 5 {
+			t.Errorf("%d reservations holding %d, want 2 holding 5", len(q.rows), q.held(1))
+		}
+	})
+
+	t.Run("other orders' reservations are taken out", func(t *testing.T) {
+		q := &reservationQueries{}
+		lines := []orderLine{{ProductCode: "ZX1", Qty: 2, Variants: blue}}
+		if err := reserveLines(ctx, q, 1, 7, lines, []int{3}, now, time.Minute); err != nil {
+			t.Fatal(err)
+		}
+
+		err := reserveLines(ctx, q, 2, 7, lines, []int{3}, now, time.Minute)
+		var shortage *model.StockShortageError
+		if !errors.As(err, &shortage) {
+			t.Fatalf("err = %v, want a StockShortageError", err)
+		}
+		if shortage.Requested != 2 || shortage.Available != 1 {
+			t.Errorf("shortage = %+v, want 2 requested and 1 available", shortage)
+		}
+
+		// Another dealer's stock is not affected.
+		if err = reserveLines(ctx, q, 2, 8, lines, []int{3}, now, time.Minute); err != nil {
+			t.Errorf("other dealer: err = %v", err)
+		}
+	})
+
+	t.Run("reserving again replaces the order's reservations", func(t *testing.T) {
+		q := &reservationQueries{}
+		if err := reserveLines(ctx, q, 1, 7, []orderLine{{ProductCode: "ZX1", Qty: 3, Variants: blue}}, []int{3}, now, time.Minute); err != nil {
+			t.Fatal(err)
+		}
+		if err := reserveLines(ctx, q, 1, 7, []orderLine{{ProductCode: "ZX1", Qty: 1, Variants: blue}}, []int{3}, now, time.Minute); err != nil {
+			t.Fatal(err)
+		}
+		if held := q.held(1); held != 1 {
+			t.Errorf("held = %d, want 1", held)
+		}
+	})
+
+	t.Run("expired reservations free their stock", func(t *testing.T) {
+		q := &reservationQueries{}
+		lines := []orderLine{{ProductCode: "ZX1", Qty: 3, Variants: blue}}
+		if err := reserveLines(ctx, q, 1, 7, lines, []int{3}, now, time.Minute); err != nil {
+			t.Fatal(err)
+		}
+		if err := reserveLines(ctx, q, 2, 7, lines, []int{3}, now.Add(2*time.Minute), time.Minute); err != nil {
+			t.Errorf("err = %v, want the expired stock reserved", err)
+		}
+	})
+}
+
+// extendQueries records the hold ExtendStockReservations is asked for.
+type extendQueries struct {
+	query.Querier
+	arg *query.ExtendStockReservationsParams
+}
+
+func (q *extendQueries) ExtendStockReservations(ctx context.Context, arg *query.ExtendStockReservationsParams) (int64, error) {
+	q.arg = arg
+	return 1, nil
+}
+
+func TestExtendStockReservation(t *testing.T) {
+	q := &extendQueries{}
+	r := &repository{qry: q}
+	paymentHold := currentStockReservation().PaymentHold
+	expires := time.Now().Add(24 * time.Hour).Truncate(time.Second)
+
+	tests := []struct {
+		name        string
+		expiredTime string
+		want        time.Duration
+	}{
+		{name: "invoice expiry", expiredTime: expires.UTC().Format(odooDateTime), want: 24 * time.Hour},
+		{name: "invoice expiry with a zone", expiredTime: expires.In(model.WIB).Format(time.RFC3339), want: 24 * time.Hour},
+		{name: "no expiry", expiredTime: "", want: paymentHold},
+		{name: "unreadable expiry", expiredTime: "tomorrow", want: paymentHold},
+		{name: "past expiry", expiredTime: time.Now().Add(-time.Hour).UTC().Format(odooDateTime), want: paymentHold},
+	}
+	for _, tt := range tests {
+		if err := r.ExtendStockReservation(context.Background(), 15, "INV/2026/0001", tt.expiredTime); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if hold := q.arg.ExpiresTime.Sub(q.arg.Now); hold < tt.want-2*time.Second || hold > tt.want {
+			t.Errorf("%s: held for %s, want %s", tt.name, hold, tt.want)
+		}
+		if q.arg.InvoiceNumber != "INV/2026/0001" {
+			t.Errorf("%s: invoice = %q, want INV/2026/0001", tt.name, q.arg.InvoiceNumber)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultRPCTimeout bounds an Odoo XML-RPC call whose context carries no
+// deadline of its own.
+const DefaultRPCTimeout = 30 * time.Second
+
+var (
+	rpcTimeoutMu sync.RWMutex
+	rpcTimeout   = DefaultRPCTimeout
+)
+
+// SetRPCTimeout replaces the timeout applied to XML-RPC calls without a
+// deadline. A zero or negative timeout leaves such calls unbounded.
+func SetRPCTimeout(timeout time.Duration) {
+	rpcTimeoutMu.Lock()
+	defer rpcTimeoutMu.Unlock()
+	rpcTimeout = timeout
+}
+
+func currentRPCTimeout() time.Duration {
+	rpcTimeoutMu.RLock()
+	defer rpcTimeoutMu.RUnlock()
+	return rpcTimeout
+}
+
+// contextExecutor is implemented by XML-RPC clients that bind a call to its
+// context, such as XMLRPCClient.
+type contextExecutor interface {
+	ExecuteKwContext(ctx context.Context, method, model string, args []interface{}, options map[string]interface{}) (interface{}, error)
+}
+
+// executeKw calls Odoo through r.rpc with the deadline of ctx, or the RPC
+// timeout when ctx has none. Clients that only offer the blocking ExecuteKw
+// are called directly: ctx is only checked before the call, which then runs
+// until the client's own transport timeout, past the deadline of ctx if need
+// be.
+func (r *repository) executeKw(ctx context.Context, method string, model string, args []interface{}, options map[string]interface{}) (interface{}, error) {
+	if _, ok := ctx.Deadline(); !ok {
+		if timeout := currentRPCTimeout(); timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if client, ok := r.rpc.(contextExecutor); ok {
+		response, err := client.ExecuteKwContext(ctx, method, model, args, options)
+		if err != nil && ctx.Err() != nil {
+			log.Info(fmt.Sprintf("[Odoo - Connector - RPC] %s %s Aborted: %s", model, method, ctx.Err().Error()))
+			return nil, ctx.Err()
+		}
+		return response, err
+	}
+
+	return r.rpc.ExecuteKw(method, model, args, options)
+}
@@ -0,0 +1,98 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"zebrax.id/emi/integration/erp/connector/odoo/odootest"
+)
+
+func newTestRepository(t *testing.T) (*repository, *odootest.Server) {
+	t.Helper()
+	srv := odootest.NewServer()
+	t.Cleanup(srv.Close)
+
+	client := NewXMLRPCClient(XMLRPCConfig{
+		URL:      srv.URL,
+		DB:       srv.DB,
+		Username: srv.Username,
+		Password: srv.Password,
+		Timeout:  5 * time.Second,
+	})
+	return &repository{rpc: client}, srv
+}
+
+func TestExecuteKwRoundTrip(t *testing.T) {
+	r, srv := newTestRepository(t)
+	srv.Respond("res.partner", "search_read", []interface{}{
+		map[string]interface{}{"id": 7, "name": "Budi & Sons", "active": true, "credit": 1.5, "parent_id": false},
+	})
+
+	response, err := r.executeKw(context.Background(), "search_read", "res.partner", []interface{}{
+		[]interface{}{[]interface{}{"name", "ilike", "budi"}},
+	}, map[string]interface{}{"fields": []string{"name", "active"}, "limit": 1})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	records := rpcRecords(response)
+	if len(records) != 1 {
+		t.Fatalf("got %d records, want 1", len(records))
+	}
+	record := records[0]
+	if record["id"] != 7 || record["name"] != "Budi & Sons" || record["active"] != true || record["credit"] != 1.5 {
+		t.Errorf("record = %#v", record)
+	}
+
+	calls := srv.CallsTo("res.partner", "search_read")
+	if len(calls) != 1 {
+		t.Fatalf("got %d calls, want 1", len(calls))
+	}
+	fields, _ := calls[0].Kwargs["fields"].([]interface{})
+	if len(fields) != 2 || fields[0] != "name" || calls[0].Kwargs["limit"] != 1 {
+		t.Errorf("kwargs = %#v", calls[0].Kwargs)
+	}
+}
+
+func TestExecuteKwFault(t *testing.T) {
+	r, srv := newTestRepository(t)
+	srv.Fail("sale.order", "action_confirm", 2, "Order is locked")
+
+	_, err := r.executeKw(context.Background(), "action_confirm", "sale.order", []interface{}{[]interface{}{1}}, nil)
+
+	var fault *XMLRPCFault
+	if !errors.As(err, &fault) {
+		t.Fatalf("err = %v, want an *XMLRPCFault", err)
+	}
+	if fault.String != "Order is locked" {
+		t.Errorf("fault = %q, want %q", fault.String, "Order is locked")
+	}
+}
+
+func TestExecuteKwHonoursContext(t *testing.T) {
+	r, srv := newTestRepository(t)
+	release := make(chan struct{})
+	defer close(release)
+	srv.Handle("sale.order", "action_confirm", func(odootest.Call) (interface{}, error) {
+		<-release
+		return true, nil
+	})
+
+	// Log in first so the deadline only covers the blocked call.
+	if _, err := r.executeKw(context.Background(), "recompute_coupon_lines", "sale.order", []interface{}{1}, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := r.executeKw(ctx, "action_confirm", "sale.order", []interface{}{[]interface{}{1}}, nil)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("err = %v, want context.DeadlineExceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("call returned after %s, want it aborted at the deadline", elapsed)
+	}
+}
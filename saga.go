@@ -0,0 +1,432 @@
+package repository
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"zebrax.id/emi/integration/core/utils"
+	"zebrax.id/emi/integration/erp/connector/odoo/repository/query"
+)
+
+// Saga statuses. Completed and compensated sagas are removed from
+// sale_order_sagas, so a stored saga is always one that a retried request has
+// to pick up.
+const (
+	SagaRunning            = "running"
+	SagaCompensating       = "compensating"
+	SagaCompensationFailed = "compensation_failed"
+)
+
+// DefaultSagaClaimTTL is how long an attempt owns a saga without saving
+// progress; a retry takes the saga over once it passes.
+const DefaultSagaClaimTTL = 2 * time.Minute
+
+// ErrSagaInProgress is returned when another attempt of the same request is
+// still creating its sale order.
+var ErrSagaInProgress = errors.New("sale order for this request is already being created")
+
+// errSagaClaimLost is returned when another attempt took the saga over, so
+// this one must stop without touching Odoo again.
+var errSagaClaimLost = errors.New("sale order saga was taken over by another attempt")
+
+// SaleOrderSagaState is the progress of the XML-RPC steps that create a draft
+// sale order, saved after every step so a retried request can resume.
+type SaleOrderSagaState struct {
+	Key       string
+	Status    string
+	Step      int
+	OrderID   int
+	LineIDs   []int
+	LastError string
+	// Reference is written to the client_order_ref of the sale order, so an
+	// order whose creation was not recorded can be found again.
+	Reference string
+}
+
+// sagaStore persists saga progress between attempts.
+type sagaStore interface {
+	Save(state *SaleOrderSagaState) error
+	Delete(key string) error
+}
+
+// sagaClaim stores the progress of one attempt in sale_order_sagas. Its
+// writes do not use the request context, so a cancelled request still records
+// what it created.
+type sagaClaim struct {
+	r      *repository
+	holder string
+	ttl    time.Duration
+}
+
+var sagaAttempts uint64
+
+func newSagaClaim(r *repository) *sagaClaim {
+	return &sagaClaim{
+		r:      r,
+		holder: fmt.Sprintf("%s-%d", instanceID, atomic.AddUint64(&sagaAttempts, 1)),
+		ttl:    DefaultSagaClaimTTL,
+	}
+}
+
+// Claim takes the saga of key for this attempt, returning its recorded
+// progress. It fails with ErrSagaInProgress while another attempt holds it.
+func (c *sagaClaim) Claim(ctx context.Context, key string) (state SaleOrderSagaState, err error) {
+	now := time.Now()
+	row, err := c.r.qry.ClaimSaleOrderSaga(ctx, &query.ClaimSaleOrderSagaParams{
+		SagaKey:          key,
+		Status:           SagaRunning,
+		Holder:           c.holder,
+		ClaimExpiresTime: now.Add(c.ttl),
+		Now:              now,
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		return state, ErrSagaInProgress
+	}
+	if err != nil {
+		return state, err
+	}
+
+	state = SaleOrderSagaState{
+		Key:       row.SagaKey,
+		Status:    row.Status,
+		Step:      int(row.Step),
+		OrderID:   int(row.OrderID),
+		LastError: row.LastError,
+		Reference: row.Reference,
+	}
+	for _, id := range row.LineIds {
+		state.LineIDs = append(state.LineIDs, int(id))
+	}
+	return state, nil
+}
+
+func (c *sagaClaim) Save(state *SaleOrderSagaState) error {
+	lineIDs := make([]int32, 0, len(state.LineIDs))
+	for _, id := range state.LineIDs {
+		lineIDs = append(lineIDs, int32(id))
+	}
+
+	now := time.Now()
+	saved, err := c.r.qry.SaveSaleOrderSaga(context.Background(), &query.SaveSaleOrderSagaParams{
+		SagaKey:          state.Key,
+		Holder:           c.holder,
+		Status:           state.Status,
+		Step:             int32(state.Step),
+		OrderID:          int32(state.OrderID),
+		LineIds:          lineIDs,
+		LastError:        state.LastError,
+		ClaimExpiresTime: now.Add(c.ttl),
+		Now:              now,
+	})
+	if err != nil {
+		return err
+	}
+	if saved == 0 {
+		return errSagaClaimLost
+	}
+	return nil
+}
+
+func (c *sagaClaim) Delete(key string) error {
+	return c.r.qry.DeleteSaleOrderSaga(context.Background(), &query.DeleteSaleOrderSagaParams{
+		SagaKey: key,
+		Holder:  c.holder,
+	})
+}
+
+// Release gives the saga up so a retry can pick it up at once.
+func (c *sagaClaim) Release(key string) {
+	err := c.r.qry.ReleaseSaleOrderSaga(context.Background(), &query.ReleaseSaleOrderSagaParams{
+		SagaKey: key,
+		Holder:  c.holder,
+	})
+	if err != nil {
+		log.Error("[Odoo - Connector - Saga] Release Error: ", err)
+	}
+}
+
+// saleOrderSagaKey identifies a sale order request across retries by the
+// customer, the dealer and the order lines.
+func saleOrderSagaKey(uId int, dealerId int, lines []orderLine) string {
+	linesJSON, _ := json.Marshal(lines)
+	return fmt.Sprintf("sale.order:%d:%d:%x", uId, dealerId, sha256.Sum256(linesJSON))
+}
+
+// sagaStep is one forward action with the action that undoes it.
+type sagaStep struct {
+	Name       string
+	Run        func(state *SaleOrderSagaState) error
+	Compensate func(state *SaleOrderSagaState) error
+}
+
+// runSaga runs steps from state.Step, saving state after each one. When a
+// step fails the completed steps are compensated in reverse order. A saga
+// left compensating by an earlier attempt is compensated first and then run
+// again from the start.
+func runSaga(store sagaStore, state *SaleOrderSagaState, steps []sagaStep) (err error) {
+	if state.Status == SagaCompensating || state.Status == SagaCompensationFailed {
+		log.Info("[Odoo - Connector - Saga] Compensate previous attempt ", state.Key)
+		if err = compensateSaga(store, state, steps); err != nil {
+			return err
+		}
+		*state = SaleOrderSagaState{Key: state.Key, Reference: state.Reference}
+	}
+
+	if state.Step > 0 && state.Step < len(steps) {
+		log.Info(fmt.Sprintf("[Odoo - Connector - Saga] Resume %s at step %s", state.Key, steps[state.Step].Name))
+	}
+
+	for state.Step < len(steps) {
+		step := steps[state.Step]
+		state.Status = SagaRunning
+		if err = step.Run(state); err != nil {
+			log.Info(fmt.Sprintf("[Odoo - Connector - Saga] %s step %s Error: %s", state.Key, step.Name, err.Error()))
+			if errors.Is(err, errSagaClaimLost) {
+				return err
+			}
+			state.LastError = err.Error()
+			if compensateErr := compensateSaga(store, state, steps); compensateErr != nil {
+				return fmt.Errorf("%s: %w (compensation: %s)", step.Name, err, compensateErr.Error())
+			}
+			if deleteErr := store.Delete(state.Key); deleteErr != nil {
+				log.Error("[Odoo - Connector - Saga] Delete Error: ", deleteErr)
+			}
+			return fmt.Errorf("%s: %w", step.Name, err)
+		}
+
+		state.Step++
+		if err = store.Save(state); err != nil {
+			return err
+		}
+	}
+
+	return store.Delete(state.Key)
+}
+
+// compensateSaga undoes the steps up to state.Step, including the partial
+// effects of the failed step, and leaves state at the first step.
+func compensateSaga(store sagaStore, state *SaleOrderSagaState, steps []sagaStep) error {
+	state.Status = SagaCompensating
+	if err := store.Save(state); err != nil {
+		return err
+	}
+
+	last := state.Step
+	if last >= len(steps) {
+		last = len(steps) - 1
+	}
+	for i := last; i >= 0; i-- {
+		if steps[i].Compensate == nil {
+			continue
+		}
+		if err := steps[i].Compensate(state); err != nil {
+			log.Info(fmt.Sprintf("[Odoo - Connector - Saga] %s compensate %s Error: %s", state.Key, steps[i].Name, err.Error()))
+			state.Status = SagaCompensationFailed
+			if saveErr := store.Save(state); saveErr != nil {
+				return errors.New(err.Error() + "; " + saveErr.Error())
+			}
+			return err
+		}
+		state.Step = i
+		if err := store.Save(state); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// sagaDraftOrders returns the IDs of the draft sale orders created with
+// reference as their client_order_ref.
+func (r *repository) sagaDraftOrders(reference string) ([]interface{}, error) {
+	if reference == "" {
+		return nil, nil
+	}
+	response, err := r.executeKw(context.Background(), "search", "sale.order", []interface{}{
+		[]interface{}{
+			[]interface{}{"client_order_ref", "=", reference},
+			[]interface{}{"state", "=", "draft"},
+		},
+	}, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	ids, _ := response.([]interface{})
+	return ids, nil
+}
+
+// rpcCreatedID reads the record ID out of a "create" XML-RPC response, which
+// arrives as a one element list.
+func rpcCreatedID(response interface{}) int {
+	id, _ := utils.StringToInt(removeFirstAndLastChar(fmt.Sprintf("%d", response)))
+	return id
+}
+
+// createSaleOrder creates the draft sale order for lines as a saga: the order,
+// one line per order line, then the coupon recompute. A failure unlinks what
+// was created; a retried request with the same key resumes after the last
+// recorded step, picking up by its reference an order an earlier attempt
+// created without recording it. Only one attempt works on a key at a time.
+// Compensation does not use ctx, so a cancelled request still rolls back what
+// it created.
+func (r *repository) createSaleOrder(ctx context.Context, uId int, dealerId int, lines []orderLine, products []productIdResult) (orderId int, err error) {
+	store := newSagaClaim(r)
+	key := saleOrderSagaKey(uId, dealerId, lines)
+
+	state, err := store.Claim(ctx, key)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if err != nil {
+			store.Release(key)
+		}
+	}()
+
+	steps := []sagaStep{
+		{
+			Name: "sale.order create",
+			Run: func(state *SaleOrderSagaState) error {
+				if state.Reference != store.holder {
+					// An earlier attempt may have created the order and
+					// stopped before recording it.
+					found, err := r.sagaDraftOrders(state.Reference)
+					if err != nil {
+						return err
+					}
+					if len(found) > 0 {
+						log.Info("[Odoo - Connector - SetOrderConfirmation] Resume Sale.Order created by an earlier attempt: ", found[0])
+						state.OrderID, _ = found[0].(int)
+						return nil
+					}
+				}
+
+				log.Info("[Odoo - Connector - SetOrderConfirmation] Execute Sale.Order - Create")
+				params := map[string]interface{}{
+					"partner_id":            uId,
+					"sale_order_type":       2,
+					"company_id":            dealerId,
+					"pricelist_id":          products[0].PriceListID,
+					"show_update_pricelist": true,
+					"state":                 "draft",
+					"client_order_ref":      state.Reference,
+				}
+				log.Info(fmt.Sprintf("[Odoo - Connector - SetOrderConfirmation] Execute Sale.Order with Params: \n%#v\n", params))
+				getOrderId, err := r.executeKw(ctx, "create", "sale.order", []interface{}{
+					[]interface{}{
+						params,
+					},
+				}, nil)
+				if err != nil {
+					return err
+				}
+
+				log.Info("[Odoo - Connector - SetOrderConfirmation] Execute Sale.Order.Line - Create Order Id Original : ", getOrderId)
+				state.OrderID = rpcCreatedID(getOrderId)
+				return nil
+			},
+			Compensate: func(state *SaleOrderSagaState) error {
+				orderIds := []interface{}{state.OrderID}
+				if state.OrderID == 0 {
+					// The create call may have failed after Odoo committed
+					// the order, e.g. when the request timed out.
+					found, err := r.sagaDraftOrders(state.Reference)
+					if err != nil {
+						return err
+					}
+					if len(found) == 0 {
+						return nil
+					}
+					orderIds = found
+				}
+				log.Info("[Odoo - Connector - SetOrderConfirmation] Compensate Sale.Order - unlink: ", orderIds)
+				_, err := r.executeKw(context.Background(), "unlink", "sale.order", []interface{}{
+					orderIds,
+				}, nil)
+				if err != nil {
+					log.Info("[Odoo - Connector - SetOrderConfirmation] Compensate Sale.Order - action_cancel: ", orderIds)
+					if _, cancelErr := r.executeKw(context.Background(), "action_cancel", "sale.order", []interface{}{
+						orderIds,
+					}, nil); cancelErr != nil {
+						return err
+					}
+				}
+				state.OrderID = 0
+				return nil
+			},
+		},
+		{
+			Name: "sale.order.line create",
+			Run: func(state *SaleOrderSagaState) error {
+				for i := len(state.LineIDs); i < len(lines); i++ {
+					line, product := lines[i], products[i]
+					params := map[string]interface{}{
+						"order_id":        state.OrderID,
+						"product_id":      product.ProductID,
+						"name":            product.ProductName,
+						"product_uom":     product.UomID,
+						"product_uom_qty": line.Qty,
+						"price_unit":      product.UnitPrice,
+						"price_total":     product.UnitPrice * float64(line.Qty),
+					}
+					log.Info(fmt.Sprintf("[Odoo - Connector - SetOrderConfirmation] Execute Sale.Order.Line with Params: \n%#v\n", params))
+					getLineId, err := r.executeKw(ctx, "create", "sale.order.line", []interface{}{
+						[]interface{}{
+							params,
+						},
+					}, nil)
+					if err != nil {
+						return err
+					}
+
+					state.LineIDs = append(state.LineIDs, rpcCreatedID(getLineId))
+					if err = store.Save(state); err != nil {
+						return err
+					}
+				}
+				return nil
+			},
+			Compensate: func(state *SaleOrderSagaState) error {
+				if len(state.LineIDs) == 0 {
+					return nil
+				}
+				log.Info("[Odoo - Connector - SetOrderConfirmation] Compensate Sale.Order.Line - unlink: ", state.LineIDs)
+				ids := make([]interface{}, 0, len(state.LineIDs))
+				for _, id := range state.LineIDs {
+					ids = append(ids, id)
+				}
+				if _, err := r.executeKw(context.Background(), "unlink", "sale.order.line", []interface{}{ids}, nil); err != nil {
+					return err
+				}
+				state.LineIDs = nil
+				return nil
+			},
+		},
+		{
+			Name: "sale.order recompute_coupon_lines",
+			Run: func(state *SaleOrderSagaState) error {
+				log.Info("[Odoo - Connector - SetOrderConfirmation] Execute Sale.Order - recompute_coupon_lines params order Id: ", state.OrderID)
+				_, err := r.executeKw(ctx, "recompute_coupon_lines", "sale.order", []interface{}{
+					[]interface{}{
+						state.OrderID,
+					},
+				}, nil)
+				return err
+			},
+		},
+	}
+
+	if err = runSaga(store, &state, steps); err != nil {
+		return 0, err
+	}
+
+	return state.OrderID, nil
+}
@@ -0,0 +1,50 @@
+-- Schema and sqlc queries for the progress of the sale order sagas.
+
+CREATE TABLE IF NOT EXISTS sale_order_sagas (
+    saga_key           VARCHAR(255) PRIMARY KEY,
+    status             VARCHAR(32)  NOT NULL,
+    step               INTEGER      NOT NULL DEFAULT 0,
+    order_id           INTEGER      NOT NULL DEFAULT 0,
+    line_ids           INTEGER[]    NOT NULL DEFAULT '{}',
+    last_error         TEXT         NOT NULL DEFAULT '',
+    -- Written to the client_order_ref of the sale order, so an order whose
+    -- create call timed out can still be found, resumed or unlinked. Kept
+    -- across attempts.
+    reference          VARCHAR(255) NOT NULL,
+    -- The attempt working on the saga; empty once it gave the saga up.
+    holder             VARCHAR(255) NOT NULL DEFAULT '',
+    claim_expires_time TIMESTAMPTZ  NOT NULL,
+    updated_time       TIMESTAMPTZ  NOT NULL
+);
+
+-- Claims the saga of a request for holder, creating it on the first attempt.
+-- No row is returned while another attempt holds an unexpired claim.
+-- name: ClaimSaleOrderSaga :one
+INSERT INTO sale_order_sagas AS s (saga_key, status, reference, holder, claim_expires_time, updated_time)
+VALUES (sqlc.arg(saga_key), sqlc.arg(status), sqlc.arg(holder), sqlc.arg(holder), sqlc.arg(claim_expires_time), sqlc.arg(now))
+ON CONFLICT (saga_key) DO UPDATE
+SET holder = EXCLUDED.holder, claim_expires_time = EXCLUDED.claim_expires_time
+WHERE s.holder = '' OR s.claim_expires_time < sqlc.arg(now)
+RETURNING *;
+
+-- Saves the progress and renews the claim; no row is updated once another
+-- attempt took the saga over.
+-- name: SaveSaleOrderSaga :execrows
+UPDATE sale_order_sagas
+SET status = sqlc.arg(status),
+    step = sqlc.arg(step),
+    order_id = sqlc.arg(order_id),
+    line_ids = sqlc.arg(line_ids),
+    last_error = sqlc.arg(last_error),
+    claim_expires_time = sqlc.arg(claim_expires_time),
+    updated_time = sqlc.arg(now)
+WHERE saga_key = sqlc.arg(saga_key) AND holder = sqlc.arg(holder);
+
+-- name: ReleaseSaleOrderSaga :exec
+UPDATE sale_order_sagas
+SET holder = ''
+WHERE saga_key = $1 AND holder = $2;
+
+-- name: DeleteSaleOrderSaga :exec
+DELETE FROM sale_order_sagas
+WHERE saga_key = $1 AND holder = $2;
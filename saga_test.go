@@ -0,0 +1,204 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"zebrax.id/emi/integration/erp/connector/odoo/odootest"
+	"zebrax.id/emi/integration/erp/connector/odoo/repository/query"
+)
+
+// sagaQueries keeps sale_order_sagas in memory, claimed the way
+// ClaimSaleOrderSaga claims them.
+type sagaQueries struct {
+	query.Querier
+	sagas map[string]query.SaleOrderSaga
+}
+
+func (q *sagaQueries) ClaimSaleOrderSaga(ctx context.Context, arg *query.ClaimSaleOrderSagaParams) (query.SaleOrderSaga, error) {
+	saga, ok := q.sagas[arg.SagaKey]
+	if !ok {
+		saga = query.SaleOrderSaga{SagaKey: arg.SagaKey, Status: arg.Status, Reference: arg.Holder}
+	} else if saga.Holder != "" && !saga.ClaimExpiresTime.Before(arg.Now) {
+		return query.SaleOrderSaga{}, sql.ErrNoRows
+	}
+	saga.Holder, saga.ClaimExpiresTime = arg.Holder, arg.ClaimExpiresTime
+	q.sagas[arg.SagaKey] = saga
+	return saga, nil
+}
+
+func (q *sagaQueries) SaveSaleOrderSaga(ctx context.Context, arg *query.SaveSaleOrderSagaParams) (int64, error) {
+	saga, ok := q.sagas[arg.SagaKey]
+	if !ok || saga.Holder != arg.Holder {
+		return 0, nil
+	}
+	saga.Status, saga.Step, saga.OrderID, saga.LineIds, saga.LastError = arg.Status, arg.Step, arg.OrderID, arg.LineIds, arg.LastError
+	saga.ClaimExpiresTime = arg.ClaimExpiresTime
+	q.sagas[arg.SagaKey] = saga
+	return 1, nil
+}
+
+func (q *sagaQueries) ReleaseSaleOrderSaga(ctx context.Context, arg *query.ReleaseSaleOrderSagaParams) error {
+	if saga, ok := q.sagas[arg.SagaKey]; ok && saga.Holder == arg.Holder {
+		saga.Holder = ""
+		q.sagas[arg.SagaKey] = saga
+	}
+	return nil
+}
+
+func (q *sagaQueries) DeleteSaleOrderSaga(ctx context.Context, arg *query.DeleteSaleOrderSagaParams) error {
+	if saga, ok := q.sagas[arg.SagaKey]; ok && saga.Holder == arg.Holder {
+		delete(q.sagas, arg.SagaKey)
+	}
+	return nil
+}
+
+func sagaLines() ([]orderLine, []productIdResult) {
+	lines := []orderLine{
+		{ProductCode: "A11113", Qty: 1, Variants: variantSet{Color: "29"}},
+		{ProductCode: "A11114", Qty: 2, Variants: variantSet{Color: "30"}},
+	}
+	products := []productIdResult{
+		{ProductID: 104, UnitPrice: 33000000, UomID: 1, ProductName: "EV-V Sporty", PriceListID: 4},
+		{ProductID: 105, UnitPrice: 1500000, UomID: 1, ProductName: "Helmet", PriceListID: 4},
+	}
+	return lines, products
+}
+
+// orderIDsOf returns the order_id of every sale.order.line create call.
+func orderIDsOf(calls []odootest.Call) (ids []interface{}) {
+	for _, call := range calls {
+		values, _ := call.Args[0].([]interface{})
+		line, _ := values[0].(map[string]interface{})
+		ids = append(ids, line["order_id"])
+	}
+	return ids
+}
+
+func TestCreateSaleOrder(t *testing.T) {
+	r, srv := newTestRepository(t)
+	qry := &sagaQueries{sagas: map[string]query.SaleOrderSaga{}}
+	r.qry = qry
+	lines, products := sagaLines()
+
+	orderId, err := r.createSaleOrder(context.Background(), 7, 3, lines, products)
+	if err != nil {
+		t.Fatal(err)
+	}
+	creates := srv.CallsTo("sale.order", "create")
+	if len(creates) != 1 || orderId == 0 {
+		t.Fatalf("order %d from %d creates, want one order", orderId, len(creates))
+	}
+	values, _ := creates[0].Args[0].([]interface{})
+	order, _ := values[0].(map[string]interface{})
+	if _, ok := order["origin"]; ok || order["client_order_ref"] == "" {
+		t.Errorf("order = %v, want the saga reference in client_order_ref and no origin", order)
+	}
+	if got := orderIDsOf(srv.CallsTo("sale.order.line", "create")); !reflect.DeepEqual(got, []interface{}{orderId, orderId}) {
+		t.Errorf("lines created for orders %v, want two for %d", got, orderId)
+	}
+	if len(qry.sagas) != 0 {
+		t.Errorf("sagas = %v, want the completed saga removed", qry.sagas)
+	}
+}
+
+func TestCreateSaleOrderCompensates(t *testing.T) {
+	r, srv := newTestRepository(t)
+	qry := &sagaQueries{sagas: map[string]query.SaleOrderSaga{}}
+	r.qry = qry
+	lines, products := sagaLines()
+
+	lineCreates := 0
+	srv.Handle("sale.order.line", "create", func(call odootest.Call) (interface{}, error) {
+		lineCreates++
+		if lineCreates == 2 {
+			return nil, &odootest.Fault{Code: 2, String: "product unavailable"}
+		}
+		return []interface{}{51}, nil
+	})
+
+	if _, err := r.createSaleOrder(context.Background(), 7, 3, lines, products); err == nil {
+		t.Fatal("err = nil, want the line create error")
+	}
+
+	lineUnlinks := srv.CallsTo("sale.order.line", "unlink")
+	if len(lineUnlinks) != 1 || !reflect.DeepEqual(lineUnlinks[0].Args[0], []interface{}{51}) {
+		t.Errorf("line unlinks = %v, want line 51", lineUnlinks)
+	}
+	orderUnlinks := srv.CallsTo("sale.order", "unlink")
+	if len(orderUnlinks) != 1 {
+		t.Errorf("order unlinks = %v, want the created order", orderUnlinks)
+	}
+	if len(qry.sagas) != 0 {
+		t.Errorf("sagas = %v, want the compensated saga removed", qry.sagas)
+	}
+}
+
+func TestCreateSaleOrderResumes(t *testing.T) {
+	lines, products := sagaLines()
+	key := saleOrderSagaKey(7, 3, lines)
+	expired := time.Now().Add(-time.Minute)
+
+	t.Run("order created but not recorded", func(t *testing.T) {
+		r, srv := newTestRepository(t)
+		r.qry = &sagaQueries{sagas: map[string]query.SaleOrderSaga{
+			key: {SagaKey: key, Status: SagaRunning, Reference: "crashed-1", Holder: "crashed-1", ClaimExpiresTime: expired},
+		}}
+		srv.Handle("sale.order", "search", func(call odootest.Call) (interface{}, error) {
+			domain, _ := call.Args[0].([]interface{})
+			if leaf, _ := domain[0].([]interface{}); reflect.DeepEqual(leaf, []interface{}{"client_order_ref", "=", "crashed-1"}) {
+				return []interface{}{41}, nil
+			}
+			return []interface{}{}, nil
+		})
+
+		orderId, err := r.createSaleOrder(context.Background(), 7, 3, lines, products)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if creates := srv.CallsTo("sale.order", "create"); orderId != 41 || len(creates) != 0 {
+			t.Errorf("order %d after %d creates, want order 41 picked up", orderId, len(creates))
+		}
+		if got := orderIDsOf(srv.CallsTo("sale.order.line", "create")); !reflect.DeepEqual(got, []interface{}{41, 41}) {
+			t.Errorf("lines created for orders %v, want two for 41", got)
+		}
+	})
+
+	t.Run("lines partly created", func(t *testing.T) {
+		r, srv := newTestRepository(t)
+		r.qry = &sagaQueries{sagas: map[string]query.SaleOrderSaga{
+			key: {SagaKey: key, Status: SagaRunning, Step: 1, OrderID: 41, LineIds: []int32{51}, Reference: "crashed-2", Holder: "crashed-2", ClaimExpiresTime: expired},
+		}}
+
+		orderId, err := r.createSaleOrder(context.Background(), 7, 3, lines, products)
+		if err != nil {
+			t.Fatal(err)
+		}
+		lineCreates := srv.CallsTo("sale.order.line", "create")
+		if orderId != 41 || len(srv.CallsTo("sale.order", "create")) != 0 || len(lineCreates) != 1 {
+			t.Fatalf("order %d with %d line creates, want order 41 and the second line only", orderId, len(lineCreates))
+		}
+		values, _ := lineCreates[0].Args[0].([]interface{})
+		if line, _ := values[0].(map[string]interface{}); line["product_id"] != 105 {
+			t.Errorf("created line = %v, want product 105", line)
+		}
+	})
+
+	t.Run("claim held by a running attempt", func(t *testing.T) {
+		r, srv := newTestRepository(t)
+		r.qry = &sagaQueries{sagas: map[string]query.SaleOrderSaga{
+			key: {SagaKey: key, Status: SagaRunning, Reference: "running-1", Holder: "running-1", ClaimExpiresTime: time.Now().Add(time.Minute)},
+		}}
+
+		if _, err := r.createSaleOrder(context.Background(), 7, 3, lines, products); !errors.Is(err, ErrSagaInProgress) {
+			t.Errorf("err = %v, want ErrSagaInProgress", err)
+		}
+		if calls := srv.Calls(); len(calls) != 0 {
+			t.Errorf("calls = %v, want none", calls)
+		}
+	})
+}
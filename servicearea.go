@@ -0,0 +1,156 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+
+	log "github.com/sirupsen/logrus"
+	"zebrax.id/emi/integration/erp/connector/odoo/model"
+	"zebrax.id/emi/integration/erp/connector/odoo/repository/query"
+)
+
+var (
+	ErrOutsideServiceArea = errors.New("address is outside the service area")
+	ErrInvalidCoordinates = model.ErrInvalidCoordinates
+	ErrInvalidServiceArea = errors.New("invalid service area")
+)
+
+// ServiceArea is the area an experience center drives test vehicles to, either
+// a radius around the center or a polygon.
+type ServiceArea struct {
+	EcID     int32
+	Name     string
+	Center   model.GeoPoint
+	RadiusKm float64
+	Polygon  []model.GeoPoint
+}
+
+// Defined reports whether the area has a radius or a polygon of at least three
+// points. A row with neither is no area at all.
+func (a ServiceArea) Defined() bool {
+	return len(a.Polygon) >= 3 || a.RadiusKm > 0
+}
+
+// Contains reports whether p lies in the area.
+func (a ServiceArea) Contains(p model.GeoPoint) bool {
+	if len(a.Polygon) >= 3 {
+		return polygonContains(a.Polygon, p)
+	}
+	return a.RadiusKm > 0 && model.DistanceKm(a.Center, p) <= a.RadiusKm
+}
+
+// OutsideServiceAreaError rejects an on-wheels booking whose address the
+// chosen experience center does not serve.
+type OutsideServiceAreaError struct {
+	EcID   int32
+	EcName string
+	// Nearest is the closest center serving the address, if any.
+	Nearest    *ServiceArea
+	DistanceKm float64
+}
+
+func (e *OutsideServiceAreaError) Error() string {
+	message := fmt.Sprintf("%s of %s", ErrOutsideServiceArea.Error(), e.EcName)
+	if e.Nearest == nil {
+		return message + "; no experience center serves this address"
+	}
+	return fmt.Sprintf("%s; the nearest center serving it is %s (%.1f km)", message, e.Nearest.Name, e.DistanceKm)
+}
+
+func (e *OutsideServiceAreaError) Unwrap() error {
+	return ErrOutsideServiceArea
+}
+
+func serviceArea(row query.ExperienceCenterServiceArea) (area ServiceArea, err error) {
+	area = ServiceArea{
+		EcID:     row.EcID,
+		Name:     row.EcName,
+		Center:   model.GeoPoint{Lat: row.CenterLatitude, Lon: row.CenterLongitude},
+		RadiusKm: row.RadiusKm.Float64,
+	}
+	if row.Polygon.Valid {
+		var pairs [][2]float64
+		if err = json.Unmarshal(row.Polygon.RawMessage, &pairs); err != nil {
+			return area, fmt.Errorf("%w of %d: %s", ErrInvalidServiceArea, row.EcID, err.Error())
+		}
+		for _, pair := range pairs {
+			area.Polygon = append(area.Polygon, model.GeoPoint{Lat: pair[0], Lon: pair[1]})
+		}
+	}
+
+	return area, nil
+}
+
+// checkServiceArea rejects an on-wheels booking outside the service area of
+// its experience center. Centers without a service area, or whose row defines
+// neither a radius nor a polygon, accept any address; a center whose service
+// area cannot be read accepts none until it is fixed.
+func (r *repository) checkServiceArea(ctx context.Context, bookParams model.BookParams) error {
+	point, err := model.ParseGeoPoint(bookParams.Latitude, bookParams.Longitude)
+	if err != nil {
+		return err
+	}
+
+	rows, err := r.qry.ListServiceAreas(ctx)
+	if err != nil {
+		return err
+	}
+
+	var (
+		booked  *ServiceArea
+		nearest *ServiceArea
+		best    = math.Inf(1)
+	)
+	for _, row := range rows {
+		area, err := serviceArea(row)
+		if err != nil {
+			log.Info("[Odoo - Connector - ServiceArea] Error: ", err.Error())
+			if row.EcID == bookParams.EcID {
+				return err
+			}
+			continue
+		}
+		if !area.Defined() {
+			continue
+		}
+		if area.EcID == bookParams.EcID {
+			booked = &area
+			continue
+		}
+		if distance := model.DistanceKm(area.Center, point); area.Contains(point) && distance < best {
+			nearest, best = &area, distance
+		}
+	}
+
+	if booked == nil {
+		log.Info(fmt.Sprintf("[Odoo - Connector - ServiceArea] No service area for EC %d", bookParams.EcID))
+		return nil
+	}
+	if booked.Contains(point) {
+		return nil
+	}
+
+	return &OutsideServiceAreaError{
+		EcID:       booked.EcID,
+		EcName:     booked.Name,
+		Nearest:    nearest,
+		DistanceKm: best,
+	}
+}
+
+// polygonContains casts a ray from p and counts the edges it crosses. Service
+// areas are small enough to treat degrees as planar.
+func polygonContains(polygon []model.GeoPoint, p model.GeoPoint) bool {
+	inside := false
+	for i, j := 0, len(polygon)-1; i < len(polygon); j, i = i, i+1 {
+		a, b := polygon[i], polygon[j]
+		if (a.Lat > p.Lat) != (b.Lat > p.Lat) &&
+			p.Lon < (b.Lon-a.Lon)*(p.Lat-a.Lat)/(b.Lat-a.Lat)+a.Lon {
+			inside = !inside
+		}
+	}
+	return inside
+}
@@ -0,0 +1,18 @@
+-- Schema and sqlc queries for the experience center service areas used by
+-- test drive on wheels.
+
+CREATE TABLE IF NOT EXISTS experience_center_service_areas (
+    ec_id            INTEGER          PRIMARY KEY,
+    ec_name          VARCHAR(255)     NOT NULL,
+    center_latitude  DOUBLE PRECISION NOT NULL,
+    center_longitude DOUBLE PRECISION NOT NULL,
+    -- Either radius_km or polygon is set. polygon is a JSON array of
+    -- [latitude, longitude] pairs.
+    radius_km        DOUBLE PRECISION,
+    polygon          JSONB,
+    updated_time     TIMESTAMP        NOT NULL DEFAULT NOW()
+);
+
+-- name: ListServiceAreas :many
+SELECT * FROM experience_center_service_areas
+ORDER BY ec_id;
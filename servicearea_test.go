@@ -0,0 +1,183 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/tabbed/pqtype"
+	"zebrax.id/emi/integration/erp/connector/odoo/model"
+	"zebrax.id/emi/integration/erp/connector/odoo/repository/query"
+)
+
+type serviceAreaQueries struct {
+	query.Querier
+	rows []query.ExperienceCenterServiceArea
+}
+
+func (q *serviceAreaQueries) ListServiceAreas(ctx context.Context) ([]query.ExperienceCenterServiceArea, error) {
+	return q.rows, nil
+}
+
+func radiusArea(ecID int32, name string, center model.GeoPoint, radiusKm float64) query.ExperienceCenterServiceArea {
+	return query.ExperienceCenterServiceArea{
+		EcID:            ecID,
+		EcName:          name,
+		CenterLatitude:  center.Lat,
+		CenterLongitude: center.Lon,
+		RadiusKm:        sql.NullFloat64{Float64: radiusKm, Valid: true},
+	}
+}
+
+func polygonArea(ecID int32, name string, center model.GeoPoint, polygon [][2]float64) query.ExperienceCenterServiceArea {
+	raw, _ := json.Marshal(polygon)
+	return query.ExperienceCenterServiceArea{
+		EcID:            ecID,
+		EcName:          name,
+		CenterLatitude:  center.Lat,
+		CenterLongitude: center.Lon,
+		Polygon:         pqtype.NullRawMessage{RawMessage: raw, Valid: true},
+	}
+}
+
+var (
+	bintaro = model.GeoPoint{Lat: -6.27466, Lon: 106.72046}
+	monas   = model.GeoPoint{Lat: -6.1754, Lon: 106.8272}
+	bogor   = model.GeoPoint{Lat: -6.5950, Lon: 106.8166}
+)
+
+// centralJakarta is a square of about 11 km around Monas.
+var centralJakarta = [][2]float64{{-6.13, 106.78}, {-6.13, 106.88}, {-6.23, 106.88}, {-6.23, 106.78}}
+
+func TestCheckServiceArea(t *testing.T) {
+	tests := []struct {
+		name    string
+		rows    []query.ExperienceCenterServiceArea
+		address model.GeoPoint
+		// message is part of the error; empty when the address is served.
+		message string
+		err     error
+	}{
+		{
+			name:    "inside the radius",
+			rows:    []query.ExperienceCenterServiceArea{radiusArea(1, "Indy Bintaro", bintaro, 20)},
+			address: monas,
+		},
+		{
+			name:    "inside the polygon",
+			rows:    []query.ExperienceCenterServiceArea{polygonArea(1, "Indy Menteng", monas, centralJakarta)},
+			address: monas,
+		},
+		{
+			name: "outside with a nearer center",
+			rows: []query.ExperienceCenterServiceArea{
+				radiusArea(1, "Indy Bintaro", bintaro, 5),
+				polygonArea(2, "Indy Menteng", monas, centralJakarta),
+				radiusArea(3, "Indy Kemang", model.GeoPoint{Lat: -6.26, Lon: 106.81}, 15),
+			},
+			address: monas,
+			message: "outside the service area of Indy Bintaro; the nearest center serving it is Indy Menteng (0.0 km)",
+			err:     ErrOutsideServiceArea,
+		},
+		{
+			name:    "outside of every center",
+			rows:    []query.ExperienceCenterServiceArea{radiusArea(1, "Indy Bintaro", bintaro, 5), radiusArea(2, "Indy Menteng", monas, 5)},
+			address: bogor,
+			message: "outside the service area of Indy Bintaro; no experience center serves this address",
+			err:     ErrOutsideServiceArea,
+		},
+		{
+			name:    "center without a row",
+			rows:    []query.ExperienceCenterServiceArea{radiusArea(2, "Indy Menteng", monas, 5)},
+			address: bogor,
+		},
+		{
+			name:    "row without radius or polygon",
+			rows:    []query.ExperienceCenterServiceArea{{EcID: 1, EcName: "Indy Bintaro", CenterLatitude: bintaro.Lat, CenterLongitude: bintaro.Lon}},
+			address: bogor,
+		},
+		{
+			name:    "polygon of two points",
+			rows:    []query.ExperienceCenterServiceArea{polygonArea(1, "Indy Bintaro", bintaro, centralJakarta[:2])},
+			address: bogor,
+		},
+		{
+			name: "unreadable polygon",
+			rows: []query.ExperienceCenterServiceArea{{
+				EcID:    1,
+				EcName:  "Indy Bintaro",
+				Polygon: pqtype.NullRawMessage{RawMessage: json.RawMessage(`{"lat": -6.2}`), Valid: true},
+			}},
+			address: monas,
+			err:     ErrInvalidServiceArea,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &repository{qry: &serviceAreaQueries{rows: tt.rows}}
+			err := r.checkServiceArea(context.Background(), model.BookParams{
+				EcID:      1,
+				Latitude:  strconv.FormatFloat(tt.address.Lat, 'f', -1, 64),
+				Longitude: strconv.FormatFloat(tt.address.Lon, 'f', -1, 64),
+			})
+			if tt.err == nil {
+				if err != nil {
+					t.Fatalf("err = %v, want nil", err)
+				}
+				return
+			}
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("err = %v, want %v", err, tt.err)
+			}
+			if !strings.Contains(err.Error(), tt.message) {
+				t.Errorf("message = %q, want it to contain %q", err.Error(), tt.message)
+			}
+		})
+	}
+}
+
+func TestCheckServiceAreaCoordinates(t *testing.T) {
+	r := &repository{qry: &serviceAreaQueries{}}
+	err := r.checkServiceArea(context.Background(), model.BookParams{EcID: 1, Latitude: "-95", Longitude: "106.8"})
+	if !errors.Is(err, ErrInvalidCoordinates) {
+		t.Errorf("err = %v, want ErrInvalidCoordinates", err)
+	}
+}
+
+func TestPolygonContains(t *testing.T) {
+	points := func(pairs [][2]float64) (polygon []model.GeoPoint) {
+		for _, pair := range pairs {
+			polygon = append(polygon, model.GeoPoint{Lat: pair[0], Lon: pair[1]})
+		}
+		return polygon
+	}
+	square := points(centralJakarta)
+	// A U open to the north: the notch between its arms is outside.
+	u := points([][2]float64{{0, 0}, {0, 3}, {3, 3}, {3, 2}, {1, 2}, {1, 1}, {3, 1}, {3, 0}})
+
+	tests := []struct {
+		name    string
+		polygon []model.GeoPoint
+		point   model.GeoPoint
+		want    bool
+	}{
+		{name: "inside the square", polygon: square, point: monas, want: true},
+		{name: "east of the square", polygon: square, point: model.GeoPoint{Lat: -6.18, Lon: 106.95}},
+		{name: "south of the square", polygon: square, point: bogor},
+		{name: "in an arm of the U", polygon: u, point: model.GeoPoint{Lat: 2, Lon: 0.5}, want: true},
+		{name: "in the base of the U", polygon: u, point: model.GeoPoint{Lat: 0.5, Lon: 1.5}, want: true},
+		{name: "in the notch of the U", polygon: u, point: model.GeoPoint{Lat: 2, Lon: 1.5}},
+		{name: "level with a vertex", polygon: u, point: model.GeoPoint{Lat: 1, Lon: -1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := polygonContains(tt.polygon, tt.point); got != tt.want {
+				t.Errorf("contains = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,96 @@
+package repository
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// SlotDisableJob is the lease name of the job that disables past slots.
+const SlotDisableJob = "testdrive.slot_disable"
+
+// SlotJobConfig controls the job that disables past test drive slots.
+type SlotJobConfig struct {
+	// Interval is how often the job runs.
+	Interval time.Duration
+	// LeaseTTL is how long an instance stays leader without renewing; another
+	// instance takes over once it passes.
+	LeaseTTL time.Duration
+}
+
+var DefaultSlotJobConfig = SlotJobConfig{
+	Interval: 5 * time.Minute,
+	LeaseTTL: 15 * time.Minute,
+}
+
+// slotJobMetrics is published on /debug/vars as testdrive_slot_disable.
+var slotJobMetrics = expvar.NewMap("testdrive_slot_disable")
+
+// RunSlotDisableJob disables past test drive slots until ctx is done. Every
+// instance may run it; only the one holding the lease does the work.
+func (r *repository) RunSlotDisableJob(ctx context.Context, config SlotJobConfig) {
+	log.Info("[Odoo - Connector - SlotDisableJob] Start")
+	defer log.Info("[Odoo - Connector - SlotDisableJob] End")
+
+	ticker := time.NewTicker(config.Interval)
+	defer ticker.Stop()
+
+	leader := false
+	defer func() {
+		if leader {
+			r.releaseLease(context.Background(), SlotDisableJob)
+		}
+	}()
+
+	for {
+		acquired, err := r.acquireLease(ctx, SlotDisableJob, config.LeaseTTL)
+		if err != nil {
+			log.Error("[Odoo - Connector - SlotDisableJob] Lease Error: ", err)
+		}
+		if acquired != leader {
+			log.Info(fmt.Sprintf("[Odoo - Connector - SlotDisableJob] Leader: %t", acquired))
+		}
+		leader = acquired
+
+		if leader {
+			r.disablePastSlots(ctx)
+		} else {
+			slotJobMetrics.Add("skipped", 1)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// disablePastSlots runs SetSlotTimeDisable, declared :execrows, and records
+// how many slots it disabled.
+func (r *repository) disablePastSlots(ctx context.Context) {
+	started := time.Now()
+	disabled, err := r.qry.SetSlotTimeDisable(ctx)
+
+	slotJobMetrics.Add("runs", 1)
+	slotJobMetrics.Set("last_run_unix", intVar(started.Unix()))
+	slotJobMetrics.Set("last_duration_ms", intVar(time.Since(started).Milliseconds()))
+	if err != nil {
+		slotJobMetrics.Add("failures", 1)
+		log.Error("[Odoo - Connector - SlotDisableJob] Disable Slot Error: ", err)
+		return
+	}
+
+	slotJobMetrics.Add("disabled_total", disabled)
+	slotJobMetrics.Set("last_disabled", intVar(disabled))
+	log.Info(fmt.Sprintf("[Odoo - Connector - SlotDisableJob] Disabled %d slots in %s", disabled, time.Since(started)))
+}
+
+func intVar(value int64) *expvar.Int {
+	v := new(expvar.Int)
+	v.Set(value)
+	return v
+}
@@ -0,0 +1,104 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"zebrax.id/emi/integration/erp/connector/odoo/model"
+	"zebrax.id/emi/integration/erp/connector/odoo/repository/query"
+)
+
+// slotQueries returns the same slots for every day and appointment type, the
+// way GetSlotTime returns slots the job has not disabled yet.
+type slotQueries struct {
+	query.Querier
+	slots    []query.GetSlotTimeRow
+	disabled int64
+	err      error
+}
+
+func (q *slotQueries) GetSlotTime(ctx context.Context, arg *query.GetSlotTimeParams) ([]query.GetSlotTimeRow, error) {
+	return q.slots, nil
+}
+
+func (q *slotQueries) GetSlotTimeOnwheels(ctx context.Context, arg *query.GetSlotTimeOnwheelsParams) ([]query.GetSlotTimeOnwheelsRow, error) {
+	return q.slots, nil
+}
+
+func (q *slotQueries) ListActiveTestDriveHolds(ctx context.Context, arg *query.ListActiveTestDriveHoldsParams) ([]query.ListActiveTestDriveHoldsRow, error) {
+	return nil, nil
+}
+
+func (q *slotQueries) SetSlotTimeDisable(ctx context.Context) (int64, error) {
+	return q.disabled, q.err
+}
+
+func TestGetTestDriveTimeSlotSkipsStartedSlots(t *testing.T) {
+	now := time.Now().In(model.WIB)
+	slot := func(start time.Time) query.GetSlotTimeRow {
+		return query.GetSlotTimeRow{
+			Combination:  "1-104-" + start.Format("2006-01-02"),
+			EcID:         1,
+			ProductID:    104,
+			BookingDate:  start.Format("2006-01-02"),
+			Stime:        start.Format("15:04"),
+			StartTimeIso: start.Format(time.RFC3339),
+			Jml:          2,
+		}
+	}
+	started, ahead := now.Add(-30*time.Minute), now.Add(30*time.Minute)
+	qry := &slotQueries{slots: []query.GetSlotTimeRow{slot(started), slot(ahead), {Combination: "1-104-unknown", StartTimeIso: "11:00", Jml: 1}}}
+	r := &repository{qry: qry}
+
+	for _, appointmentTypeId := range []int32{1, 2} {
+		list, err := r.GetTestDriveTimeSlot(context.Background(), "104", 1, now.Format("2006-01-02"), now.Format("2006-01-02"), appointmentTypeId)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var starts []string
+		for _, day := range list {
+			for _, slot := range day.TimeSlots {
+				starts = append(starts, slot.IsoStartTime)
+			}
+		}
+		if len(starts) != 2 {
+			t.Errorf("appointment type %d: slots = %v, want %s and the unreadable one", appointmentTypeId, starts, ahead.Format(time.RFC3339))
+		}
+		for _, start := range starts {
+			if start == started.Format(time.RFC3339) {
+				t.Errorf("appointment type %d: started slot %s listed", appointmentTypeId, start)
+			}
+		}
+	}
+}
+
+func TestDisablePastSlotsMetrics(t *testing.T) {
+	r := &repository{qry: &slotQueries{disabled: 3}}
+	total := func() int64 {
+		if v, ok := slotJobMetrics.Get("disabled_total").(interface{ Value() int64 }); ok {
+			return v.Value()
+		}
+		return 0
+	}
+	before := total()
+
+	r.disablePastSlots(context.Background())
+	r.disablePastSlots(context.Background())
+	if got := total() - before; got != 6 {
+		t.Errorf("disabled_total grew by %d, want 6", got)
+	}
+	if got := slotJobMetrics.Get("last_disabled").String(); got != "3" {
+		t.Errorf("last_disabled = %s, want 3", got)
+	}
+
+	r.qry = &slotQueries{err: errors.New("connection refused")}
+	r.disablePastSlots(context.Background())
+	if got := total() - before; got != 6 {
+		t.Errorf("disabled_total grew by %d after a failure, want 6", got)
+	}
+	if got := slotJobMetrics.Get("last_disabled").String(); got != "3" {
+		t.Errorf("last_disabled = %s after a failure, want 3", got)
+	}
+}
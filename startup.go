@@ -0,0 +1,85 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"zebrax.id/emi/integration/erp/connector/odoo/model"
+)
+
+// StartConfig is the part of the connector config read once at startup.
+type StartConfig struct {
+	// Attributes maps Odoo attribute IDs to variant slots in the form
+	// "4:mirror,5:wheel,10:color,11:battery". Empty keeps
+	// DefaultAttributeRegistry.
+	Attributes string
+	// Holidays lists public holidays as "2006-01-02" dates, separated by
+	// commas, on top of the global leaves of the Odoo working calendars.
+	Holidays string
+}
+
+// Start loads the registries the repository reads at startup. It is called
+// once, before the connector serves requests; ctx bounds the Odoo calls.
+func (r *repository) Start(ctx context.Context, config StartConfig) error {
+	log.Info("[Odoo - Connector - Start] Start")
+	defer log.Info("[Odoo - Connector - Start] End")
+
+	registry := DefaultAttributeRegistry
+	if config.Attributes != "" {
+		parsed, err := ParseAttributeRegistry(config.Attributes)
+		if err != nil {
+			return err
+		}
+		registry = parsed
+	}
+
+	loaded, err := r.LoadAttributeRegistry(ctx, registry)
+	switch {
+	case errors.Is(err, ErrUnknownAttribute):
+		return err
+	case err != nil:
+		// Odoo is unreachable; the configured IDs still hold.
+		log.Error("[Odoo - Connector - Start] Attribute Registry Error: ", err)
+	default:
+		registry = loaded
+	}
+	SetAttributeRegistry(registry)
+
+	configured := strings.FieldsFunc(config.Holidays, func(r rune) bool { return r == ',' || r == ' ' })
+	holidays, err := r.LoadHolidayCalendar(ctx, configured)
+	if err != nil {
+		log.Error("[Odoo - Connector - Start] Holiday Calendar Error: ", err)
+	}
+	model.SetHolidayCalendar(holidays)
+
+	return nil
+}
+
+// JobsConfig configures the background jobs started by StartJobs.
+type JobsConfig struct {
+	StockReservation StockReservationJobConfig
+	Reminder         ReminderConfig
+	SlotDisable      SlotJobConfig
+	Waitlist         WaitlistConfig
+}
+
+var DefaultJobsConfig = JobsConfig{
+	StockReservation: DefaultStockReservationJobConfig,
+	Reminder:         DefaultReminderConfig,
+	SlotDisable:      DefaultSlotJobConfig,
+	Waitlist:         DefaultWaitlistConfig,
+}
+
+// StartJobs starts the background jobs of the repository and returns; they
+// run until ctx is done. Every instance may start them; the jobs share the
+// work between instances through leases and claims.
+func (r *repository) StartJobs(ctx context.Context, config JobsConfig) {
+	log.Info("[Odoo - Connector - StartJobs] Start")
+
+	go r.RunStockReservationExpiry(ctx, config.StockReservation)
+	go r.RunTestDriveReminders(ctx, config.Reminder)
+	go r.RunSlotDisableJob(ctx, config.SlotDisable)
+	go r.RunTestDriveWaitlist(ctx, config.Waitlist)
+}
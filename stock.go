@@ -0,0 +1,29 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+)
+
+var ErrInsufficientStock = errors.New("insufficient stock")
+
+// Stock reservation statuses besides held.
+const (
+	ReservationReleased = "released"
+	ReservationConsumed = "consumed"
+)
+
+// StockShortageError names the order line a dealer cannot reserve.
+type StockShortageError struct {
+	ProductCode string
+	Requested   int
+	Available   int
+}
+
+func (e *StockShortageError) Error() string {
+	return fmt.Sprintf("%s of %s: %d requested, %d available", ErrInsufficientStock.Error(), e.ProductCode, e.Requested, e.Available)
+}
+
+func (e *StockShortageError) Unwrap() error {
+	return ErrInsufficientStock
+}
@@ -0,0 +1,122 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	log "github.com/sirupsen/logrus"
+	"zebrax.id/emi/integration/core/proto"
+	odooConnectorModel "zebrax.id/emi/integration/erp/connector/odoo/model"
+)
+
+// EvAvailablePage is one page of the locations where EVs can be test driven.
+type EvAvailablePage struct {
+	Locations []odooConnectorModel.EvAvailable
+	// NextPageToken is passed as page_token for the next page; empty on the
+	// last page.
+	NextPageToken string
+}
+
+// SlotTimePage is one page of test drive slot days.
+type SlotTimePage struct {
+	Days          []odooConnectorModel.SlotTimeResponses
+	NextPageToken string
+	// Schedule is the operating schedule of the experience center; nil when
+	// its hours are unknown.
+	Schedule *odooConnectorModel.OperatingSchedule
+}
+
+// TestDriveBooking is a booking with the structured operating schedule of its
+// experience center; Schedule is nil when the hours cannot be read.
+type TestDriveBooking struct {
+	odooConnectorModel.BookingTestDriveResponse
+	Schedule *odooConnectorModel.OperatingSchedule
+}
+
+// pageError turns an invalid page token into a bad request.
+func pageError(err error) error {
+	if errors.Is(err, odooConnectorModel.ErrInvalidPageToken) {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	return err
+}
+
+// EvAvailable returns one page of the locations with EVs to test drive.
+func (r *useCase) EvAvailable(ctx context.Context, in *proto.EvAvailableParams) (result EvAvailablePage, err error) {
+	defer log.Info("[EvAvailable] End")
+	log.Info("[EvAvailable] Start")
+
+	list, err := r.oRepo.GetEvAvailable(ctx)
+	if err != nil {
+		log.Info("[EvAvailable] Error : ", err.Error())
+		return result, err
+	}
+
+	result.Locations, result.NextPageToken, err = odooConnectorModel.PageEvAvailable(list, in.PageSize, in.PageToken)
+	if err != nil {
+		return EvAvailablePage{}, pageError(err)
+	}
+	return result, nil
+}
+
+// TestDriveTimeSlot returns one page of the slot days of a product at an
+// experience center.
+func (r *useCase) TestDriveTimeSlot(ctx context.Context, in *proto.TestDriveTimeSlotParams) (result SlotTimePage, err error) {
+	defer log.Info("[TestDriveTimeSlot] End")
+	log.Info("[TestDriveTimeSlot] Start")
+
+	if in.ProductID == "" || in.EcID == 0 || in.AppointmentTypeID == 0 || in.StartDate == "" || in.EndDate == "" {
+		return result, echo.NewHTTPError(http.StatusBadRequest, "product_id, ec_id, start_date, end_date and appointment_type_id are required")
+	}
+
+	list, err := r.oRepo.GetTestDriveTimeSlot(ctx, in.ProductID, in.EcID, in.StartDate, in.EndDate, in.AppointmentTypeID)
+	if err != nil {
+		log.Info("[TestDriveTimeSlot] Error : ", err.Error())
+		return result, err
+	}
+
+	result.Days, result.NextPageToken, err = odooConnectorModel.PageSlotTimes(list, in.PageSize, in.PageToken)
+	if err != nil {
+		return SlotTimePage{}, pageError(err)
+	}
+
+	schedule, ok, err := r.oRepo.GetExperienceCenterSchedule(ctx, in.EcID, time.Now())
+	if err != nil {
+		// The slots are still usable without the schedule.
+		log.Info("[TestDriveTimeSlot] Schedule Error : ", err.Error())
+	} else if ok {
+		result.Schedule = &schedule
+	}
+	return result, nil
+}
+
+// TestDriveBookings returns the test drive bookings of the signed-in customer.
+func (r *useCase) TestDriveBookings(ctx echo.Context) (result []TestDriveBooking, err error) {
+	defer log.Info("[TestDriveBookings] End")
+	log.Info("[TestDriveBookings] Start")
+
+	uid, ok := authenticatedCustomer(ctx)
+	if !ok {
+		return nil, echo.NewHTTPError(http.StatusUnauthorized, "sign in to list bookings")
+	}
+
+	list, err := r.oRepo.GetTestDriveListByUid(ctx.Request().Context(), uid)
+	if err != nil {
+		log.Info("[TestDriveBookings] Error : ", err.Error())
+		return nil, err
+	}
+
+	now := time.Now()
+	result = make([]TestDriveBooking, 0, len(list))
+	for _, booking := range list {
+		each := TestDriveBooking{BookingTestDriveResponse: booking}
+		if schedule, ok := booking.OperatingSchedule(now); ok {
+			each.Schedule = &schedule
+		}
+		result = append(result, each)
+	}
+	return result, nil
+}
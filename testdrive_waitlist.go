@@ -0,0 +1,80 @@
+package usecase
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+	log "github.com/sirupsen/logrus"
+	utils "zebrax.id/emi/integration/core/utils"
+	odooConnectorModel "zebrax.id/emi/integration/erp/connector/odoo/model"
+)
+
+// JoinTestDriveWaitlist puts the signed-in customer on the waitlist of the
+// slot named by the product_id, ec_id, appointment_type_id, slot_date and
+// slot_start_time query parameters.
+func (r *useCase) JoinTestDriveWaitlist(ctx echo.Context) (entry odooConnectorModel.WaitlistEntry, err error) {
+	defer log.Info("[JoinTestDriveWaitlist] End")
+	log.Info("[JoinTestDriveWaitlist] Start")
+
+	uid, ok := authenticatedCustomer(ctx)
+	if !ok {
+		return entry, echo.NewHTTPError(http.StatusUnauthorized, "sign in to join a waitlist")
+	}
+
+	productID, errProduct := utils.StringToInt32(ctx.QueryParam("product_id"))
+	ecID, errEc := utils.StringToInt32(ctx.QueryParam("ec_id"))
+	appointmentTypeID, errType := utils.StringToInt32(ctx.QueryParam("appointment_type_id"))
+	params := odooConnectorModel.WaitlistParams{
+		UID:               uid,
+		ProductID:         productID,
+		EcID:              ecID,
+		AppointmentTypeID: appointmentTypeID,
+		SlotDate:          ctx.QueryParam("slot_date"),
+		SlotStartTime:     ctx.QueryParam("slot_start_time"),
+	}
+	if errProduct != nil || errEc != nil || errType != nil || params.SlotDate == "" || params.SlotStartTime == "" {
+		return entry, echo.NewHTTPError(http.StatusBadRequest, "product_id, ec_id, appointment_type_id, slot_date and slot_start_time are required")
+	}
+
+	entry, err = r.oRepo.JoinTestDriveWaitlist(ctx.Request().Context(), params)
+	switch {
+	case errors.Is(err, odooConnectorModel.ErrAlreadyWaitlisted), errors.Is(err, odooConnectorModel.ErrSlotNotFull):
+		return entry, echo.NewHTTPError(http.StatusConflict, err.Error())
+	case errors.Is(err, odooConnectorModel.ErrSlotInPast):
+		return entry, echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	case err != nil:
+		log.Info("[JoinTestDriveWaitlist] Error : ", err.Error())
+		return entry, err
+	}
+
+	return entry, nil
+}
+
+// LeaveTestDriveWaitlist takes the signed-in customer off the waitlist entry
+// named by the id path parameter.
+func (r *useCase) LeaveTestDriveWaitlist(ctx echo.Context) (err error) {
+	defer log.Info("[LeaveTestDriveWaitlist] End")
+	log.Info("[LeaveTestDriveWaitlist] Start")
+
+	uid, ok := authenticatedCustomer(ctx)
+	if !ok {
+		return echo.NewHTTPError(http.StatusUnauthorized, "sign in to leave a waitlist")
+	}
+	id, err := strconv.ParseInt(ctx.Param("id"), 10, 64)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "id is required")
+	}
+
+	err = r.oRepo.LeaveTestDriveWaitlist(ctx.Request().Context(), id, uid)
+	if errors.Is(err, odooConnectorModel.ErrWaitlistEntryNotFound) {
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	}
+	if err != nil {
+		log.Info("[LeaveTestDriveWaitlist] Error : ", err.Error())
+		return err
+	}
+
+	return nil
+}
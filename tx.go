@@ -0,0 +1,25 @@
+package repository
+
+import (
+	"context"
+	"errors"
+
+	"zebrax.id/emi/integration/erp/connector/odoo/repository/query"
+)
+
+// execTx runs fn with queries bound to one transaction, committing when fn
+// returns nil and rolling back otherwise.
+func (r *repository) execTx(ctx context.Context, fn func(q *query.Queries) error) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	if err = fn(query.New(tx)); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return errors.New(err.Error() + "; rollback: " + rollbackErr.Error())
+		}
+		return err
+	}
+	return tx.Commit()
+}
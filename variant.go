@@ -0,0 +1,28 @@
+package model
+
+// VariantCombination is one cell of the variant matrix of a product template:
+// a value for every attribute, with its price and stock at the dealer.
+type VariantCombination struct {
+	Attributes []Attribute
+	Price      Money
+	// PriceDelta is Price less the minimum price of the template.
+	PriceDelta Money
+	Stock      int
+	// Available is false when the dealer does not sell the combination.
+	Available bool
+	// StockUnknown is set when the stock of the combination could not be
+	// read; Price is then the minimum price of the template.
+	StockUnknown bool
+}
+
+// ProductTemplateMatrix is a product template with its full variant matrix.
+type ProductTemplateMatrix struct {
+	ProductTemplate
+	MinPrice   Money
+	BookingFee Money
+	Variants   []VariantCombination
+	// Combinations is the size of the full matrix; Truncated is set when
+	// Variants holds only the first MaxVariantCombinations of them.
+	Combinations int
+	Truncated    bool
+}
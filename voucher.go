@@ -0,0 +1,755 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	utils "zebrax.id/emi/integration/core/utils"
+	"zebrax.id/emi/integration/erp/connector/odoo/model"
+	"zebrax.id/emi/integration/erp/connector/odoo/repository/query"
+)
+
+// VoucherStackingConfig holds the stacking rules that apply to every voucher.
+// Rules of coupon programs are kept in voucher_stacking_rules.
+type VoucherStackingConfig struct {
+	// MaxVouchers caps how many vouchers apply to one order.
+	MaxVouchers int
+}
+
+var DefaultVoucherStackingConfig = VoucherStackingConfig{
+	MaxVouchers: 3,
+}
+
+var (
+	voucherStackingMu     sync.RWMutex
+	voucherStackingConfig = DefaultVoucherStackingConfig
+)
+
+// SetVoucherStacking replaces the stacking rules that apply to every voucher.
+func SetVoucherStacking(config VoucherStackingConfig) {
+	voucherStackingMu.Lock()
+	defer voucherStackingMu.Unlock()
+	voucherStackingConfig = config
+}
+
+func currentVoucherStacking() VoucherStackingConfig {
+	voucherStackingMu.RLock()
+	defer voucherStackingMu.RUnlock()
+	return voucherStackingConfig
+}
+
+// SetVoucherRedeems applies the vouchers to the sales order on top of the
+// vouchers already applied. The stacking rules are checked against all of
+// them before Odoo is asked to apply any.
+func (r *repository) SetVoucherRedeems(ctx context.Context, salesOrderId int32, voucherIds []int32) (list model.OrderConfirmationResponses, err error) {
+	defer log.Info("[Odoo - Connector - SetVoucherRedeems] End")
+	log.Info("[Odoo - Connector - SetVoucherRedeems] Start")
+
+	if salesOrderId == 0 || len(voucherIds) == 0 {
+		return list, nil
+	}
+
+	applied, err := r.GetAppliedVouchers(ctx, salesOrderId)
+	if err != nil {
+		return list, err
+	}
+	stack := append([]model.AppliedVoucher{}, applied...)
+	pending := []model.AppliedVoucher{}
+	redeemed := []model.AppliedVoucher{}
+	for _, voucherId := range voucherIds {
+		if voucherId == 0 || appliedVoucher(stack, voucherId) {
+			continue
+		}
+
+		voucher, err := r.voucherCode(ctx, salesOrderId, voucherId)
+		if err != nil {
+			return list, err
+		}
+		if !voucher.ok() {
+			return list, fmt.Errorf("%w: %d", model.ErrVoucherUnavailable, voucherId)
+		}
+
+		entry := model.AppliedVoucher{VoucherID: voucherId, VoucherCode: voucher.VoucherCode}
+		stack = append(stack, entry)
+		if voucher.Redeemed {
+			redeemed = append(redeemed, entry)
+		} else {
+			pending = append(pending, entry)
+		}
+	}
+
+	if err = r.checkVoucherStacking(ctx, stack); err != nil {
+		log.Info("[Odoo - Connector - SetVoucherRedeems] Stacking ", err.Error())
+		return list, err
+	}
+
+	// Vouchers Odoo already applied are only missing from
+	// sales_order_vouchers.
+	for _, voucher := range redeemed {
+		if err = r.recordVoucher(ctx, salesOrderId, voucher); err != nil {
+			return list, err
+		}
+	}
+	for i, voucher := range pending {
+		if err = r.redeemVoucher(ctx, salesOrderId, voucher.VoucherCode); err != nil {
+			r.compensateVouchers(ctx, salesOrderId, pending[:i])
+			return list, err
+		}
+		if err = r.recordVoucher(ctx, salesOrderId, voucher); err != nil {
+			r.compensateVouchers(ctx, salesOrderId, pending[:i+1])
+			return list, err
+		}
+	}
+
+	return r.salesOrderDetail(ctx, salesOrderId), nil
+}
+
+// compensateVouchers takes the vouchers a failed SetVoucherRedeems applied
+// back off the sales order, last first, so the order keeps the vouchers it had
+// before. Failures are logged; the original error is what the caller sees.
+func (r *repository) compensateVouchers(ctx context.Context, salesOrderId int32, vouchers []model.AppliedVoucher) {
+	for i := len(vouchers) - 1; i >= 0; i-- {
+		voucher := vouchers[i]
+		if err := r.releaseVoucher(ctx, salesOrderId, voucher.VoucherCode); err != nil {
+			log.Error(fmt.Sprintf("[Odoo - Connector - SetVoucherRedeems] Compensate voucher %d of sale order %d Error: ", voucher.VoucherID, salesOrderId), err)
+			continue
+		}
+		err := r.qry.RemoveSalesOrderVoucher(ctx, &query.RemoveSalesOrderVoucherParams{
+			SalesOrderID: salesOrderId,
+			VoucherID:    voucher.VoucherID,
+		})
+		if err != nil {
+			log.Error(fmt.Sprintf("[Odoo - Connector - SetVoucherRedeems] Compensate voucher %d of sale order %d Error: ", voucher.VoucherID, salesOrderId), err)
+		}
+	}
+}
+
+// RemoveVoucher takes an applied voucher off the sales order: the coupon is
+// released for reuse and Odoo recomputes the coupon lines.
+func (r *repository) RemoveVoucher(ctx context.Context, salesOrderId int32, voucherId int32) (list model.OrderConfirmationResponses, err error) {
+	defer log.Info("[Odoo - Connector - RemoveVoucher] End")
+	log.Info("[Odoo - Connector - RemoveVoucher] Start")
+
+	applied, err := r.GetAppliedVouchers(ctx, salesOrderId)
+	if err != nil {
+		return list, err
+	}
+	var voucher *model.AppliedVoucher
+	for i := range applied {
+		if applied[i].VoucherID == voucherId {
+			voucher = &applied[i]
+		}
+	}
+	if voucher == nil {
+		return list, fmt.Errorf("%w: %d", model.ErrVoucherNotApplied, voucherId)
+	}
+
+	if err = r.releaseVoucher(ctx, salesOrderId, voucher.VoucherCode); err != nil {
+		return list, err
+	}
+
+	err = r.qry.RemoveSalesOrderVoucher(ctx, &query.RemoveSalesOrderVoucherParams{
+		SalesOrderID: salesOrderId,
+		VoucherID:    voucherId,
+	})
+	if err != nil {
+		return list, err
+	}
+
+	return r.salesOrderDetail(ctx, salesOrderId), nil
+}
+
+// RevalidateVouchers checks the vouchers applied to the sales order of
+// purchaseParams again, after its lines changed, and removes the ones that no
+// longer apply. It returns the removed vouchers with the reason.
+func (r *repository) RevalidateVouchers(ctx context.Context, purchaseParams model.PurchaseParams) (dropped []model.VoucherEligibility, err error) {
+	defer log.Info("[Odoo - Connector - RevalidateVouchers] End")
+	log.Info("[Odoo - Connector - RevalidateVouchers] Start")
+
+	salesOrderId, _ := utils.StringToInt32(purchaseParams.SalesOrderID)
+	applied, err := r.GetAppliedVouchers(ctx, salesOrderId)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, voucher := range applied {
+		eligibility, err := r.voucherEligibility(ctx, purchaseParams, voucher.VoucherID, true)
+		if err != nil {
+			return dropped, err
+		}
+		if eligibility.Eligible {
+			continue
+		}
+		if eligibility.VoucherCode == "" {
+			eligibility.VoucherCode = voucher.VoucherCode
+		}
+		log.Info(fmt.Sprintf("[Odoo - Connector - RevalidateVouchers] Drop voucher %d of sale order %d: %s", voucher.VoucherID, salesOrderId, eligibility.Reason))
+
+		// Odoo already took off the coupons it no longer applies.
+		if eligibility.Reason != model.VoucherNoLongerApplies {
+			if err = r.releaseVoucher(ctx, salesOrderId, voucher.VoucherCode); err != nil {
+				return dropped, err
+			}
+		}
+		err = r.qry.RemoveSalesOrderVoucher(ctx, &query.RemoveSalesOrderVoucherParams{
+			SalesOrderID: salesOrderId,
+			VoucherID:    voucher.VoucherID,
+		})
+		if err != nil {
+			return dropped, err
+		}
+		dropped = append(dropped, eligibility)
+	}
+
+	return dropped, nil
+}
+
+// GetAppliedVouchers returns the vouchers applied to the sales order, in the
+// order they were applied.
+func (r *repository) GetAppliedVouchers(ctx context.Context, salesOrderId int32) (list []model.AppliedVoucher, err error) {
+	rows, err := r.qry.ListSalesOrderVouchers(ctx, salesOrderId)
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		list = append(list, model.AppliedVoucher{
+			VoucherID:   row.VoucherID,
+			VoucherCode: row.VoucherCode,
+		})
+	}
+
+	return list, nil
+}
+
+// checkVoucherStacking rejects a set of vouchers that breaks the stacking
+// rules of their coupon programs; see stackingViolation.
+func (r *repository) checkVoucherStacking(ctx context.Context, vouchers []model.AppliedVoucher) error {
+	if len(vouchers) == 0 {
+		return nil
+	}
+
+	config := currentVoucherStacking()
+	if config.MaxVouchers > 0 && len(vouchers) > config.MaxVouchers {
+		// Over the cap whatever the rules say.
+		return stackingViolation(vouchers, nil, nil, config)
+	}
+
+	programs := make(map[string]int32, len(vouchers))
+	programIds := []int32{}
+	for _, voucher := range vouchers {
+		program, ok, err := r.couponProgram(ctx, voucher.VoucherCode)
+		if err != nil {
+			return err
+		}
+		if ok {
+			programs[voucher.VoucherCode] = program.ID
+			programIds = append(programIds, program.ID)
+		}
+	}
+	rules, err := r.qry.ListVoucherStackingRules(ctx, programIds)
+	if err != nil {
+		return err
+	}
+
+	return stackingViolation(vouchers, programs, rules, config)
+}
+
+// stackingViolation rejects a set of vouchers that is larger than the cap,
+// holds a standalone voucher next to others, or holds two vouchers of one
+// exclusive group. programs maps voucher codes to their coupon program and
+// rules are the stacking rules of those programs; vouchers without a rule
+// stack freely.
+func stackingViolation(vouchers []model.AppliedVoucher, programs map[string]int32, rules []query.VoucherStackingRule, config VoucherStackingConfig) error {
+	if config.MaxVouchers > 0 && len(vouchers) > config.MaxVouchers {
+		return &model.VoucherStackingError{
+			VoucherCode: vouchers[len(vouchers)-1].VoucherCode,
+			Reason:      fmt.Sprintf("exceeds the limit of %d vouchers per order", config.MaxVouchers),
+		}
+	}
+
+	byProgram := make(map[int32]query.VoucherStackingRule, len(rules))
+	for _, rule := range rules {
+		byProgram[rule.ProgramID] = rule
+	}
+
+	// Vouchers are checked in the order they were applied, so the voucher
+	// named is the one that came last.
+	groups := map[string]string{}
+	for _, voucher := range vouchers {
+		program, ok := programs[voucher.VoucherCode]
+		if !ok {
+			continue
+		}
+		rule, ok := byProgram[program]
+		if !ok {
+			continue
+		}
+		if rule.Standalone && len(vouchers) > 1 {
+			return &model.VoucherStackingError{VoucherCode: voucher.VoucherCode, Reason: "cannot be combined with other vouchers"}
+		}
+		if rule.ExclusiveGroup == "" {
+			continue
+		}
+		if other, ok := groups[rule.ExclusiveGroup]; ok {
+			return &model.VoucherStackingError{
+				VoucherCode: voucher.VoucherCode,
+				Reason:      fmt.Sprintf("cannot be combined with %s of group %s", other, rule.ExclusiveGroup),
+			}
+		}
+		groups[rule.ExclusiveGroup] = voucher.VoucherCode
+	}
+
+	return nil
+}
+
+// CheckVoucherEligibility evaluates a voucher against the sales order of
+// purchaseParams or, before the order exists, against its order lines. Nothing
+// is redeemed. A voucher that does not apply is reported through Reason, not
+// as an error.
+func (r *repository) CheckVoucherEligibility(ctx context.Context, purchaseParams model.PurchaseParams, voucherId int32) (result model.VoucherEligibility, err error) {
+	defer log.Info("[Odoo - Connector - CheckVoucherEligibility] End")
+	log.Info("[Odoo - Connector - CheckVoucherEligibility] Start")
+
+	return r.voucherEligibility(ctx, purchaseParams, voucherId, false)
+}
+
+// voucherEligibility checks a voucher about to be applied or, when applied is
+// true, one already applied to the sales order.
+func (r *repository) voucherEligibility(ctx context.Context, purchaseParams model.PurchaseParams, voucherId int32, applied bool) (result model.VoucherEligibility, err error) {
+	salesOrderId, _ := utils.StringToInt32(purchaseParams.SalesOrderID)
+	result = model.VoucherEligibility{
+		VoucherID:  voucherId,
+		OrderTotal: model.Money{Currency: model.DefaultCurrency},
+		Discount:   model.Money{Currency: model.DefaultCurrency},
+	}
+	reject := func(reason model.VoucherReason, message string) (model.VoucherEligibility, error) {
+		result.Reason = reason
+		result.Message = message
+		return result, nil
+	}
+
+	// Without a sales order Odoo lists the vouchers open to every order.
+	vouchers, err := r.GetVoucherList(ctx, salesOrderId)
+	if err != nil {
+		return result, err
+	}
+	var voucher *model.VoucherItem
+	for i := range vouchers {
+		if utils.InterfaceToString(vouchers[i].ID) == fmt.Sprintf("%d", voucherId) {
+			voucher = &vouchers[i]
+		}
+	}
+	// Odoo may leave a voucher it already applied out of the list; such a
+	// voucher is checked by fn_get_voucher_code alone.
+	if voucher == nil && !applied {
+		return reject(model.VoucherNotFound, "voucher not found")
+	}
+	if voucher != nil {
+		result.VoucherCode = voucher.VoucherCode
+
+		if !voucher.Available && !applied {
+			return reject(model.VoucherUnavailable, "voucher is not available")
+		}
+		if voucherExpired(voucher.ValidUntil, time.Now()) {
+			return reject(model.VoucherExpired, fmt.Sprintf("voucher expired on %s", voucher.ValidUntil))
+		}
+		dealerId := utils.InterfaceToString(voucher.DealerID)
+		if dealerId != "" && dealerId != "0" && purchaseParams.DealerID != "" && dealerId != purchaseParams.DealerID {
+			return reject(model.VoucherWrongDealer, fmt.Sprintf("voucher is only valid at %s", voucher.DealerName))
+		}
+	}
+
+	if salesOrderId != 0 {
+		code, err := r.voucherCode(ctx, salesOrderId, voucherId)
+		if err != nil {
+			return result, err
+		}
+		if !code.ok() {
+			return reject(model.VoucherUnavailable, code.Message)
+		}
+		if code.Redeemed && !applied {
+			return reject(model.VoucherAlreadyApplied, "voucher is already applied to this order")
+		}
+		if !code.Redeemed && applied {
+			return reject(model.VoucherNoLongerApplies, "voucher no longer applies to this order")
+		}
+		result.VoucherCode = code.VoucherCode
+	}
+
+	if salesOrderId != 0 && !applied {
+		stack, err := r.GetAppliedVouchers(ctx, salesOrderId)
+		if err != nil {
+			return result, err
+		}
+		err = r.checkVoucherStacking(ctx, append(stack, model.AppliedVoucher{VoucherID: voucherId, VoucherCode: result.VoucherCode}))
+		if errors.Is(err, model.ErrVoucherStacking) {
+			return reject(model.VoucherNotStackable, err.Error())
+		}
+		if err != nil {
+			return result, err
+		}
+	}
+
+	if result.OrderTotal, err = r.orderTotal(ctx, salesOrderId, purchaseParams); err != nil {
+		return result, err
+	}
+	if voucher != nil {
+		minimum := model.NewMoney(int64(voucher.Minimum), result.OrderTotal.Currency)
+		if result.OrderTotal.Units < minimum.Units {
+			return reject(model.VoucherBelowMinimum, fmt.Sprintf("order total is below the minimum of %s", minimum.Decimal()))
+		}
+	}
+
+	// An eligible voucher is only reported with the discount it gives.
+	if result.Discount, err = r.voucherDiscount(ctx, result.VoucherCode, result.OrderTotal); err != nil {
+		log.Error("[Odoo - Connector - CheckVoucherEligibility] Discount Error: ", err)
+		return result, err
+	}
+	result.Eligible = true
+	result.Reason = model.VoucherEligible
+
+	return result, nil
+}
+
+// orderTotal is the purchase total of the sales order, or the sum of the order
+// lines at their dealer price before the order exists.
+func (r *repository) orderTotal(ctx context.Context, salesOrderId int32, purchaseParams model.PurchaseParams) (total model.Money, err error) {
+	total = model.Money{Currency: model.DefaultCurrency}
+	if salesOrderId != 0 {
+		return model.ParseOdooAmount(r.salesOrderDetail(ctx, salesOrderId).Purchase.Total, model.DefaultCurrency)
+	}
+
+	stocks, err := r.GetProductStock(ctx, purchaseParams)
+	if err != nil {
+		return total, err
+	}
+	for i, stock := range stocks {
+		if stock.ProductPrice == "" || i >= len(purchaseParams.Orders) {
+			continue
+		}
+		price, err := model.ParseOdooAmount(stock.ProductPrice, model.DefaultCurrency)
+		if err != nil {
+			return total, err
+		}
+		qty := int64(purchaseParams.Orders[i].Qty)
+		if qty == 0 {
+			qty = 1
+		}
+		if total, err = total.Add(price.Mul(qty)); err != nil {
+			return total, err
+		}
+	}
+
+	return total, nil
+}
+
+// voucherDiscount reads the discount of the coupon program behind couponCode
+// and applies it to total. Programs that reward anything but a discount give
+// zero.
+func (r *repository) voucherDiscount(ctx context.Context, couponCode string, total model.Money) (model.Money, error) {
+	discount := model.Money{Currency: total.Currency}
+	programFields := []string{"reward_type", "discount_type", "discount_percentage", "discount_fixed_amount", "discount_max_amount"}
+
+	programs, err := r.voucherProgram(ctx, couponCode, programFields)
+	if err != nil {
+		return discount, err
+	}
+	records := rpcRecords(programs)
+	if len(records) == 0 || records[0]["reward_type"] != "discount" {
+		return discount, nil
+	}
+	program := records[0]
+
+	switch program["discount_type"] {
+	case "percentage":
+		percentage, _ := program["discount_percentage"].(float64)
+		discount.Units = int64(math.Round(float64(total.Units) * percentage / 100))
+		if maximum, _ := program["discount_max_amount"].(float64); maximum > 0 {
+			if capped, err := odooFloatAmount(maximum, total.Currency); err == nil && discount.Units > capped.Units {
+				discount = capped
+			}
+		}
+	case "fixed_amount":
+		fixed, _ := program["discount_fixed_amount"].(float64)
+		if discount, err = odooFloatAmount(fixed, total.Currency); err != nil {
+			return discount, err
+		}
+	}
+	if discount.Units > total.Units {
+		discount.Units = total.Units
+	}
+
+	return discount, nil
+}
+
+// voucherProgram reads fields of the coupon program behind couponCode: the
+// program of the coupon, or the program whose promotion code it is.
+func (r *repository) voucherProgram(ctx context.Context, couponCode string, fields []string) (interface{}, error) {
+	coupons, err := r.executeKw(ctx, "search_read", "sale.coupon", []interface{}{
+		[]interface{}{[]interface{}{"code", "=", couponCode}},
+	}, map[string]interface{}{"fields": []string{"program_id"}, "limit": 1})
+	if err != nil {
+		return nil, err
+	}
+	domain := []interface{}{[]interface{}{"promo_code", "=", couponCode}}
+	if records := rpcRecords(coupons); len(records) > 0 {
+		if program, ok := records[0]["program_id"].([]interface{}); ok && len(program) > 0 {
+			domain = []interface{}{[]interface{}{"id", "=", program[0]}}
+		}
+	}
+
+	return r.executeKw(ctx, "search_read", "sale.coupon.program", []interface{}{domain},
+		map[string]interface{}{"fields": fields, "limit": 1})
+}
+
+// couponProgram is the coupon program behind a voucher code.
+type couponProgram struct {
+	ID int32
+	// DiscountProduct is the product of the reward lines Odoo adds to an
+	// order for the program; zero for programs that give no discount.
+	DiscountProduct int
+}
+
+// couponProgram returns the coupon program behind couponCode; ok is false
+// when Odoo knows no such code.
+func (r *repository) couponProgram(ctx context.Context, couponCode string) (program couponProgram, ok bool, err error) {
+	programs, err := r.voucherProgram(ctx, couponCode, []string{"id", "discount_line_product_id"})
+	if err != nil {
+		return program, false, err
+	}
+	records := rpcRecords(programs)
+	if len(records) == 0 {
+		return program, false, nil
+	}
+	id, _ := records[0]["id"].(int)
+	program.ID = int32(id)
+	if product, ok := records[0]["discount_line_product_id"].([]interface{}); ok && len(product) > 0 {
+		program.DiscountProduct, _ = product[0].(int)
+	}
+	return program, true, nil
+}
+
+// GetVoucherReductions returns the vouchers applied to the sales order with
+// the amount each takes off it.
+func (r *repository) GetVoucherReductions(ctx context.Context, salesOrderId int32) (list []model.AppliedVoucher, err error) {
+	defer log.Info("[Odoo - Connector - GetVoucherReductions] End")
+	log.Info("[Odoo - Connector - GetVoucherReductions] Start")
+
+	if list, err = r.GetAppliedVouchers(ctx, salesOrderId); err != nil || len(list) == 0 {
+		return list, err
+	}
+	return r.voucherAmounts(ctx, salesOrderId, list)
+}
+
+// voucherAmounts sets the amount of each voucher from the reward lines Odoo
+// added to the sales order for its coupon program, as a positive reduction.
+// Odoo adds the reward of a program once, whatever the number of its coupons
+// applied, so it is counted on the first voucher of the program and the
+// others take nothing off.
+func (r *repository) voucherAmounts(ctx context.Context, salesOrderId int32, vouchers []model.AppliedVoucher) ([]model.AppliedVoucher, error) {
+	lines, err := r.executeKw(ctx, "search_read", "sale.order.line", []interface{}{
+		[]interface{}{
+			[]interface{}{"order_id", "=", salesOrderId},
+			[]interface{}{"is_reward_line", "=", true},
+		},
+	}, map[string]interface{}{"fields": []string{"product_id", "price_total"}})
+	if err != nil {
+		return vouchers, err
+	}
+	rewards := map[int]float64{}
+	for _, line := range rpcRecords(lines) {
+		if product, ok := line["product_id"].([]interface{}); ok && len(product) > 0 {
+			id, _ := product[0].(int)
+			amount, _ := line["price_total"].(float64)
+			rewards[id] += amount
+		}
+	}
+
+	counted := map[int32]bool{}
+	for i := range vouchers {
+		vouchers[i].Amount = model.Money{Currency: model.DefaultCurrency}
+
+		program, ok, err := r.couponProgram(ctx, vouchers[i].VoucherCode)
+		if err != nil {
+			return vouchers, err
+		}
+		if !ok || program.DiscountProduct == 0 || counted[program.ID] {
+			continue
+		}
+		counted[program.ID] = true
+		if vouchers[i].Amount, err = odooFloatAmount(-rewards[program.DiscountProduct], model.DefaultCurrency); err != nil {
+			return vouchers, err
+		}
+	}
+
+	return vouchers, nil
+}
+
+func odooFloatAmount(amount float64, currency model.Currency) (model.Money, error) {
+	return model.ParseOdooAmount(strconv.FormatFloat(amount, 'f', currency.Exponent, 64), currency)
+}
+
+// voucherExpired reports whether validUntil, as Odoo formats the end of a
+// voucher, has passed at now. A timestamp without a zone is in UTC, as Odoo
+// stores it; a date alone is valid through that day in WIB, where vouchers
+// are issued. Dates that cannot be read never expire.
+func voucherExpired(validUntil string, now time.Time) bool {
+	if until, ok := parseOdooTime(validUntil); ok {
+		return now.After(until)
+	}
+	validUntil = strings.TrimSpace(validUntil)
+	for _, layout := range []string{"2006-01-02", "02-01-2006", "02/01/2006", "2 January 2006", "02 Jan 2006"} {
+		if until, err := time.ParseInLocation(layout, validUntil, model.WIB); err == nil {
+			return !now.Before(until.AddDate(0, 0, 1))
+		}
+	}
+	return false
+}
+
+// voucherCode checks the voucher against the sales order.
+// Output Sample : "0|Searching Get Succesfully 6968773680224492744|6968773680224492744|Y"
+func (r *repository) voucherCode(ctx context.Context, salesOrderId int32, voucherId int32) (voucher voucherCodeResult, err error) {
+	resultGetVoucherCode, err := r.qry.GetVoucherCodeBySoIdAndVoucherId(ctx, &query.GetVoucherCodeBySoIdAndVoucherIdParams{
+		FnGetVoucherCode:   salesOrderId,
+		FnGetVoucherCode_2: voucherId,
+	})
+	if err != nil {
+		return voucher, err
+	}
+	err = decodeOdooResult("fn_get_voucher_code", resultGetVoucherCode, &voucher)
+
+	return voucher, err
+}
+
+// redeemVoucher applies the coupon code to the sales order and computes the
+// amount of the coupon line.
+func (r *repository) redeemVoucher(ctx context.Context, salesOrderId int32, couponCode string) error {
+	log.Info(fmt.Sprintf("[Odoo - Connector - SetVoucherRedeem] sale.coupon.apply.code - process_coupon_so Params SalesOrderId: %d, couponCode: %s", salesOrderId, couponCode))
+	_, err := r.executeKw(ctx, "process_coupon_so", "sale.coupon.apply.code", []interface{}{
+		map[string]interface{}{
+			"order_id":    salesOrderId,
+			"coupon_code": couponCode,
+		},
+	}, nil)
+	if err != nil {
+		log.Error("[Odoo - Connector - SetVoucherRedeem] process_coupon_so Error: ", err)
+		return err
+	}
+
+	// Output Sample : "0|Searching Get Succesfully |10900|15"
+	// the second index is the coupon line used for computing the amount
+	getVoucherLine, err := r.qry.GetVoucherLineBySoId(ctx, salesOrderId)
+	if err != nil {
+		return err
+	}
+	voucherLine := voucherLineResult{}
+	if err = decodeOdooResult("fn_get_voucher_line", getVoucherLine, &voucherLine); err != nil {
+		return err
+	}
+	log.Info(fmt.Sprintf("[Odoo - Connector - SetVoucherRedeem] sale.order.line - compute_amount Params couponLine: %d", voucherLine.LineID))
+	_, err = r.executeKw(ctx, "compute_amount", "sale.order.line", []interface{}{
+		[]interface{}{voucherLine.LineID},
+	}, nil)
+	if err != nil {
+		log.Error("[Odoo - Connector - SetVoucherRedeem] compute_amount Error: ", err)
+		return err
+	}
+
+	return nil
+}
+
+// releaseVoucher undoes process_coupon_so. A coupon code is unlinked from the
+// order and set back to new; a promotion code is cleared from the order.
+func (r *repository) releaseVoucher(ctx context.Context, salesOrderId int32, couponCode string) error {
+	log.Info(fmt.Sprintf("[Odoo - Connector - RemoveVoucher] Params SalesOrderId: %d, couponCode: %s", salesOrderId, couponCode))
+	found, err := r.executeKw(ctx, "search", "sale.coupon", []interface{}{
+		[]interface{}{
+			[]interface{}{"code", "=", couponCode},
+			[]interface{}{"sales_order_id", "=", salesOrderId},
+		},
+	}, nil)
+	if err != nil {
+		return err
+	}
+
+	if couponIds := rpcIDs(found); len(couponIds) > 0 {
+		commands := []interface{}{}
+		for _, id := range couponIds {
+			commands = append(commands, []interface{}{3, id})
+		}
+		if _, err = r.executeKw(ctx, "write", "sale.order", []interface{}{
+			[]interface{}{salesOrderId},
+			map[string]interface{}{"applied_coupon_ids": commands},
+		}, nil); err != nil {
+			return err
+		}
+		if _, err = r.executeKw(ctx, "write", "sale.coupon", []interface{}{
+			couponIds,
+			map[string]interface{}{"state": "new", "sales_order_id": false},
+		}, nil); err != nil {
+			return err
+		}
+	} else if _, err = r.executeKw(ctx, "write", "sale.order", []interface{}{
+		[]interface{}{salesOrderId},
+		map[string]interface{}{"code_promo_program_id": false},
+	}, nil); err != nil {
+		return err
+	}
+
+	_, err = r.executeKw(ctx, "recompute_coupon_lines", "sale.order", []interface{}{
+		[]interface{}{salesOrderId},
+	}, nil)
+
+	return err
+}
+
+func (r *repository) recordVoucher(ctx context.Context, salesOrderId int32, voucher model.AppliedVoucher) error {
+	return r.qry.AddSalesOrderVoucher(ctx, &query.AddSalesOrderVoucherParams{
+		SalesOrderID: salesOrderId,
+		VoucherID:    voucher.VoucherID,
+		VoucherCode:  voucher.VoucherCode,
+	})
+}
+
+func (r *repository) salesOrderDetail(ctx context.Context, salesOrderId int32) (list model.OrderConfirmationResponses) {
+	soDetail, _ := r.qry.GetSoDetailBySoId(ctx, salesOrderId)
+	if soDetail != "" {
+		json.Unmarshal([]byte(soDetail), &list)
+	}
+
+	return list
+}
+
+func appliedVoucher(vouchers []model.AppliedVoucher, voucherId int32) bool {
+	for _, voucher := range vouchers {
+		if voucher.VoucherID == voucherId {
+			return true
+		}
+	}
+	return false
+}
+
+// rpcRecords reads the records out of a "search_read" XML-RPC response.
+func rpcRecords(response interface{}) (records []map[string]interface{}) {
+	list, _ := response.([]interface{})
+	for _, item := range list {
+		if record, ok := item.(map[string]interface{}); ok {
+			records = append(records, record)
+		}
+	}
+	return records
+}
+
+// rpcIDs reads the record IDs out of a "search" XML-RPC response.
+func rpcIDs(response interface{}) []interface{} {
+	ids, _ := response.([]interface{})
+	return ids
+}
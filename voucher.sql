@@ -0,0 +1,39 @@
+-- Schema and sqlc queries for the vouchers applied to sales orders and the
+-- rules that decide which vouchers stack.
+
+-- Rules are kept per coupon program: every coupon of a program follows them.
+CREATE TABLE IF NOT EXISTS voucher_stacking_rules (
+    program_id      INTEGER      PRIMARY KEY,
+    -- At most one voucher of an exclusive group applies to an order; empty
+    -- for vouchers outside any group.
+    exclusive_group VARCHAR(64)  NOT NULL DEFAULT '',
+    -- A standalone voucher applies to an order alone.
+    standalone      BOOLEAN      NOT NULL DEFAULT FALSE,
+    updated_time    TIMESTAMP    NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS sales_order_vouchers (
+    sales_order_id INTEGER      NOT NULL,
+    voucher_id     INTEGER      NOT NULL,
+    voucher_code   VARCHAR(255) NOT NULL,
+    created_time   TIMESTAMP    NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (sales_order_id, voucher_id)
+);
+
+-- name: ListVoucherStackingRules :many
+SELECT * FROM voucher_stacking_rules
+WHERE program_id = ANY(@program_ids::int[]);
+
+-- name: ListSalesOrderVouchers :many
+SELECT * FROM sales_order_vouchers
+WHERE sales_order_id = $1
+ORDER BY created_time, voucher_id;
+
+-- name: AddSalesOrderVoucher :exec
+INSERT INTO sales_order_vouchers (sales_order_id, voucher_id, voucher_code)
+VALUES ($1, $2, $3)
+ON CONFLICT (sales_order_id, voucher_id) DO NOTHING;
+
+-- name: RemoveSalesOrderVoucher :exec
+DELETE FROM sales_order_vouchers
+WHERE sales_order_id = $1 AND voucher_id = $2;
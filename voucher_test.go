@@ -0,0 +1,222 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"zebrax.id/emi/integration/erp/connector/odoo/model"
+	"zebrax.id/emi/integration/erp/connector/odoo/odootest"
+	"zebrax.id/emi/integration/erp/connector/odoo/repository/query"
+)
+
+func TestStackingViolation(t *testing.T) {
+	vouchers := func(codes ...string) (list []model.AppliedVoucher) {
+		for i, code := range codes {
+			list = append(list, model.AppliedVoucher{VoucherID: int32(i + 1), VoucherCode: code})
+		}
+		return list
+	}
+	programs := map[string]int32{"DEALER10-A": 1, "DEALER10-B": 1, "DEALER20": 2, "NATIONAL": 3, "LAUNCH": 4, "FREE-A": 5, "FREE-B": 5}
+	rules := []query.VoucherStackingRule{
+		{ProgramID: 1, ExclusiveGroup: "dealer"},
+		{ProgramID: 2, ExclusiveGroup: "dealer"},
+		{ProgramID: 3, ExclusiveGroup: "national"},
+		{ProgramID: 4, Standalone: true},
+	}
+
+	tests := []struct {
+		name     string
+		vouchers []model.AppliedVoucher
+		max      int
+		wantCode string
+	}{
+		{name: "no vouchers", vouchers: nil, max: 3},
+		{name: "vouchers without programs stack", vouchers: vouchers("A", "B", "C"), max: 3},
+		{name: "coupons of a program without rules stack", vouchers: vouchers("FREE-A", "FREE-B"), max: 3},
+		{name: "dealer and national stack", vouchers: vouchers("DEALER10-A", "NATIONAL"), max: 3},
+		{name: "two programs of one group", vouchers: vouchers("DEALER10-A", "NATIONAL", "DEALER20"), max: 3, wantCode: "DEALER20"},
+		{name: "two coupons of one exclusive program", vouchers: vouchers("DEALER10-A", "DEALER10-B"), max: 3, wantCode: "DEALER10-B"},
+		{name: "standalone alone", vouchers: vouchers("LAUNCH"), max: 3},
+		{name: "standalone with others", vouchers: vouchers("NATIONAL", "LAUNCH"), max: 3, wantCode: "LAUNCH"},
+		{name: "over the cap", vouchers: vouchers("A", "B", "C", "D"), max: 3, wantCode: "D"},
+		{name: "no cap", vouchers: vouchers("A", "B", "C", "D"), max: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := stackingViolation(tt.vouchers, programs, rules, VoucherStackingConfig{MaxVouchers: tt.max})
+			if tt.wantCode == "" {
+				if err != nil {
+					t.Fatalf("err = %v, want nil", err)
+				}
+				return
+			}
+
+			var stacking *model.VoucherStackingError
+			if !errors.As(err, &stacking) || !errors.Is(err, model.ErrVoucherStacking) {
+				t.Fatalf("err = %v, want a stacking error", err)
+			}
+			if stacking.VoucherCode != tt.wantCode {
+				t.Errorf("voucher = %s, want %s", stacking.VoucherCode, tt.wantCode)
+			}
+		})
+	}
+}
+
+// handleCouponPrograms answers the coupon and program lookups of voucherProgram.
+// Coupon codes name the program they belong to; other codes are looked up as
+// promotion codes.
+func handleCouponPrograms(srv *odootest.Server, coupons map[string]int, promoCodes map[string]int, products map[int]int) {
+	leaf := func(call odootest.Call) []interface{} {
+		domain, _ := call.Args[0].([]interface{})
+		leaf, _ := domain[0].([]interface{})
+		return leaf
+	}
+	srv.Handle("sale.coupon", "search_read", func(call odootest.Call) (interface{}, error) {
+		program, ok := coupons[leaf(call)[2].(string)]
+		if !ok {
+			return []interface{}{}, nil
+		}
+		return []interface{}{map[string]interface{}{"program_id": []interface{}{program, "Program"}}}, nil
+	})
+	srv.Handle("sale.coupon.program", "search_read", func(call odootest.Call) (interface{}, error) {
+		var (
+			program int
+			ok      bool
+		)
+		switch l := leaf(call); l[0] {
+		case "id":
+			program, ok = l[2].(int)
+		case "promo_code":
+			program, ok = promoCodes[l[2].(string)]
+		}
+		if !ok {
+			return []interface{}{}, nil
+		}
+		return []interface{}{map[string]interface{}{
+			"id":                       program,
+			"discount_line_product_id": []interface{}{products[program], "Discount"},
+		}}, nil
+	})
+}
+
+// stackingQueries returns the stacking rules of the programs asked for.
+type stackingQueries struct {
+	query.Querier
+	rules []query.VoucherStackingRule
+}
+
+func (q *stackingQueries) ListVoucherStackingRules(ctx context.Context, programIds []int32) (rules []query.VoucherStackingRule, err error) {
+	for _, rule := range q.rules {
+		for _, id := range programIds {
+			if rule.ProgramID == id {
+				rules = append(rules, rule)
+			}
+		}
+	}
+	return rules, nil
+}
+
+func TestCheckVoucherStacking(t *testing.T) {
+	r, srv := newTestRepository(t)
+	handleCouponPrograms(srv,
+		map[string]int{"6968773680224492744": 1, "6968773680224492745": 1, "7000000000000000001": 2},
+		map[string]int{"NATIONAL": 3},
+		map[int]int{1: 70, 2: 71, 3: 72},
+	)
+	r.qry = &stackingQueries{rules: []query.VoucherStackingRule{
+		{ProgramID: 1, ExclusiveGroup: "dealer"},
+		{ProgramID: 2, ExclusiveGroup: "dealer"},
+		{ProgramID: 3, ExclusiveGroup: "national"},
+	}}
+
+	tests := []struct {
+		name     string
+		codes    []string
+		wantCode string
+	}{
+		{name: "coupon and promotion code", codes: []string{"6968773680224492744", "NATIONAL"}},
+		{name: "two coupons of one program", codes: []string{"6968773680224492744", "6968773680224492745"}, wantCode: "6968773680224492745"},
+		{name: "coupons of two programs of one group", codes: []string{"7000000000000000001", "NATIONAL", "6968773680224492744"}, wantCode: "6968773680224492744"},
+		{name: "unknown code", codes: []string{"NATIONAL", "UNKNOWN"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var vouchers []model.AppliedVoucher
+			for i, code := range tt.codes {
+				vouchers = append(vouchers, model.AppliedVoucher{VoucherID: int32(i + 1), VoucherCode: code})
+			}
+			err := r.checkVoucherStacking(context.Background(), vouchers)
+			if tt.wantCode == "" {
+				if err != nil {
+					t.Fatalf("err = %v, want nil", err)
+				}
+				return
+			}
+			var stacking *model.VoucherStackingError
+			if !errors.As(err, &stacking) || stacking.VoucherCode != tt.wantCode {
+				t.Errorf("err = %v, want a stacking error of %s", err, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestVoucherAmounts(t *testing.T) {
+	r, srv := newTestRepository(t)
+	srv.Handle("sale.order.line", "search_read", func(call odootest.Call) (interface{}, error) {
+		return []interface{}{
+			map[string]interface{}{"product_id": []interface{}{70, "Dealer discount"}, "price_total": -500000.0},
+			map[string]interface{}{"product_id": []interface{}{71, "National voucher"}, "price_total": -250000.0},
+			map[string]interface{}{"product_id": []interface{}{71, "National voucher"}, "price_total": -50000.0},
+		}, nil
+	})
+	handleCouponPrograms(srv,
+		map[string]int{"6968773680224492744": 2, "6968773680224492745": 2},
+		map[string]int{"DEALER10": 1},
+		map[int]int{1: 70, 2: 71},
+	)
+
+	vouchers, err := r.voucherAmounts(context.Background(), 15, []model.AppliedVoucher{
+		{VoucherID: 1, VoucherCode: "DEALER10"},
+		{VoucherID: 2, VoucherCode: "6968773680224492744"},
+		{VoucherID: 3, VoucherCode: "6968773680224492745"},
+		{VoucherID: 4, VoucherCode: "UNKNOWN"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The reward of the national program is counted once, on its first
+	// coupon.
+	want := []string{"500000", "300000", "0", "0"}
+	for i, voucher := range vouchers {
+		if got := voucher.Amount.Decimal(); got != want[i] {
+			t.Errorf("%s amount = %s, want %s", voucher.VoucherCode, got, want[i])
+		}
+	}
+}
+
+func TestVoucherExpired(t *testing.T) {
+	// 23:30 WIB on 31 August, 16:30 UTC.
+	now := time.Date(2026, time.August, 31, 23, 30, 0, 0, model.WIB)
+
+	tests := []struct {
+		validUntil string
+		want       bool
+	}{
+		{"2026-08-31", false},
+		{"31/08/2026", false},
+		{"2026-08-30", true},
+		{"2026-08-31 16:00:00", true},
+		{"2026-08-31 17:00:00", false},
+		{"2026-08-31T23:00:00+07:00", true},
+		{"2026-09-01T00:00:00+09:00", true},
+		{"soon", false},
+	}
+	for _, tt := range tests {
+		if got := voucherExpired(tt.validUntil, now); got != tt.want {
+			t.Errorf("voucherExpired(%q) = %v, want %v", tt.validUntil, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,512 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"zebrax.id/emi/integration/core/utils"
+	"zebrax.id/emi/integration/erp/connector/odoo/model"
+	"zebrax.id/emi/integration/erp/connector/odoo/repository/query"
+)
+
+// Waitlist statuses stored in testdrive_waitlist.
+const (
+	WaitlistWaiting = "waiting"
+	WaitlistHeld    = "held"
+	WaitlistClaimed = "claimed"
+	WaitlistExpired = "expired"
+	WaitlistLeft    = "left"
+)
+
+// DefaultWaitlistHold is how long a freed slot is held for a waitlisted
+// customer before it passes to the next one.
+const DefaultWaitlistHold = 30 * time.Minute
+
+// WaitlistNotifier tells a waitlisted customer that a slot is held for them.
+type WaitlistNotifier interface {
+	SendWaitlistHold(ctx context.Context, entry model.WaitlistEntry) error
+}
+
+// logWaitlistNotifier only logs holds; it is used until a real notifier is set
+// with SetWaitlistNotifier.
+type logWaitlistNotifier struct{}
+
+func (logWaitlistNotifier) SendWaitlistHold(ctx context.Context, entry model.WaitlistEntry) error {
+	log.Info(fmt.Sprintf("[Odoo - Connector - Waitlist] Slot %s held for user %d until %s", entry.SlotStart.Format(time.RFC3339), entry.UID, entry.HoldExpires.Format(time.RFC3339)))
+	return nil
+}
+
+var (
+	waitlistMu       sync.RWMutex
+	waitlistNotifier WaitlistNotifier = logWaitlistNotifier{}
+	waitlistHold                      = DefaultWaitlistHold
+)
+
+// SetWaitlistNotifier replaces the notifier told about held slots.
+func SetWaitlistNotifier(notifier WaitlistNotifier) {
+	waitlistMu.Lock()
+	defer waitlistMu.Unlock()
+	waitlistNotifier = notifier
+}
+
+// SetWaitlistHold replaces how long a freed slot is held.
+func SetWaitlistHold(hold time.Duration) {
+	waitlistMu.Lock()
+	defer waitlistMu.Unlock()
+	waitlistHold = hold
+}
+
+func currentWaitlist() (WaitlistNotifier, time.Duration) {
+	waitlistMu.RLock()
+	defer waitlistMu.RUnlock()
+	return waitlistNotifier, waitlistHold
+}
+
+// WaitlistConfig controls the job that passes expired holds on.
+type WaitlistConfig struct {
+	PollInterval time.Duration
+	// BatchSize is the number of expired holds handled per poll.
+	BatchSize int32
+}
+
+var DefaultWaitlistConfig = WaitlistConfig{
+	PollInterval: time.Minute,
+	BatchSize:    100,
+}
+
+// testDriveSlot identifies a slot across the booking functions, the slot
+// queries and the waitlist.
+type testDriveSlot struct {
+	ProductID         int32
+	EcID              int32
+	AppointmentTypeID int32
+	Start             time.Time
+}
+
+func bookingSlot(bookParams model.BookParams) (testDriveSlot, error) {
+	start, err := model.ParseBookingTime(bookParams.SlotDate, bookParams.SlotStartTime)
+	if err != nil {
+		return testDriveSlot{}, err
+	}
+
+	return testDriveSlot{
+		ProductID:         bookParams.ProductID,
+		EcID:              bookParams.EcID,
+		AppointmentTypeID: bookParams.BookingTypeID,
+		Start:             start,
+	}, nil
+}
+
+func waitlistEntry(row query.TestdriveWaitlist) model.WaitlistEntry {
+	return model.WaitlistEntry{
+		ID:                row.ID,
+		UID:               row.Uid,
+		ProductID:         row.ProductID,
+		EcID:              row.EcID,
+		AppointmentTypeID: row.AppointmentTypeID,
+		SlotStart:         row.SlotStart,
+		Status:            row.Status,
+		HoldExpires:       row.HoldExpires.Time,
+	}
+}
+
+// JoinTestDriveWaitlist puts the customer on the waitlist of a slot.
+func (r *repository) JoinTestDriveWaitlist(ctx context.Context, params model.WaitlistParams) (entry model.WaitlistEntry, err error) {
+	defer log.Info("[Odoo - Connector - JoinTestDriveWaitlist] End")
+	log.Info("[Odoo - Connector - JoinTestDriveWaitlist] Start")
+
+	uid, err := utils.StringToInt32(params.UID)
+	if err != nil {
+		return entry, err
+	}
+	start, err := model.ParseBookingTime(params.SlotDate, params.SlotStartTime)
+	if err != nil {
+		return entry, err
+	}
+	now := time.Now()
+	if !start.After(now) {
+		return entry, model.ErrSlotInPast
+	}
+
+	// Only full slots have a waitlist; seats held for other waiting customers
+	// count as taken.
+	slot := testDriveSlot{
+		ProductID:         params.ProductID,
+		EcID:              params.EcID,
+		AppointmentTypeID: params.AppointmentTypeID,
+		Start:             start,
+	}
+	free, err := r.slotFreeSeats(ctx, slot)
+	if err != nil {
+		return entry, err
+	}
+	if free > 0 {
+		holds, err := r.qry.CountActiveTestDriveHolds(ctx, &query.CountActiveTestDriveHoldsParams{
+			ProductID:         slot.ProductID,
+			EcID:              slot.EcID,
+			AppointmentTypeID: slot.AppointmentTypeID,
+			SlotStart:         slot.Start,
+			HoldExpires:       sql.NullTime{Time: now, Valid: true},
+		})
+		if err != nil {
+			return entry, err
+		}
+		if free > holds {
+			return entry, model.ErrSlotNotFull
+		}
+	}
+
+	row, err := r.qry.CreateTestDriveWaitlistEntry(ctx, &query.CreateTestDriveWaitlistEntryParams{
+		Uid:               uid,
+		ProductID:         params.ProductID,
+		EcID:              params.EcID,
+		AppointmentTypeID: params.AppointmentTypeID,
+		SlotStart:         start,
+		CreatedTime:       now,
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		return entry, model.ErrAlreadyWaitlisted
+	}
+	if err != nil {
+		log.Info("[Odoo - Connector - JoinTestDriveWaitlist] Error: ", err.Error())
+		return entry, err
+	}
+
+	entry = waitlistEntry(row)
+	ahead, err := r.qry.CountTestDriveWaitlistAhead(ctx, &query.CountTestDriveWaitlistAheadParams{
+		ProductID:         row.ProductID,
+		EcID:              row.EcID,
+		AppointmentTypeID: row.AppointmentTypeID,
+		SlotStart:         row.SlotStart,
+		ID:                row.ID,
+	})
+	if err != nil {
+		return entry, err
+	}
+	entry.Ahead = int(ahead)
+
+	return entry, nil
+}
+
+// LeaveTestDriveWaitlist takes the customer off a waitlist. A slot held for
+// the customer passes to the next one.
+func (r *repository) LeaveTestDriveWaitlist(ctx context.Context, id int64, uId string) (err error) {
+	defer log.Info("[Odoo - Connector - LeaveTestDriveWaitlist] End")
+	log.Info("[Odoo - Connector - LeaveTestDriveWaitlist] Start")
+
+	uid, err := utils.StringToInt32(uId)
+	if err != nil {
+		return err
+	}
+	row, err := r.qry.GetTestDriveWaitlistEntry(ctx, &query.GetTestDriveWaitlistEntryParams{ID: id, Uid: uid})
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && row.Status != WaitlistWaiting && row.Status != WaitlistHeld) {
+		return model.ErrWaitlistEntryNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	err = r.qry.SetTestDriveWaitlistStatus(ctx, &query.SetTestDriveWaitlistStatusParams{
+		ID:          id,
+		Status:      WaitlistLeft,
+		UpdatedTime: time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+
+	if row.Status == WaitlistHeld {
+		r.offerTestDriveSlot(ctx, testDriveSlot{
+			ProductID:         row.ProductID,
+			EcID:              row.EcID,
+			AppointmentTypeID: row.AppointmentTypeID,
+			Start:             row.SlotStart,
+		})
+	}
+	return nil
+}
+
+// checkTestDriveHold returns the hold the customer booking the slot may claim.
+// Customers without a hold can only book the seats nobody holds.
+func (r *repository) checkTestDriveHold(ctx context.Context, bookParams model.BookParams) (*query.TestdriveWaitlist, error) {
+	slot, err := bookingSlot(bookParams)
+	if err != nil {
+		// The booking function validates the slot itself.
+		return nil, nil
+	}
+	uid, err := utils.StringToInt32(bookParams.UID)
+	if err != nil {
+		return nil, nil
+	}
+
+	now := sql.NullTime{Time: time.Now(), Valid: true}
+	hold, err := r.qry.GetActiveTestDriveHold(ctx, &query.GetActiveTestDriveHoldParams{
+		ProductID:         slot.ProductID,
+		EcID:              slot.EcID,
+		AppointmentTypeID: slot.AppointmentTypeID,
+		SlotStart:         slot.Start,
+		Uid:               uid,
+		HoldExpires:       now,
+	})
+	if err == nil {
+		return &hold, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	holds, err := r.qry.CountActiveTestDriveHolds(ctx, &query.CountActiveTestDriveHoldsParams{
+		ProductID:         slot.ProductID,
+		EcID:              slot.EcID,
+		AppointmentTypeID: slot.AppointmentTypeID,
+		SlotStart:         slot.Start,
+		HoldExpires:       now,
+	})
+	if err != nil || holds == 0 {
+		return nil, err
+	}
+	free, err := r.slotFreeSeats(ctx, slot)
+	if err != nil {
+		return nil, err
+	}
+	if free <= holds {
+		return nil, model.ErrSlotHeld
+	}
+	return nil, nil
+}
+
+// slotFreeSeats returns the seats of slot Odoo still lists as available,
+// including the ones held for waitlisted customers.
+func (r *repository) slotFreeSeats(ctx context.Context, slot testDriveSlot) (int64, error) {
+	// The slot queries take the day of the slot in WIB; held slots are read
+	// back from the database in UTC.
+	day, _ := time.Parse("2006-01-02", slot.Start.In(model.WIB).Format("2006-01-02"))
+
+	if slot.AppointmentTypeID == 2 {
+		rows, err := r.qry.GetSlotTimeOnwheels(ctx, &query.GetSlotTimeOnwheelsParams{
+			ID:         slot.ProductID,
+			ID_2:       slot.EcID,
+			SlotDate:   day,
+			SlotDate_2: day,
+		})
+		if err != nil {
+			return 0, err
+		}
+		for _, row := range rows {
+			if slotStartsAt(row.StartTimeIso, slot.Start) {
+				return row.Jml, nil
+			}
+		}
+		return 0, nil
+	}
+
+	rows, err := r.qry.GetSlotTime(ctx, &query.GetSlotTimeParams{
+		ID:                slot.ProductID,
+		ID_2:              slot.EcID,
+		SlotDate:          day,
+		SlotDate_2:        day,
+		AppointmentTypeID: slot.AppointmentTypeID,
+	})
+	if err != nil {
+		return 0, err
+	}
+	for _, row := range rows {
+		if slotStartsAt(row.StartTimeIso, slot.Start) {
+			return row.Jml, nil
+		}
+	}
+	return 0, nil
+}
+
+func slotStartsAt(isoStart string, start time.Time) bool {
+	parsed, err := time.Parse(time.RFC3339, isoStart)
+	return err == nil && parsed.Equal(start)
+}
+
+// testDriveSlotHolds returns the number of held seats per slot start, as Unix
+// seconds, for the slots of a product between from and to.
+func (r *repository) testDriveSlotHolds(ctx context.Context, productId int32, ecId int32, appointmentTypeId int32, from time.Time, to time.Time) map[int64]int64 {
+	now := time.Now()
+	rows, err := r.qry.ListActiveTestDriveHolds(ctx, &query.ListActiveTestDriveHoldsParams{
+		ProductID:         productId,
+		EcID:              ecId,
+		AppointmentTypeID: appointmentTypeId,
+		FromTime:          from,
+		ToTime:            to,
+		Now:               sql.NullTime{Time: now, Valid: true},
+	})
+	if err != nil {
+		// Slots are still listed; booking a held seat is refused by
+		// checkTestDriveHold.
+		log.Error("[Odoo - Connector - Waitlist] List Holds Error: ", err)
+		return nil
+	}
+
+	holds := make(map[int64]int64, len(rows))
+	for _, row := range rows {
+		holds[row.SlotStart.Unix()] = row.Holds
+	}
+	return holds
+}
+
+// availableSeats formats the seats of a slot left after its holds.
+func availableSeats(seats int64, isoStart string, holds map[int64]int64) string {
+	if start, err := time.Parse(time.RFC3339, isoStart); err == nil {
+		seats -= holds[start.Unix()]
+	}
+	if seats < 0 {
+		seats = 0
+	}
+	return fmt.Sprintf("%d", seats)
+}
+
+// claimTestDriveHold closes the hold once its customer has booked the slot.
+func (r *repository) claimTestDriveHold(ctx context.Context, hold *query.TestdriveWaitlist) {
+	if hold == nil {
+		return
+	}
+	err := r.qry.SetTestDriveWaitlistStatus(ctx, &query.SetTestDriveWaitlistStatusParams{
+		ID:          hold.ID,
+		Status:      WaitlistClaimed,
+		UpdatedTime: time.Now(),
+	})
+	if err != nil {
+		log.Error(fmt.Sprintf("[Odoo - Connector - Waitlist] Claim Hold %d Error: ", hold.ID), err)
+	}
+}
+
+// testDriveSlotOfBooking reads the slot a booking occupies, before it is
+// cancelled or rescheduled.
+func (r *repository) testDriveSlotOfBooking(ctx context.Context, bookingID int32) (slot testDriveSlot, found bool) {
+	row, err := r.qry.GetTestDriveSlotByBookingId(ctx, sql.NullInt32{Int32: bookingID, Valid: true})
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.Error(fmt.Sprintf("[Odoo - Connector - Waitlist] Get Slot of Booking %d Error: ", bookingID), err)
+		}
+		return slot, false
+	}
+
+	start, err := model.ParseBookingTime(row.Date.String, row.StartTime.String)
+	if err != nil {
+		log.Info(fmt.Sprintf("[Odoo - Connector - Waitlist] Booking %d Start Time Error: %s", bookingID, err.Error()))
+		return slot, false
+	}
+	appointmentTypeID, _ := utils.StringToInt32(row.AppointmentTypeID.String)
+
+	return testDriveSlot{
+		ProductID:         row.ProductID.Int32,
+		EcID:              row.EcID.Int32,
+		AppointmentTypeID: appointmentTypeID,
+		Start:             start,
+	}, true
+}
+
+// offerTestDriveSlot holds the free seats of a slot for the first waiting
+// customers, one hold per seat, and notifies them. Nothing happens when nobody
+// waits or every free seat is already held.
+func (r *repository) offerTestDriveSlot(ctx context.Context, slot testDriveSlot) {
+	now := time.Now()
+	if !slot.Start.After(now) {
+		return
+	}
+
+	free, err := r.slotFreeSeats(ctx, slot)
+	if err != nil {
+		log.Error("[Odoo - Connector - Waitlist] Free Seats Error: ", err)
+		return
+	}
+
+	notifier, hold := currentWaitlist()
+	for i := int64(0); i < free; i++ {
+		var row query.TestdriveWaitlist
+		err = r.execTx(ctx, func(q *query.Queries) (err error) {
+			row, err = holdTestDriveSeat(ctx, q, slot, free, now, hold)
+			return err
+		})
+		if errors.Is(err, sql.ErrNoRows) {
+			return
+		}
+		if err != nil {
+			log.Error("[Odoo - Connector - Waitlist] Hold Slot Error: ", err)
+			return
+		}
+
+		log.Info(fmt.Sprintf("[Odoo - Connector - Waitlist] Hold %d for user %d until %s", row.ID, row.Uid, row.HoldExpires.Time.Format(time.RFC3339)))
+		if err = notifier.SendWaitlistHold(ctx, waitlistEntry(row)); err != nil {
+			// The hold stands; if the customer never hears of it, it expires
+			// and passes on.
+			log.Error(fmt.Sprintf("[Odoo - Connector - Waitlist] Notify Hold %d Error: ", row.ID), err)
+		}
+	}
+}
+
+// holdTestDriveSeat holds one of the free seats of slot for the first waiting
+// customer, until hold after now. It returns sql.ErrNoRows when nobody waits or
+// every free seat is already held.
+func holdTestDriveSeat(ctx context.Context, q query.Querier, slot testDriveSlot, free int64, now time.Time, hold time.Duration) (query.TestdriveWaitlist, error) {
+	err := q.LockTestDriveSlot(ctx, &query.LockTestDriveSlotParams{
+		ProductID:         slot.ProductID,
+		EcID:              slot.EcID,
+		AppointmentTypeID: slot.AppointmentTypeID,
+		SlotStart:         slot.Start,
+	})
+	if err != nil {
+		return query.TestdriveWaitlist{}, err
+	}
+
+	return q.HoldTestDriveSlot(ctx, &query.HoldTestDriveSlotParams{
+		HoldExpires:       sql.NullTime{Time: now.Add(hold), Valid: true},
+		UpdatedTime:       now,
+		ProductID:         slot.ProductID,
+		EcID:              slot.EcID,
+		AppointmentTypeID: slot.AppointmentTypeID,
+		SlotStart:         slot.Start,
+		FreeSeats:         free,
+	})
+}
+
+// RunTestDriveWaitlist passes expired holds to the next waiting customer until
+// ctx is done.
+func (r *repository) RunTestDriveWaitlist(ctx context.Context, config WaitlistConfig) {
+	log.Info("[Odoo - Connector - Waitlist] Start")
+	defer log.Info("[Odoo - Connector - Waitlist] End")
+
+	ticker := time.NewTicker(config.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		for {
+			expired, err := r.qry.ExpireTestDriveHolds(ctx, &query.ExpireTestDriveHoldsParams{
+				Now:   time.Now(),
+				Limit: config.BatchSize,
+			})
+			if err != nil {
+				log.Error("[Odoo - Connector - Waitlist] Expire Holds Error: ", err)
+				break
+			}
+			for _, row := range expired {
+				log.Info(fmt.Sprintf("[Odoo - Connector - Waitlist] Hold %d for user %d expired", row.ID, row.Uid))
+				r.offerTestDriveSlot(ctx, testDriveSlot{
+					ProductID:         row.ProductID,
+					EcID:              row.EcID,
+					AppointmentTypeID: row.AppointmentTypeID,
+					Start:             row.SlotStart,
+				})
+			}
+			if len(expired) < int(config.BatchSize) {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
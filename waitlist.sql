@@ -0,0 +1,115 @@
+-- Schema and sqlc queries for the test drive slot waitlist.
+
+CREATE TABLE IF NOT EXISTS testdrive_waitlist (
+    id                  BIGSERIAL   PRIMARY KEY,
+    uid                 INTEGER     NOT NULL,
+    product_id          INTEGER     NOT NULL,
+    ec_id               INTEGER     NOT NULL,
+    appointment_type_id INTEGER     NOT NULL,
+    slot_start          TIMESTAMPTZ NOT NULL,
+    status              VARCHAR(16) NOT NULL DEFAULT 'waiting',
+    hold_expires        TIMESTAMPTZ,
+    created_time        TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_time        TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- A customer waits at most once per slot.
+CREATE UNIQUE INDEX IF NOT EXISTS testdrive_waitlist_active_idx
+    ON testdrive_waitlist (uid, product_id, ec_id, appointment_type_id, slot_start)
+    WHERE status IN ('waiting', 'held');
+
+CREATE INDEX IF NOT EXISTS testdrive_waitlist_slot_idx
+    ON testdrive_waitlist (product_id, ec_id, appointment_type_id, slot_start, status);
+
+-- name: CreateTestDriveWaitlistEntry :one
+INSERT INTO testdrive_waitlist (uid, product_id, ec_id, appointment_type_id, slot_start, created_time, updated_time)
+VALUES ($1, $2, $3, $4, $5, $6, $6)
+ON CONFLICT (uid, product_id, ec_id, appointment_type_id, slot_start) WHERE status IN ('waiting', 'held') DO NOTHING
+RETURNING *;
+
+-- name: GetTestDriveWaitlistEntry :one
+SELECT * FROM testdrive_waitlist
+WHERE id = $1 AND uid = $2;
+
+-- name: CountTestDriveWaitlistAhead :one
+SELECT COUNT(*) FROM testdrive_waitlist
+WHERE product_id = $1 AND ec_id = $2 AND appointment_type_id = $3 AND slot_start = $4
+  AND status = 'waiting'
+  AND id < $5;
+
+-- name: SetTestDriveWaitlistStatus :exec
+UPDATE testdrive_waitlist
+SET status = $2, updated_time = $3
+WHERE id = $1;
+
+-- Serializes the holds of one slot until the end of the transaction.
+-- name: LockTestDriveSlot :exec
+SELECT pg_advisory_xact_lock(hashtext(concat_ws(':', 'testdrive_slot',
+    sqlc.arg(product_id)::INTEGER, sqlc.arg(ec_id)::INTEGER,
+    sqlc.arg(appointment_type_id)::INTEGER, sqlc.arg(slot_start)::TIMESTAMPTZ)));
+
+-- Holds the slot for the first waiting customer while fewer customers hold it
+-- than it has free seats. Run under LockTestDriveSlot.
+-- name: HoldTestDriveSlot :one
+UPDATE testdrive_waitlist
+SET status = 'held', hold_expires = sqlc.arg(hold_expires), updated_time = sqlc.arg(updated_time)
+WHERE id = (
+    SELECT w.id FROM testdrive_waitlist w
+    WHERE w.product_id = sqlc.arg(product_id) AND w.ec_id = sqlc.arg(ec_id)
+      AND w.appointment_type_id = sqlc.arg(appointment_type_id) AND w.slot_start = sqlc.arg(slot_start)
+      AND w.status = 'waiting'
+    ORDER BY w.id
+    LIMIT 1
+    FOR UPDATE SKIP LOCKED
+)
+AND (
+    SELECT COUNT(*) FROM testdrive_waitlist h
+    WHERE h.product_id = sqlc.arg(product_id) AND h.ec_id = sqlc.arg(ec_id)
+      AND h.appointment_type_id = sqlc.arg(appointment_type_id) AND h.slot_start = sqlc.arg(slot_start)
+      AND h.status = 'held'
+      AND h.hold_expires > sqlc.arg(updated_time)
+) < sqlc.arg(free_seats)::BIGINT
+RETURNING *;
+
+-- name: GetActiveTestDriveHold :one
+SELECT * FROM testdrive_waitlist
+WHERE product_id = $1 AND ec_id = $2 AND appointment_type_id = $3 AND slot_start = $4
+  AND uid = $5
+  AND status = 'held'
+  AND hold_expires > $6
+LIMIT 1;
+
+-- name: CountActiveTestDriveHolds :one
+SELECT COUNT(*) FROM testdrive_waitlist
+WHERE product_id = $1 AND ec_id = $2 AND appointment_type_id = $3 AND slot_start = $4
+  AND status = 'held'
+  AND hold_expires > $5;
+
+-- Counts the held seats per slot, to take them off the listed availability.
+-- name: ListActiveTestDriveHolds :many
+SELECT slot_start, COUNT(*) AS holds FROM testdrive_waitlist
+WHERE product_id = sqlc.arg(product_id) AND ec_id = sqlc.arg(ec_id)
+  AND appointment_type_id = sqlc.arg(appointment_type_id)
+  AND slot_start >= sqlc.arg(from_time) AND slot_start < sqlc.arg(to_time)
+  AND status = 'held'
+  AND hold_expires > sqlc.arg(now)
+GROUP BY slot_start;
+
+-- name: ExpireTestDriveHolds :many
+UPDATE testdrive_waitlist
+SET status = 'expired', updated_time = sqlc.arg(now)
+WHERE id IN (
+    SELECT id FROM testdrive_waitlist
+    WHERE status = 'held'
+      AND hold_expires <= sqlc.arg(now)
+    ORDER BY hold_expires
+    LIMIT sqlc.arg('limit')
+    FOR UPDATE SKIP LOCKED
+)
+RETURNING *;
+
+-- Reads the slot of a booking from the view behind GetTestDriveListByCustomerView.
+-- name: GetTestDriveSlotByBookingId :one
+SELECT product_id, ec_id, appointment_type_id, date, start_time
+FROM v_testdrive_list_by_customer
+WHERE booking_id = $1;
@@ -0,0 +1,305 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"zebrax.id/emi/integration/erp/connector/odoo/model"
+	"zebrax.id/emi/integration/erp/connector/odoo/repository/query"
+)
+
+// waitlistQueries keeps the waitlist in memory the way waitlist.sql keeps it
+// in testdrive_waitlist, for the slots of one day with seats free seats each.
+type waitlistQueries struct {
+	query.Querier
+	seats   map[time.Time]int64
+	entries []query.TestdriveWaitlist
+}
+
+func (q *waitlistQueries) GetSlotTime(ctx context.Context, arg *query.GetSlotTimeParams) (rows []query.GetSlotTimeRow, err error) {
+	for start, seats := range q.seats {
+		if start.In(model.WIB).Format("2006-01-02") == arg.SlotDate.Format("2006-01-02") {
+			rows = append(rows, query.GetSlotTimeRow{StartTimeIso: start.In(model.WIB).Format(time.RFC3339), Jml: seats})
+		}
+	}
+	return rows, nil
+}
+
+func (q *waitlistQueries) sameSlot(row query.TestdriveWaitlist, productID int32, ecID int32, appointmentTypeID int32, start time.Time) bool {
+	return row.ProductID == productID && row.EcID == ecID && row.AppointmentTypeID == appointmentTypeID && row.SlotStart.Equal(start)
+}
+
+func (q *waitlistQueries) CreateTestDriveWaitlistEntry(ctx context.Context, arg *query.CreateTestDriveWaitlistEntryParams) (query.TestdriveWaitlist, error) {
+	for _, row := range q.entries {
+		if row.Uid == arg.Uid && q.sameSlot(row, arg.ProductID, arg.EcID, arg.AppointmentTypeID, arg.SlotStart) &&
+			(row.Status == WaitlistWaiting || row.Status == WaitlistHeld) {
+			return query.TestdriveWaitlist{}, sql.ErrNoRows
+		}
+	}
+	row := query.TestdriveWaitlist{
+		ID:                int64(len(q.entries) + 1),
+		Uid:               arg.Uid,
+		ProductID:         arg.ProductID,
+		EcID:              arg.EcID,
+		AppointmentTypeID: arg.AppointmentTypeID,
+		SlotStart:         arg.SlotStart,
+		Status:            WaitlistWaiting,
+	}
+	q.entries = append(q.entries, row)
+	return row, nil
+}
+
+func (q *waitlistQueries) CountTestDriveWaitlistAhead(ctx context.Context, arg *query.CountTestDriveWaitlistAheadParams) (ahead int64, err error) {
+	for _, row := range q.entries {
+		if q.sameSlot(row, arg.ProductID, arg.EcID, arg.AppointmentTypeID, arg.SlotStart) && row.Status == WaitlistWaiting && row.ID < arg.ID {
+			ahead++
+		}
+	}
+	return ahead, nil
+}
+
+func (q *waitlistQueries) GetTestDriveWaitlistEntry(ctx context.Context, arg *query.GetTestDriveWaitlistEntryParams) (query.TestdriveWaitlist, error) {
+	for _, row := range q.entries {
+		if row.ID == arg.ID && row.Uid == arg.Uid {
+			return row, nil
+		}
+	}
+	return query.TestdriveWaitlist{}, sql.ErrNoRows
+}
+
+func (q *waitlistQueries) SetTestDriveWaitlistStatus(ctx context.Context, arg *query.SetTestDriveWaitlistStatusParams) error {
+	for i := range q.entries {
+		if q.entries[i].ID == arg.ID {
+			q.entries[i].Status = arg.Status
+		}
+	}
+	return nil
+}
+
+func (q *waitlistQueries) LockTestDriveSlot(ctx context.Context, arg *query.LockTestDriveSlotParams) error {
+	return nil
+}
+
+func (q *waitlistQueries) HoldTestDriveSlot(ctx context.Context, arg *query.HoldTestDriveSlotParams) (query.TestdriveWaitlist, error) {
+	held, _ := q.CountActiveTestDriveHolds(ctx, &query.CountActiveTestDriveHoldsParams{
+		ProductID:         arg.ProductID,
+		EcID:              arg.EcID,
+		AppointmentTypeID: arg.AppointmentTypeID,
+		SlotStart:         arg.SlotStart,
+		HoldExpires:       sql.NullTime{Time: arg.UpdatedTime, Valid: true},
+	})
+	if held >= arg.FreeSeats {
+		return query.TestdriveWaitlist{}, sql.ErrNoRows
+	}
+	for i := range q.entries {
+		if q.sameSlot(q.entries[i], arg.ProductID, arg.EcID, arg.AppointmentTypeID, arg.SlotStart) && q.entries[i].Status == WaitlistWaiting {
+			q.entries[i].Status = WaitlistHeld
+			q.entries[i].HoldExpires = arg.HoldExpires
+			return q.entries[i], nil
+		}
+	}
+	return query.TestdriveWaitlist{}, sql.ErrNoRows
+}
+
+func (q *waitlistQueries) GetActiveTestDriveHold(ctx context.Context, arg *query.GetActiveTestDriveHoldParams) (query.TestdriveWaitlist, error) {
+	for _, row := range q.entries {
+		if q.sameSlot(row, arg.ProductID, arg.EcID, arg.AppointmentTypeID, arg.SlotStart) && row.Uid == arg.Uid &&
+			row.Status == WaitlistHeld && row.HoldExpires.Time.After(arg.HoldExpires.Time) {
+			return row, nil
+		}
+	}
+	return query.TestdriveWaitlist{}, sql.ErrNoRows
+}
+
+func (q *waitlistQueries) CountActiveTestDriveHolds(ctx context.Context, arg *query.CountActiveTestDriveHoldsParams) (holds int64, err error) {
+	for _, row := range q.entries {
+		if q.sameSlot(row, arg.ProductID, arg.EcID, arg.AppointmentTypeID, arg.SlotStart) &&
+			row.Status == WaitlistHeld && row.HoldExpires.Time.After(arg.HoldExpires.Time) {
+			holds++
+		}
+	}
+	return holds, nil
+}
+
+func (q *waitlistQueries) ExpireTestDriveHolds(ctx context.Context, arg *query.ExpireTestDriveHoldsParams) (expired []query.TestdriveWaitlist, err error) {
+	for i := range q.entries {
+		if q.entries[i].Status == WaitlistHeld && !q.entries[i].HoldExpires.Time.After(arg.Now) {
+			q.entries[i].Status = WaitlistExpired
+			expired = append(expired, q.entries[i])
+		}
+	}
+	return expired, nil
+}
+
+// waitlistSlot is a slot at 11:00 WIB tomorrow.
+func waitlistSlot() testDriveSlot {
+	day := time.Now().In(model.WIB).AddDate(0, 0, 1)
+	return testDriveSlot{
+		ProductID:         104,
+		EcID:              1,
+		AppointmentTypeID: 1,
+		Start:             time.Date(day.Year(), day.Month(), day.Day(), 11, 0, 0, 0, model.WIB),
+	}
+}
+
+func waitlistParams(uid string, slot testDriveSlot) model.WaitlistParams {
+	return model.WaitlistParams{
+		UID:               uid,
+		ProductID:         slot.ProductID,
+		EcID:              slot.EcID,
+		AppointmentTypeID: slot.AppointmentTypeID,
+		SlotDate:          slot.Start.Format("2006-01-02"),
+		SlotStartTime:     "11:00",
+	}
+}
+
+func TestJoinTestDriveWaitlist(t *testing.T) {
+	slot := waitlistSlot()
+	qry := &waitlistQueries{seats: map[time.Time]int64{slot.Start: 1}}
+	r := &repository{qry: qry}
+	ctx := context.Background()
+
+	if _, err := r.JoinTestDriveWaitlist(ctx, waitlistParams("7", slot)); !errors.Is(err, model.ErrSlotNotFull) {
+		t.Fatalf("join with a free seat: err = %v, want ErrSlotNotFull", err)
+	}
+
+	qry.seats[slot.Start] = 0
+	first, err := r.JoinTestDriveWaitlist(ctx, waitlistParams("7", slot))
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := r.JoinTestDriveWaitlist(ctx, waitlistParams("8", slot))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Ahead != 0 || second.Ahead != 1 {
+		t.Errorf("ahead = %d and %d, want 0 and 1", first.Ahead, second.Ahead)
+	}
+	if !second.SlotStart.Equal(slot.Start) {
+		t.Errorf("slot start = %s, want %s", second.SlotStart, slot.Start)
+	}
+	if _, err = r.JoinTestDriveWaitlist(ctx, waitlistParams("7", slot)); !errors.Is(err, model.ErrAlreadyWaitlisted) {
+		t.Errorf("second join: err = %v, want ErrAlreadyWaitlisted", err)
+	}
+
+	// A free seat held for a waiting customer still leaves the slot full.
+	qry.seats[slot.Start] = 1
+	qry.entries[0].Status = WaitlistHeld
+	qry.entries[0].HoldExpires = sql.NullTime{Time: time.Now().Add(time.Hour), Valid: true}
+	if _, err = r.JoinTestDriveWaitlist(ctx, waitlistParams("9", slot)); err != nil {
+		t.Errorf("join with every free seat held: err = %v", err)
+	}
+
+	past := slot
+	past.Start = time.Now().Add(-time.Hour).In(model.WIB)
+	params := waitlistParams("7", past)
+	params.SlotStartTime = past.Start.Format(time.RFC3339)
+	if _, err = r.JoinTestDriveWaitlist(ctx, params); !errors.Is(err, model.ErrSlotInPast) {
+		t.Errorf("past slot: err = %v, want ErrSlotInPast", err)
+	}
+}
+
+func TestHoldTestDriveSeat(t *testing.T) {
+	slot := waitlistSlot()
+	qry := &waitlistQueries{}
+	for _, uid := range []int32{7, 8, 9} {
+		qry.entries = append(qry.entries, query.TestdriveWaitlist{
+			ID: int64(len(qry.entries) + 1), Uid: uid, ProductID: slot.ProductID, EcID: slot.EcID,
+			AppointmentTypeID: slot.AppointmentTypeID, SlotStart: slot.Start, Status: WaitlistWaiting,
+		})
+	}
+	ctx := context.Background()
+	now := time.Now()
+
+	// Two free seats are held for the first two customers, in order.
+	for _, want := range []int32{7, 8} {
+		row, err := holdTestDriveSeat(ctx, qry, slot, 2, now, DefaultWaitlistHold)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if row.Uid != want || !row.HoldExpires.Time.Equal(now.Add(DefaultWaitlistHold)) {
+			t.Errorf("hold for user %d until %s, want user %d until %s", row.Uid, row.HoldExpires.Time, want, now.Add(DefaultWaitlistHold))
+		}
+	}
+	if _, err := holdTestDriveSeat(ctx, qry, slot, 2, now, DefaultWaitlistHold); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("third hold on two seats: err = %v, want sql.ErrNoRows", err)
+	}
+
+	// An expired hold passes on to the next customer.
+	later := now.Add(DefaultWaitlistHold)
+	expired, err := qry.ExpireTestDriveHolds(ctx, &query.ExpireTestDriveHoldsParams{Now: later, Limit: 10})
+	if err != nil || len(expired) != 2 {
+		t.Fatalf("expired = %d holds (%v), want 2", len(expired), err)
+	}
+	row, err := holdTestDriveSeat(ctx, qry, slot, 2, later, DefaultWaitlistHold)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if row.Uid != 9 {
+		t.Errorf("passed on to user %d, want 9", row.Uid)
+	}
+	if _, err = holdTestDriveSeat(ctx, qry, slot, 2, later, DefaultWaitlistHold); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("hold with nobody waiting: err = %v, want sql.ErrNoRows", err)
+	}
+}
+
+func TestCheckTestDriveHold(t *testing.T) {
+	slot := waitlistSlot()
+	qry := &waitlistQueries{
+		seats: map[time.Time]int64{slot.Start: 2},
+		entries: []query.TestdriveWaitlist{{
+			ID: 1, Uid: 7, ProductID: slot.ProductID, EcID: slot.EcID, AppointmentTypeID: slot.AppointmentTypeID,
+			SlotStart: slot.Start, Status: WaitlistHeld, HoldExpires: sql.NullTime{Time: time.Now().Add(time.Hour), Valid: true},
+		}},
+	}
+	r := &repository{qry: qry}
+	ctx := context.Background()
+	book := func(uid string) model.BookParams {
+		return model.BookParams{
+			UID: uid, ProductID: slot.ProductID, EcID: slot.EcID, BookingTypeID: slot.AppointmentTypeID,
+			SlotDate: slot.Start.Format("2006-01-02"), SlotStartTime: "11:00",
+		}
+	}
+
+	hold, err := r.checkTestDriveHold(ctx, book("7"))
+	if err != nil || hold == nil || hold.ID != 1 {
+		t.Errorf("holder: hold = %+v, err = %v, want hold 1", hold, err)
+	}
+	// One of the two seats is not held.
+	if hold, err = r.checkTestDriveHold(ctx, book("8")); err != nil || hold != nil {
+		t.Errorf("free seat: hold = %+v, err = %v, want neither", hold, err)
+	}
+
+	qry.seats[slot.Start] = 1
+	if _, err = r.checkTestDriveHold(ctx, book("8")); !errors.Is(err, model.ErrSlotHeld) {
+		t.Errorf("only seat held: err = %v, want ErrSlotHeld", err)
+	}
+}
+
+func TestAvailableSeats(t *testing.T) {
+	start := waitlistSlot().Start
+	holds := map[int64]int64{start.Unix(): 2}
+
+	tests := []struct {
+		name     string
+		seats    int64
+		isoStart string
+		want     string
+	}{
+		{name: "held seats taken off", seats: 3, isoStart: start.Format(time.RFC3339), want: "1"},
+		{name: "same instant in UTC", seats: 3, isoStart: start.UTC().Format(time.RFC3339), want: "1"},
+		{name: "more holds than seats", seats: 1, isoStart: start.Format(time.RFC3339), want: "0"},
+		{name: "other slot", seats: 3, isoStart: start.Add(time.Hour).Format(time.RFC3339), want: "3"},
+		{name: "unreadable start", seats: 3, isoStart: "11:00", want: "3"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := availableSeats(tt.seats, tt.isoStart, holds); got != tt.want {
+				t.Errorf("seats = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
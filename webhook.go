@@ -0,0 +1,197 @@
+package usecase
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	log "github.com/sirupsen/logrus"
+	"zebrax.id/emi/integration/erp/adapter/repository/query"
+)
+
+// Webhook signature headers. The signature is the hex HMAC-SHA256 of
+// "<timestamp>.<body>" and is sent as "v1=<hex>". During a key rotation the
+// sender may send several comma separated signatures, one per key.
+const (
+	WebhookTimestampHeader = "X-Webhook-Timestamp"
+	WebhookSignatureHeader = "X-Webhook-Signature"
+
+	webhookSignatureScheme = "v1="
+)
+
+var (
+	ErrWebhookUnsigned  = errors.New("webhook is not signed")
+	ErrWebhookStale     = errors.New("webhook timestamp is outside the tolerance")
+	ErrWebhookSignature = errors.New("webhook signature does not match")
+	ErrWebhookReplayed  = errors.New("webhook was already delivered")
+)
+
+// WebhookVerifier checks the signature of the BO webhooks. Keys maps a key ID
+// to its secret; every key in the map is accepted, so a rotation adds the new
+// key, moves the senders over, then removes the old key.
+type WebhookVerifier struct {
+	Keys      map[string]string
+	Tolerance time.Duration
+}
+
+// ParseWebhookKeys reads signing keys from config in the form
+// "2023-01:secret,2023-07:secret".
+func ParseWebhookKeys(spec string) (map[string]string, error) {
+	keys := make(map[string]string)
+	for _, pair := range strings.Split(spec, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		item := strings.SplitN(pair, ":", 2)
+		if len(item) != 2 || strings.TrimSpace(item[1]) == "" {
+			return nil, fmt.Errorf("webhook keys: invalid entry for key %q", strings.TrimSpace(item[0]))
+		}
+		keys[strings.TrimSpace(item[0])] = strings.TrimSpace(item[1])
+	}
+
+	return keys, nil
+}
+
+var (
+	webhookVerifierMu sync.RWMutex
+	webhookVerifier   = &WebhookVerifier{Keys: map[string]string{}, Tolerance: 5 * time.Minute}
+)
+
+// SetWebhookVerifier replaces the verifier used by BOStatusOrder and
+// LicenceStatus. Until it is called no key is configured and every webhook is
+// rejected.
+func SetWebhookVerifier(verifier *WebhookVerifier) {
+	webhookVerifierMu.Lock()
+	defer webhookVerifierMu.Unlock()
+
+	webhookVerifier = verifier
+}
+
+func currentWebhookVerifier() *WebhookVerifier {
+	webhookVerifierMu.RLock()
+	defer webhookVerifierMu.RUnlock()
+
+	return webhookVerifier
+}
+
+// Verify checks the timestamp and signature headers against body and returns
+// the digest of the delivery, the hex SHA-256 of "<timestamp>.<body>". The
+// digest does not depend on the key or on how the signature is written, so it
+// identifies a delivery however it is re-signed or re-encoded.
+func (v *WebhookVerifier) Verify(header http.Header, body []byte, now time.Time) (digest string, err error) {
+	timestamp := header.Get(WebhookTimestampHeader)
+	signatures := header.Get(WebhookSignatureHeader)
+	if timestamp == "" || signatures == "" {
+		return "", ErrWebhookUnsigned
+	}
+
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return "", ErrWebhookUnsigned
+	}
+	if age := now.Sub(time.Unix(unix, 0)); age > v.Tolerance || age < -v.Tolerance {
+		return "", ErrWebhookStale
+	}
+
+	// Every key is tried in a fixed order, whatever key the sender names.
+	keyIDs := make([]string, 0, len(v.Keys))
+	for keyID := range v.Keys {
+		keyIDs = append(keyIDs, keyID)
+	}
+	sort.Strings(keyIDs)
+
+	for _, keyID := range keyIDs {
+		mac := hmac.New(sha256.New, []byte(v.Keys[keyID]))
+		mac.Write([]byte(timestamp + "."))
+		mac.Write(body)
+		expected := mac.Sum(nil)
+
+		for _, candidate := range strings.Split(signatures, ",") {
+			candidate = strings.TrimSpace(candidate)
+			if !strings.HasPrefix(candidate, webhookSignatureScheme) {
+				continue
+			}
+			given, err := hex.DecodeString(strings.TrimPrefix(candidate, webhookSignatureScheme))
+			if err != nil {
+				continue
+			}
+			if hmac.Equal(given, expected) {
+				return webhookDigest(timestamp, body), nil
+			}
+		}
+	}
+
+	return "", ErrWebhookSignature
+}
+
+func webhookDigest(timestamp string, body []byte) string {
+	sum := sha256.New()
+	sum.Write([]byte(timestamp + "."))
+	sum.Write(body)
+	return hex.EncodeToString(sum.Sum(nil))
+}
+
+// webhookDelivery is a verified webhook request, recorded once its payload
+// has been processed.
+type webhookDelivery struct {
+	source    string
+	digest    string
+	tolerance time.Duration
+}
+
+// verifyWebhook reads the request body and verifies its signature. It returns
+// the body and the delivery for the handler to record, or a 401 error for the
+// handler to send back.
+func (r *useCase) verifyWebhook(ctx echo.Context, source string) (body []byte, delivery webhookDelivery, err error) {
+	body, err = io.ReadAll(ctx.Request().Body)
+	if err != nil {
+		return nil, delivery, err
+	}
+
+	verifier := currentWebhookVerifier()
+	digest, err := verifier.Verify(ctx.Request().Header, body, time.Now())
+	if err != nil {
+		log.Info(fmt.Sprintf("[Webhook] %s Rejected: %s", source, err.Error()))
+		return nil, delivery, echo.NewHTTPError(http.StatusUnauthorized, err.Error())
+	}
+
+	return body, webhookDelivery{source: source, digest: digest, tolerance: verifier.Tolerance}, nil
+}
+
+// recordWebhookDelivery records the delivery in the transaction that applies
+// its payload, so the same signed request cannot be replayed within the
+// tolerance while a retry of a delivery that failed is still processed. A
+// replay is returned as a 401 error.
+func recordWebhookDelivery(ctx context.Context, q *query.Queries, delivery webhookDelivery) error {
+	_, err := q.CreateWebhookDelivery(ctx, &query.CreateWebhookDeliveryParams{
+		Source:       delivery.source,
+		Digest:       delivery.digest,
+		ReceivedTime: time.Now(),
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		log.Info(fmt.Sprintf("[Webhook] %s Rejected: %s", delivery.source, ErrWebhookReplayed.Error()))
+		return echo.NewHTTPError(http.StatusUnauthorized, ErrWebhookReplayed.Error())
+	}
+
+	return err
+}
+
+// pruneWebhookDeliveries deletes the deliveries older than twice the
+// tolerance, which can no longer pass Verify.
+func (r *useCase) pruneWebhookDeliveries(ctx context.Context, delivery webhookDelivery) {
+	if err := r.repo.DeleteWebhookDeliveriesBefore(ctx, time.Now().Add(-2*delivery.tolerance)); err != nil {
+		log.Error("[Webhook] Prune Deliveries Error: ", err)
+	}
+}
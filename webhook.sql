@@ -0,0 +1,22 @@
+-- Schema and sqlc queries for the signed webhook deliveries.
+
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+    source        VARCHAR(64) NOT NULL,
+    -- Hex SHA-256 of "<timestamp>.<body>", the same however the delivery is
+    -- signed.
+    digest        CHAR(64)    NOT NULL,
+    received_time TIMESTAMP   NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (source, digest)
+);
+
+CREATE INDEX IF NOT EXISTS webhook_deliveries_received_time_idx ON webhook_deliveries (received_time);
+
+-- name: CreateWebhookDelivery :one
+INSERT INTO webhook_deliveries (source, digest, received_time)
+VALUES ($1, $2, $3)
+ON CONFLICT (source, digest) DO NOTHING
+RETURNING *;
+
+-- name: DeleteWebhookDeliveriesBefore :exec
+DELETE FROM webhook_deliveries
+WHERE received_time < $1;
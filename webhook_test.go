@@ -0,0 +1,105 @@
+package usecase
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func signWebhook(secret string, timestamp string, body string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "." + body))
+	return webhookSignatureScheme + hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestWebhookVerifierVerify(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	body := `{"invoice_id":"INV/2023/0001","status":"paid"}`
+	verifier := &WebhookVerifier{
+		Keys:      map[string]string{"2023-01": "old-secret", "2023-07": "new-secret"},
+		Tolerance: 5 * time.Minute,
+	}
+	digest := webhookDigest(timestamp, []byte(body))
+
+	tests := []struct {
+		name      string
+		timestamp string
+		signature string
+		body      string
+		want      error
+	}{
+		{name: "current key", timestamp: timestamp, signature: signWebhook("new-secret", timestamp, body), body: body},
+		{name: "previous key", timestamp: timestamp, signature: signWebhook("old-secret", timestamp, body), body: body},
+		{name: "missing scheme", timestamp: timestamp, signature: strings.ToUpper(signWebhook("new-secret", timestamp, body)[len(webhookSignatureScheme):]), body: body, want: ErrWebhookSignature},
+		{name: "uppercase hex with scheme", timestamp: timestamp, signature: webhookSignatureScheme + strings.ToUpper(signWebhook("new-secret", timestamp, body)[len(webhookSignatureScheme):]), body: body},
+		{name: "one of several signatures", timestamp: timestamp, signature: "v1=00ff, " + signWebhook("old-secret", timestamp, body), body: body},
+		{name: "unknown key", timestamp: timestamp, signature: signWebhook("other-secret", timestamp, body), body: body, want: ErrWebhookSignature},
+		{name: "tampered body", timestamp: timestamp, signature: signWebhook("new-secret", timestamp, body), body: body + " ", want: ErrWebhookSignature},
+		{name: "missing signature", timestamp: timestamp, body: body, want: ErrWebhookUnsigned},
+		{name: "invalid timestamp", timestamp: "yesterday", signature: signWebhook("new-secret", "yesterday", body), body: body, want: ErrWebhookUnsigned},
+		{name: "stale", timestamp: strconv.FormatInt(now.Add(-6*time.Minute).Unix(), 10), signature: "v1=00", body: body, want: ErrWebhookStale},
+		{name: "from the future", timestamp: strconv.FormatInt(now.Add(6*time.Minute).Unix(), 10), signature: "v1=00", body: body, want: ErrWebhookStale},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := http.Header{}
+			header.Set(WebhookTimestampHeader, tt.timestamp)
+			if tt.signature != "" {
+				header.Set(WebhookSignatureHeader, tt.signature)
+			}
+
+			got, err := verifier.Verify(header, []byte(tt.body), now)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("err = %v, want %v", err, tt.want)
+			}
+			if tt.want == nil && got != digest {
+				t.Errorf("digest = %s, want %s", got, digest)
+			}
+		})
+	}
+}
+
+// A delivery re-signed with another key or re-encoded keeps its digest, so
+// recordWebhookDelivery rejects it as a replay.
+func TestWebhookDigestIgnoresSignatureEncoding(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	body := `{"plate":"B 1234 XYZ"}`
+	verifier := &WebhookVerifier{
+		Keys:      map[string]string{"a": "secret-a", "b": "secret-b"},
+		Tolerance: time.Minute,
+	}
+
+	signature := signWebhook("secret-a", timestamp, body)
+	variants := []string{
+		signature,
+		webhookSignatureScheme + strings.ToUpper(signature[len(webhookSignatureScheme):]),
+		signWebhook("secret-b", timestamp, body),
+		signWebhook("secret-b", timestamp, body) + "," + signature,
+	}
+
+	seen := map[string]bool{}
+	for _, variant := range variants {
+		header := http.Header{}
+		header.Set(WebhookTimestampHeader, timestamp)
+		header.Set(WebhookSignatureHeader, variant)
+		for i := 0; i < 10; i++ {
+			digest, err := verifier.Verify(header, []byte(body), now)
+			if err != nil {
+				t.Fatalf("%s: %v", variant, err)
+			}
+			seen[digest] = true
+		}
+	}
+	if len(seen) != 1 {
+		t.Errorf("got %d digests for one delivery, want 1", len(seen))
+	}
+}
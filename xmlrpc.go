@@ -0,0 +1,169 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"zebrax.id/emi/integration/erp/connector/odoo/xmlrpc"
+)
+
+// XMLRPCConfig configures an XMLRPCClient.
+type XMLRPCConfig struct {
+	// URL is the base URL of Odoo, e.g. https://erp.example.com.
+	URL      string
+	DB       string
+	Username string
+	Password string
+	// Timeout bounds every HTTP round trip, whatever the context allows.
+	Timeout time.Duration
+}
+
+// XMLRPCFault is a fault returned by Odoo.
+type XMLRPCFault struct {
+	Code   int
+	String string
+}
+
+func (f *XMLRPCFault) Error() string {
+	return fmt.Sprintf("odoo: fault %d: %s", f.Code, f.String)
+}
+
+// XMLRPCClient calls the Odoo XML-RPC API. Each call is an HTTP request bound
+// to its context, so cancelling the context aborts the call instead of leaving
+// it running.
+type XMLRPCClient struct {
+	config XMLRPCConfig
+	http   *http.Client
+
+	mu  sync.Mutex
+	uid int
+}
+
+// NewXMLRPCClient returns a client for config. It logs in on the first call.
+func NewXMLRPCClient(config XMLRPCConfig) *XMLRPCClient {
+	return &XMLRPCClient{
+		config: config,
+		http:   &http.Client{Timeout: config.Timeout},
+	}
+}
+
+// ExecuteKw calls method on model without a deadline of its own; the client
+// Timeout still applies.
+func (c *XMLRPCClient) ExecuteKw(method, model string, args []interface{}, options map[string]interface{}) (interface{}, error) {
+	return c.ExecuteKwContext(context.Background(), method, model, args, options)
+}
+
+// ExecuteKwContext calls method on model through execute_kw.
+func (c *XMLRPCClient) ExecuteKwContext(ctx context.Context, method, model string, args []interface{}, options map[string]interface{}) (interface{}, error) {
+	uid, err := c.login(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if args == nil {
+		args = []interface{}{}
+	}
+	if options == nil {
+		options = map[string]interface{}{}
+	}
+
+	return c.call(ctx, "/xmlrpc/2/object", "execute_kw", c.config.DB, uid, c.config.Password, model, method, args, options)
+}
+
+func (c *XMLRPCClient) login(ctx context.Context) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.uid != 0 {
+		return c.uid, nil
+	}
+
+	response, err := c.call(ctx, "/xmlrpc/2/common", "authenticate", c.config.DB, c.config.Username, c.config.Password, map[string]interface{}{})
+	if err != nil {
+		return 0, err
+	}
+	uid, ok := response.(int)
+	if !ok || uid == 0 {
+		return 0, errors.New("odoo: authentication failed")
+	}
+
+	c.uid = uid
+	return uid, nil
+}
+
+func (c *XMLRPCClient) call(ctx context.Context, path string, method string, params ...interface{}) (interface{}, error) {
+	var body bytes.Buffer
+	body.WriteString(`<?xml version="1.0"?><methodCall><methodName>`)
+	xml.EscapeText(&body, []byte(method))
+	body.WriteString(`</methodName><params>`)
+	for _, param := range params {
+		body.WriteString("<param>")
+		if err := xmlrpc.EncodeValue(&body, param); err != nil {
+			return nil, err
+		}
+		body.WriteString("</param>")
+	}
+	body.WriteString(`</params></methodCall>`)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimRight(c.config.URL, "/")+path, &body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "text/xml")
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("odoo: %s %s: %s", path, method, resp.Status)
+	}
+
+	return readXMLRPCResponse(resp.Body)
+}
+
+// readXMLRPCResponse decodes a methodResponse. Integers decode as int,
+// doubles as float64, structs as map[string]interface{} and arrays as
+// []interface{}; a fault is returned as *XMLRPCFault.
+func readXMLRPCResponse(r io.Reader) (interface{}, error) {
+	decoder := xml.NewDecoder(r)
+	fault := false
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return nil, errors.New("odoo: empty XML-RPC response")
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		start, isStart := token.(xml.StartElement)
+		if !isStart {
+			continue
+		}
+		switch start.Name.Local {
+		case "fault":
+			fault = true
+		case "value":
+			value, err := xmlrpc.DecodeValue(decoder)
+			if err != nil {
+				return nil, err
+			}
+			if !fault {
+				return value, nil
+			}
+
+			members, _ := value.(map[string]interface{})
+			code, _ := members["faultCode"].(int)
+			message, _ := members["faultString"].(string)
+			return nil, &XMLRPCFault{Code: code, String: message}
+		}
+	}
+}
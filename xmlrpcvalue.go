@@ -0,0 +1,239 @@
+// Package xmlrpc encodes and decodes XML-RPC values, for the Odoo client of
+// the connector and the fake Odoo server of odootest.
+package xmlrpc
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DateTime is the layout of <dateTime.iso8601> values. Odoo sends and expects
+// them in UTC.
+const DateTime = "20060102T15:04:05"
+
+// DecodeValue decodes the content of a <value> element whose start tag has
+// been read, up to and including its end tag. Integers decode as int, doubles
+// as float64, structs as map[string]interface{} and arrays as []interface{}.
+func DecodeValue(decoder *xml.Decoder) (value interface{}, err error) {
+	var (
+		text  strings.Builder
+		typed bool
+	)
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return nil, err
+		}
+
+		switch t := token.(type) {
+		case xml.CharData:
+			text.Write(t)
+		case xml.StartElement:
+			if value, err = decodeTyped(decoder, t); err != nil {
+				return nil, err
+			}
+			typed = true
+		case xml.EndElement:
+			if t.Name.Local != "value" {
+				return nil, fmt.Errorf("xmlrpc: unexpected </%s> in value", t.Name.Local)
+			}
+			if !typed {
+				// A value without a type element is a string.
+				return text.String(), nil
+			}
+			return value, nil
+		}
+	}
+}
+
+func decodeTyped(decoder *xml.Decoder, start xml.StartElement) (interface{}, error) {
+	switch start.Name.Local {
+	case "struct":
+		return decodeStruct(decoder)
+	case "array":
+		return decodeArray(decoder)
+	case "nil":
+		return nil, decoder.Skip()
+	}
+
+	var text string
+	if err := decoder.DecodeElement(&text, &start); err != nil {
+		return nil, err
+	}
+
+	switch start.Name.Local {
+	case "string":
+		return text, nil
+	case "int", "i4", "i8":
+		return strconv.Atoi(strings.TrimSpace(text))
+	case "boolean":
+		return strings.TrimSpace(text) == "1", nil
+	case "double":
+		return strconv.ParseFloat(strings.TrimSpace(text), 64)
+	case "dateTime.iso8601":
+		return time.Parse(DateTime, strings.TrimSpace(text))
+	case "base64":
+		return base64.StdEncoding.DecodeString(strings.TrimSpace(text))
+	}
+
+	return nil, fmt.Errorf("xmlrpc: unsupported XML-RPC type <%s>", start.Name.Local)
+}
+
+func decodeStruct(decoder *xml.Decoder) (map[string]interface{}, error) {
+	result := make(map[string]interface{})
+	var name string
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return nil, err
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "name":
+				if err = decoder.DecodeElement(&name, &t); err != nil {
+					return nil, err
+				}
+			case "value":
+				value, err := DecodeValue(decoder)
+				if err != nil {
+					return nil, err
+				}
+				result[strings.TrimSpace(name)] = value
+			}
+		case xml.EndElement:
+			if t.Name.Local == "struct" {
+				return result, nil
+			}
+		}
+	}
+}
+
+func decodeArray(decoder *xml.Decoder) ([]interface{}, error) {
+	result := []interface{}{}
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return nil, err
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			if t.Name.Local == "value" {
+				value, err := DecodeValue(decoder)
+				if err != nil {
+					return nil, err
+				}
+				result = append(result, value)
+			}
+		case xml.EndElement:
+			if t.Name.Local == "array" {
+				return result, nil
+			}
+		}
+	}
+}
+
+// EncodeValue writes value as an XML-RPC <value>. Slices, maps and
+// structs of other types are encoded through their JSON form.
+func EncodeValue(buf *bytes.Buffer, value interface{}) error {
+	switch value.(type) {
+	case nil, bool, int, int8, int16, int32, int64, uint8, uint16, uint32, float32, float64,
+		string, json.Number, time.Time, []byte, []interface{}, map[string]interface{}:
+	default:
+		generic, err := jsonValue(value)
+		if err != nil {
+			return err
+		}
+		return EncodeValue(buf, generic)
+	}
+
+	buf.WriteString("<value>")
+	defer buf.WriteString("</value>")
+
+	switch v := value.(type) {
+	case nil:
+		buf.WriteString("<nil/>")
+	case bool:
+		if v {
+			buf.WriteString("<boolean>1</boolean>")
+		} else {
+			buf.WriteString("<boolean>0</boolean>")
+		}
+	case int, int8, int16, int32, int64, uint8, uint16, uint32:
+		fmt.Fprintf(buf, "<int>%d</int>", v)
+	case float32, float64:
+		fmt.Fprintf(buf, "<double>%v</double>", v)
+	case string:
+		buf.WriteString("<string>")
+		xml.EscapeText(buf, []byte(v))
+		buf.WriteString("</string>")
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			fmt.Fprintf(buf, "<int>%d</int>", i)
+		} else {
+			fmt.Fprintf(buf, "<double>%s</double>", v.String())
+		}
+	case time.Time:
+		fmt.Fprintf(buf, "<dateTime.iso8601>%s</dateTime.iso8601>", v.UTC().Format(DateTime))
+	case []byte:
+		fmt.Fprintf(buf, "<base64>%s</base64>", base64.StdEncoding.EncodeToString(v))
+	case []interface{}:
+		buf.WriteString("<array><data>")
+		for _, item := range v {
+			if err := EncodeValue(buf, item); err != nil {
+				return err
+			}
+		}
+		buf.WriteString("</data></array>")
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		buf.WriteString("<struct>")
+		for _, key := range keys {
+			buf.WriteString("<member><name>")
+			xml.EscapeText(buf, []byte(key))
+			buf.WriteString("</name>")
+			if err := EncodeValue(buf, v[key]); err != nil {
+				return err
+			}
+			buf.WriteString("</member>")
+		}
+		buf.WriteString("</struct>")
+	}
+
+	return nil
+}
+
+// jsonValue converts value to the generic types EncodeValue handles.
+func jsonValue(value interface{}) (interface{}, error) {
+	if rv := reflect.ValueOf(value); rv.Kind() == reflect.Slice && rv.IsNil() {
+		return []interface{}{}, nil
+	}
+
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return nil, fmt.Errorf("xmlrpc: cannot encode %T: %w", value, err)
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	var generic interface{}
+	if err = decoder.Decode(&generic); err != nil {
+		return nil, err
+	}
+	return generic, nil
+}